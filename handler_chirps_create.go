@@ -3,11 +3,16 @@ package main
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
 	"net/http"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/Grey-1011/go-server/internal/auth"
+	"github.com/Grey-1011/go-server/internal/database"
+	"github.com/Grey-1011/go-server/internal/entities"
+	"github.com/Grey-1011/go-server/internal/moderation"
 )
 
 /*
@@ -18,11 +23,94 @@ type Chirp struct {
 	ID       int    `json:"id"`
 	Body     string `json:"body"` // 注意json 后没有空格
 	AuthorID int    `json:"author_id"`
+	// 作者当前的简要资料
+	Author    ChirpAuthor `json:"author"`
+	InReplyTo int         `json:"in_reply_to,omitempty"` // 回复的 chirp 的 ID
+	CreatedAt time.Time   `json:"created_at"`
+	UpdatedAt time.Time   `json:"updated_at"`
+	// 编辑过的 chirp 才有 edited_at
+	EditedAt *time.Time `json:"edited_at,omitempty"`
+	// 只有回收站中的 chirp 才有 deleted_at
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+	// 转发和引用中被转发、被引用的 chirp，由 resolveEmbedded 填充
+	RechirpOf *EmbeddedChirp `json:"rechirp_of,omitempty"`
+	QuoteOf   *EmbeddedChirp `json:"quote_of,omitempty"`
+	// 直接回复的数量
+	ReplyCount int `json:"reply_count"`
+	LikeCount  int `json:"like_count"`
+	// 请求带有 bearer token 时，表示当前用户是否赞过这个 chirp
+	LikedByMe *bool `json:"liked_by_me,omitempty"`
+	// 转发和引用的数量
+	RechirpCount int `json:"rechirp_count"`
+	QuoteCount   int `json:"quote_count"`
+	// 正文中的话题和提及
+	Entities []ChirpEntity `json:"entities,omitempty"`
+	// 附加的媒体
+	Media []Media `json:"media,omitempty"`
+	// 附加的投票，结果由 setPollVotes 按当前用户公开
+	Poll *Poll `json:"poll,omitempty"`
+}
+
+// ChirpEntity 是正文中的一个话题或提及。
+// indices 是字节位置，rune_indices 是字符位置，都是 [开始, 结束)，包括开头的 # 或 @。
+type ChirpEntity struct {
+	Type        string `json:"type"` // "hashtag" 或 "mention"
+	Text        string `json:"text"` // 小写的话题，或被提及用户的 handle
+	Indices     [2]int `json:"indices"`
+	RuneIndices [2]int `json:"rune_indices"`
+	UserID      int    `json:"user_id,omitempty"` // 被提及的用户
+}
+
+// chirpFromDB 把数据库中的 chirp 转换为 API 响应
+// 被转发、被引用的 chirp 只填充了 ID，需要再调用 resolveEmbedded
+func chirpFromDB(dbChirp database.Chirp) Chirp {
+	chirp := Chirp{
+		ID:           dbChirp.ID,
+		Body:         dbChirp.Body,
+		AuthorID:     dbChirp.AuthorID,
+		Author:       chirpAuthorFromDB(dbChirp.AuthorID, dbChirp.Author),
+		InReplyTo:    dbChirp.InReplyTo,
+		ReplyCount:   dbChirp.ReplyCount,
+		LikeCount:    dbChirp.LikeCount,
+		RechirpCount: dbChirp.RechirpCount,
+		QuoteCount:   dbChirp.QuoteCount,
+		CreatedAt:    dbChirp.CreatedAt,
+		UpdatedAt:    dbChirp.UpdatedAt,
+		EditedAt:     dbChirp.EditedAt,
+		DeletedAt:    dbChirp.DeletedAt,
+		Poll:         pollFromDB(dbChirp.Poll),
+	}
+	for _, entity := range dbChirp.Entities {
+		chirp.Entities = append(chirp.Entities, ChirpEntity{
+			Type:        entity.Type,
+			Text:        entity.Text,
+			Indices:     [2]int{entity.Start, entity.End},
+			RuneIndices: [2]int{entity.RuneStart, entity.RuneEnd},
+			UserID:      entity.UserID,
+		})
+	}
+	for _, m := range dbChirp.Media {
+		chirp.Media = append(chirp.Media, mediaFromDB(m))
+	}
+	if dbChirp.RechirpOf != 0 {
+		chirp.RechirpOf = &EmbeddedChirp{ID: dbChirp.RechirpOf}
+	}
+	if dbChirp.QuoteOf != 0 {
+		chirp.QuoteOf = &EmbeddedChirp{ID: dbChirp.QuoteOf}
+	}
+	return chirp
 }
 
 func (cfg *apiConfig) handlerChirpsCreate(w http.ResponseWriter, r *http.Request) {
 	type parameters struct {
-		Body string `json:"body"`
+		Body      string `json:"body"`
+		InReplyTo int    `json:"in_reply_to"` // 可选，回复的 chirp 的 ID
+		QuoteOf   int    `json:"quote_of"`    // 可选，引用的 chirp 的 ID
+		MediaIDs  []int  `json:"media_ids"`   // 可选，附加的媒体（POST /api/media 返回的 ID）
+		// 可选，附加的投票
+		Poll *pollParameters `json:"poll"`
+		// 可选，定时发布的时间，必须在将来
+		PublishAt *time.Time `json:"publish_at"`
 	}
 
 	token, err := auth.GetBearerToken(r.Header)
@@ -40,6 +128,9 @@ func (cfg *apiConfig) handlerChirpsCreate(w http.ResponseWriter, r *http.Request
 		respondWithJSON(w, http.StatusBadRequest, "Couldn't parse user ID")
 		return
 	}
+	if !cfg.requireVerified(w, userID) {
+		return
+	}
 
 	// 创建一个 JSON 解码器来解析请求体
 	decoder := json.NewDecoder(r.Body)
@@ -52,53 +143,143 @@ func (cfg *apiConfig) handlerChirpsCreate(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	cleaned, err := validateChirp(params.Body)
+	cleaned, decision, err := cfg.validateChirp(params.Body)
 	if err != nil {
 		respondWithError(w, http.StatusBadRequest, err.Error())
 		return
 	}
+	chirpEntities, err := cfg.chirpEntities(cleaned)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't resolve mentions")
+		return
+	}
+	if len(params.MediaIDs) > maxChirpMedia {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("A chirp can have at most %d media", maxChirpMedia))
+		return
+	}
+	chirpMedia := []database.ChirpMedia{}
+	for _, id := range params.MediaIDs {
+		chirpMedia = append(chirpMedia, database.ChirpMedia{ID: id})
+	}
+	publishAt := time.Now()
+	if params.PublishAt != nil {
+		publishAt = *params.PublishAt
+		if !publishAt.After(time.Now()) || publishAt.After(time.Now().Add(maxScheduleAhead)) {
+			respondWithError(w, http.StatusBadRequest, fmt.Sprintf("publish_at must be in the future, within %s", maxScheduleAhead))
+			return
+		}
+	}
+	var poll *database.ChirpPoll
+	if params.Poll != nil {
+		poll, err = cfg.validatePoll(*params.Poll, publishAt)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+
+	if params.PublishAt != nil {
+		cfg.scheduleChirp(w, database.ScheduledChirp{
+			AuthorID:   userID,
+			Body:       cleaned,
+			InReplyTo:  params.InReplyTo,
+			QuoteOf:    params.QuoteOf,
+			Moderation: decision,
+			Media:      chirpMedia,
+			Poll:       poll,
+			PublishAt:  publishAt.UTC(),
+		})
+		return
+	}
 
 	// 创建 Chirp ,  需要 userID
-	chirp, err := cfg.DB.CreateChirp(cleaned, userID)
+	chirp, err := cfg.DB.CreateChirp(database.Chirp{
+		Body:       cleaned,
+		AuthorID:   userID,
+		InReplyTo:  params.InReplyTo,
+		QuoteOf:    params.QuoteOf,
+		Moderation: decision,
+		Entities:   chirpEntities,
+		Media:      chirpMedia,
+		Poll:       poll,
+	})
 	if err != nil {
+		if errors.Is(err, database.ErrParentNotExist) {
+			respondWithError(w, http.StatusBadRequest, "Couldn't find the chirp to reply to")
+			return
+		}
+		if errors.Is(err, database.ErrQuotedNotExist) {
+			respondWithError(w, http.StatusBadRequest, "Couldn't find the chirp to quote")
+			return
+		}
+		if errors.Is(err, database.ErrMediaNotAvailable) {
+			respondWithError(w, http.StatusBadRequest, "Couldn't find the media to attach")
+			return
+		}
 		respondWithError(w, http.StatusInternalServerError, "Couldn't create chirp")
 		return
 	}
 
-	respondWithJSON(w, http.StatusCreated, Chirp{
-		ID:       chirp.ID,
-		Body:     chirp.Body,
-		AuthorID: chirp.AuthorID,
-	})
+	resp := chirpFromDB(chirp)
+	err = cfg.resolveEmbedded([]*Chirp{&resp})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve quoted chirps")
+		return
+	}
+	respondWithJSON(w, http.StatusCreated, resp)
 
 	// 如果 Chirp 合法，则返回成功响应
 	// respondWithJSON(w, http.StatusCreated, cleaned)
 }
 
-func validateChirp(body string) (string, error) {
-	const maxChirpLength = 140
-	if len(body) > maxChirpLength {
-		return "", errors.New("Chirp is too long")
+// validateChirp 用内容审核管道检查 chirp 正文，返回审核后的正文和要记录在 chirp 上的审核结果。
+// 被拒绝时返回的错误可以直接作为响应消息。
+func (cfg *apiConfig) validateChirp(body string) (string, database.ChirpModeration, error) {
+	decision := cfg.moderation.Moderate(body)
+	if decision.Action == moderation.ActionReject {
+		return "", database.ChirpModeration{}, errors.New(decision.Reason)
 	}
 
-	badWords := map[string]struct{}{
-		"kerfuffle": {},
-		"sharbert":  {},
-		"fornax":    {},
-	}
-	cleaned := getCleanedBody(body, badWords)
-	return cleaned, nil
+	return decision.Body, database.ChirpModeration{
+		Action:  string(decision.Action),
+		Matched: decision.Matched,
+	}, nil
 }
 
-// 定义了 cleanProfaneWords 函数来替换不雅词语。
-func getCleanedBody(body string, badWords map[string]struct{}) string {
-	words := strings.Split(body, " ")
-	for i, word := range words {
-		// cleanedWord := strings.Trim(word, ".,!?:;") // 去除标点符号
-		loweredWord := strings.ToLower(word)
-		if _, ok := badWords[loweredWord]; ok {
-			words[i] = "****"
+// chirpEntities 提取审核后的正文中的话题和提及。
+// 提及按 handle 解析为用户（不区分大小写），不存在的 handle 不会成为实体。
+func (cfg *apiConfig) chirpEntities(body string) ([]database.ChirpEntity, error) {
+	parsed := entities.Parse(body)
+	handles := []string{}
+	for _, entity := range parsed {
+		if entity.Type == entities.TypeMention {
+			handles = append(handles, entity.Text)
+		}
+	}
+	users, err := cfg.DB.GetUsersByHandle(handles)
+	if err != nil {
+		return nil, err
+	}
+
+	chirpEntities := []database.ChirpEntity{}
+	for _, entity := range parsed {
+		chirpEntity := database.ChirpEntity{
+			Type:      string(entity.Type),
+			Text:      entity.Text,
+			Start:     entity.Start,
+			End:       entity.End,
+			RuneStart: entity.RuneStart,
+			RuneEnd:   entity.RuneEnd,
+		}
+		if entity.Type == entities.TypeMention {
+			user, ok := users[strings.ToLower(entity.Text)]
+			if !ok {
+				continue
+			}
+			chirpEntity.Text = user.Handle
+			chirpEntity.UserID = user.ID
 		}
+		chirpEntities = append(chirpEntities, chirpEntity)
 	}
-	return strings.Join(words, " ")
+	return chirpEntities, nil
 }
@@ -1,10 +1,12 @@
 package main
 
 import (
+	"errors"
 	"net/http"
 	"strconv"
 
 	"github.com/Grey-1011/go-server/internal/auth"
+	"github.com/Grey-1011/go-server/internal/database"
 )
 
 
@@ -33,7 +35,7 @@ func (cfg *apiConfig) handlerChirpsDelete(w http.ResponseWriter, r *http.Request
 	}
 
 	dbChirp, err := cfg.DB.GetChirp(chirpID)
-	if err != nil {
+	if err != nil || dbChirp.DeletedAt != nil {
 		respondWithError(w, http.StatusNotFound, "Couldn't get chirp")
 		return
 	}
@@ -43,8 +45,13 @@ func (cfg *apiConfig) handlerChirpsDelete(w http.ResponseWriter, r *http.Request
 	}
 
 
-	err = cfg.DB.DeleteChirp(chirpID)
+	// 移入回收站，保留期内作者可以恢复
+	err = cfg.DB.DeleteChirp(chirpID, userID)
 	if err != nil {
+		if errors.Is(err, database.ErrNotExist) {
+			respondWithError(w, http.StatusNotFound, "Couldn't get chirp")
+			return
+		}
 		respondWithError(w, http.StatusInternalServerError, "Couldn't delete chirp")
 		return
 	}
@@ -1,9 +1,14 @@
 package main
 
 import (
+	"encoding/base64"
+	"errors"
 	"net/http"
-	"sort"
 	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Grey-1011/go-server/internal/database"
 )
 
 func (cfg *apiConfig) handlerChirpsGet(w http.ResponseWriter, r *http.Request) {
@@ -14,66 +19,218 @@ func (cfg *apiConfig) handlerChirpsGet(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	dbChirp, err := cfg.DB.GetChirp(chirpId)
+	viewerID, err := cfg.viewerID(r)
 	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT")
+		return
+	}
+
+	dbChirp, err := cfg.DB.GetChirp(chirpId)
+	// 回收站中的 chirp 对外不可见
+	if err != nil || dbChirp.DeletedAt != nil {
 		respondWithError(w, http.StatusNotFound, "Couldn't get chirp")
 		return
 	}
 
-	respondWithJSON(w, http.StatusOK, Chirp{
-		ID:   dbChirp.ID,
-		Body: dbChirp.Body,
-	})
+	chirp := chirpFromDB(dbChirp)
+	err = cfg.setLikedByMe(viewerID, []*Chirp{&chirp})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve likes")
+		return
+	}
+	err = cfg.setPollVotes(viewerID, []*Chirp{&chirp})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve poll votes")
+		return
+	}
+	err = cfg.resolveEmbedded([]*Chirp{&chirp})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve quoted chirps")
+		return
+	}
+	respondWithJSON(w, http.StatusOK, chirp)
 }
 
+const (
+	defaultChirpsLimit = 20
+	maxChirpsLimit     = 100
+)
 
+/*
+handlerChirpsRetrieve 获取 Chirps，支持的查询参数：
+- author_id: 只返回该作者的 chirp
+- sort: asc（默认）或 desc 按 id 排序；created_at 或 -created_at 按创建时间升序或降序排序
+- since / until: RFC 3339 时间，只返回 since <= created_at < until 的 chirp
+- limit / cursor: 分页。指定其中任意一个时，响应是 {"chirps": [...], "next_cursor": "..."}，
+  把 next_cursor 作为下一次请求的 cursor 即可获取下一页，没有更多数据时不返回 next_cursor。
+  不分页时响应是 chirp 数组。
+带有 bearer token 时，每个 chirp 都有 liked_by_me。
+*/
 func (cfg *apiConfig) handlerChirpsRetrieve(w http.ResponseWriter, r *http.Request) {
-	// 获取所有 Chirps
-	dbChirps, err := cfg.DB.GetChirps()
+	type response struct {
+		Chirps     []Chirp `json:"chirps"`
+		NextCursor string  `json:"next_cursor,omitempty"`
+	}
+
+	viewerID, err := cfg.viewerID(r)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve chirps")
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT")
 		return
 	}
 
-	// 初始化 authorID 为 -1（表示没有指定特定作者）。
-	authorID := -1
-	authorIDString := r.URL.Query().Get("author_id")
+	query := r.URL.Query()
+	q := database.ChirpQuery{}
+
+	authorIDString := query.Get("author_id")
 	// 如果 author_id 存在
 	if authorIDString != "" {
-		authorID, err = strconv.Atoi(authorIDString)
+		authorID, err := strconv.Atoi(authorIDString)
 		if err != nil {
 			respondWithError(w, http.StatusBadRequest, "Invalid author ID")
 			return
 		}
+		q.AuthorID = authorID
+	}
+
+	switch query.Get("sort") {
+	case "", "asc":
+	case "desc":
+		q.Desc = true
+	case "created_at":
+		q.SortBy = database.ChirpSortCreatedAt
+	case "-created_at":
+		q.SortBy = database.ChirpSortCreatedAt
+		q.Desc = true
+	default:
+		respondWithError(w, http.StatusBadRequest, "Invalid sort")
+		return
+	}
+
+	q.Since, err = parseTimeParam(query.Get("since"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid since")
+		return
+	}
+	q.Until, err = parseTimeParam(query.Get("until"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid until")
+		return
+	}
+
+	limitString := query.Get("limit")
+	cursor := query.Get("cursor")
+	paginated := limitString != "" || cursor != ""
+	if paginated {
+		limit := defaultChirpsLimit
+		if limitString != "" {
+			limit, err = strconv.Atoi(limitString)
+			if err != nil || limit < 1 {
+				respondWithError(w, http.StatusBadRequest, "Invalid limit")
+				return
+			}
+			limit = min(limit, maxChirpsLimit)
+		}
+		if cursor != "" {
+			err = decodeChirpCursor(cursor, &q)
+			if err != nil {
+				respondWithError(w, http.StatusBadRequest, "Invalid cursor")
+				return
+			}
+		}
+		// 多取一条，用来判断是否还有下一页
+		q.Limit = limit + 1
 	}
 
-	sortDirection := "asc"
-	sortDirectionParam := r.URL.Query().Get("sort")
-	if sortDirectionParam == "desc" {
-		sortDirection = "desc"
+	dbChirps, err := cfg.DB.ListChirps(q)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve chirps")
+		return
 	}
 
 	chirps := []Chirp{}
 	for _, dbChirp := range dbChirps {
-		// 如果指定了 author_id 且 chirp 的 AuthorID 不匹配，跳过该 chirp。
-		if authorID != -1 && dbChirp.AuthorID != authorID {
-			continue
-		}
+		chirps = append(chirps, chirpFromDB(dbChirp))
+	}
+	err = cfg.setLikedByMe(viewerID, chirpPointers(chirps))
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve likes")
+		return
+	}
+	err = cfg.setPollVotes(viewerID, chirpPointers(chirps))
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve poll votes")
+		return
+	}
+	err = cfg.resolveEmbedded(chirpPointers(chirps))
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve quoted chirps")
+		return
+	}
+
+	if !paginated {
+		respondWithJSON(w, http.StatusOK, chirps)
+		return
+	}
 
-		chirps = append(chirps, Chirp{
-			ID:   dbChirp.ID,
-			AuthorID:  dbChirp.AuthorID,
-			Body: dbChirp.Body,
-		})
+	resp := response{
+		Chirps: chirps,
 	}
+	if len(chirps) == q.Limit {
+		resp.Chirps = chirps[:q.Limit-1]
+		resp.NextCursor = encodeChirpCursor(dbChirps[q.Limit-2], q.SortBy)
+	}
+	respondWithJSON(w, http.StatusOK, resp)
+}
+
+// parseTimeParam 解析 RFC 3339 格式的查询参数，空字符串返回零值
+func parseTimeParam(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	return time.Parse(time.RFC3339Nano, value)
+}
 
-	// 按 id 升序排列
-	sort.Slice(chirps, func(i, j int) bool {
-		if sortDirection == "desc" {
-			return chirps[i].ID > chirps[j].ID
+/*
+cursor 对客户端是不透明的，内部是上一页最后一个 chirp 的排序键：
+- 按 id 排序时是 "<id>"
+- 按 created_at 排序时是 "<created_at 的 Unix 纳秒>_<id>"
+*/
+func encodeChirpCursor(chirp database.Chirp, sortBy string) string {
+	key := strconv.Itoa(chirp.ID)
+	if sortBy == database.ChirpSortCreatedAt {
+		key = strconv.FormatInt(chirp.CreatedAt.UnixNano(), 10) + "_" + key
+	}
+	return base64.RawURLEncoding.EncodeToString([]byte(key))
+}
+
+// decodeChirpCursor 解析 cursor，并设置 q 的分页位置。cursor 必须和 q 使用同一种排序。
+func decodeChirpCursor(cursor string, q *database.ChirpQuery) error {
+	dat, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return err
+	}
+
+	idString := string(dat)
+	if q.SortBy == database.ChirpSortCreatedAt {
+		nanos, rest, ok := strings.Cut(idString, "_")
+		if !ok {
+			return errors.New("invalid cursor")
+		}
+		n, err := strconv.ParseInt(nanos, 10, 64)
+		if err != nil {
+			return err
 		}
-		return chirps[i].ID < chirps[j].ID
-	})
+		q.AfterCreatedAt = time.Unix(0, n).UTC()
+		idString = rest
+	}
 
-	respondWithJSON(w, http.StatusOK, chirps)
+	id, err := strconv.Atoi(idString)
+	if err != nil {
+		return err
+	}
+	if id < 1 {
+		return errors.New("invalid cursor")
+	}
+	q.AfterID = id
+	return nil
 }
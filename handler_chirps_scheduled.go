@@ -0,0 +1,157 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Grey-1011/go-server/internal/auth"
+	"github.com/Grey-1011/go-server/internal/database"
+)
+
+// 定时发布的时间最晚在多久之后
+const maxScheduleAhead = 365 * 24 * time.Hour
+
+// 定时 chirp 的状态
+const (
+	scheduledStatusPending = "scheduled"
+	scheduledStatusFailed  = "failed"
+)
+
+// ScheduledChirp 是一个还没有发布的定时 chirp，只有作者能看到
+type ScheduledChirp struct {
+	ID        int       `json:"id"`
+	Body      string    `json:"body"`
+	AuthorID  int       `json:"author_id"`
+	InReplyTo int       `json:"in_reply_to,omitempty"`
+	QuoteOf   int       `json:"quote_of,omitempty"`
+	Media     []Media   `json:"media,omitempty"`
+	Poll      *Poll     `json:"poll,omitempty"`
+	PublishAt time.Time `json:"publish_at"`
+	CreatedAt time.Time `json:"created_at"`
+	Status    string    `json:"status"`          // "scheduled" 或 "failed"
+	Error     string    `json:"error,omitempty"` // 发布失败的原因
+}
+
+func scheduledChirpFromDB(dbScheduled database.ScheduledChirp) ScheduledChirp {
+	scheduled := ScheduledChirp{
+		ID:        dbScheduled.ID,
+		Body:      dbScheduled.Body,
+		AuthorID:  dbScheduled.AuthorID,
+		InReplyTo: dbScheduled.InReplyTo,
+		QuoteOf:   dbScheduled.QuoteOf,
+		Poll:      pollFromDB(dbScheduled.Poll),
+		PublishAt: dbScheduled.PublishAt,
+		CreatedAt: dbScheduled.CreatedAt,
+		Status:    scheduledStatusPending,
+	}
+	for _, m := range dbScheduled.Media {
+		scheduled.Media = append(scheduled.Media, mediaFromDB(m))
+	}
+	if dbScheduled.FailedAt != nil {
+		scheduled.Status = scheduledStatusFailed
+		scheduled.Error = dbScheduled.Error
+	}
+	return scheduled
+}
+
+// scheduleChirp 保存 handlerChirpsCreate 中带有 publish_at 的 chirp，返回 201 和定时 chirp
+func (cfg *apiConfig) scheduleChirp(w http.ResponseWriter, scheduled database.ScheduledChirp) {
+	scheduled, err := cfg.DB.ScheduleChirp(scheduled)
+	if err != nil {
+		if errors.Is(err, database.ErrParentNotExist) {
+			respondWithError(w, http.StatusBadRequest, "Couldn't find the chirp to reply to")
+			return
+		}
+		if errors.Is(err, database.ErrQuotedNotExist) {
+			respondWithError(w, http.StatusBadRequest, "Couldn't find the chirp to quote")
+			return
+		}
+		if errors.Is(err, database.ErrMediaNotAvailable) {
+			respondWithError(w, http.StatusBadRequest, "Couldn't find the media to attach")
+			return
+		}
+		respondWithError(w, http.StatusInternalServerError, "Couldn't schedule chirp")
+		return
+	}
+
+	respondWithJSON(w, http.StatusCreated, scheduledChirpFromDB(scheduled))
+}
+
+// handlerChirpsScheduled 返回当前用户的定时 chirp（包括发布失败的），最早发布的在前
+func (cfg *apiConfig) handlerChirpsScheduled(w http.ResponseWriter, r *http.Request) {
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT")
+		return
+	}
+	subject, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT")
+		return
+	}
+	userID, err := strconv.Atoi(subject)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't parse user ID")
+		return
+	}
+
+	dbScheduled, err := cfg.DB.ListScheduledChirps(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve scheduled chirps")
+		return
+	}
+
+	scheduled := []ScheduledChirp{}
+	for _, s := range dbScheduled {
+		scheduled = append(scheduled, scheduledChirpFromDB(s))
+	}
+	respondWithJSON(w, http.StatusOK, scheduled)
+}
+
+// handlerChirpsScheduledDelete 取消查询参数 id 指定的、还没有发布的定时 chirp，只有作者可以取消。成功时返回 204。
+// ID 不放在路径中，/api/chirps/scheduled/{id} 会和 /api/chirps/{chirpID}/likes 等路由冲突。
+func (cfg *apiConfig) handlerChirpsScheduledDelete(w http.ResponseWriter, r *http.Request) {
+	scheduledID, err := strconv.Atoi(r.URL.Query().Get("id"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid scheduled chirp ID")
+		return
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT")
+		return
+	}
+	subject, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT")
+		return
+	}
+	userID, err := strconv.Atoi(subject)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't parse user ID")
+		return
+	}
+
+	// 别人的定时 chirp 对当前用户不可见，所以同样返回 404
+	scheduled, err := cfg.DB.GetScheduledChirp(scheduledID)
+	if err != nil || scheduled.AuthorID != userID {
+		respondWithError(w, http.StatusNotFound, "Couldn't find scheduled chirp")
+		return
+	}
+
+	err = cfg.DB.CancelScheduledChirp(scheduledID)
+	if err != nil {
+		if errors.Is(err, database.ErrNotExist) {
+			// 已经被发布或取消
+			respondWithError(w, http.StatusNotFound, "Couldn't find scheduled chirp")
+			return
+		}
+		respondWithError(w, http.StatusInternalServerError, "Couldn't delete scheduled chirp")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
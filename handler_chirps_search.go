@@ -0,0 +1,81 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/Grey-1011/go-server/internal/database"
+)
+
+/*
+handlerChirpsSearch 全文搜索 Chirps，支持的查询参数：
+- q: 查询语句（必填）。多个词之间是 AND 关系，用双引号括起来的是短语，不区分大小写。
+- author_id: 只返回该作者的 chirp
+- limit: 最多返回的数量，默认 20，最大 100
+结果按相关度排序。
+*/
+func (cfg *apiConfig) handlerChirpsSearch(w http.ResponseWriter, r *http.Request) {
+	viewerID, err := cfg.viewerID(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT")
+		return
+	}
+
+	query := r.URL.Query()
+	q := database.SearchQuery{
+		Text:  query.Get("q"),
+		Limit: defaultChirpsLimit,
+	}
+	if strings.TrimSpace(q.Text) == "" {
+		respondWithError(w, http.StatusBadRequest, "Missing search query")
+		return
+	}
+
+	authorIDString := query.Get("author_id")
+	if authorIDString != "" {
+		authorID, err := strconv.Atoi(authorIDString)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid author ID")
+			return
+		}
+		q.AuthorID = authorID
+	}
+
+	limitString := query.Get("limit")
+	if limitString != "" {
+		limit, err := strconv.Atoi(limitString)
+		if err != nil || limit < 1 {
+			respondWithError(w, http.StatusBadRequest, "Invalid limit")
+			return
+		}
+		q.Limit = min(limit, maxChirpsLimit)
+	}
+
+	dbChirps, err := cfg.DB.SearchChirps(q)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't search chirps")
+		return
+	}
+
+	chirps := []Chirp{}
+	for _, dbChirp := range dbChirps {
+		chirps = append(chirps, chirpFromDB(dbChirp))
+	}
+	err = cfg.setLikedByMe(viewerID, chirpPointers(chirps))
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve likes")
+		return
+	}
+	err = cfg.setPollVotes(viewerID, chirpPointers(chirps))
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve poll votes")
+		return
+	}
+	err = cfg.resolveEmbedded(chirpPointers(chirps))
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve quoted chirps")
+		return
+	}
+	respondWithJSON(w, http.StatusOK, chirps)
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/Grey-1011/go-server/internal/database"
+)
+
+const (
+	defaultThreadDepth = 10
+	maxThreadDepth     = 50
+)
+
+// ThreadChirp 是对话树中的一个节点
+type ThreadChirp struct {
+	Chirp
+	// 超过 depth 的回复不会返回，这时 replies 的数量小于 reply_count
+	Replies []ThreadChirp `json:"replies"`
+}
+
+/*
+handlerChirpThreadGet 返回 chirp 所在的对话：
+- ancestors: 从对话的根到被回复的 chirp
+- chirp: 请求的 chirp，以及 depth 层以内的回复（默认 10，最多 50，0 表示不返回回复）
+*/
+func (cfg *apiConfig) handlerChirpThreadGet(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		Ancestors []Chirp     `json:"ancestors"`
+		Chirp     ThreadChirp `json:"chirp"`
+	}
+
+	chirpIDString := r.PathValue("chirpID")
+	chirpID, err := strconv.Atoi(chirpIDString)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid chirp ID")
+		return
+	}
+
+	viewerID, err := cfg.viewerID(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT")
+		return
+	}
+
+	depth := defaultThreadDepth
+	if depthString := r.URL.Query().Get("depth"); depthString != "" {
+		depth, err = strconv.Atoi(depthString)
+		if err != nil || depth < 0 {
+			respondWithError(w, http.StatusBadRequest, "Invalid depth")
+			return
+		}
+		depth = min(depth, maxThreadDepth)
+	}
+
+	thread, err := cfg.DB.GetChirpThread(chirpID, depth)
+	if err != nil {
+		if errors.Is(err, database.ErrNotExist) {
+			respondWithError(w, http.StatusNotFound, "Couldn't get chirp")
+			return
+		}
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve thread")
+		return
+	}
+
+	ancestors := []Chirp{}
+	for _, dbChirp := range thread.Ancestors {
+		ancestors = append(ancestors, chirpFromDB(dbChirp))
+	}
+
+	resp := response{
+		Ancestors: ancestors,
+		Chirp:     threadChirpFromDB(thread.Chirp, thread.Replies),
+	}
+	err = cfg.setLikedByMe(viewerID, append(chirpPointers(resp.Ancestors), resp.Chirp.chirps()...))
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve likes")
+		return
+	}
+	err = cfg.setPollVotes(viewerID, append(chirpPointers(resp.Ancestors), resp.Chirp.chirps()...))
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve poll votes")
+		return
+	}
+	err = cfg.resolveEmbedded(append(chirpPointers(resp.Ancestors), resp.Chirp.chirps()...))
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve quoted chirps")
+		return
+	}
+	respondWithJSON(w, http.StatusOK, resp)
+}
+
+// chirps 返回指向对话树中每个 chirp 的指针
+func (node *ThreadChirp) chirps() []*Chirp {
+	ptrs := []*Chirp{&node.Chirp}
+	for i := range node.Replies {
+		ptrs = append(ptrs, node.Replies[i].chirps()...)
+	}
+	return ptrs
+}
+
+// threadChirpFromDB 从 replies 递归构造以 dbChirp 为根的对话树
+func threadChirpFromDB(dbChirp database.Chirp, replies map[int][]database.Chirp) ThreadChirp {
+	node := ThreadChirp{
+		Chirp:   chirpFromDB(dbChirp),
+		Replies: []ThreadChirp{},
+	}
+	for _, reply := range replies[dbChirp.ID] {
+		node.Replies = append(node.Replies, threadChirpFromDB(reply, replies))
+	}
+	return node
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Grey-1011/go-server/internal/auth"
+	"github.com/Grey-1011/go-server/internal/database"
+)
+
+// handlerChirpsTrash 返回当前用户回收站中的 chirp，最近删除的在前
+func (cfg *apiConfig) handlerChirpsTrash(w http.ResponseWriter, r *http.Request) {
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT")
+		return
+	}
+	subject, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT")
+		return
+	}
+	userID, err := strconv.Atoi(subject)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't parse user ID")
+		return
+	}
+
+	dbChirps, err := cfg.DB.ListDeletedChirps(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve chirps")
+		return
+	}
+
+	chirps := []Chirp{}
+	for _, dbChirp := range dbChirps {
+		chirps = append(chirps, chirpFromDB(dbChirp))
+	}
+	err = cfg.resolveEmbedded(chirpPointers(chirps))
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve quoted chirps")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, chirps)
+}
+
+// handlerChirpsRestore 让作者在保留期内把 chirp 移出回收站
+func (cfg *apiConfig) handlerChirpsRestore(w http.ResponseWriter, r *http.Request) {
+	chirpIDString := r.PathValue("chirpID")
+	chirpID, err := strconv.Atoi(chirpIDString)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid chirp ID")
+		return
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT")
+		return
+	}
+	subject, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT")
+		return
+	}
+	userID, err := strconv.Atoi(subject)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't parse user ID")
+		return
+	}
+
+	dbChirp, err := cfg.DB.GetChirp(chirpID)
+	if err != nil || dbChirp.DeletedAt == nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't find deleted chirp")
+		return
+	}
+	if dbChirp.AuthorID != userID {
+		respondWithError(w, http.StatusForbidden, "You can't restore this chirp")
+		return
+	}
+	// 已经超过保留期，等待清理任务永久删除
+	if time.Since(*dbChirp.DeletedAt) > cfg.chirpRetention {
+		respondWithError(w, http.StatusGone, "Chirp can no longer be restored")
+		return
+	}
+
+	chirp, err := cfg.DB.RestoreChirp(chirpID)
+	if err != nil {
+		if errors.Is(err, database.ErrNotExist) {
+			respondWithError(w, http.StatusNotFound, "Couldn't find deleted chirp")
+			return
+		}
+		if errors.Is(err, database.ErrAlreadyExists) {
+			respondWithError(w, http.StatusConflict, "You have already rechirped this chirp")
+			return
+		}
+		respondWithError(w, http.StatusInternalServerError, "Couldn't restore chirp")
+		return
+	}
+
+	resp := chirpFromDB(chirp)
+	err = cfg.setPollVotes(userID, []*Chirp{&resp})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve poll votes")
+		return
+	}
+	err = cfg.resolveEmbedded([]*Chirp{&resp})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve quoted chirps")
+		return
+	}
+	respondWithJSON(w, http.StatusOK, resp)
+}
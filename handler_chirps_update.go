@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/Grey-1011/go-server/internal/auth"
+	"github.com/Grey-1011/go-server/internal/database"
+)
+
+// handlerChirpsUpdate 只允许作者编辑 chirp，新的正文同样要经过内容审核，旧的正文保存为历史版本
+func (cfg *apiConfig) handlerChirpsUpdate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Body string `json:"body"`
+	}
+
+	chirpIDString := r.PathValue("chirpID")
+	chirpID, err := strconv.Atoi(chirpIDString)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid chirp ID")
+		return
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT")
+		return
+	}
+	subject, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT")
+		return
+	}
+	userID, err := strconv.Atoi(subject)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't parse user ID")
+		return
+	}
+
+	dbChirp, err := cfg.DB.GetChirp(chirpID)
+	if err != nil || dbChirp.DeletedAt != nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't get chirp")
+		return
+	}
+	if dbChirp.AuthorID != userID {
+		respondWithError(w, http.StatusForbidden, "You can't edit this chirp")
+		return
+	}
+	// 转发没有自己的正文
+	if dbChirp.RechirpOf != 0 {
+		respondWithError(w, http.StatusBadRequest, "Can't edit a rechirp")
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err = decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't decode parameters")
+		return
+	}
+
+	cleaned, decision, err := cfg.validateChirp(params.Body)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	chirpEntities, err := cfg.chirpEntities(cleaned)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't resolve mentions")
+		return
+	}
+
+	chirp, err := cfg.DB.UpdateChirp(chirpID, cleaned, decision, chirpEntities)
+	if err != nil {
+		if errors.Is(err, database.ErrNotExist) {
+			respondWithError(w, http.StatusNotFound, "Couldn't get chirp")
+			return
+		}
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update chirp")
+		return
+	}
+
+	resp := chirpFromDB(chirp)
+	err = cfg.setPollVotes(userID, []*Chirp{&resp})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve poll votes")
+		return
+	}
+	err = cfg.resolveEmbedded([]*Chirp{&resp})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve quoted chirps")
+		return
+	}
+	respondWithJSON(w, http.StatusOK, resp)
+}
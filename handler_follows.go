@@ -0,0 +1,111 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Grey-1011/go-server/internal/auth"
+	"github.com/Grey-1011/go-server/internal/database"
+)
+
+// FollowUser 是关注列表中的一项，不包含邮箱等私人信息
+type FollowUser struct {
+	UserID    int       `json:"user_id"`
+	CreatedAt time.Time `json:"created_at"` // 关注的时间
+}
+
+// handlerUsersFollow 关注 {userID}，重复关注不会有影响
+func (cfg *apiConfig) handlerUsersFollow(w http.ResponseWriter, r *http.Request) {
+	cfg.handleFollow(w, r, cfg.DB.Follow)
+}
+
+// handlerUsersUnfollow 取消关注 {userID}，没有关注也不会出错
+func (cfg *apiConfig) handlerUsersUnfollow(w http.ResponseWriter, r *http.Request) {
+	cfg.handleFollow(w, r, cfg.DB.Unfollow)
+}
+
+func (cfg *apiConfig) handleFollow(w http.ResponseWriter, r *http.Request, update func(followerID, followeeID int) error) {
+	followeeIDString := r.PathValue("userID")
+	followeeID, err := strconv.Atoi(followeeIDString)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid user ID")
+		return
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT")
+		return
+	}
+	subject, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT")
+		return
+	}
+	userID, err := strconv.Atoi(subject)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't parse user ID")
+		return
+	}
+
+	if followeeID == userID {
+		respondWithError(w, http.StatusBadRequest, "Can't follow yourself")
+		return
+	}
+
+	err = update(userID, followeeID)
+	if err != nil {
+		if errors.Is(err, database.ErrNotExist) {
+			respondWithError(w, http.StatusNotFound, "Couldn't find user")
+			return
+		}
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update follow")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handlerUserFollowersGet 返回关注 {userID} 的用户，最近关注的在前
+func (cfg *apiConfig) handlerUserFollowersGet(w http.ResponseWriter, r *http.Request) {
+	cfg.handleFollowsList(w, r, cfg.DB.ListFollowers, func(follow database.Follow) int {
+		return follow.FollowerID
+	})
+}
+
+// handlerUserFollowingGet 返回 {userID} 关注的用户，最近关注的在前
+func (cfg *apiConfig) handlerUserFollowingGet(w http.ResponseWriter, r *http.Request) {
+	cfg.handleFollowsList(w, r, cfg.DB.ListFollowing, func(follow database.Follow) int {
+		return follow.FolloweeID
+	})
+}
+
+func (cfg *apiConfig) handleFollowsList(w http.ResponseWriter, r *http.Request, list func(userID int) ([]database.Follow, error), other func(database.Follow) int) {
+	userIDString := r.PathValue("userID")
+	userID, err := strconv.Atoi(userIDString)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid user ID")
+		return
+	}
+
+	dbFollows, err := list(userID)
+	if err != nil {
+		if errors.Is(err, database.ErrNotExist) {
+			respondWithError(w, http.StatusNotFound, "Couldn't find user")
+			return
+		}
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve follows")
+		return
+	}
+
+	users := []FollowUser{}
+	for _, follow := range dbFollows {
+		users = append(users, FollowUser{
+			UserID:    other(follow),
+			CreatedAt: follow.CreatedAt,
+		})
+	}
+	respondWithJSON(w, http.StatusOK, users)
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/Grey-1011/go-server/internal/auth"
+	"github.com/Grey-1011/go-server/internal/database"
+)
+
+// viewerID 返回请求中 bearer token 对应的用户 ID，没有 Authorization 头时返回 0（匿名访问）
+func (cfg *apiConfig) viewerID(r *http.Request) (int, error) {
+	token, err := auth.GetBearerToken(r.Header)
+	if errors.Is(err, auth.ErrNoAuthHeaderIncluded) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	subject, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(subject)
+}
+
+// setLikedByMe 为当前用户设置 chirps 的 liked_by_me，viewerID 为 0 时不设置
+func (cfg *apiConfig) setLikedByMe(viewerID int, chirps []*Chirp) error {
+	if viewerID == 0 || len(chirps) == 0 {
+		return nil
+	}
+
+	ids := make([]int, 0, len(chirps))
+	for _, chirp := range chirps {
+		ids = append(ids, chirp.ID)
+	}
+	liked, err := cfg.DB.LikedByUser(viewerID, ids)
+	if err != nil {
+		return err
+	}
+	for _, chirp := range chirps {
+		likedByMe := liked[chirp.ID]
+		chirp.LikedByMe = &likedByMe
+	}
+	return nil
+}
+
+// chirpPointers 返回指向 chirps 中每个元素的指针，用于 setLikedByMe 和 resolveEmbedded
+func chirpPointers(chirps []Chirp) []*Chirp {
+	ptrs := make([]*Chirp, 0, len(chirps))
+	for i := range chirps {
+		ptrs = append(ptrs, &chirps[i])
+	}
+	return ptrs
+}
+
+// handlerChirpsLike 点赞，重复点赞不会有影响。返回点赞后的 chirp。
+func (cfg *apiConfig) handlerChirpsLike(w http.ResponseWriter, r *http.Request) {
+	cfg.handleLike(w, r, cfg.DB.LikeChirp)
+}
+
+// handlerChirpsUnlike 取消点赞，没有赞过也不会出错。返回取消后的 chirp。
+func (cfg *apiConfig) handlerChirpsUnlike(w http.ResponseWriter, r *http.Request) {
+	cfg.handleLike(w, r, cfg.DB.UnlikeChirp)
+}
+
+func (cfg *apiConfig) handleLike(w http.ResponseWriter, r *http.Request, update func(chirpID, userID int) (database.Chirp, error)) {
+	chirpIDString := r.PathValue("chirpID")
+	chirpID, err := strconv.Atoi(chirpIDString)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid chirp ID")
+		return
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT")
+		return
+	}
+	subject, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT")
+		return
+	}
+	userID, err := strconv.Atoi(subject)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't parse user ID")
+		return
+	}
+
+	dbChirp, err := update(chirpID, userID)
+	if err != nil {
+		if errors.Is(err, database.ErrNotExist) {
+			respondWithError(w, http.StatusNotFound, "Couldn't get chirp")
+			return
+		}
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update like")
+		return
+	}
+
+	chirp := chirpFromDB(dbChirp)
+	err = cfg.setLikedByMe(userID, []*Chirp{&chirp})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve likes")
+		return
+	}
+	err = cfg.setPollVotes(userID, []*Chirp{&chirp})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve poll votes")
+		return
+	}
+	err = cfg.resolveEmbedded([]*Chirp{&chirp})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve quoted chirps")
+		return
+	}
+	respondWithJSON(w, http.StatusOK, chirp)
+}
+
+// handlerUserLikesGet 返回用户赞过的 chirp，最近赞的在前
+func (cfg *apiConfig) handlerUserLikesGet(w http.ResponseWriter, r *http.Request) {
+	userIDString := r.PathValue("userID")
+	userID, err := strconv.Atoi(userIDString)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid user ID")
+		return
+	}
+
+	viewerID, err := cfg.viewerID(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT")
+		return
+	}
+
+	dbChirps, err := cfg.DB.ListLikedChirps(userID)
+	if err != nil {
+		if errors.Is(err, database.ErrNotExist) {
+			respondWithError(w, http.StatusNotFound, "Couldn't find user")
+			return
+		}
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve chirps")
+		return
+	}
+
+	chirps := []Chirp{}
+	for _, dbChirp := range dbChirps {
+		chirps = append(chirps, chirpFromDB(dbChirp))
+	}
+	err = cfg.setLikedByMe(viewerID, chirpPointers(chirps))
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve likes")
+		return
+	}
+	err = cfg.setPollVotes(viewerID, chirpPointers(chirps))
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve poll votes")
+		return
+	}
+	err = cfg.resolveEmbedded(chirpPointers(chirps))
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve quoted chirps")
+		return
+	}
+	respondWithJSON(w, http.StatusOK, chirps)
+}
@@ -66,11 +66,7 @@ func (cfg *apiConfig) handlerLogin(w http.ResponseWriter, r *http.Request) {
 
 
 	respondWithJSON(w, http.StatusOK, response{
-		User: User{
-			ID:    user.ID,
-			Email: user.Email,
-			IsChirpyRed: user.IsChirpyRed,
-		},
+		User: userFromDB(user),
 		Token: accessToken,
 		RefreshToken: refreshToken,
 	})
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Grey-1011/go-server/internal/auth"
+	"github.com/Grey-1011/go-server/internal/database"
+	"github.com/Grey-1011/go-server/internal/media"
+)
+
+// 一个 chirp 最多附加的媒体数量
+const maxChirpMedia = 4
+
+// 上传请求中除文件之外的部分（multipart 边界和头）允许的大小
+const maxMediaFormOverhead = 64 << 10
+
+// Media 是上传后返回的媒体，以及 chirp 中附加的媒体
+type Media struct {
+	ID          int        `json:"id"`
+	URL         string     `json:"url"`
+	ContentType string     `json:"content_type"`
+	Size        int64      `json:"size"`
+	Width       int        `json:"width"`
+	Height      int        `json:"height"`
+	CreatedAt   *time.Time `json:"created_at,omitempty"` // 只在上传的响应中有
+}
+
+// mediaURL 返回媒体文件的地址，文件按内容寻址，所以地址不会指向不同的内容
+func mediaURL(hash string) string {
+	return "/media/" + hash
+}
+
+// mediaFromDB 把 chirp 中附加的媒体转换为 API 响应
+func mediaFromDB(m database.ChirpMedia) Media {
+	return Media{
+		ID:          m.ID,
+		URL:         mediaURL(m.Hash),
+		ContentType: m.ContentType,
+		Size:        m.Size,
+		Width:       m.Width,
+		Height:      m.Height,
+	}
+}
+
+// ==== 上传媒体 ====
+/*
+handlerMediaUpload 接收 multipart/form-data 中名为 file 的文件：
+1) 请求体的大小限制为 cfg.media.MaxSize() 加上表单的开销，超过时返回 413。
+2) 类型由内容嗅探决定，不是支持的图片时返回 415；无法读取宽高时返回 400。
+3) 文件按内容哈希保存，然后创建属于当前用户的媒体记录，返回 201。
+上传的媒体需要在 chirp 中通过 media_ids 附加，一直没有附加的会被后台任务清理。
+*/
+func (cfg *apiConfig) handlerMediaUpload(w http.ResponseWriter, r *http.Request) {
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT")
+		return
+	}
+	subject, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT")
+		return
+	}
+	userID, err := strconv.Atoi(subject)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't parse user ID")
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, cfg.media.MaxSize()+maxMediaFormOverhead)
+	reader, err := r.MultipartReader()
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Expected a multipart/form-data request")
+		return
+	}
+
+	var maxBytesErr *http.MaxBytesError
+	for {
+		part, err := reader.NextPart()
+		if errors.Is(err, io.EOF) {
+			respondWithError(w, http.StatusBadRequest, "Couldn't find file in form")
+			return
+		}
+		if errors.As(err, &maxBytesErr) {
+			respondWithError(w, http.StatusRequestEntityTooLarge, "File is too large")
+			return
+		}
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Couldn't read form")
+			return
+		}
+		if part.FormName() != "file" {
+			continue
+		}
+
+		dbMedia := database.Media{}
+		_, err = cfg.media.Put(part, func(blob media.Blob) error {
+			dbMedia, err = cfg.DB.CreateMedia(database.Media{
+				UserID:      userID,
+				Hash:        blob.Hash,
+				ContentType: blob.ContentType,
+				Size:        blob.Size,
+				Width:       blob.Width,
+				Height:      blob.Height,
+			})
+			return err
+		})
+		switch {
+		case errors.Is(err, media.ErrTooLarge), errors.As(err, &maxBytesErr):
+			respondWithError(w, http.StatusRequestEntityTooLarge, "File is too large")
+			return
+		case errors.Is(err, media.ErrUnsupportedType):
+			respondWithError(w, http.StatusUnsupportedMediaType, "Unsupported media type")
+			return
+		case errors.Is(err, media.ErrInvalidImage):
+			respondWithError(w, http.StatusBadRequest, "Couldn't decode image")
+			return
+		case err != nil:
+			respondWithError(w, http.StatusInternalServerError, "Couldn't save media")
+			return
+		}
+
+		resp := Media{
+			ID:          dbMedia.ID,
+			URL:         mediaURL(dbMedia.Hash),
+			ContentType: dbMedia.ContentType,
+			Size:        dbMedia.Size,
+			Width:       dbMedia.Width,
+			Height:      dbMedia.Height,
+			CreatedAt:   &dbMedia.CreatedAt,
+		}
+		respondWithJSON(w, http.StatusCreated, resp)
+		return
+	}
+}
+
+// ==== 读取媒体文件 ====
+/*
+handlerMediaGet 返回 hash 对应的文件。
+文件内容不会变化，所以响应可以被永久缓存（immutable），ETag 就是哈希，If-None-Match 命中时返回 304。
+类型由 http.ServeContent 嗅探，并禁止浏览器再次猜测（nosniff）。
+*/
+func (cfg *apiConfig) handlerMediaGet(w http.ResponseWriter, r *http.Request) {
+	hash := r.PathValue("hash")
+	f, err := cfg.media.Open(hash)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't find media")
+		return
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't read media")
+		return
+	}
+
+	w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	w.Header().Set("ETag", `"`+hash+`"`)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	http.ServeContent(w, r, "", info.ModTime(), f)
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/Grey-1011/go-server/internal/auth"
+	"github.com/Grey-1011/go-server/internal/moderation"
+)
+
+// authorizeAdmin 检查请求头中的 "Authorization: ApiKey <ADMIN_API_KEY>"，失败时写入错误响应并返回 false
+func (cfg *apiConfig) authorizeAdmin(w http.ResponseWriter, r *http.Request) bool {
+	if cfg.adminKey == "" {
+		respondWithError(w, http.StatusForbidden, "Admin API is disabled")
+		return false
+	}
+	apiKey, err := auth.GetAPIKey(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find api key")
+		return false
+	}
+	// 用常数时间比较，避免通过响应时间猜出密钥
+	if subtle.ConstantTimeCompare([]byte(apiKey), []byte(cfg.adminKey)) != 1 {
+		respondWithError(w, http.StatusUnauthorized, "API key is invalid")
+		return false
+	}
+	return true
+}
+
+func (cfg *apiConfig) handlerModerationWordsList(w http.ResponseWriter, r *http.Request) {
+	if !cfg.authorizeAdmin(w, r) {
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, cfg.moderationWords.Entries())
+}
+
+// handlerModerationWordsSet 添加一个敏感词或修改它的策略，policy 默认为 mask
+func (cfg *apiConfig) handlerModerationWordsSet(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Word   string            `json:"word"`
+		Policy moderation.Action `json:"policy"`
+	}
+
+	if !cfg.authorizeAdmin(w, r) {
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters")
+		return
+	}
+	if params.Policy == "" {
+		params.Policy = moderation.ActionMask
+	}
+
+	entry, err := cfg.moderationWords.Set(params.Word, params.Policy)
+	if err != nil {
+		if errors.Is(err, moderation.ErrInvalidWord) {
+			respondWithError(w, http.StatusBadRequest, "Invalid word")
+			return
+		}
+		if errors.Is(err, moderation.ErrInvalidPolicy) {
+			respondWithError(w, http.StatusBadRequest, "Invalid policy")
+			return
+		}
+		respondWithError(w, http.StatusInternalServerError, "Couldn't save word list")
+		return
+	}
+
+	respondWithJSON(w, http.StatusCreated, entry)
+}
+
+func (cfg *apiConfig) handlerModerationWordsDelete(w http.ResponseWriter, r *http.Request) {
+	if !cfg.authorizeAdmin(w, r) {
+		return
+	}
+
+	err := cfg.moderationWords.Remove(r.PathValue("word"))
+	if err != nil {
+		if errors.Is(err, moderation.ErrWordNotFound) {
+			respondWithError(w, http.StatusNotFound, "Couldn't find word")
+			return
+		}
+		respondWithError(w, http.StatusInternalServerError, "Couldn't save word list")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Grey-1011/go-server/internal/auth"
+	"github.com/Grey-1011/go-server/internal/database"
+)
+
+// Notification 是发给当前用户的一条通知
+type Notification struct {
+	ID        int       `json:"id"`
+	Type      string    `json:"type"`     // 目前只有 "mention"
+	ActorID   int       `json:"actor_id"` // 提及你的用户
+	ChirpID   int       `json:"chirp_id"`
+	CreatedAt time.Time `json:"created_at"`
+	Read      bool      `json:"read"`
+}
+
+/*
+handlerNotificationsGet 返回当前用户的通知，最新的在前，支持的查询参数：
+- limit: 最多返回的数量，默认 20，最大 100
+- before: 只返回 ID 小于 before 的通知，把上一页最后一条通知的 ID 传入即可获取下一页
+*/
+func (cfg *apiConfig) handlerNotificationsGet(w http.ResponseWriter, r *http.Request) {
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT")
+		return
+	}
+	subject, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT")
+		return
+	}
+	userID, err := strconv.Atoi(subject)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't parse user ID")
+		return
+	}
+
+	query := r.URL.Query()
+	q := database.NotificationQuery{
+		UserID: userID,
+		Limit:  defaultChirpsLimit,
+	}
+	limitString := query.Get("limit")
+	if limitString != "" {
+		limit, err := strconv.Atoi(limitString)
+		if err != nil || limit < 1 {
+			respondWithError(w, http.StatusBadRequest, "Invalid limit")
+			return
+		}
+		q.Limit = min(limit, maxChirpsLimit)
+	}
+	beforeString := query.Get("before")
+	if beforeString != "" {
+		before, err := strconv.Atoi(beforeString)
+		if err != nil || before < 1 {
+			respondWithError(w, http.StatusBadRequest, "Invalid before")
+			return
+		}
+		q.BeforeID = before
+	}
+
+	dbNotifications, err := cfg.DB.ListNotifications(q)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve notifications")
+		return
+	}
+
+	notifications := []Notification{}
+	for _, notification := range dbNotifications {
+		notifications = append(notifications, Notification{
+			ID:        notification.ID,
+			Type:      notification.Type,
+			ActorID:   notification.ActorID,
+			ChirpID:   notification.ChirpID,
+			CreatedAt: notification.CreatedAt,
+			Read:      notification.ReadAt != nil,
+		})
+	}
+	respondWithJSON(w, http.StatusOK, notifications)
+}
+
+// handlerNotificationsRead 把当前用户的所有通知标记为已读
+func (cfg *apiConfig) handlerNotificationsRead(w http.ResponseWriter, r *http.Request) {
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT")
+		return
+	}
+	subject, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT")
+		return
+	}
+	userID, err := strconv.Atoi(subject)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't parse user ID")
+		return
+	}
+
+	err = cfg.DB.MarkNotificationsRead(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update notifications")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
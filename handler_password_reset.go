@@ -0,0 +1,126 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/Grey-1011/go-server/internal/auth"
+	"github.com/Grey-1011/go-server/internal/database"
+	"github.com/Grey-1011/go-server/internal/mailer"
+)
+
+// 重置密码的令牌的有效期
+const resetPasswordTokenTTL = time.Hour
+
+// ==== 忘记密码 ====
+/*
+handlerPasswordForgot 向请求体中的邮箱发送重置密码的邮件：
+1) 无论邮箱是否属于某个用户，都返回相同的 202 响应，不能用来探测邮箱是否注册过。
+2) 查找用户和发送邮件在后台进行，响应时间同样不会暴露邮箱是否存在。
+3) 令牌的有效期为 resetPasswordTokenTTL，同一个用户之前发出的重置令牌同时失效。
+*/
+func (cfg *apiConfig) handlerPasswordForgot(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Email string `json:"email"`
+	}
+
+	params := parameters{}
+	err := json.NewDecoder(r.Body).Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters")
+		return
+	}
+	if !validEmail(params.Email) {
+		respondWithError(w, http.StatusBadRequest, "Invalid email address")
+		return
+	}
+
+	go func() {
+		err := cfg.sendPasswordReset(params.Email)
+		if err != nil && !errors.Is(err, database.ErrNotExist) {
+			log.Printf("Couldn't send password reset email: %s", err)
+		}
+	}()
+
+	respondWithJSON(w, http.StatusAccepted, map[string]string{
+		"message": "If an account with this email exists, a password reset email has been sent",
+	})
+}
+
+// sendPasswordReset 为邮箱是 email 的用户生成重置密码的令牌并发送到 email，用户不存在时返回 ErrNotExist
+func (cfg *apiConfig) sendPasswordReset(email string) error {
+	user, err := cfg.DB.GetUserByEmail(email)
+	if err != nil {
+		return err
+	}
+
+	token, err := auth.MakeRefreshToken()
+	if err != nil {
+		return err
+	}
+	err = cfg.DB.CreateUserToken(database.UserToken{
+		Hash:      auth.HashToken(token),
+		UserID:    user.ID,
+		Purpose:   database.TokenPurposeResetPassword,
+		Email:     user.Email,
+		ExpiresAt: time.Now().Add(resetPasswordTokenTTL).UTC(),
+	})
+	if err != nil {
+		return err
+	}
+
+	return cfg.mailer.Send(mailer.Message{
+		To:      user.Email,
+		Subject: "Reset your Chirpy password",
+		Body: fmt.Sprintf(
+			"Use this code to choose a new password:\n\n%s\n\nSend it to POST /api/password/reset as {\"token\": \"<code>\", \"password\": \"<new password>\"}. It expires in %s.\n\nIf you didn't ask to reset your password, you can ignore this email.\n",
+			token, resetPasswordTokenTTL,
+		),
+	})
+}
+
+// ==== 重置密码 ====
+/*
+handlerPasswordReset 使用邮件中的令牌把密码改为请求体中的 password，成功时返回 204：
+1) 令牌只能使用一次，无效、已使用或已过期时返回 400。
+2) 用户所有的 refresh token 被撤销，其他设备需要重新登录；已经签发的 JWT 在过期之前仍然有效。
+*/
+func (cfg *apiConfig) handlerPasswordReset(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Token    string `json:"token"`
+		Password string `json:"password"`
+	}
+
+	params := parameters{}
+	err := json.NewDecoder(r.Body).Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters")
+		return
+	}
+	if params.Password == "" {
+		respondWithError(w, http.StatusBadRequest, "Missing password")
+		return
+	}
+
+	hashedPassword, err := auth.HashPassword(params.Password)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't hash password")
+		return
+	}
+
+	_, err = cfg.DB.ResetPassword(auth.HashToken(params.Token), hashedPassword, time.Now())
+	if err != nil {
+		if errors.Is(err, database.ErrNotExist) {
+			respondWithError(w, http.StatusBadRequest, "Invalid or expired token")
+			return
+		}
+		respondWithError(w, http.StatusInternalServerError, "Couldn't reset password")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
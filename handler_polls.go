@@ -0,0 +1,233 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Grey-1011/go-server/internal/auth"
+	"github.com/Grey-1011/go-server/internal/database"
+	"github.com/Grey-1011/go-server/internal/moderation"
+)
+
+// 投票的限制
+const (
+	minPollOptions      = 2
+	maxPollOptions      = 4
+	maxPollOptionLength = 25 // 按 Unicode 字符计算
+	minPollDuration     = 5 * time.Minute
+	maxPollDuration     = 7 * 24 * time.Hour
+)
+
+// Poll 是 chirp 中的投票。
+// 结果（每个选项的 votes 和 total_votes）只有在当前用户投过票或投票截止后才会返回。
+type Poll struct {
+	Options    []PollOption `json:"options"`
+	ClosesAt   time.Time    `json:"closes_at"`
+	Closed     bool         `json:"closed"`
+	TotalVotes *int         `json:"total_votes,omitempty"`
+	// 当前用户选择的选项（从 0 开始），没有投票时没有这个字段
+	MyVote *int `json:"my_vote,omitempty"`
+
+	votes []int // 每个选项的票数，由 revealResults 公开
+}
+
+type PollOption struct {
+	Text  string `json:"text"`
+	Votes *int   `json:"votes,omitempty"`
+}
+
+// pollFromDB 把数据库中的投票转换为 API 响应，已经截止的投票直接公开结果
+func pollFromDB(dbPoll *database.ChirpPoll) *Poll {
+	if dbPoll == nil {
+		return nil
+	}
+	poll := &Poll{
+		Options:  []PollOption{},
+		ClosesAt: dbPoll.ClosesAt,
+		Closed:   dbPoll.Closed(time.Now()),
+		votes:    make([]int, len(dbPoll.Options)),
+	}
+	for _, option := range dbPoll.Options {
+		poll.Options = append(poll.Options, PollOption{Text: option})
+	}
+	copy(poll.votes, dbPoll.Votes)
+	if poll.Closed {
+		poll.revealResults()
+	}
+	return poll
+}
+
+// revealResults 在响应中公开每个选项的票数和总票数
+func (poll *Poll) revealResults() {
+	total := 0
+	for i := range poll.Options {
+		votes := poll.votes[i]
+		poll.Options[i].Votes = &votes
+		total += votes
+	}
+	poll.TotalVotes = &total
+}
+
+// setPollVotes 为当前用户设置 chirps 中投票的 my_vote，并公开已投票的投票的结果，viewerID 为 0 时不设置
+func (cfg *apiConfig) setPollVotes(viewerID int, chirps []*Chirp) error {
+	if viewerID == 0 {
+		return nil
+	}
+
+	ids := []int{}
+	for _, chirp := range chirps {
+		if chirp.Poll != nil {
+			ids = append(ids, chirp.ID)
+		}
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+	votes, err := cfg.DB.PollVotesByUser(viewerID, ids)
+	if err != nil {
+		return err
+	}
+	for _, chirp := range chirps {
+		option, ok := votes[chirp.ID]
+		if chirp.Poll == nil || !ok {
+			continue
+		}
+		chirp.Poll.MyVote = &option
+		chirp.Poll.revealResults()
+	}
+	return nil
+}
+
+// pollParameters 是创建 chirp 时的投票参数
+type pollParameters struct {
+	Options  []string  `json:"options"`
+	ClosesAt time.Time `json:"closes_at"`
+}
+
+// ==== 校验投票 ====
+/*
+validatePoll 检查创建 chirp 时提交的投票，返回要保存的投票。被拒绝时返回的错误可以直接作为响应消息。
+1) 选项有 minPollOptions 到 maxPollOptions 个，去掉首尾空白后不能为空、不能超过 maxPollOptionLength 个字符、不能重复。
+2) 选项和正文一样经过内容审核，被拒绝时整个 chirp 被拒绝。
+3) 截止时间在 start（chirp 发布的时间）之后的 minPollDuration 到 maxPollDuration 之间。
+*/
+func (cfg *apiConfig) validatePoll(params pollParameters, start time.Time) (*database.ChirpPoll, error) {
+	if len(params.Options) < minPollOptions || len(params.Options) > maxPollOptions {
+		return nil, fmt.Errorf("A poll must have %d to %d options", minPollOptions, maxPollOptions)
+	}
+
+	options := []string{}
+	seen := map[string]bool{}
+	for _, option := range params.Options {
+		option = strings.TrimSpace(option)
+		if option == "" {
+			return nil, errors.New("Poll options can't be empty")
+		}
+		if len([]rune(option)) > maxPollOptionLength {
+			return nil, fmt.Errorf("Poll options can be at most %d characters", maxPollOptionLength)
+		}
+		if seen[strings.ToLower(option)] {
+			return nil, errors.New("Poll options must be unique")
+		}
+		seen[strings.ToLower(option)] = true
+
+		decision := cfg.moderation.Moderate(option)
+		if decision.Action == moderation.ActionReject {
+			return nil, errors.New(decision.Reason)
+		}
+		options = append(options, decision.Body)
+	}
+
+	if params.ClosesAt.Before(start.Add(minPollDuration)) || params.ClosesAt.After(start.Add(maxPollDuration)) {
+		return nil, fmt.Errorf("A poll must close between %s and %s after the chirp is published", minPollDuration, maxPollDuration)
+	}
+
+	return &database.ChirpPoll{
+		Options:  options,
+		ClosesAt: params.ClosesAt.UTC(),
+	}, nil
+}
+
+// ==== 投票 ====
+/*
+handlerPollVote 为当前用户投票，请求体是 {"option": 选项的序号（从 0 开始）}。
+每个用户在每个投票中只能投一次，不能修改。返回投票后的 chirp，其中包含投票结果。
+*/
+func (cfg *apiConfig) handlerPollVote(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Option *int `json:"option"`
+	}
+
+	chirpID, err := strconv.Atoi(r.PathValue("chirpID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid chirp ID")
+		return
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT")
+		return
+	}
+	subject, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT")
+		return
+	}
+	userID, err := strconv.Atoi(subject)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't parse user ID")
+		return
+	}
+
+	params := parameters{}
+	err = json.NewDecoder(r.Body).Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters")
+		return
+	}
+	if params.Option == nil {
+		respondWithError(w, http.StatusBadRequest, "Missing option")
+		return
+	}
+
+	dbChirp, err := cfg.DB.VotePoll(chirpID, userID, *params.Option)
+	if err != nil {
+		switch {
+		case errors.Is(err, database.ErrNotExist):
+			respondWithError(w, http.StatusNotFound, "Couldn't find poll")
+		case errors.Is(err, database.ErrInvalidPollOption):
+			respondWithError(w, http.StatusBadRequest, "Invalid option")
+		case errors.Is(err, database.ErrPollClosed):
+			respondWithError(w, http.StatusConflict, "Poll is closed")
+		case errors.Is(err, database.ErrAlreadyExists):
+			respondWithError(w, http.StatusConflict, "You have already voted in this poll")
+		default:
+			respondWithError(w, http.StatusInternalServerError, "Couldn't save vote")
+		}
+		return
+	}
+
+	chirp := chirpFromDB(dbChirp)
+	err = cfg.setLikedByMe(userID, []*Chirp{&chirp})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve likes")
+		return
+	}
+	err = cfg.setPollVotes(userID, []*Chirp{&chirp})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve poll votes")
+		return
+	}
+	err = cfg.resolveEmbedded([]*Chirp{&chirp})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve quoted chirps")
+		return
+	}
+	respondWithJSON(w, http.StatusCreated, chirp)
+}
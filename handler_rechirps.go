@@ -0,0 +1,154 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Grey-1011/go-server/internal/auth"
+	"github.com/Grey-1011/go-server/internal/database"
+)
+
+// EmbeddedChirp 是转发或引用中的原始 chirp。
+// 原始 chirp 被删除（在回收站中或已永久删除）后只保留 ID，并且 deleted 为 true。
+type EmbeddedChirp struct {
+	ID        int          `json:"id"`
+	Body      string       `json:"body,omitempty"`
+	AuthorID  int          `json:"author_id,omitempty"`
+	Author    *ChirpAuthor `json:"author,omitempty"`
+	CreatedAt *time.Time   `json:"created_at,omitempty"`
+	EditedAt  *time.Time   `json:"edited_at,omitempty"`
+	Deleted   bool         `json:"deleted,omitempty"`
+}
+
+// resolveEmbedded 批量读取 chirps 中被转发、被引用的 chirp，填充它们的作者和正文
+func (cfg *apiConfig) resolveEmbedded(chirps []*Chirp) error {
+	embedded := []*EmbeddedChirp{}
+	for _, chirp := range chirps {
+		if chirp.RechirpOf != nil {
+			embedded = append(embedded, chirp.RechirpOf)
+		}
+		if chirp.QuoteOf != nil {
+			embedded = append(embedded, chirp.QuoteOf)
+		}
+	}
+	if len(embedded) == 0 {
+		return nil
+	}
+
+	ids := make([]int, 0, len(embedded))
+	for _, e := range embedded {
+		ids = append(ids, e.ID)
+	}
+	originals, err := cfg.DB.GetChirpsByID(ids)
+	if err != nil {
+		return err
+	}
+	for _, e := range embedded {
+		original, ok := originals[e.ID]
+		if !ok || original.DeletedAt != nil {
+			*e = EmbeddedChirp{ID: e.ID, Deleted: true}
+			continue
+		}
+		author := chirpAuthorFromDB(original.AuthorID, original.Author)
+		*e = EmbeddedChirp{
+			ID:        original.ID,
+			Body:      original.Body,
+			AuthorID:  original.AuthorID,
+			Author:    &author,
+			CreatedAt: &original.CreatedAt,
+			EditedAt:  original.EditedAt,
+		}
+	}
+	return nil
+}
+
+// handlerChirpsRechirp 转发 chirp。第一次转发返回 201 和新的转发，已经转发过时返回 200 和已有的转发。
+func (cfg *apiConfig) handlerChirpsRechirp(w http.ResponseWriter, r *http.Request) {
+	chirpIDString := r.PathValue("chirpID")
+	chirpID, err := strconv.Atoi(chirpIDString)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid chirp ID")
+		return
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT")
+		return
+	}
+	subject, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT")
+		return
+	}
+	userID, err := strconv.Atoi(subject)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't parse user ID")
+		return
+	}
+	if !cfg.requireVerified(w, userID) {
+		return
+	}
+
+	dbChirp, created, err := cfg.DB.Rechirp(chirpID, userID)
+	if err != nil {
+		if errors.Is(err, database.ErrNotExist) {
+			respondWithError(w, http.StatusNotFound, "Couldn't get chirp")
+			return
+		}
+		respondWithError(w, http.StatusInternalServerError, "Couldn't rechirp")
+		return
+	}
+
+	chirp := chirpFromDB(dbChirp)
+	err = cfg.resolveEmbedded([]*Chirp{&chirp})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve quoted chirps")
+		return
+	}
+	status := http.StatusOK
+	if created {
+		status = http.StatusCreated
+	}
+	respondWithJSON(w, status, chirp)
+}
+
+// handlerChirpsUnrechirp 把当前用户对 chirp 的转发移入回收站
+func (cfg *apiConfig) handlerChirpsUnrechirp(w http.ResponseWriter, r *http.Request) {
+	chirpIDString := r.PathValue("chirpID")
+	chirpID, err := strconv.Atoi(chirpIDString)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid chirp ID")
+		return
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT")
+		return
+	}
+	subject, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT")
+		return
+	}
+	userID, err := strconv.Atoi(subject)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't parse user ID")
+		return
+	}
+
+	err = cfg.DB.Unrechirp(chirpID, userID)
+	if err != nil {
+		if errors.Is(err, database.ErrNotExist) {
+			respondWithError(w, http.StatusNotFound, "Couldn't find rechirp")
+			return
+		}
+		respondWithError(w, http.StatusInternalServerError, "Couldn't delete rechirp")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Grey-1011/go-server/internal/database"
+	"github.com/Grey-1011/go-server/internal/entities"
+)
+
+const (
+	defaultTrendingWindow = 24 * time.Hour
+	maxTrendingWindow     = 7 * 24 * time.Hour
+	defaultTrendingLimit  = 10
+)
+
+/*
+handlerTagChirps 返回使用话题 {tag} 的 chirp，按创建时间降序排列。
+{tag} 不区分大小写，可以带开头的 #（需要编码为 %23）。
+分页方式和 GET /api/timeline 相同，响应总是 {"chirps": [...], "next_cursor": "..."}。
+*/
+func (cfg *apiConfig) handlerTagChirps(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		Chirps     []Chirp `json:"chirps"`
+		NextCursor string  `json:"next_cursor,omitempty"`
+	}
+
+	tag := entities.NormalizeTag(r.PathValue("tag"))
+	if !entities.ValidTag(tag) {
+		respondWithError(w, http.StatusBadRequest, "Invalid tag")
+		return
+	}
+
+	viewerID, err := cfg.viewerID(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT")
+		return
+	}
+
+	query := r.URL.Query()
+	limit := defaultChirpsLimit
+	limitString := query.Get("limit")
+	if limitString != "" {
+		limit, err = strconv.Atoi(limitString)
+		if err != nil || limit < 1 {
+			respondWithError(w, http.StatusBadRequest, "Invalid limit")
+			return
+		}
+		limit = min(limit, maxChirpsLimit)
+	}
+
+	cq := database.ChirpQuery{SortBy: database.ChirpSortCreatedAt}
+	cursor := query.Get("cursor")
+	if cursor != "" {
+		err = decodeChirpCursor(cursor, &cq)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid cursor")
+			return
+		}
+	}
+
+	// 多取一条，用来判断是否还有下一页
+	q := database.TagQuery{
+		Tag:            tag,
+		AfterID:        cq.AfterID,
+		AfterCreatedAt: cq.AfterCreatedAt,
+		Limit:          limit + 1,
+	}
+	dbChirps, err := cfg.DB.ListTagChirps(q)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve chirps")
+		return
+	}
+
+	chirps := []Chirp{}
+	for _, dbChirp := range dbChirps {
+		chirps = append(chirps, chirpFromDB(dbChirp))
+	}
+	err = cfg.setLikedByMe(viewerID, chirpPointers(chirps))
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve likes")
+		return
+	}
+	err = cfg.setPollVotes(viewerID, chirpPointers(chirps))
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve poll votes")
+		return
+	}
+	err = cfg.resolveEmbedded(chirpPointers(chirps))
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve quoted chirps")
+		return
+	}
+
+	resp := response{
+		Chirps: chirps,
+	}
+	if len(chirps) == q.Limit {
+		resp.Chirps = chirps[:q.Limit-1]
+		resp.NextCursor = encodeChirpCursor(dbChirps[q.Limit-2], database.ChirpSortCreatedAt)
+	}
+	respondWithJSON(w, http.StatusOK, resp)
+}
+
+/*
+handlerTrendingTags 返回最近一段时间内使用最多的话题，支持的查询参数：
+- window: 时间窗口，Go 的 duration 格式（例如 1h、24h），默认 24h，最大 168h
+- limit: 最多返回的数量，默认 10，最大 100
+窗口是滑动的：统计的是 [现在 - window, 现在) 之间创建的、没有被删除的 chirp。
+*/
+func (cfg *apiConfig) handlerTrendingTags(w http.ResponseWriter, r *http.Request) {
+	type trendingTag struct {
+		Tag   string `json:"tag"`
+		Count int    `json:"count"`
+	}
+
+	query := r.URL.Query()
+	window := defaultTrendingWindow
+	windowString := query.Get("window")
+	if windowString != "" {
+		var err error
+		window, err = time.ParseDuration(windowString)
+		if err != nil || window <= 0 || window > maxTrendingWindow {
+			respondWithError(w, http.StatusBadRequest, "Invalid window")
+			return
+		}
+	}
+
+	limit := defaultTrendingLimit
+	limitString := query.Get("limit")
+	if limitString != "" {
+		var err error
+		limit, err = strconv.Atoi(limitString)
+		if err != nil || limit < 1 {
+			respondWithError(w, http.StatusBadRequest, "Invalid limit")
+			return
+		}
+		limit = min(limit, maxChirpsLimit)
+	}
+
+	counts, err := cfg.DB.TrendingTags(time.Now().Add(-window), limit)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve trending tags")
+		return
+	}
+
+	tags := []trendingTag{}
+	for _, count := range counts {
+		tags = append(tags, trendingTag{
+			Tag:   count.Tag,
+			Count: count.Count,
+		})
+	}
+	respondWithJSON(w, http.StatusOK, tags)
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/Grey-1011/go-server/internal/auth"
+	"github.com/Grey-1011/go-server/internal/database"
+)
+
+/*
+handlerTimeline 返回当前用户关注的人发的 chirp，按创建时间降序排列。
+分页方式和 GET /api/chirps 相同（limit 默认 20，最大 100；cursor 为上一页的 next_cursor），
+响应总是 {"chirps": [...], "next_cursor": "..."}。
+*/
+func (cfg *apiConfig) handlerTimeline(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		Chirps     []Chirp `json:"chirps"`
+		NextCursor string  `json:"next_cursor,omitempty"`
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT")
+		return
+	}
+	subject, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT")
+		return
+	}
+	userID, err := strconv.Atoi(subject)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't parse user ID")
+		return
+	}
+
+	query := r.URL.Query()
+	limit := defaultChirpsLimit
+	limitString := query.Get("limit")
+	if limitString != "" {
+		limit, err = strconv.Atoi(limitString)
+		if err != nil || limit < 1 {
+			respondWithError(w, http.StatusBadRequest, "Invalid limit")
+			return
+		}
+		limit = min(limit, maxChirpsLimit)
+	}
+
+	// 时间线的 cursor 和按 created_at 排序的 GET /api/chirps 使用同一种格式
+	cq := database.ChirpQuery{SortBy: database.ChirpSortCreatedAt}
+	cursor := query.Get("cursor")
+	if cursor != "" {
+		err = decodeChirpCursor(cursor, &cq)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid cursor")
+			return
+		}
+	}
+
+	// 多取一条，用来判断是否还有下一页
+	q := database.TimelineQuery{
+		UserID:         userID,
+		AfterID:        cq.AfterID,
+		AfterCreatedAt: cq.AfterCreatedAt,
+		Limit:          limit + 1,
+	}
+	dbChirps, err := cfg.DB.Timeline(q)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve timeline")
+		return
+	}
+
+	chirps := []Chirp{}
+	for _, dbChirp := range dbChirps {
+		chirps = append(chirps, chirpFromDB(dbChirp))
+	}
+	err = cfg.setLikedByMe(userID, chirpPointers(chirps))
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve likes")
+		return
+	}
+	err = cfg.setPollVotes(userID, chirpPointers(chirps))
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve poll votes")
+		return
+	}
+	err = cfg.resolveEmbedded(chirpPointers(chirps))
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve quoted chirps")
+		return
+	}
+
+	resp := response{
+		Chirps: chirps,
+	}
+	if len(chirps) == q.Limit {
+		resp.Chirps = chirps[:q.Limit-1]
+		resp.NextCursor = encodeChirpCursor(dbChirps[q.Limit-2], database.ChirpSortCreatedAt)
+	}
+	respondWithJSON(w, http.StatusOK, resp)
+}
@@ -3,19 +3,49 @@ package main
 import (
 	"encoding/json"
 	"errors"
+	"log"
 	"net/http"
+	"time"
 
 	"github.com/Grey-1011/go-server/internal/auth"
 	"github.com/Grey-1011/go-server/internal/database"
 )
 
 type User struct {
-	ID          int    `json:"id"`
-	Email       string `json:"email"`
-	Password    string `json:"-"` // Note: "-" :
-	IsChirpyRed bool   `json:"is_chirpy_red"`
+	ID          int       `json:"id"`
+	Email       string    `json:"email"`
+	Handle      string    `json:"handle"`
+	Password    string    `json:"-"` // Note: "-" :
+	IsChirpyRed bool      `json:"is_chirpy_red"`
+	Verified    bool      `json:"verified"` // 邮箱是否已经验证
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+	// 公开的资料
+	DisplayName string `json:"display_name"`
+	Bio         string `json:"bio"`
+	AvatarURL   string `json:"avatar_url,omitempty"`
+	// 申请删除账号后，账号被永久删除的时间
+	DeleteAt *time.Time `json:"delete_at,omitempty"`
 }
 
+// userFromDB 把数据库中的用户转换为 API 响应（不包含密码）
+func userFromDB(user database.User) User {
+	return User{
+		ID:          user.ID,
+		Email:       user.Email,
+		Handle:      user.Handle,
+		IsChirpyRed: user.IsChirpyRed,
+		Verified:    user.Verified,
+		CreatedAt:   user.CreatedAt,
+		UpdatedAt:   user.UpdatedAt,
+		DisplayName: user.DisplayName,
+		Bio:         user.Bio,
+		AvatarURL:   avatarURL(user.Avatar),
+		DeleteAt:    user.DeleteAt,
+	}
+}
+
+// handlerUsersCreate 注册新用户，并向邮箱发送验证邮件，验证之前不能发布 chirp
 func (cfg *apiConfig) handlerUsersCreate(w http.ResponseWriter, r *http.Request) {
 	type parameters struct {
 		Email    string `json:"email"`
@@ -32,6 +62,10 @@ func (cfg *apiConfig) handlerUsersCreate(w http.ResponseWriter, r *http.Request)
 		respondWithError(w, http.StatusInternalServerError, "Couldn't decode parameters")
 		return
 	}
+	if !validEmail(params.Email) {
+		respondWithError(w, http.StatusBadRequest, "Invalid email address")
+		return
+	}
 
 	hashPassword, err := auth.HashPassword(params.Password)
 	if err != nil {
@@ -50,11 +84,13 @@ func (cfg *apiConfig) handlerUsersCreate(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	// 发送失败时用户仍然创建成功，可以通过 POST /api/users/verify/resend 重新发送
+	err = cfg.sendEmailVerification(user.ID, user.Email)
+	if err != nil {
+		log.Printf("Couldn't send verification email to user %d: %s", user.ID, err)
+	}
+
 	respondWithJSON(w, http.StatusCreated, response{
-		User: User{
-			ID:    user.ID,
-			Email: user.Email,
-			IsChirpyRed:  user.IsChirpyRed,
-		},
+		User: userFromDB(user),
 	})
 }
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Grey-1011/go-server/internal/auth"
+	"github.com/Grey-1011/go-server/internal/database"
+)
+
+// ==== 删除账号 ====
+/*
+handlerUsersDelete 申请删除当前用户的账号，请求体中的 password 必须是当前的密码，错误时返回 401：
+1) 账号不会立即删除，而是在宽限期（ACCOUNT_DELETION_GRACE）结束后由后台任务永久删除，响应中的 delete_at 是删除的时间。
+2) 用户所有的 refresh token 被撤销。宽限期内仍然可以登录，并用 POST /api/users/restore 撤销申请。
+3) 已经申请过时返回 409。
+*/
+func (cfg *apiConfig) handlerUsersDelete(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Password string `json:"password"`
+	}
+
+	// 获取 Token
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT")
+		return
+	}
+	// 验证 Token
+	subject, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT")
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err = decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters")
+		return
+	}
+	if params.Password == "" {
+		respondWithError(w, http.StatusBadRequest, "Missing password")
+		return
+	}
+
+	userIDInt, err := strconv.Atoi(subject)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't parse user ID")
+		return
+	}
+
+	user, err := cfg.DB.GetUser(userIDInt)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't find user")
+		return
+	}
+	// 确认密码，避免拿到 JWT 的人删除账号
+	err = auth.CheckPasswordHash(params.Password, user.HashedPassword)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Invalid password")
+		return
+	}
+
+	user, err = cfg.DB.ScheduleUserDeletion(userIDInt, time.Now().Add(cfg.accountDeletionGrace))
+	if err != nil {
+		if errors.Is(err, database.ErrAlreadyExists) {
+			respondWithError(w, http.StatusConflict, "Account deletion is already scheduled")
+			return
+		}
+		respondWithError(w, http.StatusInternalServerError, "Couldn't schedule account deletion")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, userFromDB(user))
+}
+
+// handlerUsersRestore 在宽限期内撤销删除账号的申请，没有申请时返回 409
+func (cfg *apiConfig) handlerUsersRestore(w http.ResponseWriter, r *http.Request) {
+	// 获取 Token
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT")
+		return
+	}
+	// 验证 Token
+	subject, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT")
+		return
+	}
+
+	userIDInt, err := strconv.Atoi(subject)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't parse user ID")
+		return
+	}
+
+	user, err := cfg.DB.GetUser(userIDInt)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't find user")
+		return
+	}
+	if user.DeleteAt == nil {
+		respondWithError(w, http.StatusConflict, "Account deletion is not scheduled")
+		return
+	}
+
+	user, err = cfg.DB.CancelUserDeletion(userIDInt)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't cancel account deletion")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, userFromDB(user))
+}
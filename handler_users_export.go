@@ -0,0 +1,142 @@
+package main
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/Grey-1011/go-server/internal/auth"
+	"github.com/Grey-1011/go-server/internal/database"
+)
+
+// 导出的媒体文件的扩展名，按上传时检测到的类型
+var mediaExtensions = map[string]string{
+	"image/png":  ".png",
+	"image/jpeg": ".jpg",
+	"image/gif":  ".gif",
+}
+
+// ==== 导出账号数据 ====
+/*
+handlerUsersExport 把保存的与当前用户有关的所有数据打包成一个 zip 文件下载：
+1) account.json 是用户资料（和其他接口返回的一样，包括邮箱），其他 JSON 文件是数据库中的原始记录：
+   chirps（包括回收站中的）、chirp_revisions、likes、following、followers、notifications、
+   poll_votes、scheduled_chirps 和 media。
+2) 用户上传的媒体文件保存在 media/ 目录下，文件名是哈希加扩展名。
+数据库记录在一个快照中读取；开始写入响应之后出错只能记录日志，客户端会得到一个不完整的 zip。
+*/
+func (cfg *apiConfig) handlerUsersExport(w http.ResponseWriter, r *http.Request) {
+	// 获取 Token
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT")
+		return
+	}
+	// 验证 Token
+	subject, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT")
+		return
+	}
+
+	userIDInt, err := strconv.Atoi(subject)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't parse user ID")
+		return
+	}
+
+	export, err := cfg.DB.ExportUser(userIDInt)
+	if err != nil {
+		if errors.Is(err, database.ErrNotExist) {
+			respondWithError(w, http.StatusNotFound, "Couldn't find user")
+			return
+		}
+		respondWithError(w, http.StatusInternalServerError, "Couldn't export user data")
+		return
+	}
+
+	filename := fmt.Sprintf("chirpy-export-%s-%s.zip", export.User.Handle, time.Now().UTC().Format("2006-01-02"))
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`"`)
+	w.WriteHeader(http.StatusOK)
+
+	err = cfg.writeUserExport(w, export)
+	if err != nil {
+		log.Printf("Couldn't export data of user %d: %s", userIDInt, err)
+	}
+}
+
+// writeUserExport 把 export 写成 zip 文件，媒体文件从 cfg.media 读取，已经不存在的跳过
+func (cfg *apiConfig) writeUserExport(w io.Writer, export database.UserExport) error {
+	archive := zip.NewWriter(w)
+
+	files := []struct {
+		name string
+		data interface{}
+	}{
+		{"account.json", userFromDB(export.User)},
+		{"chirps.json", export.Chirps},
+		{"chirp_revisions.json", export.ChirpRevisions},
+		{"likes.json", export.Likes},
+		{"following.json", export.Following},
+		{"followers.json", export.Followers},
+		{"notifications.json", export.Notifications},
+		{"poll_votes.json", export.PollVotes},
+		{"scheduled_chirps.json", export.ScheduledChirps},
+		{"media.json", export.Media},
+	}
+	for _, file := range files {
+		f, err := archive.Create(file.name)
+		if err != nil {
+			return err
+		}
+		encoder := json.NewEncoder(f)
+		encoder.SetIndent("", "  ")
+		err = encoder.Encode(file.data)
+		if err != nil {
+			return err
+		}
+	}
+
+	// 同一个文件可能被上传多次，只导出一份
+	written := map[string]bool{}
+	for _, media := range export.Media {
+		if written[media.Hash] {
+			continue
+		}
+		written[media.Hash] = true
+
+		err := cfg.copyExportMedia(archive, media)
+		if errors.Is(err, os.ErrNotExist) {
+			log.Printf("Skipped missing media file %s in export", media.Hash)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return archive.Close()
+}
+
+func (cfg *apiConfig) copyExportMedia(archive *zip.Writer, media database.Media) error {
+	src, err := cfg.media.Open(media.Hash)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := archive.Create("media/" + media.Hash + mediaExtensions[media.ContentType])
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(dst, src)
+	return err
+}
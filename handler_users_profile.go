@@ -0,0 +1,343 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/Grey-1011/go-server/internal/auth"
+	"github.com/Grey-1011/go-server/internal/database"
+	"github.com/Grey-1011/go-server/internal/entities"
+	"github.com/Grey-1011/go-server/internal/moderation"
+)
+
+// 用户资料的限制
+const (
+	maxDisplayNameLength = 50  // 按 Unicode 字符计算
+	maxBioLength         = 160 // 按 Unicode 字符计算
+	// 两次修改 handle 之间至少间隔的时间，避免频繁更换 handle 冒充他人
+	handleChangeInterval = 30 * 24 * time.Hour
+)
+
+// Profile 是用户的公开资料，不包含邮箱等私人信息
+type Profile struct {
+	ID          int       `json:"id"`
+	Handle      string    `json:"handle"`
+	DisplayName string    `json:"display_name"`
+	Bio         string    `json:"bio"`
+	AvatarURL   string    `json:"avatar_url,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+func profileFromDB(user database.User) Profile {
+	return Profile{
+		ID:          user.ID,
+		Handle:      user.Handle,
+		DisplayName: user.DisplayName,
+		Bio:         user.Bio,
+		AvatarURL:   avatarURL(user.Avatar),
+		CreatedAt:   user.CreatedAt,
+	}
+}
+
+// PublicUser 是按 handle 或 ID 读取的用户：公开资料、Chirpy Red 状态和统计数据
+type PublicUser struct {
+	Profile
+	IsChirpyRed bool      `json:"is_chirpy_red"`
+	Stats       UserStats `json:"stats"`
+}
+
+// UserStats 是用户的统计数据，注册时间见 created_at
+type UserStats struct {
+	ChirpCount     int `json:"chirp_count"`
+	FollowerCount  int `json:"follower_count"`
+	FollowingCount int `json:"following_count"`
+}
+
+func userStatsFromDB(stats database.UserStats) UserStats {
+	return UserStats{
+		ChirpCount:     stats.ChirpCount,
+		FollowerCount:  stats.FollowerCount,
+		FollowingCount: stats.FollowingCount,
+	}
+}
+
+// ChirpAuthor 是 chirp 中作者的简要资料
+type ChirpAuthor struct {
+	ID          int    `json:"id"`
+	Handle      string `json:"handle"`
+	DisplayName string `json:"display_name"`
+	AvatarURL   string `json:"avatar_url,omitempty"`
+}
+
+func chirpAuthorFromDB(authorID int, author database.ChirpAuthor) ChirpAuthor {
+	return ChirpAuthor{
+		ID:          authorID,
+		Handle:      author.Handle,
+		DisplayName: author.DisplayName,
+		AvatarURL:   avatarURL(author.Avatar),
+	}
+}
+
+// avatarURL 返回头像的地址，没有头像时返回空字符串
+func avatarURL(avatar *database.ChirpMedia) string {
+	if avatar == nil {
+		return ""
+	}
+	return mediaURL(avatar.Hash)
+}
+
+// ==== 读取公开资料 ====
+/*
+handlerUsersGet 返回 {user} 的公开资料、Chirpy Red 状态和统计数据，不包含邮箱：
+1) {user} 只由数字组成时是用户 ID。纯数字的 handle 是保留的（见 entities.ReservedHandle），所以不会有歧义。
+2) 否则是 handle，不区分大小写，可以带开头的 @。
+*/
+func (cfg *apiConfig) handlerUsersGet(w http.ResponseWriter, r *http.Request) {
+	ref := strings.TrimPrefix(r.PathValue("user"), "@")
+
+	var user database.User
+	if userID, err := strconv.Atoi(ref); err == nil && strings.Trim(ref, "0123456789") == "" {
+		user, err = cfg.DB.GetUser(userID)
+		if errors.Is(err, database.ErrNotExist) {
+			respondWithError(w, http.StatusNotFound, "Couldn't find user")
+			return
+		}
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve user")
+			return
+		}
+	} else {
+		users, err := cfg.DB.GetUsersByHandle([]string{ref})
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve user")
+			return
+		}
+		var ok bool
+		user, ok = users[strings.ToLower(ref)]
+		if !ok {
+			respondWithError(w, http.StatusNotFound, "Couldn't find user")
+			return
+		}
+	}
+
+	stats, err := cfg.DB.GetUserStats(user.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve user stats")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, PublicUser{
+		Profile:     profileFromDB(user),
+		IsChirpyRed: user.IsChirpyRed,
+		Stats:       userStatsFromDB(stats),
+	})
+}
+
+// handlerUsersMe 返回当前用户（包括邮箱等只有自己能看到的字段）和统计数据
+func (cfg *apiConfig) handlerUsersMe(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		User
+		Stats UserStats `json:"stats"`
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT")
+		return
+	}
+	subject, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT")
+		return
+	}
+	userID, err := strconv.Atoi(subject)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't parse user ID")
+		return
+	}
+
+	user, err := cfg.DB.GetUser(userID)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't find user")
+		return
+	}
+	stats, err := cfg.DB.GetUserStats(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve user stats")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, response{
+		User:  userFromDB(user),
+		Stats: userStatsFromDB(stats),
+	})
+}
+
+// ==== 修改 handle ====
+/*
+handlerUsersHandleChange 把当前用户的 handle 改为请求体中的 handle（可以带开头的 @）：
+1) handle 只能由字母、数字和下划线组成，最长 entities.MaxHandleLength 个字符，否则返回 400。
+2) 保留的 handle（见 entities.ReservedHandle）返回 400，已被其他用户占用（不区分大小写）时返回 409。
+3) 每 handleChangeInterval 只能修改一次，太频繁时返回 429，Retry-After 是还需要等待的秒数。
+注册时自动分配的 handle 可以立即修改。
+*/
+func (cfg *apiConfig) handlerUsersHandleChange(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Handle string `json:"handle"`
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT")
+		return
+	}
+	subject, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT")
+		return
+	}
+	userID, err := strconv.Atoi(subject)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't parse user ID")
+		return
+	}
+
+	params := parameters{}
+	err = json.NewDecoder(r.Body).Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters")
+		return
+	}
+	handle := strings.TrimPrefix(strings.TrimSpace(params.Handle), "@")
+	if !entities.ValidHandle(handle) {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("A handle must be 1 to %d letters, digits or underscores", entities.MaxHandleLength))
+		return
+	}
+	if entities.ReservedHandle(handle) {
+		respondWithError(w, http.StatusBadRequest, "This handle is reserved")
+		return
+	}
+
+	now := time.Now()
+	user, err := cfg.DB.ChangeHandle(userID, handle, now.Add(-handleChangeInterval))
+	if err != nil {
+		switch {
+		case errors.Is(err, database.ErrAlreadyExists):
+			respondWithError(w, http.StatusConflict, "Handle is already taken")
+		case errors.Is(err, database.ErrHandleChangeTooSoon):
+			if user, err := cfg.DB.GetUser(userID); err == nil && user.HandleChangedAt != nil {
+				retryAfter := user.HandleChangedAt.Add(handleChangeInterval).Sub(now)
+				w.Header().Set("Retry-After", strconv.Itoa(int(retryAfter.Seconds())+1))
+			}
+			respondWithError(w, http.StatusTooManyRequests, fmt.Sprintf("You can only change your handle once every %s", handleChangeInterval))
+		case errors.Is(err, database.ErrNotExist):
+			respondWithError(w, http.StatusNotFound, "Couldn't find user")
+		default:
+			respondWithError(w, http.StatusInternalServerError, "Couldn't change handle")
+		}
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, userFromDB(user))
+}
+
+// ==== 修改资料 ====
+/*
+handlerUsersProfileUpdate 修改当前用户的公开资料，请求体中没有的字段保持不变：
+- display_name: 最长 maxDisplayNameLength 个字符，不能包含换行等控制字符，空字符串表示清除。
+- bio: 最长 maxBioLength 个字符，空字符串表示清除。
+- avatar_media_id: 用 POST /api/media 上传的图片作为头像，0 表示移除头像。
+显示名称和简介去掉首尾空白后经过内容审核（敏感词），被拒绝时返回 400。
+*/
+func (cfg *apiConfig) handlerUsersProfileUpdate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		DisplayName   *string `json:"display_name"`
+		Bio           *string `json:"bio"`
+		AvatarMediaID *int    `json:"avatar_media_id"`
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT")
+		return
+	}
+	subject, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT")
+		return
+	}
+	userID, err := strconv.Atoi(subject)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't parse user ID")
+		return
+	}
+
+	params := parameters{}
+	err = json.NewDecoder(r.Body).Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters")
+		return
+	}
+
+	// 只修改请求中的字段，合并在数据库的一个事务中进行
+	profile := database.UserProfile{
+		AvatarID: params.AvatarMediaID,
+	}
+	if params.DisplayName != nil {
+		if strings.ContainsFunc(*params.DisplayName, unicode.IsControl) {
+			respondWithError(w, http.StatusBadRequest, "Display name can't contain control characters")
+			return
+		}
+		displayName, err := cfg.moderateProfileText(*params.DisplayName, "Display name", maxDisplayNameLength)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		profile.DisplayName = &displayName
+	}
+	if params.Bio != nil {
+		bio, err := cfg.moderateProfileText(*params.Bio, "Bio", maxBioLength)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		profile.Bio = &bio
+	}
+
+	user, err := cfg.DB.UpdateProfile(userID, profile)
+	if err != nil {
+		if errors.Is(err, database.ErrNotExist) {
+			respondWithError(w, http.StatusNotFound, "Couldn't find user")
+			return
+		}
+		if errors.Is(err, database.ErrMediaNotAvailable) {
+			respondWithError(w, http.StatusBadRequest, "Couldn't find the media to use as avatar")
+			return
+		}
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update profile")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, userFromDB(user))
+}
+
+// moderateProfileText 去掉 text 的首尾空白，检查长度并进行内容审核，返回要保存的文本。
+// 被拒绝时返回的错误可以直接作为响应消息，field 是错误消息中的字段名。
+func (cfg *apiConfig) moderateProfileText(text, field string, maxLength int) (string, error) {
+	text = strings.TrimSpace(text)
+	if utf8.RuneCountInString(text) > maxLength {
+		return "", fmt.Errorf("%s can be at most %d characters", field, maxLength)
+	}
+	decision := cfg.profileModeration.Moderate(text)
+	if decision.Action == moderation.ActionReject {
+		return "", errors.New(decision.Reason)
+	}
+	return decision.Body, nil
+}
@@ -2,20 +2,37 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
+	"log"
 	"net/http"
 	"strconv"
+	"time"
 
 	"github.com/Grey-1011/go-server/internal/auth"
+	"github.com/Grey-1011/go-server/internal/database"
 )
 
+// ==== 修改邮箱和密码 ====
+/*
+handlerUsersUpdate 修改当前用户的邮箱和密码，请求体中没有的字段保持不变：
+1) 修改邮箱或密码时必须在 current_password 中提供当前的密码，缺少时返回 400，错误时返回 401。
+2) 新的邮箱需要通过验证邮件确认之后才会替换原来的邮箱，在此之前响应中的 pending_email 是等待验证的邮箱；
+   新的邮箱已被其他用户使用时返回 409。
+3) 修改密码后，用户其他设备上的 refresh token 全部被撤销。当前设备得到新的 token 和 refresh_token，不需要重新登录。
+*/
 func (cfg *apiConfig) handlerUsersUpdate(w http.ResponseWriter, r *http.Request) {
 	type parameters struct {
-		Email    string `json:"email"`
-		Password string `json:"password"`
+		Email           *string `json:"email"`
+		Password        *string `json:"password"`
+		CurrentPassword string  `json:"current_password"`
 	}
 
 	type response struct {
 		User
+		PendingEmail string `json:"pending_email,omitempty"`
+		// 只在修改密码时返回
+		Token        string `json:"token,omitempty"`
+		RefreshToken string `json:"refresh_token,omitempty"`
 	}
 
 	// 获取 Token
@@ -35,14 +52,15 @@ func (cfg *apiConfig) handlerUsersUpdate(w http.ResponseWriter, r *http.Request)
 	params := parameters{}
 	err = decoder.Decode(&params)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't decode parameters")
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters")
 		return
 	}
-
-	// 加密 Password
-	hashedPassword, err := auth.HashPassword(params.Password)
-	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't hash password")
+	if params.Email != nil && !validEmail(*params.Email) {
+		respondWithError(w, http.StatusBadRequest, "Invalid email address")
+		return
+	}
+	if params.Password != nil && *params.Password == "" {
+		respondWithError(w, http.StatusBadRequest, "Missing password")
 		return
 	}
 
@@ -52,18 +70,95 @@ func (cfg *apiConfig) handlerUsersUpdate(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	user, err := cfg.DB.UpdateUser(userIDInt, params.Email, hashedPassword)
+	current, err := cfg.DB.GetUser(userIDInt)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't find user")
+		return
+	}
+	changeEmail := params.Email != nil && *params.Email != current.Email
+	changePassword := params.Password != nil
+	if !changeEmail && !changePassword {
+		respondWithJSON(w, http.StatusOK, response{
+			User: userFromDB(current),
+		})
+		return
+	}
+
+	// 确认当前的密码，避免拿到 JWT 的人接管账号
+	if params.CurrentPassword == "" {
+		respondWithError(w, http.StatusBadRequest, "Current password is required to change email or password")
+		return
+	}
+	err = auth.CheckPasswordHash(params.CurrentPassword, current.HashedPassword)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Invalid current password")
+		return
+	}
+
+	update := database.UserUpdate{}
+	if changePassword {
+		// 加密 Password
+		hashedPassword, err := auth.HashPassword(*params.Password)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't hash password")
+			return
+		}
+		update.HashedPassword = &hashedPassword
+	}
+
+	pendingEmail := ""
+	if changeEmail {
+		if _, err := cfg.DB.GetUserByEmail(*params.Email); err == nil {
+			respondWithError(w, http.StatusConflict, "Email is already in use")
+			return
+		}
+		// 先发送验证邮件，发送失败时不修改任何内容
+		err = cfg.sendEmailVerification(userIDInt, *params.Email)
+		if err != nil {
+			log.Printf("Couldn't send verification email to user %d: %s", userIDInt, err)
+			respondWithError(w, http.StatusInternalServerError, "Couldn't send verification email")
+			return
+		}
+		pendingEmail = *params.Email
+	}
+
+	user, err := cfg.DB.UpdateUser(userIDInt, update)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't create user")
+		if errors.Is(err, database.ErrAlreadyExists) {
+			respondWithError(w, http.StatusConflict, "Email is already in use")
+			return
+		}
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update user")
 		return
 	}
 
-	respondWithJSON(w, http.StatusOK, response{
-		User: User{
-			ID:    user.ID,
-			Email: user.Email,
-			IsChirpyRed: user.IsChirpyRed,
-		},
-	})
+	resp := response{
+		User:         userFromDB(user),
+		PendingEmail: pendingEmail,
+	}
+	if changePassword {
+		// 为当前设备签发新的 token，然后撤销其他所有的 refresh token
+		resp.Token, err = auth.MakeJWT(user.ID, cfg.jwtSecret, time.Hour)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't create access JWT")
+			return
+		}
+		resp.RefreshToken, err = auth.MakeRefreshToken()
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't create refresh token")
+			return
+		}
+		err = cfg.DB.SaveRefreshToken(user.ID, resp.RefreshToken)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't save refresh token")
+			return
+		}
+		err = cfg.DB.RevokeUserRefreshTokens(user.ID, resp.RefreshToken)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't revoke sessions")
+			return
+		}
+	}
 
+	respondWithJSON(w, http.StatusOK, resp)
 }
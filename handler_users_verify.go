@@ -0,0 +1,152 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/mail"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Grey-1011/go-server/internal/auth"
+	"github.com/Grey-1011/go-server/internal/database"
+	"github.com/Grey-1011/go-server/internal/mailer"
+)
+
+// 验证邮箱的令牌的有效期
+const verifyEmailTokenTTL = 24 * time.Hour
+
+// 邮箱地址的最大长度（RFC 5321）
+const maxEmailLength = 254
+
+// validEmail 判断 email 是否是一个不带显示名称的邮箱地址，例如 walt@breakingbad.com。
+// 保留的顶级域名 .invalid 不能使用，匿名化的账号使用这个域名的地址（见 database.PurgeUser）。
+func validEmail(email string) bool {
+	if len(email) > maxEmailLength {
+		return false
+	}
+	addr, err := mail.ParseAddress(email)
+	if err != nil || addr.Name != "" || addr.Address != email {
+		return false
+	}
+	domain := strings.ToLower(email[strings.LastIndex(email, "@")+1:])
+	return domain != "invalid" && !strings.HasSuffix(domain, ".invalid")
+}
+
+// ==== 发送验证邮件 ====
+/*
+sendEmailVerification 为用户生成一个验证 email 的令牌，并发送到 email：
+1) 令牌是随机的 256 位值，数据库中只保存它的哈希，有效期为 verifyEmailTokenTTL。
+2) 同一个用户之前发出的验证令牌同时失效。
+3) 注册时 email 就是用户的邮箱；修改邮箱时 email 是新的邮箱，验证之后才会替换原来的邮箱。
+*/
+func (cfg *apiConfig) sendEmailVerification(userID int, email string) error {
+	token, err := auth.MakeRefreshToken()
+	if err != nil {
+		return err
+	}
+	err = cfg.DB.CreateUserToken(database.UserToken{
+		Hash:      auth.HashToken(token),
+		UserID:    userID,
+		Purpose:   database.TokenPurposeVerifyEmail,
+		Email:     email,
+		ExpiresAt: time.Now().Add(verifyEmailTokenTTL).UTC(),
+	})
+	if err != nil {
+		return err
+	}
+
+	return cfg.mailer.Send(mailer.Message{
+		To:      email,
+		Subject: "Verify your Chirpy email address",
+		Body: fmt.Sprintf(
+			"Use this code to verify your email address:\n\n%s\n\nSend it to POST /api/users/verify as {\"token\": \"<code>\"}. It expires in %s.\n\nIf you didn't sign up for Chirpy, you can ignore this email.\n",
+			token, verifyEmailTokenTTL,
+		),
+	})
+}
+
+// requireVerified 检查用户的邮箱已经验证，否则返回 403 并返回 false
+func (cfg *apiConfig) requireVerified(w http.ResponseWriter, userID int) bool {
+	user, err := cfg.DB.GetUser(userID)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't find user")
+		return false
+	}
+	if !user.Verified {
+		respondWithError(w, http.StatusForbidden, "Verify your email address before posting")
+		return false
+	}
+	return true
+}
+
+// handlerUsersVerify 使用邮件中的令牌验证邮箱，返回验证后的用户。令牌只能使用一次。
+func (cfg *apiConfig) handlerUsersVerify(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Token string `json:"token"`
+	}
+
+	params := parameters{}
+	err := json.NewDecoder(r.Body).Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters")
+		return
+	}
+
+	user, err := cfg.DB.VerifyEmail(auth.HashToken(params.Token), time.Now())
+	if err != nil {
+		if errors.Is(err, database.ErrNotExist) {
+			respondWithError(w, http.StatusBadRequest, "Invalid or expired token")
+			return
+		}
+		if errors.Is(err, database.ErrAlreadyExists) {
+			respondWithError(w, http.StatusConflict, "Email is already in use")
+			return
+		}
+		respondWithError(w, http.StatusInternalServerError, "Couldn't verify email")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, userFromDB(user))
+}
+
+// handlerUsersVerifyResend 重新发送注册时的验证邮件，之前的令牌失效。成功时返回 204。
+func (cfg *apiConfig) handlerUsersVerifyResend(w http.ResponseWriter, r *http.Request) {
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT")
+		return
+	}
+	subject, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT")
+		return
+	}
+	userID, err := strconv.Atoi(subject)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't parse user ID")
+		return
+	}
+
+	user, err := cfg.DB.GetUser(userID)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't find user")
+		return
+	}
+	if user.Verified {
+		respondWithError(w, http.StatusConflict, "Email is already verified")
+		return
+	}
+
+	err = cfg.sendEmailVerification(user.ID, user.Email)
+	if err != nil {
+		log.Printf("Couldn't send verification email to user %d: %s", user.ID, err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't send verification email")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Grey-1011/go-server/internal/auth"
+	"github.com/Grey-1011/go-server/internal/database"
+	"github.com/Grey-1011/go-server/internal/mailer"
+)
+
+// fakeMailer 记录发送的邮件，不真正发送
+type fakeMailer struct {
+	mu   sync.Mutex
+	sent []mailer.Message
+}
+
+func (m *fakeMailer) Send(msg mailer.Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sent = append(m.sent, msg)
+	return nil
+}
+
+// last 返回最后一封邮件，以及邮件中的令牌
+func (m *fakeMailer) last(t *testing.T) (mailer.Message, string) {
+	t.Helper()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(m.sent) == 0 {
+		t.Fatal("no email was sent")
+	}
+	msg := m.sent[len(m.sent)-1]
+	token := regexp.MustCompile(`\b[0-9a-f]{64}\b`).FindString(msg.Body)
+	if token == "" {
+		t.Fatalf("no token in email body:\n%s", msg.Body)
+	}
+	return msg, token
+}
+
+func (m *fakeMailer) count() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.sent)
+}
+
+// newTestAPIConfig 返回使用临时 JSON 数据库和 fakeMailer 的 apiConfig，以及数据库文件的路径
+func newTestAPIConfig(t *testing.T) (*apiConfig, *fakeMailer, string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "database.json")
+	db, err := database.NewDB(path)
+	if err != nil {
+		t.Fatalf("NewDB: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	mail := &fakeMailer{}
+	return &apiConfig{
+		DB:        db,
+		jwtSecret: "secret",
+		mailer:    mail,
+	}, mail, path
+}
+
+// serve 用 handler 处理一个 JSON 请求，jwt 不为空时作为 Bearer token
+func serve(t *testing.T, handler http.HandlerFunc, method string, jwt string, body any) *httptest.ResponseRecorder {
+	t.Helper()
+	dat, err := json.Marshal(body)
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+	r := httptest.NewRequest(method, "/", bytes.NewReader(dat))
+	if jwt != "" {
+		r.Header.Set("Authorization", "Bearer "+jwt)
+	}
+	w := httptest.NewRecorder()
+	handler(w, r)
+	return w
+}
+
+// decodeUser 解码响应中的用户
+func decodeUser(t *testing.T, w *httptest.ResponseRecorder) User {
+	t.Helper()
+	user := User{}
+	err := json.NewDecoder(w.Body).Decode(&user)
+	if err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	return user
+}
+
+// storedData 返回数据库快照和日志的内容
+func storedData(t *testing.T, path string) string {
+	t.Helper()
+	snapshot, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+	log, err := os.ReadFile(path + ".log")
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+	return string(snapshot) + string(log)
+}
+
+type credentials struct {
+	Email    string `json:"email"`
+	Password string `json:"password"`
+}
+
+type tokenParams struct {
+	Token string `json:"token"`
+}
+
+func TestSignupVerification(t *testing.T) {
+	cfg, mail, path := newTestAPIConfig(t)
+
+	w := serve(t, cfg.handlerUsersCreate, "POST", "", credentials{"walt@breakingbad.com", "pw"})
+	if w.Code != http.StatusCreated {
+		t.Fatalf("create user: %d %s", w.Code, w.Body)
+	}
+	user := decodeUser(t, w)
+	if user.Verified {
+		t.Error("new user is already verified")
+	}
+
+	msg, token := mail.last(t)
+	if msg.To != "walt@breakingbad.com" || !strings.Contains(msg.Subject, "Verify") {
+		t.Errorf("email = %+v", msg)
+	}
+	// 数据库中只保存令牌的哈希
+	data := storedData(t, path)
+	if strings.Contains(data, token) {
+		t.Error("the token is stored in plain text")
+	}
+	if !strings.Contains(data, auth.HashToken(token)) {
+		t.Error("the token hash is not stored")
+	}
+
+	// 重新发送之后，之前的令牌失效
+	jwt, err := auth.MakeJWT(user.ID, cfg.jwtSecret, time.Hour)
+	if err != nil {
+		t.Fatalf("MakeJWT: %v", err)
+	}
+	w = serve(t, cfg.handlerUsersVerifyResend, "POST", jwt, nil)
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("resend: %d %s", w.Code, w.Body)
+	}
+	if mail.count() != 2 {
+		t.Fatalf("sent %d emails, want 2", mail.count())
+	}
+	_, resent := mail.last(t)
+	if resent == token {
+		t.Fatal("resent the same token")
+	}
+	w = serve(t, cfg.handlerUsersVerify, "POST", "", tokenParams{token})
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("verify with the old token: %d %s, want 400", w.Code, w.Body)
+	}
+
+	w = serve(t, cfg.handlerUsersVerify, "POST", "", tokenParams{resent})
+	if w.Code != http.StatusOK || !decodeUser(t, w).Verified {
+		t.Fatalf("verify: %d %s", w.Code, w.Body)
+	}
+	// 令牌只能使用一次，已经验证的用户不能再重新发送
+	w = serve(t, cfg.handlerUsersVerify, "POST", "", tokenParams{resent})
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("verify twice: %d %s, want 400", w.Code, w.Body)
+	}
+	w = serve(t, cfg.handlerUsersVerifyResend, "POST", jwt, nil)
+	if w.Code != http.StatusConflict {
+		t.Errorf("resend after verification: %d %s, want 409", w.Code, w.Body)
+	}
+}
+
+func TestEmailChangeVerification(t *testing.T) {
+	cfg, mail, path := newTestAPIConfig(t)
+
+	w := serve(t, cfg.handlerUsersCreate, "POST", "", credentials{"walt@breakingbad.com", "pw"})
+	if w.Code != http.StatusCreated {
+		t.Fatalf("create user: %d %s", w.Code, w.Body)
+	}
+	user := decodeUser(t, w)
+	_, token := mail.last(t)
+	serve(t, cfg.handlerUsersVerify, "POST", "", tokenParams{token})
+	jwt, err := auth.MakeJWT(user.ID, cfg.jwtSecret, time.Hour)
+	if err != nil {
+		t.Fatalf("MakeJWT: %v", err)
+	}
+
+	type update struct {
+		Email           string `json:"email"`
+		CurrentPassword string `json:"current_password"`
+	}
+	w = serve(t, cfg.handlerUsersUpdate, "PATCH", jwt, update{"heisenberg@breakingbad.com", "pw"})
+	if w.Code != http.StatusOK {
+		t.Fatalf("change email: %d %s", w.Code, w.Body)
+	}
+	// 验证之前邮箱不变
+	if user := decodeUser(t, w); user.Email != "walt@breakingbad.com" || !user.Verified {
+		t.Errorf("user before verification = %+v", user)
+	}
+
+	msg, token := mail.last(t)
+	if msg.To != "heisenberg@breakingbad.com" {
+		t.Errorf("email sent to %q, want the new address", msg.To)
+	}
+	data := storedData(t, path)
+	if strings.Contains(data, token) || !strings.Contains(data, auth.HashToken(token)) {
+		t.Error("the token is not stored as its hash")
+	}
+
+	// 再次修改时之前的令牌失效
+	w = serve(t, cfg.handlerUsersUpdate, "PATCH", jwt, update{"heisenberg@albuquerque.com", "pw"})
+	if w.Code != http.StatusOK {
+		t.Fatalf("change email again: %d %s", w.Code, w.Body)
+	}
+	msg, latest := mail.last(t)
+	if msg.To != "heisenberg@albuquerque.com" {
+		t.Errorf("email sent to %q, want the latest address", msg.To)
+	}
+	w = serve(t, cfg.handlerUsersVerify, "POST", "", tokenParams{token})
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("verify with the old token: %d %s, want 400", w.Code, w.Body)
+	}
+
+	w = serve(t, cfg.handlerUsersVerify, "POST", "", tokenParams{latest})
+	if w.Code != http.StatusOK {
+		t.Fatalf("verify: %d %s", w.Code, w.Body)
+	}
+	if user := decodeUser(t, w); user.Email != "heisenberg@albuquerque.com" || !user.Verified {
+		t.Errorf("user after verification = %+v", user)
+	}
+	if _, err := cfg.DB.GetUserByEmail("walt@breakingbad.com"); err == nil {
+		t.Error("the old email still belongs to the user")
+	}
+}
@@ -2,6 +2,7 @@ package auth
 
 import (
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/hex"
 	"errors"
 	"fmt"
@@ -103,6 +104,12 @@ func MakeRefreshToken() (string, error) {
 
 }
 
+// HashToken 返回一次性令牌的 SHA-256（hex）。数据库只保存哈希，数据库泄露时令牌本身不会泄露
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
 // GetAPIKey -
 func GetAPIKey(headers http.Header) (string, error) {
 	authHeader := headers.Get("Authorization")
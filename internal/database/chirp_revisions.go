@@ -0,0 +1,82 @@
+package database
+
+import "time"
+
+// ChirpRevision 是 chirp 被编辑之前的一个版本
+type ChirpRevision struct {
+	ID         int             `json:"id"`
+	ChirpID    int             `json:"chirp_id"`
+	Body       string          `json:"body"`
+	Moderation ChirpModeration `json:"moderation"`
+	CreatedAt  time.Time       `json:"created_at"`  // 这个版本写入的时间（chirp 的创建时间或上一次编辑的时间）
+	ReplacedAt time.Time       `json:"replaced_at"` // 这个版本被编辑替换的时间
+}
+
+var chirpRevisionsTable = table[int, ChirpRevision]{
+	name:  "chirp_revisions",
+	m:     func(dbStructure *DBStructure) *map[int]ChirpRevision { return &dbStructure.ChirpRevisions },
+	index: indexChirpRevision,
+}
+
+// indexChirpRevision 维护 chirp ID -> 历史版本 ID 的索引
+func indexChirpRevision(dbStructure *DBStructure, id int, old, new *ChirpRevision) {
+	idx := dbStructure.idx
+	if old != nil {
+		if byChirp, ok := idx.chirpRevisions[old.ChirpID]; ok {
+			byChirp.remove(id)
+			if len(*byChirp) == 0 {
+				delete(idx.chirpRevisions, old.ChirpID)
+			}
+		}
+	}
+	if new != nil {
+		byChirp, ok := idx.chirpRevisions[new.ChirpID]
+		if !ok {
+			byChirp = &sortedIDs{}
+			idx.chirpRevisions[new.ChirpID] = byChirp
+		}
+		byChirp.insert(id)
+	}
+}
+
+// revisionOf 返回 chirp 当前正文在 replacedAt 被替换时的历史版本（不含 ID）
+func revisionOf(chirp Chirp, replacedAt time.Time) ChirpRevision {
+	createdAt := chirp.CreatedAt
+	if chirp.EditedAt != nil {
+		createdAt = *chirp.EditedAt
+	}
+	return ChirpRevision{
+		ChirpID:    chirp.ID,
+		Body:       chirp.Body,
+		Moderation: chirp.Moderation,
+		CreatedAt:  createdAt,
+		ReplacedAt: replacedAt,
+	}
+}
+
+// ==== 获取 Chirp 的历史版本 ====
+/*
+GetChirpRevisions 按时间顺序（从最早的版本开始）返回 chirp 的历史版本，不包括当前版本。
+chirp 不存在时返回 ErrNotExist。
+*/
+func (db *DB) GetChirpRevisions(chirpID int) ([]ChirpRevision, error) {
+	revisions := []ChirpRevision{}
+	err := db.View(func(dbStructure *DBStructure) error {
+		if _, ok := dbStructure.Chirps[chirpID]; !ok {
+			return ErrNotExist
+		}
+		byChirp, ok := dbStructure.idx.chirpRevisions[chirpID]
+		if !ok {
+			return nil
+		}
+		for _, id := range *byChirp {
+			revisions = append(revisions, dbStructure.ChirpRevisions[id])
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return revisions, nil
+}
@@ -1,97 +1,546 @@
 package database
 
-// Chirp 结构体表示一个 chirp（类似 tweet），包含两个字段：
+import (
+	"errors"
+	"sort"
+	"time"
+)
+
+// ErrParentNotExist 表示回复的 chirp 不存在或已被删除
+var ErrParentNotExist = errors.New("parent chirp does not exist")
+
+// ErrQuotedNotExist 表示引用的 chirp 不存在或已被删除
+var ErrQuotedNotExist = errors.New("quoted chirp does not exist")
+
+// Chirp 结构体表示一个 chirp（类似 tweet）
 type Chirp struct {
-	ID   int    `json:"id"`
-	Body string `json:"body"`
-	AuthorID int `json:"author_id"`
+	ID        int       `json:"id"`
+	Body      string    `json:"body"`
+	AuthorID  int       `json:"author_id"`
+	InReplyTo int       `json:"in_reply_to,omitempty"` // 回复的 chirp 的 ID，0 表示不是回复
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	// EditedAt 是最后一次编辑正文的时间，没有编辑过时为 nil
+	EditedAt *time.Time `json:"edited_at,omitempty"`
+	// 被删除的 chirp 先放入回收站：DeletedAt 是删除时间，DeletedBy 是执行删除的用户 ID，
+	// 超过保留期后才会被 PurgeChirps 永久删除
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+	DeletedBy int        `json:"deleted_by,omitempty"`
+	// 转发（rechirp）没有正文，RechirpOf 是被转发的 chirp 的 ID；引用（quote）有自己的正文，QuoteOf 是被引用的 chirp 的 ID。
+	// 两者指向的总是原始 chirp，而不是另一个转发
+	RechirpOf int `json:"rechirp_of,omitempty"`
+	QuoteOf   int `json:"quote_of,omitempty"`
+
+	Moderation ChirpModeration `json:"moderation"`
+	Entities   []ChirpEntity   `json:"entities,omitempty"` // 正文中的话题和提及
+	Media      []ChirpMedia    `json:"media,omitempty"`    // 附加的媒体，按附加时的顺序
+	Poll       *ChirpPoll      `json:"poll,omitempty"`     // 附加的投票，没有时为 nil
+
+	// 以下是读取时计算的派生字段，不会被保存
+	ReplyCount int `json:"-"` // 不在回收站中的直接回复的数量
+	LikeCount  int `json:"-"` // 点赞数
+	// 不在回收站中的转发和引用的数量
+	RechirpCount int `json:"-"`
+	QuoteCount   int `json:"-"`
+	// 作者当前的公开资料
+	Author ChirpAuthor `json:"-"`
+}
+
+// ChirpAuthor 是 chirp 作者的公开资料，读取 chirp 时从用户中复制
+type ChirpAuthor struct {
+	Handle      string      `json:"handle"`
+	DisplayName string      `json:"display_name"`
+	Avatar      *ChirpMedia `json:"avatar"`
+}
+
+// ChirpModeration 记录创建 chirp 时内容审核的结果，旧数据中为零值（未记录）
+type ChirpModeration struct {
+	Action  string   `json:"action"`            // "allow" 或 "mask"
+	Matched []string `json:"matched,omitempty"` // 命中的规则，例如被屏蔽的词
+}
+
+var chirpsTable = table[int, Chirp]{
+	name:  "chirps",
+	m:     func(dbStructure *DBStructure) *map[int]Chirp { return &dbStructure.Chirps },
+	index: indexChirp,
+}
+
+// indexChirp 维护 chirp 的 ID 索引、创建时间索引、对应的作者索引，以及全文搜索的倒排索引。
+// 回收站中的 chirp 只在 deletedChirps 索引中，所以列表和搜索都不会返回它们。
+func indexChirp(dbStructure *DBStructure, id int, old, new *Chirp) {
+	idx := dbStructure.idx
+	if old != nil && old.DeletedAt != nil {
+		idx.deletedChirps.remove(id)
+		old = nil
+	}
+	if new != nil && new.DeletedAt != nil {
+		idx.deletedChirps.insert(id)
+		new = nil
+	}
+	if old == nil || new == nil || old.Body != new.Body {
+		if old != nil {
+			idx.search.remove(id, old.Body)
+		}
+		if new != nil {
+			idx.search.add(id, new.Body)
+		}
+	}
+
+	if old != nil {
+		key := timeKey{at: old.CreatedAt, id: id}
+		idx.chirpIDs.remove(id)
+		idx.chirpsByTime.remove(key)
+		if byAuthor, ok := idx.chirpsByAuthor[old.AuthorID]; ok {
+			byAuthor.remove(id)
+			idx.chirpsByAuthorAndTime[old.AuthorID].remove(key)
+		}
+	}
+	if new != nil {
+		key := timeKey{at: new.CreatedAt, id: id}
+		idx.chirpIDs.insert(id)
+		idx.chirpsByTime.insert(key)
+		byAuthor, ok := idx.chirpsByAuthor[new.AuthorID]
+		if !ok {
+			byAuthor = &sortedIDs{}
+			idx.chirpsByAuthor[new.AuthorID] = byAuthor
+			idx.chirpsByAuthorAndTime[new.AuthorID] = &timeIndex{}
+		}
+		byAuthor.insert(id)
+		idx.chirpsByAuthorAndTime[new.AuthorID].insert(key)
+	}
+
+	if old != nil && old.InReplyTo != 0 {
+		if replies, ok := idx.replies[old.InReplyTo]; ok {
+			replies.remove(id)
+			if len(*replies) == 0 {
+				delete(idx.replies, old.InReplyTo)
+			}
+		}
+	}
+	if new != nil && new.InReplyTo != 0 {
+		replies, ok := idx.replies[new.InReplyTo]
+		if !ok {
+			replies = &sortedIDs{}
+			idx.replies[new.InReplyTo] = replies
+		}
+		replies.insert(id)
+	}
+
+	if old != nil {
+		for _, tag := range old.tags() {
+			if ix, ok := idx.tags[tag]; ok {
+				ix.remove(timeKey{at: old.CreatedAt, id: id})
+				if len(*ix) == 0 {
+					delete(idx.tags, tag)
+				}
+			}
+		}
+	}
+	if new != nil {
+		for _, tag := range new.tags() {
+			ix, ok := idx.tags[tag]
+			if !ok {
+				ix = &timeIndex{}
+				idx.tags[tag] = ix
+			}
+			ix.insert(timeKey{at: new.CreatedAt, id: id})
+		}
+	}
+
+	if old != nil && old.RechirpOf != 0 {
+		delete(idx.rechirps[old.RechirpOf], old.AuthorID)
+		if len(idx.rechirps[old.RechirpOf]) == 0 {
+			delete(idx.rechirps, old.RechirpOf)
+		}
+	}
+	if new != nil && new.RechirpOf != 0 {
+		if _, ok := idx.rechirps[new.RechirpOf]; !ok {
+			idx.rechirps[new.RechirpOf] = map[int]int{}
+		}
+		idx.rechirps[new.RechirpOf][new.AuthorID] = id
+	}
+
+	if old != nil && old.QuoteOf != 0 {
+		delete(idx.quotes[old.QuoteOf], id)
+		if len(idx.quotes[old.QuoteOf]) == 0 {
+			delete(idx.quotes, old.QuoteOf)
+		}
+	}
+	if new != nil && new.QuoteOf != 0 {
+		if _, ok := idx.quotes[new.QuoteOf]; !ok {
+			idx.quotes[new.QuoteOf] = map[int]struct{}{}
+		}
+		idx.quotes[new.QuoteOf][id] = struct{}{}
+	}
 }
 
+// withCounts 返回填充了派生字段（回复数、点赞数、转发数、引用数、投票的票数、作者的资料）的 chirp
+func (dbStructure *DBStructure) withCounts(chirp Chirp) Chirp {
+	chirp.ReplyCount = 0
+	if replies, ok := dbStructure.idx.replies[chirp.ID]; ok {
+		chirp.ReplyCount = len(*replies)
+	}
+	chirp.LikeCount = len(dbStructure.idx.likesByChirp[chirp.ID])
+	chirp.RechirpCount = len(dbStructure.idx.rechirps[chirp.ID])
+	chirp.QuoteCount = len(dbStructure.idx.quotes[chirp.ID])
+	chirp.Poll = dbStructure.withVotes(chirp.ID, chirp.Poll)
+	chirp.Author = ChirpAuthor{}
+	if author, ok := dbStructure.Users[chirp.AuthorID]; ok {
+		chirp.Author = ChirpAuthor{Handle: author.Handle, DisplayName: author.DisplayName}
+		if author.Avatar != nil {
+			avatar := *author.Avatar
+			chirp.Author.Avatar = &avatar
+		}
+	}
+	return chirp
+}
+
+// chirp 的排序字段
+const (
+	ChirpSortID        = "id"
+	ChirpSortCreatedAt = "created_at"
+)
+
+// ChirpQuery 描述一次 chirp 范围查询
+type ChirpQuery struct {
+	AuthorID int       // 只返回该作者的 chirp，0 表示不过滤
+	SortBy   string    // ChirpSortID（默认）或 ChirpSortCreatedAt，created_at 相同时按 ID 排序
+	Desc     bool      // 降序
+	Since    time.Time // 只返回 created_at >= Since 的 chirp，零值表示不限制
+	Until    time.Time // 只返回 created_at < Until 的 chirp，零值表示不限制
+	// 只返回排序上位于 (AfterCreatedAt, AfterID) 之后的 chirp（用于分页），AfterID 为 0 表示从头开始。
+	// 按 ID 排序时忽略 AfterCreatedAt。
+	AfterID        int
+	AfterCreatedAt time.Time
+	Limit          int // 最多返回的数量，0 表示不限制
+}
+
+// inTimeRange 判断 t 是否位于 q 的 [Since, Until) 之间
+func (q ChirpQuery) inTimeRange(t time.Time) bool {
+	if !q.Since.IsZero() && t.Before(q.Since) {
+		return false
+	}
+	if !q.Until.IsZero() && !t.Before(q.Until) {
+		return false
+	}
+	return true
+}
 
 // ==== 创建 Chirp ====
 // CreateChirp 方法创建一个新的 chirp 并保存到数据库中。
 /*
-1) 首先加载当前的数据库结构。
-2) 创建一个新的 Chirp，分配一个唯一 ID。
-3) 将新的 Chirp 添加到 dbStructure.Chirps 映射中。
-4) 将更新后的数据库结构写回文件。
+调用方填写 chirp 的内容（Body、AuthorID、InReplyTo、QuoteOf、Moderation、Entities、Poll），ID 和时间戳由数据库分配。
+附加媒体时 Media 中只需要填写媒体 ID，其余字段由媒体记录填充。
+转发用 Rechirp 创建。在一个 Update 事务中：
+1) 如果是回复，检查被回复的 chirp 存在且不在回收站中，否则返回 ErrParentNotExist。
+2) 如果是引用，检查被引用的 chirp 存在且不在回收站中，否则返回 ErrQuotedNotExist。
+   引用一个转发时引用的是原始 chirp。
+3) 从 ID 序列中分配一个唯一 ID（ID 不会被重复使用）。
+4) 附加媒体：媒体必须是作者上传的、还没有附加到其他 chirp，否则返回 ErrMediaNotAvailable。
+5) 将新的 Chirp 添加到 dbStructure.Chirps 映射中。
+6) 为提及的用户创建通知。
+事务结束时这次修改会被追加到日志。
 */
-func (db *DB) CreateChirp(body string, authorID int) (Chirp, error) {
-	dbStructure, err := db.loadDB()
+func (db *DB) CreateChirp(chirp Chirp) (Chirp, error) {
+	err := db.Update(func(dbStructure *DBStructure) error {
+		var err error
+		chirp, err = dbStructure.createChirp(chirp)
+		return err
+	})
 	if err != nil {
 		return Chirp{}, err
 	}
 
-	id := len(dbStructure.Chirps) + 1
-	chirp := Chirp{
-		ID:   id,
-		Body: body,
-		AuthorID: authorID,
-	}
-	dbStructure.Chirps[id] = chirp
+	return chirp, nil
+}
 
-	err = db.writeDB(dbStructure)
+// createChirp 是 CreateChirp 的事务内部分，发布定时 chirp 时也会用到，必须在 Update 事务中调用
+func (dbStructure *DBStructure) createChirp(chirp Chirp) (Chirp, error) {
+	err := dbStructure.checkReferences(&chirp)
 	if err != nil {
 		return Chirp{}, err
 	}
 
-	return chirp, nil
+	chirp.ID = dbStructure.nextID(chirpsTable.name)
+	chirp.CreatedAt = time.Now().UTC()
+	chirp.UpdatedAt = chirp.CreatedAt
+	err = dbStructure.attachMedia(&chirp)
+	if err != nil {
+		return Chirp{}, err
+	}
+	chirpsTable.put(dbStructure, chirp.ID, chirp)
+	dbStructure.notifyMentions(chirp, nil)
+	return dbStructure.withCounts(chirp), nil
 }
 
+// checkReferences 检查 chirp 回复和引用的 chirp 存在且不在回收站中，并把 QuoteOf 解析为原始 chirp
+func (dbStructure *DBStructure) checkReferences(chirp *Chirp) error {
+	if chirp.InReplyTo != 0 {
+		parent, ok := dbStructure.Chirps[chirp.InReplyTo]
+		if !ok || parent.DeletedAt != nil {
+			return ErrParentNotExist
+		}
+	}
+	chirp.RechirpOf = 0
+	if chirp.QuoteOf != 0 {
+		quoted, ok := dbStructure.original(chirp.QuoteOf)
+		if !ok {
+			return ErrQuotedNotExist
+		}
+		chirp.QuoteOf = quoted.ID
+	}
+	return nil
+}
 
-
-// ==== 获取所有 Chirps ====
+// ==== 范围查询 ====
 /*
-1) 加载数据库结构。
-2) 遍历 dbStructure.Chirps 映射，将所有的 Chirp 添加到一个切片中。
-3) 返回这个切片。
+ListChirps 使用内存中的有序索引，从分页位置之后开始读取，最多读取 q.Limit 条，不需要扫描全部 chirp。
+- 按 created_at 排序时使用创建时间索引，Since/Until 通过二分查找定位。
+- 按 ID 排序时使用 ID 索引，Since/Until 在遍历时过滤。
+ID 不会被重复使用，所以分页时即使有新建或删除的 chirp，顺序也是稳定的。
 */
-func (db *DB) GetChirps() ([]Chirp, error) {
-	dbStructure, err := db.loadDB()
+func (db *DB) ListChirps(q ChirpQuery) ([]Chirp, error) {
+	chirps := []Chirp{}
+	err := db.View(func(dbStructure *DBStructure) error {
+		collect := func(id int) bool {
+			chirp := dbStructure.Chirps[id]
+			if !q.inTimeRange(chirp.CreatedAt) {
+				return true
+			}
+			chirps = append(chirps, dbStructure.withCounts(chirp))
+			return q.Limit == 0 || len(chirps) < q.Limit
+		}
+
+		if q.SortBy == ChirpSortCreatedAt {
+			ix := dbStructure.idx.chirpsByTime
+			if q.AuthorID != 0 {
+				ix = nil
+				if byAuthor, ok := dbStructure.idx.chirpsByAuthorAndTime[q.AuthorID]; ok {
+					ix = *byAuthor
+				}
+			}
+
+			var after *timeKey
+			if q.AfterID != 0 {
+				after = &timeKey{at: q.AfterCreatedAt, id: q.AfterID}
+			}
+			ix.scan(q.Since, q.Until, after, q.Desc, collect)
+			return nil
+		}
+
+		ids := dbStructure.idx.chirpIDs
+		if q.AuthorID != 0 {
+			ids = nil
+			if byAuthor, ok := dbStructure.idx.chirpsByAuthor[q.AuthorID]; ok {
+				ids = *byAuthor
+			}
+		}
+		ids.scan(q.AfterID, q.Desc, collect)
+		return nil
+	})
 	if err != nil {
 		return nil, err
 	}
 
-	chirps := make([]Chirp, 0, len(dbStructure.Chirps))
-	for _, chirp := range dbStructure.Chirps {
-		chirps = append(chirps, chirp)
+	return chirps, nil
+}
+
+// 获取指定 ID 的 Chirp，包括回收站中的 chirp（DeletedAt 不为 nil），由调用方决定是否可见
+func (db *DB) GetChirp(id int) (Chirp, error) {
+	chirp := Chirp{}
+	err := db.View(func(dbStructure *DBStructure) error {
+		var ok bool
+		chirp, ok = dbStructure.Chirps[id]
+		if !ok {
+			return ErrNotExist
+		}
+		chirp = dbStructure.withCounts(chirp)
+		return nil
+	})
+	if err != nil {
+		return Chirp{}, err
 	}
 
-	return chirps, nil
+	return chirp, nil
 }
 
+// ==== 编辑 Chirp ====
+/*
+UpdateChirp 修改 chirp 的正文、审核结果和实体。在一个 Update 事务中：
+1) 把修改前的正文保存为一个 ChirpRevision。
+2) 更新 chirp，并把 UpdatedAt 和 EditedAt 设为当前时间。
+3) 为新提及的用户创建通知，之前已经提及过的用户不会再收到通知。
+chirp 不存在或在回收站中时返回 ErrNotExist。
+*/
+func (db *DB) UpdateChirp(id int, body string, moderation ChirpModeration, entities []ChirpEntity) (Chirp, error) {
+	chirp := Chirp{}
+	err := db.Update(func(dbStructure *DBStructure) error {
+		old, ok := dbStructure.Chirps[id]
+		if !ok || old.DeletedAt != nil {
+			return ErrNotExist
+		}
 
+		now := time.Now().UTC()
+		revision := revisionOf(old, now)
+		revision.ID = dbStructure.nextID(chirpRevisionsTable.name)
+		chirpRevisionsTable.put(dbStructure, revision.ID, revision)
 
-// 获取指定 ID 的 Chirp
-func (db *DB) GetChirp(id int) (Chirp, error) {
-	dbStructure, err := db.loadDB()
+		chirp = old
+		chirp.Body = body
+		chirp.Moderation = moderation
+		chirp.Entities = entities
+		chirp.UpdatedAt = now
+		chirp.EditedAt = &now
+		chirpsTable.put(dbStructure, id, chirp)
+
+		mentioned := map[int]bool{}
+		for _, userID := range old.mentionedUsers() {
+			mentioned[userID] = true
+		}
+		dbStructure.notifyMentions(chirp, mentioned)
+		chirp = dbStructure.withCounts(chirp)
+		return nil
+	})
 	if err != nil {
 		return Chirp{}, err
 	}
 
-	chirp, ok := dbStructure.Chirps[id]
-	if !ok {
-		return Chirp{}, ErrNotExist
+	return chirp, nil
+}
+
+// ==== 删除 Chirp ====
+/*
+DeleteChirp 把 chirp 移入回收站（软删除），记录删除时间和执行删除的用户 deletedBy。
+chirp 不存在或已经在回收站中时返回 ErrNotExist。
+*/
+func (db *DB) DeleteChirp(id int, deletedBy int) error {
+	return db.Update(func(dbStructure *DBStructure) error {
+		chirp, ok := dbStructure.Chirps[id]
+		if !ok || chirp.DeletedAt != nil {
+			return ErrNotExist
+		}
+
+		now := time.Now().UTC()
+		chirp.DeletedAt = &now
+		chirp.DeletedBy = deletedBy
+		chirpsTable.put(dbStructure, id, chirp)
+		return nil
+	})
+}
+
+// RestoreChirp 把 chirp 移出回收站。chirp 不存在或不在回收站中时返回 ErrNotExist。
+// 恢复一个转发时，如果作者已经重新转发了同一个 chirp，返回 ErrAlreadyExists。
+func (db *DB) RestoreChirp(id int) (Chirp, error) {
+	chirp := Chirp{}
+	err := db.Update(func(dbStructure *DBStructure) error {
+		var ok bool
+		chirp, ok = dbStructure.Chirps[id]
+		if !ok || chirp.DeletedAt == nil {
+			return ErrNotExist
+		}
+		if chirp.RechirpOf != 0 {
+			if _, ok := dbStructure.idx.rechirps[chirp.RechirpOf][chirp.AuthorID]; ok {
+				return ErrAlreadyExists
+			}
+		}
+
+		chirp.DeletedAt = nil
+		chirp.DeletedBy = 0
+		chirpsTable.put(dbStructure, id, chirp)
+		chirp = dbStructure.withCounts(chirp)
+		return nil
+	})
+	if err != nil {
+		return Chirp{}, err
 	}
 
 	return chirp, nil
 }
 
+// ListDeletedChirps 返回作者回收站中的 chirp，最近删除的在前
+func (db *DB) ListDeletedChirps(authorID int) ([]Chirp, error) {
+	chirps := []Chirp{}
+	err := db.View(func(dbStructure *DBStructure) error {
+		for _, id := range dbStructure.idx.deletedChirps {
+			chirp := dbStructure.Chirps[id]
+			if chirp.AuthorID == authorID {
+				chirps = append(chirps, dbStructure.withCounts(chirp))
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
 
-// DeleteChirp
+	sortByDeletedAt(chirps)
+	return chirps, nil
+}
 
-func (db *DB) DeleteChirp(id int) error {
-	dbStructure, err := db.loadDB()
+// sortByDeletedAt 按删除时间降序排序，删除时间相同时 ID 大的在前
+func sortByDeletedAt(chirps []Chirp) {
+	sort.Slice(chirps, func(i, j int) bool {
+		a, b := chirps[i].DeletedAt, chirps[j].DeletedAt
+		if !a.Equal(*b) {
+			return a.After(*b)
+		}
+		return chirps[i].ID > chirps[j].ID
+	})
+}
+
+// ==== 清空回收站 ====
+/*
+PurgeChirps 永久删除在 deletedBefore 之前被删除的 chirp 以及它们的历史版本、点赞、投票和通知，返回删除的 chirp 数量。
+它们的媒体不再附加到任何 chirp，之后由 PurgeMedia 清理。
+所有删除在一个 Update 事务中完成。
+*/
+func (db *DB) PurgeChirps(deletedBefore time.Time) (int, error) {
+	purged := 0
+	err := db.Update(func(dbStructure *DBStructure) error {
+		// 复制一份，删除时会修改索引
+		ids := append([]int{}, dbStructure.idx.deletedChirps...)
+		for _, id := range ids {
+			if !dbStructure.Chirps[id].DeletedAt.Before(deletedBefore) {
+				continue
+			}
+			dbStructure.purgeChirp(id)
+			purged++
+		}
+		return nil
+	})
 	if err != nil {
-		return err
+		return 0, err
 	}
 
-	delete(dbStructure.Chirps, id)
-	err = db.writeDB(dbStructure)
-	if err != nil {
-		return err
+	return purged, nil
+}
+
+// purgeChirp 永久删除 chirp 以及它的历史版本、点赞、投票和通知，并解除它的媒体的附加，必须在 Update 事务中调用
+func (dbStructure *DBStructure) purgeChirp(id int) {
+	dbStructure.detachMedia(dbStructure.Chirps[id])
+
+	notificationIDs := []int{}
+	if byChirp, ok := dbStructure.idx.notificationsByChirp[id]; ok {
+		notificationIDs = append(notificationIDs, *byChirp...)
+	}
+	for _, notificationID := range notificationIDs {
+		notificationsTable.delete(dbStructure, notificationID)
 	}
 
-	return nil
+	for userID := range dbStructure.idx.likesByChirp[id] {
+		likesTable.delete(dbStructure, likeKey(id, userID))
+	}
+	for userID := range dbStructure.idx.pollVotes[id] {
+		pollVotesTable.delete(dbStructure, pollVoteKey(id, userID))
+	}
+
+	revisionIDs := []int{}
+	if byChirp, ok := dbStructure.idx.chirpRevisions[id]; ok {
+		revisionIDs = append(revisionIDs, *byChirp...)
+	}
+	for _, revisionID := range revisionIDs {
+		chirpRevisionsTable.delete(dbStructure, revisionID)
+	}
 
-}
\ No newline at end of file
+	chirpsTable.delete(dbStructure, id)
+}
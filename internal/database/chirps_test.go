@@ -0,0 +1,150 @@
+package database
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestStoreListChirps(t *testing.T) {
+	testStore(t, func(t *testing.T, st *storeHarness) {
+		st.createUser("walt@breakingbad.com")
+		st.createUser("jesse@breakingbad.com")
+		for i := 0; i < 5; i++ {
+			st.createChirp(1+i%2, "chirp")
+		}
+
+		tests := []struct {
+			name string
+			q    ChirpQuery
+			want []int
+		}{
+			{"all", ChirpQuery{}, []int{1, 2, 3, 4, 5}},
+			{"desc", ChirpQuery{Desc: true}, []int{5, 4, 3, 2, 1}},
+			{"author", ChirpQuery{AuthorID: 2}, []int{2, 4}},
+			{"limit", ChirpQuery{Limit: 2}, []int{1, 2}},
+			{"after", ChirpQuery{AfterID: 2, Limit: 2}, []int{3, 4}},
+			{"desc after", ChirpQuery{Desc: true, AfterID: 2}, []int{1}},
+			{"created_at", ChirpQuery{SortBy: ChirpSortCreatedAt}, []int{1, 2, 3, 4, 5}},
+		}
+		for _, tt := range tests {
+			chirps, err := st.ListChirps(tt.q)
+			if err != nil {
+				t.Fatalf("%s: ListChirps: %v", tt.name, err)
+			}
+			if got := chirpIDs(chirps); !equalIDs(got, tt.want) {
+				t.Errorf("%s: ListChirps = %v, want %v", tt.name, got, tt.want)
+			}
+		}
+	})
+}
+
+func TestStoreChirpTimestamps(t *testing.T) {
+	testStore(t, func(t *testing.T, st *storeHarness) {
+		st.createUser("walt@breakingbad.com")
+		chirps := []Chirp{}
+		for i := 0; i < 4; i++ {
+			chirps = append(chirps, st.createChirp(1, "chirp"))
+		}
+		for _, chirp := range chirps {
+			if chirp.CreatedAt.IsZero() || !chirp.UpdatedAt.Equal(chirp.CreatedAt) {
+				t.Fatalf("CreateChirp = %+v", chirp)
+			}
+		}
+
+		st.reopen()
+		got, err := st.GetChirp(1)
+		if err != nil || !got.CreatedAt.Equal(chirps[0].CreatedAt) {
+			t.Errorf("GetChirp after reopen CreatedAt = %v, %v, want %v", got.CreatedAt, err, chirps[0].CreatedAt)
+		}
+
+		// [Since, Until)
+		q := ChirpQuery{Since: chirps[1].CreatedAt, Until: chirps[3].CreatedAt}
+		if got, _ := st.ListChirps(q); !equalIDs(chirpIDs(got), []int{2, 3}) {
+			t.Errorf("ListChirps(since, until) = %v, want [2 3]", chirpIDs(got))
+		}
+	})
+}
+
+func TestStorePagination(t *testing.T) {
+	testStore(t, func(t *testing.T, st *storeHarness) {
+		st.createUser("walt@breakingbad.com")
+		for i := 0; i < 7; i++ {
+			st.createChirp(1, "chirp")
+		}
+
+		// 按 created_at 降序逐页读取，每一页从上一页最后一个 chirp 之后开始
+		seen := []int{}
+		q := ChirpQuery{SortBy: ChirpSortCreatedAt, Desc: true, Limit: 3}
+		for page := 0; page < 5; page++ {
+			chirps, err := st.ListChirps(q)
+			if err != nil {
+				t.Fatalf("ListChirps: %v", err)
+			}
+			if len(chirps) == 0 {
+				break
+			}
+			seen = append(seen, chirpIDs(chirps)...)
+			last := chirps[len(chirps)-1]
+			q.AfterID, q.AfterCreatedAt = last.ID, last.CreatedAt
+		}
+		if want := []int{7, 6, 5, 4, 3, 2, 1}; !equalIDs(seen, want) {
+			t.Errorf("pages = %v, want %v", seen, want)
+		}
+	})
+}
+
+func TestStoreSoftDelete(t *testing.T) {
+	testStore(t, func(t *testing.T, st *storeHarness) {
+		st.createUser("walt@breakingbad.com")
+		st.createChirp(1, "one")
+		st.createChirp(1, "two")
+
+		err := st.DeleteChirp(1, 1)
+		if err != nil {
+			t.Fatalf("DeleteChirp: %v", err)
+		}
+		err = st.DeleteChirp(1, 1)
+		if !errors.Is(err, ErrNotExist) {
+			t.Errorf("DeleteChirp twice error = %v, want ErrNotExist", err)
+		}
+		deleted, err := st.GetChirp(1)
+		if err != nil || deleted.DeletedAt == nil || deleted.DeletedBy != 1 {
+			t.Fatalf("GetChirp(deleted) = %+v, %v", deleted, err)
+		}
+		if chirps, _ := st.ListChirps(ChirpQuery{}); !equalIDs(chirpIDs(chirps), []int{2}) {
+			t.Errorf("ListChirps after delete = %v, want [2]", chirpIDs(chirps))
+		}
+		if trash, _ := st.ListDeletedChirps(1); !equalIDs(chirpIDs(trash), []int{1}) {
+			t.Errorf("ListDeletedChirps = %v, want [1]", chirpIDs(trash))
+		}
+
+		restored, err := st.RestoreChirp(1)
+		if err != nil || restored.DeletedAt != nil {
+			t.Fatalf("RestoreChirp = %+v, %v", restored, err)
+		}
+		_, err = st.RestoreChirp(1)
+		if !errors.Is(err, ErrNotExist) {
+			t.Errorf("RestoreChirp twice error = %v, want ErrNotExist", err)
+		}
+
+		st.DeleteChirp(1, 1)
+		n, err := st.PurgeChirps(time.Now().Add(-time.Hour))
+		if err != nil || n != 0 {
+			t.Errorf("PurgeChirps(before deletion) = %d, %v, want 0", n, err)
+		}
+		n, err = st.PurgeChirps(time.Now().Add(time.Second))
+		if err != nil || n != 1 {
+			t.Errorf("PurgeChirps = %d, %v, want 1", n, err)
+		}
+
+		st.reopen()
+		_, err = st.GetChirp(1)
+		if !errors.Is(err, ErrNotExist) {
+			t.Errorf("GetChirp(purged) error = %v, want ErrNotExist", err)
+		}
+		if trash, _ := st.ListDeletedChirps(1); len(trash) != 0 {
+			t.Errorf("ListDeletedChirps after purge = %v", chirpIDs(trash))
+		}
+	})
+}
@@ -3,50 +3,124 @@ package database
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
+	"io"
+	"log"
 	"os" // os 用于文件操作。
+	"path/filepath"
 	"sync"
 )
 
 var ErrNotExist = errors.New("resource does not exist")
 
 // 数据库结构体 DB
+/*
+DB 在 NewDB 之后把整个 DBStructure 缓存在内存中，读操作只需要在读锁下查 map。
+写操作通过 Update 追加到预写日志（见 wal.go），日志积累到一定数量后压缩成快照文件。
+*/
 type DB struct {
-	path string        // 数据库文件的路径。
-	mu   *sync.RWMutex // 读写锁（RWMutex），用于确保并发安全。
+	path    string        // 数据库快照文件的路径。
+	logPath string        // 预写日志文件的路径。
+	mu      *sync.RWMutex // 读写锁（RWMutex），用于确保并发安全。
+
+	data       DBStructure // 内存中的当前状态
+	log        *os.File    // 以追加模式打开的日志文件
+	logRecords int         // 上次压缩之后日志中的事务数
+	// 写日志失败、又无法把日志恢复到写入之前的状态时设置，之后的所有写操作都返回这个错误
+	broken error
 }
 
 // 数据库的内部结构，包含一个 Chirps 映射
 type DBStructure struct {
-	Chirps        map[int]Chirp           `json:"chirps"`
-	Users         map[int]User            `json:"users"`
-	RefreshTokens map[string]RefreshToken `json:"refresh_tokens"`
+	SchemaVersion   int                     `json:"schema_version"` // 见 migrations.go
+	Chirps          map[int]Chirp           `json:"chirps"`
+	ChirpRevisions  map[int]ChirpRevision   `json:"chirp_revisions"`
+	Users           map[int]User            `json:"users"`
+	RefreshTokens   map[string]RefreshToken `json:"refresh_tokens"`
+	Likes           map[string]Like         `json:"likes"`
+	Follows         map[string]Follow       `json:"follows"`
+	Notifications   map[int]Notification    `json:"notifications"`
+	Media           map[int]Media           `json:"media"`
+	PollVotes       map[string]PollVote     `json:"poll_votes"`
+	ScheduledChirps map[int]ScheduledChirp  `json:"scheduled_chirps"`
+	UserTokens      map[string]UserToken    `json:"user_tokens"`
+	Sequences       map[string]int          `json:"sequences"` // 每个集合已分配的最大 ID
+
+	changes []change // 当前事务中的修改，不会被编码
+	idx     *indexes // 内存索引，不会被编码
 }
 
 // ==== 创建新数据库 ====
 /*
-NewDB 函数创建一个新的 DB 对象，确保数据库文件存在。
-如果文件不存在，调用 ensureDB 函数创建它。
+NewDB 函数创建一个新的 DB 对象：
+1) 确保快照文件存在，如果不存在，调用 ensureDB 函数创建它。
+2) 加载快照，并重放上次退出前尚未压缩的日志。
+3) 需要迁移时，先原样备份快照和日志：压缩会用当前的 DBStructure 重写快照，丢掉它不认识的字段。
+4) 压缩：把恢复出的状态写成新的快照，并清空日志。
+5) 执行尚未执行的 schema 迁移（见 migrations.go）。
 */
 func NewDB(path string) (*DB, error) {
 	db := &DB{
-		path: path,
-		mu:   &sync.RWMutex{},
+		path:    path,
+		logPath: path + ".log",
+		mu:      &sync.RWMutex{},
 	}
 	err := db.ensureDB()
-	return db, err
+	if err != nil {
+		return db, err
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	db.data, err = db.readDB()
+	if err != nil {
+		return db, err
+	}
+	_, err = replayLog(db.logPath, &db.data)
+	if err != nil {
+		return db, err
+	}
+
+	from := db.data.SchemaVersion
+	if from > latestSchemaVersion() {
+		return db, errSchemaTooNew(db.path, from)
+	}
+	backupPath := ""
+	if from < latestSchemaVersion() {
+		backupPath, err = db.backup(from)
+		if err != nil {
+			return db, err
+		}
+	}
+
+	db.log, err = os.OpenFile(db.logPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return db, err
+	}
+	err = db.compact()
+	if err != nil {
+		return db, err
+	}
+	return db, db.migrate(backupPath)
 }
 
 // ==== 创建数据库文件 ====
 /*
 createDB 方法创建一个新的空数据库文件，包含一个空的 Chirps 映射，并将其写回文件。
+调用方必须持有写锁。
 */
 func (db *DB) createDB() error {
+	return db.writeDB(newDBStructure())
+}
+
+// newDBStructure 返回一个空的、处于最新 schema 版本的数据库结构
+func newDBStructure() DBStructure {
 	dbStructure := DBStructure{
-		Chirps:        map[int]Chirp{},
-		Users:         map[int]User{},
-		RefreshTokens: map[string]RefreshToken{},
+		SchemaVersion: latestSchemaVersion(),
 	}
-	return db.writeDB(dbStructure)
+	dbStructure.initCollections()
+	return dbStructure
 }
 
 //  确保数据库文件存在
@@ -54,35 +128,166 @@ func (db *DB) createDB() error {
 ensureDB 方法检查数据库文件是否存在，如果不存在，则创建一个新的数据库文件。
 */
 func (db *DB) ensureDB() error {
-	_, err := os.ReadFile(db.path)
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	_, err := os.Stat(db.path)
 	if errors.Is(err, os.ErrNotExist) {
 		return db.createDB()
 	}
 	return err
 }
 
+// 关闭前压缩日志，下次启动时无需重放
+func (db *DB) Close() error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	err := db.compact()
+	if err != nil {
+		return err
+	}
+	return db.log.Close()
+}
+
 // 重置数据库
 func (db *DB) ResetDB() error {
-	err := os.Remove(db.path)
-	if errors.Is(err, os.ErrNotExist) {
-		return nil
-	}
-	return db.ensureDB()
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	db.data = newDBStructure()
+	return db.compact()
 }
 
-// ==== 加载数据库 ====
+// ==== 只读访问 ====
 /*
-1) 使用读锁确保并发安全。
-2) 读取文件内容。
-3) 将文件内容解码为 DBStructure。
+View 在读锁内用内存中的状态调用 fn。
+fn 不能修改 dbStructure，也不能在返回后继续持有其中的 map，修改请使用 Update。
 */
-func (db *DB) loadDB() (DBStructure, error) {
+func (db *DB) View(fn func(dbStructure *DBStructure) error) error {
 	db.mu.RLock()
 	defer db.mu.RUnlock()
 
+	return fn(&db.data)
+}
+
+// ==== 读写事务 ====
+/*
+Update 在整个 读取-修改-写入 过程中持有写锁，避免并发请求互相覆盖（丢失更新）。
+1) 调用 fn 修改内存中的 dbStructure，fn 必须通过 table 的 put/delete 修改集合。
+2) fn 返回 nil 时把本次事务的修改作为一行追加到日志并 fsync；
+   fn 返回错误或写日志失败时回滚内存中的修改，并把错误原样返回。
+3) 日志达到 compactThreshold 条后压缩成快照。此时事务已经持久化，压缩失败只记录日志，下一次写入时重试。
+*/
+func (db *DB) Update(fn func(dbStructure *DBStructure) error) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if db.broken != nil {
+		return db.broken
+	}
+
+	err := fn(&db.data)
+	if err != nil {
+		db.data.rollback()
+		return err
+	}
+	if len(db.data.changes) == 0 {
+		return nil
+	}
+
+	err = db.appendLog(db.data.changes)
+	if err != nil {
+		db.data.rollback()
+		return err
+	}
+	db.data.changes = nil
+
+	if db.logRecords >= compactThreshold {
+		err = db.compact()
+		if err != nil {
+			log.Printf("Couldn't compact database log: %s", err)
+		}
+	}
+	return nil
+}
+
+// ==== 写日志 ====
+/*
+appendLog 把一个事务写入日志，调用方必须持有写锁。
+写入或 fsync 失败时把日志截断回写入之前的长度：
+否则只写了一半的行会和下一个事务连在一起，重放时成为损坏的记录；fsync 失败的事务也可能在重启后重新出现。
+截断也失败时把 DB 标记为损坏，之后的写操作都返回错误。
+*/
+func (db *DB) appendLog(changes []change) error {
+	dat, err := encodeChanges(changes)
+	if err != nil {
+		return err
+	}
+
+	offset, err := db.log.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+	_, err = db.log.Write(dat)
+	if err == nil {
+		err = db.log.Sync()
+	}
+	if err != nil {
+		db.truncateLog(offset, err)
+		return err
+	}
+
+	db.logRecords++
+	return nil
+}
+
+// truncateLog 在写入失败（原因是 cause）之后把日志恢复到 offset 字节
+func (db *DB) truncateLog(offset int64, cause error) {
+	err := db.log.Truncate(offset)
+	if err == nil {
+		_, err = db.log.Seek(offset, io.SeekStart)
+	}
+	if err == nil {
+		err = db.log.Sync()
+	}
+	if err != nil {
+		db.broken = fmt.Errorf("database log is unusable after failed write (%v): %w", cause, err)
+		log.Print(db.broken)
+	}
+}
+
+// ==== 压缩 ====
+/*
+compact 把内存状态写成新的快照，然后清空日志，调用方必须持有写锁。
+快照写入是原子的；如果在清空日志前崩溃，重放已经包含在快照中的日志也是安全的。
+*/
+func (db *DB) compact() error {
+	err := db.writeDB(db.data)
+	if err != nil {
+		return err
+	}
+
+	err = db.log.Truncate(0)
+	if err != nil {
+		return err
+	}
+	err = db.log.Sync()
+	if err != nil {
+		return err
+	}
+
+	db.logRecords = 0
+	return nil
+}
+
+/*
+readDB 读取快照文件并解码为 DBStructure。
+*/
+func (db *DB) readDB() (DBStructure, error) {
 	dbStructure := DBStructure{}
 	dat, err := os.ReadFile(db.path)
-	if errors.Is(err, os.ErrNotExist) {
+	if err != nil {
 		return dbStructure, err
 	}
 	err = json.Unmarshal(dat, &dbStructure) // 解码
@@ -90,27 +295,65 @@ func (db *DB) loadDB() (DBStructure, error) {
 		return dbStructure, err
 	}
 
+	dbStructure.initCollections()
 	return dbStructure, nil
 }
 
 // ==== 写入数据库 ====
 /*
-1) 使用写锁确保并发安全。
-2) 将数据库结构编码为 JSON。
-3) 将 JSON 数据写入文件。
+writeDB 原子地写入快照文件，调用方必须持有写锁。
+1) 将数据库结构编码为 JSON。
+2) 写入同目录下的临时文件并 fsync，确保数据落盘。
+3) 用 rename 替换原文件：rename 是原子的，崩溃时文件要么是旧内容，要么是新内容，不会只写了一半。
+4) fsync 目录，确保 rename 本身也已持久化。
 */
 func (db *DB) writeDB(dbStructure DBStructure) error {
-	db.mu.Lock()
-	defer db.mu.Unlock()
-
 	dat, err := json.Marshal(dbStructure) // 编码
 	if err != nil {
 		return err
 	}
 
-	err = os.WriteFile(db.path, dat, 0600)
+	return writeFileAtomic(db.path, dat)
+}
+
+func writeFileAtomic(path string, dat []byte) error {
+	dir := filepath.Dir(path)
+	tmp, err := os.CreateTemp(dir, filepath.Base(path)+".tmp-*")
 	if err != nil {
 		return err
 	}
-	return nil
+	// rename 成功后 Remove 会失败，这里忽略它的错误
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(dat)
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+	err = tmp.Sync()
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+	err = tmp.Close()
+	if err != nil {
+		return err
+	}
+
+	err = os.Rename(tmp.Name(), path)
+	if err != nil {
+		return err
+	}
+
+	return syncDir(dir)
+}
+
+// syncDir fsync 目录，让目录项的变更（创建、rename）持久化
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
 }
@@ -0,0 +1,165 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+// TestUpdateConcurrent 并发创建用户和 chirp，检查没有丢失的更新、ID 没有重复，并且重新打开后结果不变。
+// 用 go test -race 运行时也会检查数据竞争。
+func TestUpdateConcurrent(t *testing.T) {
+	const n = 50
+	path := filepath.Join(t.TempDir(), "db.json")
+	db, err := NewDB(path)
+	if err != nil {
+		t.Fatalf("NewDB: %v", err)
+	}
+
+	var wg sync.WaitGroup
+	userIDs := make([]int, n)
+	chirpIDs := make([]int, n)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			user, err := db.CreateUser(fmt.Sprintf("user%d@example.com", i), "hash")
+			if err != nil {
+				t.Errorf("CreateUser: %v", err)
+				return
+			}
+			userIDs[i] = user.ID
+			chirp, err := db.CreateChirp(Chirp{AuthorID: user.ID, Body: "chirp"})
+			if err != nil {
+				t.Errorf("CreateChirp: %v", err)
+				return
+			}
+			chirpIDs[i] = chirp.ID
+		}(i)
+	}
+	wg.Wait()
+	if t.Failed() {
+		return
+	}
+
+	check := func(db *DB) {
+		t.Helper()
+		for _, ids := range [][]int{userIDs, chirpIDs} {
+			seen := map[int]bool{}
+			for _, id := range ids {
+				if seen[id] {
+					t.Errorf("duplicate ID %d", id)
+				}
+				seen[id] = true
+			}
+		}
+		err := db.View(func(dbStructure *DBStructure) error {
+			if len(dbStructure.Users) != n || len(dbStructure.Chirps) != n {
+				t.Errorf("got %d users and %d chirps, want %d", len(dbStructure.Users), len(dbStructure.Chirps), n)
+			}
+			for _, id := range userIDs {
+				if _, ok := dbStructure.Users[id]; !ok {
+					t.Errorf("user %d is missing", id)
+				}
+			}
+			for _, id := range chirpIDs {
+				if _, ok := dbStructure.Chirps[id]; !ok {
+					t.Errorf("chirp %d is missing", id)
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("View: %v", err)
+		}
+	}
+	check(db)
+
+	err = db.Close()
+	if err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	db, err = NewDB(path)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer db.Close()
+	check(db)
+}
+
+// TestTruncateLogAfterFailedWrite 模拟写了一半的事务：截断之后的事务不能和残留的字节连在一起，重新打开时能正常重放
+func TestTruncateLogAfterFailedWrite(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "db.json")
+	db, err := NewDB(path)
+	if err != nil {
+		t.Fatalf("NewDB: %v", err)
+	}
+	db.CreateUser("walt@breakingbad.com", "hash")
+
+	offset, err := db.log.Seek(0, io.SeekEnd)
+	if err != nil {
+		t.Fatalf("Seek: %v", err)
+	}
+	_, err = db.log.Write([]byte(`{"changes":[{"op":"put","coll`))
+	if err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	db.truncateLog(offset, errors.New("short write"))
+	if db.broken != nil {
+		t.Fatalf("broken = %v", db.broken)
+	}
+
+	_, err = db.CreateChirp(Chirp{AuthorID: 1, Body: "Say my name"})
+	if err != nil {
+		t.Fatalf("CreateChirp: %v", err)
+	}
+	// 不压缩，直接从日志重放
+	db.log.Close()
+
+	db, err = NewDB(path)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer db.Close()
+	chirp, err := db.GetChirp(1)
+	if err != nil || chirp.Body != "Say my name" {
+		t.Errorf("GetChirp = %+v, %v", chirp, err)
+	}
+}
+
+// TestUpdateBrokenLog 写日志失败并且无法截断时，事务被回滚，之后的写操作都返回错误
+func TestUpdateBrokenLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "db.json")
+	db, err := NewDB(path)
+	if err != nil {
+		t.Fatalf("NewDB: %v", err)
+	}
+	defer db.log.Close()
+
+	// 只读打开的日志文件既不能写入也不能截断
+	readOnly, err := os.Open(db.logPath)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	db.log.Close()
+	db.log = readOnly
+
+	_, err = db.CreateUser("walt@breakingbad.com", "hash")
+	if err == nil {
+		t.Fatal("CreateUser succeeded with an unwritable log")
+	}
+	if _, err := db.GetUserByEmail("walt@breakingbad.com"); !errors.Is(err, ErrNotExist) {
+		t.Errorf("GetUserByEmail error = %v, want ErrNotExist (rolled back)", err)
+	}
+	if db.broken == nil {
+		t.Fatal("DB is not marked as broken")
+	}
+	_, err = db.CreateUser("jesse@breakingbad.com", "hash")
+	if !errors.Is(err, db.broken) {
+		t.Errorf("CreateUser after failure error = %v, want %v", err, db.broken)
+	}
+}
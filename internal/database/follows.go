@@ -0,0 +1,124 @@
+package database
+
+import (
+	"fmt"
+	"time"
+)
+
+// Follow 表示 FollowerID 关注了 FolloweeID
+type Follow struct {
+	FollowerID int       `json:"follower_id"`
+	FolloweeID int       `json:"followee_id"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// follows 集合的键是 "<关注者 ID>:<被关注者 ID>"
+func followKey(followerID, followeeID int) string {
+	return fmt.Sprintf("%d:%d", followerID, followeeID)
+}
+
+var followsTable = table[string, Follow]{
+	name:  "follows",
+	m:     func(dbStructure *DBStructure) *map[string]Follow { return &dbStructure.Follows },
+	index: indexFollow,
+}
+
+// indexFollow 维护每个用户关注的人和粉丝，都按 (关注时间, 用户 ID) 排序
+func indexFollow(dbStructure *DBStructure, key string, old, new *Follow) {
+	idx := dbStructure.idx
+	if old != nil {
+		if following, ok := idx.following[old.FollowerID]; ok {
+			following.remove(timeKey{at: old.CreatedAt, id: old.FolloweeID})
+		}
+		if followers, ok := idx.followers[old.FolloweeID]; ok {
+			followers.remove(timeKey{at: old.CreatedAt, id: old.FollowerID})
+		}
+	}
+	if new != nil {
+		following, ok := idx.following[new.FollowerID]
+		if !ok {
+			following = &timeIndex{}
+			idx.following[new.FollowerID] = following
+		}
+		following.insert(timeKey{at: new.CreatedAt, id: new.FolloweeID})
+
+		followers, ok := idx.followers[new.FolloweeID]
+		if !ok {
+			followers = &timeIndex{}
+			idx.followers[new.FolloweeID] = followers
+		}
+		followers.insert(timeKey{at: new.CreatedAt, id: new.FollowerID})
+	}
+}
+
+// ==== 关注 ====
+/*
+Follow 是幂等的：已经关注时什么都不做。被关注的用户不存在时返回 ErrNotExist。
+*/
+func (db *DB) Follow(followerID, followeeID int) error {
+	return db.Update(func(dbStructure *DBStructure) error {
+		if _, ok := dbStructure.Users[followeeID]; !ok {
+			return ErrNotExist
+		}
+
+		key := followKey(followerID, followeeID)
+		if _, ok := dbStructure.Follows[key]; ok {
+			return nil
+		}
+		followsTable.put(dbStructure, key, Follow{
+			FollowerID: followerID,
+			FolloweeID: followeeID,
+			CreatedAt:  time.Now().UTC(),
+		})
+		return nil
+	})
+}
+
+// Unfollow 取消关注，没有关注时什么都不做
+func (db *DB) Unfollow(followerID, followeeID int) error {
+	return db.Update(func(dbStructure *DBStructure) error {
+		followsTable.delete(dbStructure, followKey(followerID, followeeID))
+		return nil
+	})
+}
+
+// ListFollowers 返回用户的粉丝，最近关注的在前。用户不存在时返回 ErrNotExist。
+func (db *DB) ListFollowers(userID int) ([]Follow, error) {
+	return db.listFollows(userID, func(dbStructure *DBStructure) *timeIndex {
+		return dbStructure.idx.followers[userID]
+	}, func(id int) string {
+		return followKey(id, userID)
+	})
+}
+
+// ListFollowing 返回用户关注的人，最近关注的在前。用户不存在时返回 ErrNotExist。
+func (db *DB) ListFollowing(userID int) ([]Follow, error) {
+	return db.listFollows(userID, func(dbStructure *DBStructure) *timeIndex {
+		return dbStructure.idx.following[userID]
+	}, func(id int) string {
+		return followKey(userID, id)
+	})
+}
+
+func (db *DB) listFollows(userID int, index func(*DBStructure) *timeIndex, key func(id int) string) ([]Follow, error) {
+	follows := []Follow{}
+	err := db.View(func(dbStructure *DBStructure) error {
+		if _, ok := dbStructure.Users[userID]; !ok {
+			return ErrNotExist
+		}
+		ix := index(dbStructure)
+		if ix == nil {
+			return nil
+		}
+		ix.scan(time.Time{}, time.Time{}, nil, true, func(id int) bool {
+			follows = append(follows, dbStructure.Follows[key(id)])
+			return true
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return follows, nil
+}
@@ -0,0 +1,53 @@
+package database
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestStoreFollowsAndTimeline(t *testing.T) {
+	testStore(t, func(t *testing.T, st *storeHarness) {
+		st.createUser("walt@breakingbad.com")
+		st.createUser("jesse@breakingbad.com")
+		st.createUser("skyler@breakingbad.com")
+		st.createChirp(2, "jesse 1")
+		st.createChirp(3, "skyler 1")
+		st.createChirp(1, "walt 1")
+		st.createChirp(2, "jesse 2")
+
+		for _, f := range [][2]int{{1, 2}, {1, 3}, {3, 2}} {
+			err := st.Follow(f[0], f[1])
+			if err != nil {
+				t.Fatalf("Follow(%d, %d): %v", f[0], f[1], err)
+			}
+		}
+		err := st.Unfollow(1, 3)
+		if err != nil {
+			t.Fatalf("Unfollow: %v", err)
+		}
+
+		st.reopen()
+		followers, err := st.ListFollowers(2)
+		if err != nil || len(followers) != 2 {
+			t.Fatalf("ListFollowers = %+v, %v", followers, err)
+		}
+		following, err := st.ListFollowing(1)
+		if err != nil || len(following) != 1 || following[0].FolloweeID != 2 {
+			t.Fatalf("ListFollowing = %+v, %v", following, err)
+		}
+		_, err = st.ListFollowers(99)
+		if !errors.Is(err, ErrNotExist) {
+			t.Errorf("ListFollowers(99) error = %v, want ErrNotExist", err)
+		}
+
+		// 时间线只包含关注的人的 chirp，最新的在前
+		timeline, err := st.Timeline(TimelineQuery{UserID: 1, Limit: 1})
+		if err != nil || !equalIDs(chirpIDs(timeline), []int{4}) {
+			t.Fatalf("Timeline page 1 = %v, %v, want [4]", chirpIDs(timeline), err)
+		}
+		timeline, err = st.Timeline(TimelineQuery{UserID: 1, AfterID: 4, AfterCreatedAt: timeline[0].CreatedAt})
+		if err != nil || !equalIDs(chirpIDs(timeline), []int{1}) {
+			t.Errorf("Timeline page 2 = %v, %v, want [1]", chirpIDs(timeline), err)
+		}
+	})
+}
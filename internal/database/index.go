@@ -0,0 +1,190 @@
+package database
+
+import (
+	"sort"
+	"time"
+)
+
+// ==== 内存索引 ====
+/*
+indexes 保存从集合派生出来的二级索引，只存在于内存中，不会被编码到快照或日志里。
+加载数据库时由 initCollections 重建，之后由各个 table 的 index 钩子在记录变化时增量维护。
+*/
+type indexes struct {
+	chirpIDs              sortedIDs                // 所有 chirp 的 ID
+	chirpsByAuthor        map[int]*sortedIDs       // 作者 ID -> 该作者的 chirp ID
+	chirpsByTime          timeIndex                // 所有 chirp，按 (created_at, id) 排序
+	chirpsByAuthorAndTime map[int]*timeIndex       // 作者 ID -> 该作者的 chirp，按 (created_at, id) 排序
+	search                *searchIndex             // chirp 正文的倒排索引，见 search.go
+	chirpRevisions        map[int]*sortedIDs       // chirp ID -> 该 chirp 的历史版本 ID
+	deletedChirps         sortedIDs                // 回收站中的 chirp 的 ID，不在上面的 chirp 索引中
+	replies               map[int]*sortedIDs       // chirp ID -> 它的直接回复的 ID（不包括回收站中的）
+	likesByChirp          map[int]map[int]struct{} // chirp ID -> 赞过它的用户 ID
+	rechirps              map[int]map[int]int      // chirp ID -> 转发者 ID -> 转发的 ID（不包括回收站中的）
+	quotes                map[int]map[int]struct{} // chirp ID -> 引用它的 chirp 的 ID（不包括回收站中的）
+	likesByUser           map[int]*timeIndex       // 用户 ID -> 赞过的 chirp，按 (点赞时间, chirp ID) 排序
+	following             map[int]*timeIndex       // 用户 ID -> 关注的人，按 (关注时间, 用户 ID) 排序
+	usersByHandle         map[string]int           // 小写的 handle -> 用户 ID
+	usersByEmail          map[string]int           // 邮箱 -> 用户 ID
+	tags                  map[string]*timeIndex    // 话题 -> 使用它的 chirp（不包括回收站中的），按 (created_at, id) 排序
+	notificationsByUser   map[int]*sortedIDs       // 用户 ID -> 该用户的通知 ID
+	notificationsByChirp  map[int]*sortedIDs       // chirp ID -> 由它产生的通知 ID
+	followers             map[int]*timeIndex       // 用户 ID -> 粉丝，按 (关注时间, 用户 ID) 排序
+	mediaByHash           map[string]int           // 文件哈希 -> 引用它的媒体记录数量
+	unattachedMedia       sortedIDs                // 没有附加到 chirp 的媒体 ID
+	pollVotes             map[int]map[int]int      // chirp ID -> 投票的用户 ID -> 选择的选项
+	pollTallies           map[int]map[int]int      // chirp ID -> 选项 -> 票数
+	scheduledByAuthor     map[int]*sortedIDs       // 作者 ID -> 该作者的定时 chirp ID
+	scheduledByTime       timeIndex                // 等待发布的定时 chirp，按 (publish_at, id) 排序
+}
+
+func newIndexes() *indexes {
+	return &indexes{
+		chirpsByAuthor:        map[int]*sortedIDs{},
+		chirpsByAuthorAndTime: map[int]*timeIndex{},
+		search:                newSearchIndex(),
+		chirpRevisions:        map[int]*sortedIDs{},
+		replies:               map[int]*sortedIDs{},
+		likesByChirp:          map[int]map[int]struct{}{},
+		rechirps:              map[int]map[int]int{},
+		quotes:                map[int]map[int]struct{}{},
+		likesByUser:           map[int]*timeIndex{},
+		following:             map[int]*timeIndex{},
+		usersByHandle:         map[string]int{},
+		usersByEmail:          map[string]int{},
+		tags:                  map[string]*timeIndex{},
+		notificationsByUser:   map[int]*sortedIDs{},
+		notificationsByChirp:  map[int]*sortedIDs{},
+		followers:             map[int]*timeIndex{},
+		mediaByHash:           map[string]int{},
+		pollVotes:             map[int]map[int]int{},
+		pollTallies:           map[int]map[int]int{},
+		scheduledByAuthor:     map[int]*sortedIDs{},
+	}
+}
+
+// sortedIDs 是一个升序、无重复的 ID 列表。
+// ID 由序列递增分配，新记录总是追加在末尾，insert 通常是 O(1)。
+type sortedIDs []int
+
+func (ids *sortedIDs) insert(id int) {
+	s := *ids
+	i := sort.SearchInts(s, id)
+	if i < len(s) && s[i] == id {
+		return
+	}
+	s = append(s, 0)
+	copy(s[i+1:], s[i:])
+	s[i] = id
+	*ids = s
+}
+
+func (ids *sortedIDs) remove(id int) {
+	s := *ids
+	i := sort.SearchInts(s, id)
+	if i == len(s) || s[i] != id {
+		return
+	}
+	*ids = append(s[:i], s[i+1:]...)
+}
+
+// scan 按升序（desc 为 true 时按降序）遍历位于 after 之后的 ID，fn 返回 false 时停止。
+// after 为 0 时从头开始。
+func (ids sortedIDs) scan(after int, desc bool, fn func(id int) bool) {
+	if !desc {
+		i := sort.SearchInts(ids, after+1)
+		for ; i < len(ids); i++ {
+			if !fn(ids[i]) {
+				return
+			}
+		}
+		return
+	}
+
+	i := len(ids) - 1
+	if after > 0 {
+		i = sort.SearchInts(ids, after) - 1
+	}
+	for ; i >= 0; i-- {
+		if !fn(ids[i]) {
+			return
+		}
+	}
+}
+
+// timeKey 是 timeIndex 中的一项，按时间排序，时间相同时按 ID 排序
+type timeKey struct {
+	at time.Time
+	id int
+}
+
+func (k timeKey) less(other timeKey) bool {
+	if !k.at.Equal(other.at) {
+		return k.at.Before(other.at)
+	}
+	return k.id < other.id
+}
+
+// timeIndex 是按 timeKey 升序排列的列表
+type timeIndex []timeKey
+
+// search 返回第一个不小于 key 的位置
+func (ix timeIndex) search(key timeKey) int {
+	return sort.Search(len(ix), func(i int) bool {
+		return !ix[i].less(key)
+	})
+}
+
+func (ix *timeIndex) insert(key timeKey) {
+	s := *ix
+	i := s.search(key)
+	if i < len(s) && !key.less(s[i]) {
+		return
+	}
+	s = append(s, timeKey{})
+	copy(s[i+1:], s[i:])
+	s[i] = key
+	*ix = s
+}
+
+func (ix *timeIndex) remove(key timeKey) {
+	s := *ix
+	i := s.search(key)
+	if i == len(s) || key.less(s[i]) {
+		return
+	}
+	*ix = append(s[:i], s[i+1:]...)
+}
+
+// scan 遍历时间位于 [since, until) 之间、并且排序上位于 after 之后的项，fn 返回 false 时停止。
+// since、until 为零值表示不限制，after 为 nil 表示从头开始。
+func (ix timeIndex) scan(since, until time.Time, after *timeKey, desc bool, fn func(id int) bool) {
+	start, end := 0, len(ix)
+	if !since.IsZero() {
+		start = ix.search(timeKey{at: since})
+	}
+	if !until.IsZero() {
+		end = ix.search(timeKey{at: until})
+	}
+	if after != nil {
+		if desc {
+			end = min(end, ix.search(*after))
+		} else {
+			start = max(start, ix.search(timeKey{at: after.at, id: after.id + 1}))
+		}
+	}
+
+	if !desc {
+		for i := start; i < end; i++ {
+			if !fn(ix[i].id) {
+				return
+			}
+		}
+		return
+	}
+	for i := end - 1; i >= start; i-- {
+		if !fn(ix[i].id) {
+			return
+		}
+	}
+}
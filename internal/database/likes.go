@@ -0,0 +1,152 @@
+package database
+
+import (
+	"fmt"
+	"time"
+)
+
+// Like 表示一个用户赞了一个 chirp，每个用户对每个 chirp 最多一个
+type Like struct {
+	ChirpID   int       `json:"chirp_id"`
+	UserID    int       `json:"user_id"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// likes 集合的键是 "<chirp ID>:<用户 ID>"
+func likeKey(chirpID, userID int) string {
+	return fmt.Sprintf("%d:%d", chirpID, userID)
+}
+
+var likesTable = table[string, Like]{
+	name:  "likes",
+	m:     func(dbStructure *DBStructure) *map[string]Like { return &dbStructure.Likes },
+	index: indexLike,
+}
+
+// indexLike 维护 chirp -> 点赞用户，以及用户 -> 按点赞时间排序的 chirp 的索引
+func indexLike(dbStructure *DBStructure, key string, old, new *Like) {
+	idx := dbStructure.idx
+	if old != nil {
+		delete(idx.likesByChirp[old.ChirpID], old.UserID)
+		if len(idx.likesByChirp[old.ChirpID]) == 0 {
+			delete(idx.likesByChirp, old.ChirpID)
+		}
+		if byUser, ok := idx.likesByUser[old.UserID]; ok {
+			byUser.remove(timeKey{at: old.CreatedAt, id: old.ChirpID})
+		}
+	}
+	if new != nil {
+		byChirp, ok := idx.likesByChirp[new.ChirpID]
+		if !ok {
+			byChirp = map[int]struct{}{}
+			idx.likesByChirp[new.ChirpID] = byChirp
+		}
+		byChirp[new.UserID] = struct{}{}
+
+		byUser, ok := idx.likesByUser[new.UserID]
+		if !ok {
+			byUser = &timeIndex{}
+			idx.likesByUser[new.UserID] = byUser
+		}
+		byUser.insert(timeKey{at: new.CreatedAt, id: new.ChirpID})
+	}
+}
+
+// ==== 点赞 ====
+/*
+LikeChirp 是幂等的：已经赞过时什么都不做。
+chirp 不存在或在回收站中时返回 ErrNotExist。返回点赞后的 chirp。
+*/
+func (db *DB) LikeChirp(chirpID, userID int) (Chirp, error) {
+	chirp := Chirp{}
+	err := db.Update(func(dbStructure *DBStructure) error {
+		var ok bool
+		chirp, ok = dbStructure.Chirps[chirpID]
+		if !ok || chirp.DeletedAt != nil {
+			return ErrNotExist
+		}
+
+		key := likeKey(chirpID, userID)
+		if _, ok := dbStructure.Likes[key]; !ok {
+			likesTable.put(dbStructure, key, Like{
+				ChirpID:   chirpID,
+				UserID:    userID,
+				CreatedAt: time.Now().UTC(),
+			})
+		}
+		chirp = dbStructure.withCounts(chirp)
+		return nil
+	})
+	if err != nil {
+		return Chirp{}, err
+	}
+
+	return chirp, nil
+}
+
+// UnlikeChirp 取消点赞，同样是幂等的。chirp 不存在或在回收站中时返回 ErrNotExist。
+func (db *DB) UnlikeChirp(chirpID, userID int) (Chirp, error) {
+	chirp := Chirp{}
+	err := db.Update(func(dbStructure *DBStructure) error {
+		var ok bool
+		chirp, ok = dbStructure.Chirps[chirpID]
+		if !ok || chirp.DeletedAt != nil {
+			return ErrNotExist
+		}
+
+		likesTable.delete(dbStructure, likeKey(chirpID, userID))
+		chirp = dbStructure.withCounts(chirp)
+		return nil
+	})
+	if err != nil {
+		return Chirp{}, err
+	}
+
+	return chirp, nil
+}
+
+// LikedByUser 返回 chirpIDs 中被 userID 赞过的 chirp
+func (db *DB) LikedByUser(userID int, chirpIDs []int) (map[int]bool, error) {
+	liked := map[int]bool{}
+	err := db.View(func(dbStructure *DBStructure) error {
+		for _, chirpID := range chirpIDs {
+			if _, ok := dbStructure.idx.likesByChirp[chirpID][userID]; ok {
+				liked[chirpID] = true
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return liked, nil
+}
+
+// ListLikedChirps 返回用户赞过的 chirp（不包括回收站中的），最近赞的在前。
+// 用户不存在时返回 ErrNotExist。
+func (db *DB) ListLikedChirps(userID int) ([]Chirp, error) {
+	chirps := []Chirp{}
+	err := db.View(func(dbStructure *DBStructure) error {
+		if _, ok := dbStructure.Users[userID]; !ok {
+			return ErrNotExist
+		}
+		byUser, ok := dbStructure.idx.likesByUser[userID]
+		if !ok {
+			return nil
+		}
+		byUser.scan(time.Time{}, time.Time{}, nil, true, func(chirpID int) bool {
+			chirp := dbStructure.Chirps[chirpID]
+			if chirp.DeletedAt == nil {
+				chirps = append(chirps, dbStructure.withCounts(chirp))
+			}
+			return true
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return chirps, nil
+}
@@ -0,0 +1,40 @@
+package database
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestStoreLikes(t *testing.T) {
+	testStore(t, func(t *testing.T, st *storeHarness) {
+		st.createUser("walt@breakingbad.com")
+		st.createUser("jesse@breakingbad.com")
+		st.createChirp(1, "one")
+		st.createChirp(1, "two")
+
+		st.LikeChirp(1, 1)
+		// 重复点赞不会重复计数
+		st.LikeChirp(1, 1)
+		chirp, err := st.LikeChirp(1, 2)
+		if err != nil || chirp.LikeCount != 2 {
+			t.Fatalf("LikeChirp = %+v, %v, want 2 likes", chirp, err)
+		}
+		_, err = st.LikeChirp(99, 2)
+		if !errors.Is(err, ErrNotExist) {
+			t.Errorf("LikeChirp(99) error = %v, want ErrNotExist", err)
+		}
+
+		st.reopen()
+		liked, err := st.LikedByUser(1, []int{1, 2, 99})
+		if err != nil || !liked[1] || liked[2] || liked[99] {
+			t.Errorf("LikedByUser = %v, %v", liked, err)
+		}
+		if chirps, _ := st.ListLikedChirps(2); !equalIDs(chirpIDs(chirps), []int{1}) {
+			t.Errorf("ListLikedChirps = %v, want [1]", chirpIDs(chirps))
+		}
+		chirp, err = st.UnlikeChirp(1, 1)
+		if err != nil || chirp.LikeCount != 1 {
+			t.Errorf("UnlikeChirp = %+v, %v, want 1 like", chirp, err)
+		}
+	})
+}
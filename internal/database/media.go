@@ -0,0 +1,159 @@
+package database
+
+import (
+	"errors"
+	"sort"
+	"time"
+)
+
+// ErrMediaNotAvailable 表示要附加的媒体不存在、不属于 chirp 的作者，或者已经附加到了其他 chirp（或被定时 chirp 预留、被用作头像）
+var ErrMediaNotAvailable = errors.New("media is not available")
+
+// Media 是一次上传的记录。文件按内容的哈希保存（见 internal/media），
+// 相同内容的多次上传共用一个文件，但各自有一条记录，每条记录最多附加到一个 chirp。
+type Media struct {
+	ID          int       `json:"id"`
+	UserID      int       `json:"user_id"` // 上传者
+	Hash        string    `json:"hash"`
+	ContentType string    `json:"content_type"`
+	Size        int64     `json:"size"`
+	Width       int       `json:"width"`
+	Height      int       `json:"height"`
+	ChirpID     int       `json:"chirp_id,omitempty"` // 附加到的 chirp，0 表示没有附加
+	CreatedAt   time.Time `json:"created_at"`
+	// 被还没有发布的定时 chirp 预留，发布时附加到新的 chirp，取消时释放
+	ScheduledChirpID int `json:"scheduled_chirp_id,omitempty"`
+	// 被用作这个用户的头像，更换头像时释放
+	AvatarUserID int `json:"avatar_user_id,omitempty"`
+}
+
+// ChirpMedia 是附加到 chirp 上的媒体（也用于用户的头像）。文件内容不会变化，所以创建 chirp 时把元数据复制到 chirp 中，读取时不需要再查询
+type ChirpMedia struct {
+	ID          int    `json:"id"`
+	Hash        string `json:"hash"`
+	ContentType string `json:"content_type"`
+	Size        int64  `json:"size"`
+	Width       int    `json:"width"`
+	Height      int    `json:"height"`
+}
+
+var mediaTable = table[int, Media]{
+	name:  "media",
+	m:     func(dbStructure *DBStructure) *map[int]Media { return &dbStructure.Media },
+	index: indexMedia,
+}
+
+// indexMedia 维护每个文件被多少条记录引用，以及没有被使用（见 unattached）的记录
+func indexMedia(dbStructure *DBStructure, id int, old, new *Media) {
+	idx := dbStructure.idx
+	if old != nil {
+		idx.mediaByHash[old.Hash]--
+		if idx.mediaByHash[old.Hash] == 0 {
+			delete(idx.mediaByHash, old.Hash)
+		}
+		if old.unattached() {
+			idx.unattachedMedia.remove(id)
+		}
+	}
+	if new != nil {
+		idx.mediaByHash[new.Hash]++
+		if new.unattached() {
+			idx.unattachedMedia.insert(id)
+		}
+	}
+}
+
+// unattached 判断媒体是否既没有附加到 chirp，也没有被定时 chirp 预留或被用作头像
+func (media *Media) unattached() bool {
+	return media.ChirpID == 0 && media.ScheduledChirpID == 0 && media.AvatarUserID == 0
+}
+
+// ==== 创建媒体记录 ====
+/*
+CreateMedia 保存一次上传的记录，ID 和 CreatedAt 由数据库分配。
+新上传的媒体没有附加到任何 chirp，创建 chirp 时再通过 Chirp.Media 附加。
+*/
+func (db *DB) CreateMedia(media Media) (Media, error) {
+	err := db.Update(func(dbStructure *DBStructure) error {
+		media.ID = dbStructure.nextID(mediaTable.name)
+		media.ChirpID = 0
+		media.ScheduledChirpID = 0
+		media.AvatarUserID = 0
+		media.CreatedAt = time.Now().UTC()
+		mediaTable.put(dbStructure, media.ID, media)
+		return nil
+	})
+	if err != nil {
+		return Media{}, err
+	}
+
+	return media, nil
+}
+
+// attachMedia 把 chirp.Media 中的媒体附加到 chirp，并用媒体记录填充 chirp.Media，必须在 Update 事务中调用。
+// 媒体不存在、不属于作者或已经附加到其他 chirp 时返回 ErrMediaNotAvailable。
+func (dbStructure *DBStructure) attachMedia(chirp *Chirp) error {
+	for i, attached := range chirp.Media {
+		media, ok := dbStructure.Media[attached.ID]
+		if !ok || media.UserID != chirp.AuthorID || !media.unattached() {
+			return ErrMediaNotAvailable
+		}
+		media.ChirpID = chirp.ID
+		mediaTable.put(dbStructure, media.ID, media)
+		chirp.Media[i] = chirpMediaOf(media)
+	}
+	return nil
+}
+
+func chirpMediaOf(media Media) ChirpMedia {
+	return ChirpMedia{
+		ID:          media.ID,
+		Hash:        media.Hash,
+		ContentType: media.ContentType,
+		Size:        media.Size,
+		Width:       media.Width,
+		Height:      media.Height,
+	}
+}
+
+// detachMedia 在 chirp 被永久删除时解除它的媒体的附加，之后由 PurgeMedia 清理，必须在 Update 事务中调用
+func (dbStructure *DBStructure) detachMedia(chirp Chirp) {
+	for _, attached := range chirp.Media {
+		media, ok := dbStructure.Media[attached.ID]
+		if !ok || media.ChirpID != chirp.ID {
+			continue
+		}
+		media.ChirpID = 0
+		mediaTable.put(dbStructure, media.ID, media)
+	}
+}
+
+// ==== 清理孤儿媒体 ====
+/*
+PurgeMedia 删除在 unattachedBefore 之前上传、并且没有附加到 chirp（也没有被定时 chirp 预留或被用作头像）的媒体记录，包括上传后从未使用的，
+以及所在 chirp 已被永久删除的。返回不再被任何记录引用的文件哈希（按字典序），由调用方删除文件。
+*/
+func (db *DB) PurgeMedia(unattachedBefore time.Time) ([]string, error) {
+	unused := []string{}
+	err := db.Update(func(dbStructure *DBStructure) error {
+		// 复制一份，删除时会修改索引
+		ids := append([]int{}, dbStructure.idx.unattachedMedia...)
+		for _, id := range ids {
+			media := dbStructure.Media[id]
+			if !media.CreatedAt.Before(unattachedBefore) {
+				continue
+			}
+			mediaTable.delete(dbStructure, id)
+			if dbStructure.idx.mediaByHash[media.Hash] == 0 {
+				unused = append(unused, media.Hash)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Strings(unused)
+	return unused, nil
+}
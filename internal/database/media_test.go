@@ -0,0 +1,69 @@
+package database
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStorePurgeMedia(t *testing.T) {
+	testStore(t, func(t *testing.T, st *storeHarness) {
+		st.createUser("walt@breakingbad.com")
+		st.createUser("jesse@breakingbad.com")
+		shared, single := strings.Repeat("a", 64), strings.Repeat("b", 64)
+		// 同一个文件被上传两次：两条记录共用一个哈希
+		for _, media := range []Media{
+			{UserID: 1, Hash: shared, ContentType: "image/png", Size: 10, Width: 1, Height: 1},
+			{UserID: 1, Hash: shared, ContentType: "image/png", Size: 10, Width: 1, Height: 1},
+			{UserID: 1, Hash: single, ContentType: "image/gif", Size: 20, Width: 2, Height: 2},
+		} {
+			_, err := st.CreateMedia(media)
+			if err != nil {
+				t.Fatalf("CreateMedia: %v", err)
+			}
+		}
+
+		// 只有作者能附加自己上传的媒体
+		_, err := st.CreateChirp(Chirp{AuthorID: 2, Body: "mine", Media: []ChirpMedia{{ID: 1}}})
+		if !errors.Is(err, ErrMediaNotAvailable) {
+			t.Errorf("CreateChirp(other's media) error = %v, want ErrMediaNotAvailable", err)
+		}
+		chirp, err := st.CreateChirp(Chirp{AuthorID: 1, Body: "look", Media: []ChirpMedia{{ID: 1}}})
+		if err != nil || len(chirp.Media) != 1 || chirp.Media[0].Hash != shared {
+			t.Fatalf("CreateChirp = %+v, %v", chirp, err)
+		}
+		_, err = st.CreateChirp(Chirp{AuthorID: 1, Body: "again", Media: []ChirpMedia{{ID: 1}}})
+		if !errors.Is(err, ErrMediaNotAvailable) {
+			t.Errorf("CreateChirp(attached media) error = %v, want ErrMediaNotAvailable", err)
+		}
+
+		// 刚上传的不会被清理
+		hashes, err := st.PurgeMedia(time.Now().Add(-time.Hour))
+		if err != nil || len(hashes) != 0 {
+			t.Errorf("PurgeMedia(before upload) = %v, %v, want none", hashes, err)
+		}
+
+		// 记录 2 被删除，但记录 1 仍然引用同一个文件
+		st.reopen()
+		hashes, err = st.PurgeMedia(time.Now().Add(time.Second))
+		if err != nil || len(hashes) != 1 || hashes[0] != single {
+			t.Fatalf("PurgeMedia = %v, %v, want [%s]", hashes, err, single)
+		}
+
+		// chirp 被永久删除后，最后一条记录也被删除，文件才不再被引用
+		st.DeleteChirp(chirp.ID, 1)
+		_, err = st.PurgeChirps(time.Now().Add(time.Second))
+		if err != nil {
+			t.Fatalf("PurgeChirps: %v", err)
+		}
+		hashes, err = st.PurgeMedia(time.Now().Add(time.Second))
+		if err != nil || len(hashes) != 1 || hashes[0] != shared {
+			t.Errorf("PurgeMedia after purging the chirp = %v, %v, want [%s]", hashes, err, shared)
+		}
+		hashes, _ = st.PurgeMedia(time.Now().Add(time.Second))
+		if len(hashes) != 0 {
+			t.Errorf("PurgeMedia twice = %v, want none", hashes)
+		}
+	})
+}
@@ -0,0 +1,325 @@
+package database
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ==== Schema 迁移 ====
+/*
+database.json 中的 schema_version 记录了文件的 schema 版本。
+NewDB 加载数据库后，按顺序执行版本号大于 schema_version 的迁移，执行前会把原文件备份到
+<path>.v<旧版本>-<时间>.bak，尚未压缩的日志备份到 <备份>.log。
+
+添加字段或集合时，在 migrations 末尾追加一个迁移来回填旧数据，不要修改已有的迁移。
+迁移直接修改 dbStructure 中的 map（不经过 table），执行完后整个状态会被写成新的快照。
+*/
+
+type migration struct {
+	version     int
+	description string
+	migrate     func(dbStructure *DBStructure) error
+}
+
+var migrations = []migration{
+	{
+		version:     1,
+		description: "initialize ID sequences from existing records",
+		migrate: func(dbStructure *DBStructure) error {
+			dbStructure.initSequences()
+			return nil
+		},
+	},
+	{
+		version:     2,
+		description: "backfill created_at/updated_at on chirps and users with the migration time",
+		migrate: func(dbStructure *DBStructure) error {
+			now := time.Now().UTC()
+			for id, chirp := range dbStructure.Chirps {
+				if chirp.CreatedAt.IsZero() {
+					chirp.CreatedAt = now
+					chirp.UpdatedAt = now
+					dbStructure.Chirps[id] = chirp
+				}
+			}
+			for id, user := range dbStructure.Users {
+				if user.CreatedAt.IsZero() {
+					user.CreatedAt = now
+					user.UpdatedAt = now
+					dbStructure.Users[id] = user
+				}
+			}
+			return nil
+		},
+	},
+	{
+		version:     3,
+		description: "assign handles to existing users",
+		migrate: func(dbStructure *DBStructure) error {
+			for id, user := range dbStructure.Users {
+				if user.Handle == "" {
+					user.Handle = "user" + strconv.Itoa(id)
+					dbStructure.Users[id] = user
+				}
+			}
+			return nil
+		},
+	},
+	{
+		version:     4,
+		description: "mark existing users as verified",
+		migrate: func(dbStructure *DBStructure) error {
+			for id, user := range dbStructure.Users {
+				user.Verified = true
+				dbStructure.Users[id] = user
+			}
+			return nil
+		},
+	},
+	{
+		version:     5,
+		description: "rename handles reserved for anonymized accounts",
+		migrate: func(dbStructure *DBStructure) error {
+			users := []User{}
+			handles := map[string]bool{}
+			for _, user := range dbStructure.Users {
+				users = append(users, user)
+				handles[strings.ToLower(user.Handle)] = true
+			}
+			renames, err := anonymousHandleRenames(users, func(handle string) (bool, error) {
+				return handles[strings.ToLower(handle)], nil
+			})
+			if err != nil {
+				return err
+			}
+			for id, handle := range renames {
+				user := dbStructure.Users[id]
+				user.Handle = handle
+				dbStructure.Users[id] = user
+			}
+			return nil
+		},
+	},
+}
+
+// latestSchemaVersion 是当前代码支持的 schema 版本
+func latestSchemaVersion() int {
+	return migrations[len(migrations)-1].version
+}
+
+// MigrationStep 描述一个迁移以及它对数据的修改
+type MigrationStep struct {
+	Version     int
+	Description string
+	Changes     []string // 例如 "sequences: 2 added"
+}
+
+// MigrationPlan 是 PlanMigrations 的结果
+type MigrationPlan struct {
+	FromVersion int
+	ToVersion   int
+	Steps       []MigrationStep
+}
+
+// ==== 迁移预演 ====
+/*
+PlanMigrations 加载 path 处的数据库（快照 + 日志），在内存中执行待执行的迁移，
+并报告每个迁移修改了哪些记录。不会修改任何文件。
+*/
+func PlanMigrations(path string) (MigrationPlan, error) {
+	db := &DB{
+		path:    path,
+		logPath: path + ".log",
+	}
+	dbStructure, err := db.readDB()
+	if err != nil {
+		return MigrationPlan{}, err
+	}
+	_, err = replayLog(db.logPath, &dbStructure)
+	if err != nil {
+		return MigrationPlan{}, err
+	}
+
+	if dbStructure.SchemaVersion > latestSchemaVersion() {
+		return MigrationPlan{}, errSchemaTooNew(path, dbStructure.SchemaVersion)
+	}
+
+	plan := MigrationPlan{
+		FromVersion: dbStructure.SchemaVersion,
+		ToVersion:   dbStructure.SchemaVersion,
+	}
+	err = runMigrations(&dbStructure, func(m migration, before, after map[string]map[string]json.RawMessage) {
+		plan.ToVersion = m.version
+		plan.Steps = append(plan.Steps, MigrationStep{
+			Version:     m.version,
+			Description: m.description,
+			Changes:     diffCollections(before, after),
+		})
+	})
+	if err != nil {
+		return MigrationPlan{}, err
+	}
+	return plan, nil
+}
+
+// ==== 备份 ====
+/*
+backup 把快照文件和日志原样复制到 <path>.v<from>-<时间>.bak 和 <备份>.log，返回快照备份的路径。
+必须在 NewDB 压缩之前调用，这时文件还没有被当前代码重写过；日志为空时不备份。
+把两个备份文件改名回 <path> 和 <path>.log 就能恢复迁移前的数据库。
+*/
+func (db *DB) backup(from int) (string, error) {
+	backupPath := fmt.Sprintf("%s.v%d-%s.bak", db.path, from, time.Now().UTC().Format("20060102T150405"))
+	dat, err := os.ReadFile(db.path)
+	if err != nil {
+		return "", err
+	}
+	err = writeFileAtomic(backupPath, dat)
+	if err != nil {
+		return "", err
+	}
+
+	logDat, err := os.ReadFile(db.logPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return backupPath, nil
+	}
+	if err != nil {
+		return "", err
+	}
+	if len(logDat) == 0 {
+		return backupPath, nil
+	}
+	return backupPath, writeFileAtomic(backupPath+".log", logDat)
+}
+
+// ==== 执行迁移 ====
+/*
+migrate 在 NewDB 中调用，调用方必须持有写锁，并且日志已经压缩（快照包含全部数据）。
+backupPath 是 backup 创建的备份，已经是最新版本时为空，什么也不做。
+1) 在内存中按顺序执行迁移。
+2) 把迁移后的状态写成新的快照。
+*/
+func (db *DB) migrate(backupPath string) error {
+	from := db.data.SchemaVersion
+	if from == latestSchemaVersion() {
+		return nil
+	}
+
+	err := runMigrations(&db.data, nil)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Migrated %s from schema version %d to %d (backup: %s)", db.path, from, db.data.SchemaVersion, backupPath)
+	return db.compact()
+}
+
+func errSchemaTooNew(path string, version int) error {
+	return fmt.Errorf("%s has schema version %d, newer than the supported version %d", path, version, latestSchemaVersion())
+}
+
+// runMigrations 执行 dbStructure.SchemaVersion 之后的所有迁移。
+// onStep 不为 nil 时，每个迁移执行后都会用迁移前后的集合调用它。
+func runMigrations(dbStructure *DBStructure, onStep func(m migration, before, after map[string]map[string]json.RawMessage)) error {
+	for _, m := range migrations {
+		if m.version <= dbStructure.SchemaVersion {
+			continue
+		}
+
+		var before map[string]map[string]json.RawMessage
+		var err error
+		if onStep != nil {
+			before, err = encodeCollections(dbStructure)
+			if err != nil {
+				return err
+			}
+		}
+
+		err = m.migrate(dbStructure)
+		if err != nil {
+			return fmt.Errorf("migration %d (%s): %w", m.version, m.description, err)
+		}
+		dbStructure.initCollections()
+		dbStructure.SchemaVersion = m.version
+
+		if onStep != nil {
+			after, err := encodeCollections(dbStructure)
+			if err != nil {
+				return err
+			}
+			onStep(m, before, after)
+		}
+	}
+	return nil
+}
+
+// encodeCollections 把每个集合编码为 key -> JSON，用于比较迁移前后的数据
+func encodeCollections(dbStructure *DBStructure) (map[string]map[string]json.RawMessage, error) {
+	dat, err := json.Marshal(dbStructure)
+	if err != nil {
+		return nil, err
+	}
+	raw := map[string]json.RawMessage{}
+	err = json.Unmarshal(dat, &raw)
+	if err != nil {
+		return nil, err
+	}
+
+	encoded := map[string]map[string]json.RawMessage{}
+	for name := range collections {
+		records := map[string]json.RawMessage{}
+		if len(raw[name]) > 0 {
+			err = json.Unmarshal(raw[name], &records)
+			if err != nil {
+				return nil, err
+			}
+		}
+		encoded[name] = records
+	}
+	return encoded, nil
+}
+
+// diffCollections 统计每个集合中新增、修改、删除的记录数
+func diffCollections(before, after map[string]map[string]json.RawMessage) []string {
+	names := make([]string, 0, len(after))
+	for name := range after {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	changes := []string{}
+	for _, name := range names {
+		added, changed, removed := 0, 0, 0
+		for key, value := range after[name] {
+			old, ok := before[name][key]
+			if !ok {
+				added++
+			} else if string(old) != string(value) {
+				changed++
+			}
+		}
+		for key := range before[name] {
+			if _, ok := after[name][key]; !ok {
+				removed++
+			}
+		}
+
+		if added > 0 {
+			changes = append(changes, fmt.Sprintf("%s: %d added", name, added))
+		}
+		if changed > 0 {
+			changes = append(changes, fmt.Sprintf("%s: %d changed", name, changed))
+		}
+		if removed > 0 {
+			changes = append(changes, fmt.Sprintf("%s: %d removed", name, removed))
+		}
+	}
+	return changes
+}
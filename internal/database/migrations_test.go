@@ -0,0 +1,77 @@
+package database
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestMigrateBackup 打开 v1 的数据库：迁移前的备份必须和原来的快照、日志逐字节相同，
+// 包括当前的 DBStructure 不认识的字段
+func TestMigrateBackup(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "db.json")
+	snapshot, err := os.ReadFile("testdata/v1.json")
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+	logDat, err := os.ReadFile("testdata/v1.json.log")
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+	for file, dat := range map[string][]byte{path: snapshot, path + ".log": logDat} {
+		err := os.WriteFile(file, dat, 0600)
+		if err != nil {
+			t.Fatalf("WriteFile: %v", err)
+		}
+	}
+
+	db, err := NewDB(path)
+	if err != nil {
+		t.Fatalf("NewDB: %v", err)
+	}
+	defer db.Close()
+	if db.data.SchemaVersion != latestSchemaVersion() {
+		t.Errorf("SchemaVersion = %d, want %d", db.data.SchemaVersion, latestSchemaVersion())
+	}
+	// 日志中的 chirp 在迁移前被重放
+	chirp, err := db.GetChirp(2)
+	if err != nil || chirp.CreatedAt.IsZero() {
+		t.Errorf("GetChirp(2) = %+v, %v", chirp, err)
+	}
+
+	backups, err := filepath.Glob(path + ".v1-*.bak")
+	if err != nil || len(backups) != 1 {
+		t.Fatalf("backups = %v, %v", backups, err)
+	}
+	for backup, want := range map[string][]byte{backups[0]: snapshot, backups[0] + ".log": logDat} {
+		got, err := os.ReadFile(backup)
+		if err != nil {
+			t.Fatalf("ReadFile: %v", err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("%s =\n%s\nwant\n%s", filepath.Base(backup), got, want)
+		}
+	}
+}
+
+// TestMigrateLatest 已经是最新版本的数据库不会被备份
+func TestMigrateLatest(t *testing.T) {
+	dir := t.TempDir()
+	db, err := NewDB(filepath.Join(dir, "db.json"))
+	if err != nil {
+		t.Fatalf("NewDB: %v", err)
+	}
+	db.Close()
+	db, err = NewDB(filepath.Join(dir, "db.json"))
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer db.Close()
+
+	backups, _ := filepath.Glob(filepath.Join(dir, "*.bak"))
+	if len(backups) != 0 {
+		t.Errorf("backups = %v, want none", backups)
+	}
+}
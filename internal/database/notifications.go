@@ -0,0 +1,133 @@
+package database
+
+import (
+	"time"
+)
+
+// 通知的类型
+const (
+	NotificationMention = "mention" // ActorID 在 ChirpID 中提及了 UserID
+)
+
+// Notification 是发给 UserID 的一条通知
+type Notification struct {
+	ID        int        `json:"id"`
+	UserID    int        `json:"user_id"`
+	Type      string     `json:"type"`
+	ActorID   int        `json:"actor_id"`
+	ChirpID   int        `json:"chirp_id"`
+	CreatedAt time.Time  `json:"created_at"`
+	ReadAt    *time.Time `json:"read_at,omitempty"` // 未读时为 nil
+}
+
+var notificationsTable = table[int, Notification]{
+	name:  "notifications",
+	m:     func(dbStructure *DBStructure) *map[int]Notification { return &dbStructure.Notifications },
+	index: indexNotification,
+}
+
+// indexNotification 维护每个用户的通知和每个 chirp 相关的通知
+func indexNotification(dbStructure *DBStructure, id int, old, new *Notification) {
+	idx := dbStructure.idx
+	if old != nil {
+		if byUser, ok := idx.notificationsByUser[old.UserID]; ok {
+			byUser.remove(id)
+		}
+		if byChirp, ok := idx.notificationsByChirp[old.ChirpID]; ok {
+			byChirp.remove(id)
+			if len(*byChirp) == 0 {
+				delete(idx.notificationsByChirp, old.ChirpID)
+			}
+		}
+	}
+	if new != nil {
+		byUser, ok := idx.notificationsByUser[new.UserID]
+		if !ok {
+			byUser = &sortedIDs{}
+			idx.notificationsByUser[new.UserID] = byUser
+		}
+		byUser.insert(id)
+
+		byChirp, ok := idx.notificationsByChirp[new.ChirpID]
+		if !ok {
+			byChirp = &sortedIDs{}
+			idx.notificationsByChirp[new.ChirpID] = byChirp
+		}
+		byChirp.insert(id)
+	}
+}
+
+// notifyMentions 为 chirp 中提及的、不在 except 中的用户创建通知，必须在 Update 事务中调用
+func (dbStructure *DBStructure) notifyMentions(chirp Chirp, except map[int]bool) {
+	for _, userID := range chirp.mentionedUsers() {
+		if except[userID] {
+			continue
+		}
+		notification := Notification{
+			ID:        dbStructure.nextID(notificationsTable.name),
+			UserID:    userID,
+			Type:      NotificationMention,
+			ActorID:   chirp.AuthorID,
+			ChirpID:   chirp.ID,
+			CreatedAt: chirp.UpdatedAt,
+		}
+		notificationsTable.put(dbStructure, notification.ID, notification)
+	}
+}
+
+// NotificationQuery 描述一次通知查询，结果按 ID 降序（最新的在前）排列
+type NotificationQuery struct {
+	UserID   int
+	BeforeID int // 只返回 ID 小于 BeforeID 的通知（用于分页），0 表示从最新的开始
+	Limit    int // 最多返回的数量，0 表示不限制
+}
+
+func (db *DB) ListNotifications(q NotificationQuery) ([]Notification, error) {
+	notifications := []Notification{}
+	err := db.View(func(dbStructure *DBStructure) error {
+		byUser, ok := dbStructure.idx.notificationsByUser[q.UserID]
+		if !ok {
+			return nil
+		}
+		byUser.scan(q.BeforeID, true, func(id int) bool {
+			notifications = append(notifications, dbStructure.Notifications[id])
+			return q.Limit == 0 || len(notifications) < q.Limit
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return notifications, nil
+}
+
+// ==== 标记已读 ====
+/*
+MarkNotificationsRead 把用户的所有未读通知标记为已读。
+通知总是全部一起标记，所以未读的通知一定是最新的那些，从最新的开始向前遍历到第一条已读的通知即可。
+*/
+func (db *DB) MarkNotificationsRead(userID int) error {
+	return db.Update(func(dbStructure *DBStructure) error {
+		byUser, ok := dbStructure.idx.notificationsByUser[userID]
+		if !ok {
+			return nil
+		}
+
+		now := time.Now().UTC()
+		unread := []int{}
+		byUser.scan(0, true, func(id int) bool {
+			if dbStructure.Notifications[id].ReadAt != nil {
+				return false
+			}
+			unread = append(unread, id)
+			return true
+		})
+		for _, id := range unread {
+			notification := dbStructure.Notifications[id]
+			notification.ReadAt = &now
+			notificationsTable.put(dbStructure, id, notification)
+		}
+		return nil
+	})
+}
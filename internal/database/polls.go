@@ -0,0 +1,147 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrPollClosed 表示投票已经截止
+var ErrPollClosed = errors.New("poll is closed")
+
+// ErrInvalidPollOption 表示选项不存在
+var ErrInvalidPollOption = errors.New("invalid poll option")
+
+// ChirpPoll 是附加在 chirp 上的投票，创建后不能修改
+type ChirpPoll struct {
+	Options  []string  `json:"options"`
+	ClosesAt time.Time `json:"closes_at"`
+
+	// 读取时计算的派生字段，不会被保存：每个选项的票数，和 Options 一一对应
+	Votes []int `json:"-"`
+}
+
+// Closed 判断投票在 now 时是否已经截止
+func (poll *ChirpPoll) Closed(now time.Time) bool {
+	return !now.Before(poll.ClosesAt)
+}
+
+// PollVote 表示一个用户在一个投票中选择了第 Option 个选项（从 0 开始），每个用户对每个投票只能投一次
+type PollVote struct {
+	ChirpID   int       `json:"chirp_id"`
+	UserID    int       `json:"user_id"`
+	Option    int       `json:"option"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// poll_votes 集合的键是 "<chirp ID>:<用户 ID>"
+func pollVoteKey(chirpID, userID int) string {
+	return fmt.Sprintf("%d:%d", chirpID, userID)
+}
+
+var pollVotesTable = table[string, PollVote]{
+	name:  "poll_votes",
+	m:     func(dbStructure *DBStructure) *map[string]PollVote { return &dbStructure.PollVotes },
+	index: indexPollVote,
+}
+
+// indexPollVote 维护每个投票中用户的选择，以及每个选项的票数
+func indexPollVote(dbStructure *DBStructure, key string, old, new *PollVote) {
+	idx := dbStructure.idx
+	if old != nil {
+		delete(idx.pollVotes[old.ChirpID], old.UserID)
+		if len(idx.pollVotes[old.ChirpID]) == 0 {
+			delete(idx.pollVotes, old.ChirpID)
+		}
+		idx.pollTallies[old.ChirpID][old.Option]--
+		if idx.pollTallies[old.ChirpID][old.Option] == 0 {
+			delete(idx.pollTallies[old.ChirpID], old.Option)
+		}
+		if len(idx.pollTallies[old.ChirpID]) == 0 {
+			delete(idx.pollTallies, old.ChirpID)
+		}
+	}
+	if new != nil {
+		if _, ok := idx.pollVotes[new.ChirpID]; !ok {
+			idx.pollVotes[new.ChirpID] = map[int]int{}
+			idx.pollTallies[new.ChirpID] = map[int]int{}
+		}
+		idx.pollVotes[new.ChirpID][new.UserID] = new.Option
+		idx.pollTallies[new.ChirpID][new.Option]++
+	}
+}
+
+// withVotes 返回填充了每个选项票数的投票副本，不会修改 chirp 中保存的投票
+func (dbStructure *DBStructure) withVotes(chirpID int, poll *ChirpPoll) *ChirpPoll {
+	if poll == nil {
+		return nil
+	}
+	p := *poll
+	p.Votes = make([]int, len(p.Options))
+	for option, n := range dbStructure.idx.pollTallies[chirpID] {
+		p.Votes[option] = n
+	}
+	return &p
+}
+
+// ==== 投票 ====
+/*
+VotePoll 在一个 Update 事务中记录 userID 对 chirp 中投票的选择，返回投票后的 chirp：
+1) chirp 不存在、在回收站中或没有投票时返回 ErrNotExist。
+2) 投票已截止时返回 ErrPollClosed，选项不存在时返回 ErrInvalidPollOption。
+3) 已经投过票时返回 ErrAlreadyExists，投票不能修改。
+*/
+func (db *DB) VotePoll(chirpID, userID, option int) (Chirp, error) {
+	chirp := Chirp{}
+	err := db.Update(func(dbStructure *DBStructure) error {
+		var ok bool
+		chirp, ok = dbStructure.Chirps[chirpID]
+		if !ok || chirp.DeletedAt != nil || chirp.Poll == nil {
+			return ErrNotExist
+		}
+
+		now := time.Now().UTC()
+		if chirp.Poll.Closed(now) {
+			return ErrPollClosed
+		}
+		if option < 0 || option >= len(chirp.Poll.Options) {
+			return ErrInvalidPollOption
+		}
+		key := pollVoteKey(chirpID, userID)
+		if _, ok := dbStructure.PollVotes[key]; ok {
+			return ErrAlreadyExists
+		}
+
+		pollVotesTable.put(dbStructure, key, PollVote{
+			ChirpID:   chirpID,
+			UserID:    userID,
+			Option:    option,
+			CreatedAt: now,
+		})
+		chirp = dbStructure.withCounts(chirp)
+		return nil
+	})
+	if err != nil {
+		return Chirp{}, err
+	}
+
+	return chirp, nil
+}
+
+// PollVotesByUser 返回 userID 在 chirpIDs 中的哪些投票里投过票，以及选择的选项
+func (db *DB) PollVotesByUser(userID int, chirpIDs []int) (map[int]int, error) {
+	votes := map[int]int{}
+	err := db.View(func(dbStructure *DBStructure) error {
+		for _, id := range chirpIDs {
+			if option, ok := dbStructure.idx.pollVotes[id][userID]; ok {
+				votes[id] = option
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return votes, nil
+}
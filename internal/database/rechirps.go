@@ -0,0 +1,93 @@
+package database
+
+import (
+	"time"
+)
+
+// original 返回 id 对应的不在回收站中的 chirp；如果它是一个转发，返回被转发的原始 chirp
+func (dbStructure *DBStructure) original(id int) (Chirp, bool) {
+	chirp, ok := dbStructure.Chirps[id]
+	if ok && chirp.RechirpOf != 0 {
+		chirp, ok = dbStructure.Chirps[chirp.RechirpOf]
+	}
+	if !ok || chirp.DeletedAt != nil {
+		return Chirp{}, false
+	}
+	return chirp, true
+}
+
+// ==== 转发 ====
+/*
+Rechirp 以 userID 的身份转发 chirpID，转发一个转发时转发的是原始 chirp。
+每个用户对同一个 chirp 只能有一个转发：已经转发过时返回已有的转发，created 为 false。
+被转发的 chirp 不存在或在回收站中时返回 ErrNotExist。
+*/
+func (db *DB) Rechirp(chirpID, userID int) (rechirp Chirp, created bool, err error) {
+	err = db.Update(func(dbStructure *DBStructure) error {
+		original, ok := dbStructure.original(chirpID)
+		if !ok {
+			return ErrNotExist
+		}
+
+		if id, ok := dbStructure.idx.rechirps[original.ID][userID]; ok {
+			rechirp = dbStructure.withCounts(dbStructure.Chirps[id])
+			return nil
+		}
+
+		rechirp = Chirp{
+			ID:        dbStructure.nextID(chirpsTable.name),
+			AuthorID:  userID,
+			RechirpOf: original.ID,
+			CreatedAt: time.Now().UTC(),
+		}
+		rechirp.UpdatedAt = rechirp.CreatedAt
+		chirpsTable.put(dbStructure, rechirp.ID, rechirp)
+		created = true
+		return nil
+	})
+	if err != nil {
+		return Chirp{}, false, err
+	}
+
+	return rechirp, created, nil
+}
+
+// Unrechirp 把 userID 对 chirpID 的转发移入回收站。没有转发过时返回 ErrNotExist。
+func (db *DB) Unrechirp(chirpID, userID int) error {
+	return db.Update(func(dbStructure *DBStructure) error {
+		// 原始 chirp 可能已经被删除，这里只需要它的 ID
+		originalID := chirpID
+		if chirp, ok := dbStructure.Chirps[chirpID]; ok && chirp.RechirpOf != 0 {
+			originalID = chirp.RechirpOf
+		}
+
+		id, ok := dbStructure.idx.rechirps[originalID][userID]
+		if !ok {
+			return ErrNotExist
+		}
+		rechirp := dbStructure.Chirps[id]
+		now := time.Now().UTC()
+		rechirp.DeletedAt = &now
+		rechirp.DeletedBy = userID
+		chirpsTable.put(dbStructure, id, rechirp)
+		return nil
+	})
+}
+
+// GetChirpsByID 批量获取 chirp，包括回收站中的 chirp。不存在的 ID 不会出现在结果中。
+func (db *DB) GetChirpsByID(ids []int) (map[int]Chirp, error) {
+	chirps := map[int]Chirp{}
+	err := db.View(func(dbStructure *DBStructure) error {
+		for _, id := range ids {
+			if chirp, ok := dbStructure.Chirps[id]; ok {
+				chirps[id] = dbStructure.withCounts(chirp)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return chirps, nil
+}
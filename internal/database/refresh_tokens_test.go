@@ -0,0 +1,46 @@
+package database
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestStoreRevokeUserRefreshTokens(t *testing.T) {
+	testStore(t, func(t *testing.T, st *storeHarness) {
+		st.createUser("walt@breakingbad.com")
+		st.createUser("jesse@breakingbad.com")
+		for _, token := range []string{"a", "b", "c"} {
+			err := st.SaveRefreshToken(1, token)
+			if err != nil {
+				t.Fatalf("SaveRefreshToken: %v", err)
+			}
+		}
+		st.SaveRefreshToken(2, "jesse")
+
+		// 保留 except，撤销用户的其他会话
+		err := st.RevokeUserRefreshTokens(1, "c")
+		if err != nil {
+			t.Fatalf("RevokeUserRefreshTokens: %v", err)
+		}
+		st.reopen()
+		for _, token := range []string{"a", "b"} {
+			if _, err := st.UserForRefreshToken(token); !errors.Is(err, ErrNotExist) {
+				t.Errorf("UserForRefreshToken(%s) error = %v, want ErrNotExist", token, err)
+			}
+		}
+		for _, token := range []string{"c", "jesse"} {
+			if _, err := st.UserForRefreshToken(token); err != nil {
+				t.Errorf("UserForRefreshToken(%s) error = %v", token, err)
+			}
+		}
+
+		// except 为空时全部撤销
+		err = st.RevokeUserRefreshTokens(1, "")
+		if err != nil {
+			t.Fatalf("RevokeUserRefreshTokens: %v", err)
+		}
+		if _, err := st.UserForRefreshToken("c"); !errors.Is(err, ErrNotExist) {
+			t.Errorf("UserForRefreshToken(c) error = %v, want ErrNotExist", err)
+		}
+	})
+}
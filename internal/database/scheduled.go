@@ -0,0 +1,252 @@
+package database
+
+import (
+	"sort"
+	"time"
+)
+
+// ScheduledChirp 是一个还没有发布的定时 chirp，只有作者能看到。
+// 到达 PublishAt 后由调度器发布为普通的 chirp（新的 ID，created_at 是发布时间），同时删除这条记录。
+type ScheduledChirp struct {
+	ID         int             `json:"id"`
+	AuthorID   int             `json:"author_id"`
+	Body       string          `json:"body"`
+	InReplyTo  int             `json:"in_reply_to,omitempty"`
+	QuoteOf    int             `json:"quote_of,omitempty"`
+	Moderation ChirpModeration `json:"moderation"`
+	Media      []ChirpMedia    `json:"media,omitempty"` // 预留的媒体
+	Poll       *ChirpPoll      `json:"poll,omitempty"`
+	PublishAt  time.Time       `json:"publish_at"`
+	CreatedAt  time.Time       `json:"created_at"`
+	// 发布失败（例如被回复的 chirp 已被删除）时记录失败的时间和原因，之后不会再尝试发布
+	FailedAt *time.Time `json:"failed_at,omitempty"`
+	Error    string     `json:"error,omitempty"`
+}
+
+var scheduledChirpsTable = table[int, ScheduledChirp]{
+	name:  "scheduled_chirps",
+	m:     func(dbStructure *DBStructure) *map[int]ScheduledChirp { return &dbStructure.ScheduledChirps },
+	index: indexScheduledChirp,
+}
+
+// indexScheduledChirp 维护每个作者的定时 chirp，以及等待发布的定时 chirp（按发布时间排序，不包括发布失败的）
+func indexScheduledChirp(dbStructure *DBStructure, id int, old, new *ScheduledChirp) {
+	idx := dbStructure.idx
+	if old != nil {
+		if byAuthor, ok := idx.scheduledByAuthor[old.AuthorID]; ok {
+			byAuthor.remove(id)
+			if len(*byAuthor) == 0 {
+				delete(idx.scheduledByAuthor, old.AuthorID)
+			}
+		}
+		if old.FailedAt == nil {
+			idx.scheduledByTime.remove(timeKey{at: old.PublishAt, id: id})
+		}
+	}
+	if new != nil {
+		byAuthor, ok := idx.scheduledByAuthor[new.AuthorID]
+		if !ok {
+			byAuthor = &sortedIDs{}
+			idx.scheduledByAuthor[new.AuthorID] = byAuthor
+		}
+		byAuthor.insert(id)
+		if new.FailedAt == nil {
+			idx.scheduledByTime.insert(timeKey{at: new.PublishAt, id: id})
+		}
+	}
+}
+
+// ==== 创建定时 chirp ====
+/*
+ScheduleChirp 保存一个定时 chirp，ID 和 CreatedAt 由数据库分配。在一个 Update 事务中：
+1) 和 CreateChirp 一样检查被回复、被引用的 chirp（ErrParentNotExist、ErrQuotedNotExist），引用转发时引用原始 chirp。
+2) 预留媒体：媒体必须是作者上传的、没有附加也没有被预留，否则返回 ErrMediaNotAvailable。
+   预留的媒体不会被 PurgeMedia 清理。
+发布时会再检查一次，期间被回复的 chirp 被删除时发布失败。
+*/
+func (db *DB) ScheduleChirp(scheduled ScheduledChirp) (ScheduledChirp, error) {
+	err := db.Update(func(dbStructure *DBStructure) error {
+		chirp := Chirp{InReplyTo: scheduled.InReplyTo, QuoteOf: scheduled.QuoteOf}
+		err := dbStructure.checkReferences(&chirp)
+		if err != nil {
+			return err
+		}
+		scheduled.QuoteOf = chirp.QuoteOf
+
+		scheduled.ID = dbStructure.nextID(scheduledChirpsTable.name)
+		scheduled.CreatedAt = time.Now().UTC()
+		scheduled.FailedAt = nil
+		scheduled.Error = ""
+		for i, reserved := range scheduled.Media {
+			media, ok := dbStructure.Media[reserved.ID]
+			if !ok || media.UserID != scheduled.AuthorID || !media.unattached() {
+				return ErrMediaNotAvailable
+			}
+			media.ScheduledChirpID = scheduled.ID
+			mediaTable.put(dbStructure, media.ID, media)
+			scheduled.Media[i] = chirpMediaOf(media)
+		}
+		scheduledChirpsTable.put(dbStructure, scheduled.ID, scheduled)
+		return nil
+	})
+	if err != nil {
+		return ScheduledChirp{}, err
+	}
+
+	return scheduled, nil
+}
+
+// GetScheduledChirp 返回指定 ID 的定时 chirp，由调用方检查作者
+func (db *DB) GetScheduledChirp(id int) (ScheduledChirp, error) {
+	scheduled := ScheduledChirp{}
+	err := db.View(func(dbStructure *DBStructure) error {
+		var ok bool
+		scheduled, ok = dbStructure.ScheduledChirps[id]
+		if !ok {
+			return ErrNotExist
+		}
+		return nil
+	})
+	if err != nil {
+		return ScheduledChirp{}, err
+	}
+
+	return scheduled, nil
+}
+
+// ListScheduledChirps 返回作者的定时 chirp（包括发布失败的），按发布时间排序，最早发布的在前
+func (db *DB) ListScheduledChirps(authorID int) ([]ScheduledChirp, error) {
+	scheduled := []ScheduledChirp{}
+	err := db.View(func(dbStructure *DBStructure) error {
+		if byAuthor, ok := dbStructure.idx.scheduledByAuthor[authorID]; ok {
+			for _, id := range *byAuthor {
+				scheduled = append(scheduled, dbStructure.ScheduledChirps[id])
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sortByPublishAt(scheduled)
+	return scheduled, nil
+}
+
+// sortByPublishAt 按发布时间升序排序，发布时间相同时 ID 小的在前
+func sortByPublishAt(scheduled []ScheduledChirp) {
+	sort.Slice(scheduled, func(i, j int) bool {
+		a, b := scheduled[i].PublishAt, scheduled[j].PublishAt
+		if !a.Equal(b) {
+			return a.Before(b)
+		}
+		return scheduled[i].ID < scheduled[j].ID
+	})
+}
+
+// CancelScheduledChirp 删除一个还没有发布的定时 chirp，并释放它预留的媒体。不存在时返回 ErrNotExist。
+func (db *DB) CancelScheduledChirp(id int) error {
+	return db.Update(func(dbStructure *DBStructure) error {
+		scheduled, ok := dbStructure.ScheduledChirps[id]
+		if !ok {
+			return ErrNotExist
+		}
+		dbStructure.releaseMedia(scheduled)
+		scheduledChirpsTable.delete(dbStructure, id)
+		return nil
+	})
+}
+
+// releaseMedia 释放定时 chirp 预留的媒体，必须在 Update 事务中调用
+func (dbStructure *DBStructure) releaseMedia(scheduled ScheduledChirp) {
+	for _, reserved := range scheduled.Media {
+		media, ok := dbStructure.Media[reserved.ID]
+		if !ok || media.ScheduledChirpID != scheduled.ID {
+			continue
+		}
+		media.ScheduledChirpID = 0
+		mediaTable.put(dbStructure, media.ID, media)
+	}
+}
+
+// DueScheduledChirps 返回发布时间不晚于 now、等待发布的定时 chirp，最早的在前，发布失败的不会返回
+func (db *DB) DueScheduledChirps(now time.Time) ([]ScheduledChirp, error) {
+	due := []ScheduledChirp{}
+	err := db.View(func(dbStructure *DBStructure) error {
+		// until 不包括边界，加 1 纳秒以包括 PublishAt 等于 now 的
+		dbStructure.idx.scheduledByTime.scan(time.Time{}, now.Add(time.Nanosecond), nil, false, func(id int) bool {
+			due = append(due, dbStructure.ScheduledChirps[id])
+			return true
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return due, nil
+}
+
+// ==== 发布定时 chirp ====
+/*
+PublishScheduledChirp 在一个 Update 事务中把定时 chirp 发布为普通的 chirp：
+释放预留的媒体，用 createChirp 创建 chirp（附加媒体、为提及的用户创建通知），然后删除定时 chirp。
+entities 是发布时解析的话题和提及。定时 chirp 不存在（例如已被取消）时返回 ErrNotExist；
+createChirp 失败时整个事务回滚，定时 chirp 保持不变。
+*/
+func (db *DB) PublishScheduledChirp(id int, entities []ChirpEntity) (Chirp, error) {
+	chirp := Chirp{}
+	err := db.Update(func(dbStructure *DBStructure) error {
+		scheduled, ok := dbStructure.ScheduledChirps[id]
+		if !ok || scheduled.FailedAt != nil {
+			return ErrNotExist
+		}
+
+		dbStructure.releaseMedia(scheduled)
+		var err error
+		chirp, err = dbStructure.createChirp(scheduled.chirp(entities))
+		if err != nil {
+			return err
+		}
+		scheduledChirpsTable.delete(dbStructure, id)
+		return nil
+	})
+	if err != nil {
+		return Chirp{}, err
+	}
+
+	return chirp, nil
+}
+
+// chirp 返回定时 chirp 发布后的内容，媒体只包含 ID，由 attachMedia 重新填充
+func (scheduled ScheduledChirp) chirp(entities []ChirpEntity) Chirp {
+	media := []ChirpMedia{}
+	for _, m := range scheduled.Media {
+		media = append(media, ChirpMedia{ID: m.ID})
+	}
+	return Chirp{
+		Body:       scheduled.Body,
+		AuthorID:   scheduled.AuthorID,
+		InReplyTo:  scheduled.InReplyTo,
+		QuoteOf:    scheduled.QuoteOf,
+		Moderation: scheduled.Moderation,
+		Entities:   entities,
+		Media:      media,
+		Poll:       scheduled.Poll,
+	}
+}
+
+// FailScheduledChirp 记录定时 chirp 发布失败的原因，之后不会再尝试发布它，预留的媒体保持预留，直到作者删除它
+func (db *DB) FailScheduledChirp(id int, reason string) error {
+	return db.Update(func(dbStructure *DBStructure) error {
+		scheduled, ok := dbStructure.ScheduledChirps[id]
+		if !ok {
+			return ErrNotExist
+		}
+		now := time.Now().UTC()
+		scheduled.FailedAt = &now
+		scheduled.Error = reason
+		scheduledChirpsTable.put(dbStructure, id, scheduled)
+		return nil
+	})
+}
@@ -0,0 +1,237 @@
+package database
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// ==== 全文搜索 ====
+/*
+JSON 后端在内存中维护一个倒排索引（词 -> chirp ID -> 词在 chirp 中的位置），
+由 indexChirp 在 chirp 创建、修改、删除时增量更新，和其他内存索引一样不会被持久化。
+SQLite 后端使用 FTS5（见 sqlite_search.go）。
+
+查询语法：
+- 多个词之间是 AND 关系：chirp 必须包含所有的词。
+- 用双引号括起来的是短语：词必须按顺序相邻出现，例如 "one who knocks"。
+- 不区分大小写，字母和数字以外的字符都是分隔符。
+结果按 BM25 相关度排序，相关度相同时新的 chirp 在前。
+*/
+
+// SearchQuery 描述一次全文搜索
+type SearchQuery struct {
+	Text     string // 查询语句
+	AuthorID int    // 只返回该作者的 chirp，0 表示不过滤
+	Limit    int    // 最多返回的数量，0 表示不限制
+}
+
+// BM25 参数
+const (
+	bm25K1 = 1.2
+	bm25B  = 0.75
+)
+
+// tokenize 把文本切分为小写的词，返回的切片下标就是词的位置
+func tokenize(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+}
+
+// parseSearchQuery 把查询语句解析为子句，每个子句是一个短语（单个词是长度为 1 的短语）
+func parseSearchQuery(text string) [][]string {
+	clauses := [][]string{}
+	// 按双引号切分后，奇数下标的部分位于引号内
+	for i, part := range strings.Split(text, `"`) {
+		terms := tokenize(part)
+		if i%2 == 1 {
+			if len(terms) > 0 {
+				clauses = append(clauses, terms)
+			}
+			continue
+		}
+		for _, term := range terms {
+			clauses = append(clauses, []string{term})
+		}
+	}
+	return clauses
+}
+
+// searchIndex 是 chirp 正文的倒排索引
+type searchIndex struct {
+	postings map[string]map[int][]int // 词 -> chirp ID -> 位置（升序）
+	docLen   map[int]int              // chirp ID -> 词数
+	totalLen int                      // 所有 chirp 的词数之和
+}
+
+func newSearchIndex() *searchIndex {
+	return &searchIndex{
+		postings: map[string]map[int][]int{},
+		docLen:   map[int]int{},
+	}
+}
+
+func (ix *searchIndex) add(id int, body string) {
+	terms := tokenize(body)
+	for pos, term := range terms {
+		docs, ok := ix.postings[term]
+		if !ok {
+			docs = map[int][]int{}
+			ix.postings[term] = docs
+		}
+		docs[id] = append(docs[id], pos)
+	}
+	ix.docLen[id] = len(terms)
+	ix.totalLen += len(terms)
+}
+
+func (ix *searchIndex) remove(id int, body string) {
+	for _, term := range tokenize(body) {
+		docs, ok := ix.postings[term]
+		if !ok {
+			continue
+		}
+		delete(docs, id)
+		if len(docs) == 0 {
+			delete(ix.postings, term)
+		}
+	}
+	ix.totalLen -= ix.docLen[id]
+	delete(ix.docLen, id)
+}
+
+// match 返回包含所有子句的 chirp ID
+func (ix *searchIndex) match(clauses [][]string) []int {
+	terms := distinctTerms(clauses)
+	if len(terms) == 0 {
+		return nil
+	}
+
+	// 从文档数最少的词开始求交集
+	sort.Slice(terms, func(i, j int) bool {
+		return len(ix.postings[terms[i]]) < len(ix.postings[terms[j]])
+	})
+	ids := []int{}
+	for id := range ix.postings[terms[0]] {
+		ok := true
+		for _, term := range terms[1:] {
+			if _, found := ix.postings[term][id]; !found {
+				ok = false
+				break
+			}
+		}
+		for _, clause := range clauses {
+			if !ok {
+				break
+			}
+			ok = ix.containsPhrase(id, clause)
+		}
+		if ok {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// containsPhrase 判断 chirp 中是否有按顺序相邻出现的 phrase
+func (ix *searchIndex) containsPhrase(id int, phrase []string) bool {
+	if len(phrase) == 1 {
+		return true
+	}
+	for _, start := range ix.postings[phrase[0]][id] {
+		found := true
+		for offset, term := range phrase[1:] {
+			positions := ix.postings[term][id]
+			want := start + offset + 1
+			i := sort.SearchInts(positions, want)
+			if i == len(positions) || positions[i] != want {
+				found = false
+				break
+			}
+		}
+		if found {
+			return true
+		}
+	}
+	return false
+}
+
+// score 计算查询词对一个 chirp 的 BM25 相关度
+func (ix *searchIndex) score(id int, terms []string) float64 {
+	n := float64(len(ix.docLen))
+	avgLen := float64(ix.totalLen) / math.Max(n, 1)
+	docLen := float64(ix.docLen[id])
+
+	score := 0.0
+	for _, term := range terms {
+		df := float64(len(ix.postings[term]))
+		tf := float64(len(ix.postings[term][id]))
+		idf := math.Log(1 + (n-df+0.5)/(df+0.5))
+		score += idf * tf * (bm25K1 + 1) / (tf + bm25K1*(1-bm25B+bm25B*docLen/avgLen))
+	}
+	return score
+}
+
+func distinctTerms(clauses [][]string) []string {
+	seen := map[string]struct{}{}
+	terms := []string{}
+	for _, clause := range clauses {
+		for _, term := range clause {
+			if _, ok := seen[term]; ok {
+				continue
+			}
+			seen[term] = struct{}{}
+			terms = append(terms, term)
+		}
+	}
+	return terms
+}
+
+// ==== 搜索 Chirps ====
+func (db *DB) SearchChirps(q SearchQuery) ([]Chirp, error) {
+	clauses := parseSearchQuery(q.Text)
+	terms := distinctTerms(clauses)
+
+	chirps := []Chirp{}
+	err := db.View(func(dbStructure *DBStructure) error {
+		ix := dbStructure.idx.search
+
+		type hit struct {
+			chirp Chirp
+			score float64
+		}
+		hits := []hit{}
+		for _, id := range ix.match(clauses) {
+			chirp := dbStructure.Chirps[id]
+			if q.AuthorID != 0 && chirp.AuthorID != q.AuthorID {
+				continue
+			}
+			hits = append(hits, hit{
+				chirp: dbStructure.withCounts(chirp),
+				score: ix.score(id, terms),
+			})
+		}
+
+		sort.Slice(hits, func(i, j int) bool {
+			if hits[i].score != hits[j].score {
+				return hits[i].score > hits[j].score
+			}
+			return hits[i].chirp.ID > hits[j].chirp.ID
+		})
+		if q.Limit != 0 && len(hits) > q.Limit {
+			hits = hits[:q.Limit]
+		}
+
+		for _, h := range hits {
+			chirps = append(chirps, h.chirp)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return chirps, nil
+}
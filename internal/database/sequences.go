@@ -0,0 +1,35 @@
+package database
+
+// ==== ID 序列 ====
+/*
+每个集合在 DBStructure.Sequences 中有一个持久化的计数器，记录已经分配过的最大 ID。
+ID 只会递增，删除记录后也不会被重新分配。
+（以前使用 len(map) + 1 作为新 ID，删除一条记录后，下一条新记录会覆盖已有的记录。）
+*/
+
+var sequencesTable = table[string, int]{
+	name: "sequences",
+	m:    func(dbStructure *DBStructure) *map[string]int { return &dbStructure.Sequences },
+}
+
+// nextID 为 collection 分配下一个 ID，必须在 Update 事务中调用
+func (dbStructure *DBStructure) nextID(collection string) int {
+	id := dbStructure.Sequences[collection] + 1
+	sequencesTable.put(dbStructure, collection, id)
+	return id
+}
+
+// initSequences 用已有数据初始化计数器：
+// 没有 sequences 的旧数据库文件，计数器从各集合当前的最大 ID 开始。
+func (dbStructure *DBStructure) initSequences() {
+	for id := range dbStructure.Chirps {
+		if id > dbStructure.Sequences[chirpsTable.name] {
+			dbStructure.Sequences[chirpsTable.name] = id
+		}
+	}
+	for id := range dbStructure.Users {
+		if id > dbStructure.Sequences[usersTable.name] {
+			dbStructure.Sequences[usersTable.name] = id
+		}
+	}
+}
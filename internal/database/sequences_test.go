@@ -0,0 +1,66 @@
+package database
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestStoreSequences(t *testing.T) {
+	testStore(t, func(t *testing.T, st *storeHarness) {
+		st.createUser("walt@breakingbad.com")
+		st.createChirp(1, "one")
+		st.createChirp(1, "two")
+
+		// 重新打开后继续递增
+		st.reopen()
+		if chirp := st.createChirp(1, "three"); chirp.ID != 3 {
+			t.Errorf("CreateChirp after reopen ID = %d, want 3", chirp.ID)
+		}
+		if user := st.createUser("jesse@breakingbad.com"); user.ID != 2 {
+			t.Errorf("CreateUser after reopen ID = %d, want 2", user.ID)
+		}
+
+		// ResetDB 之后重新从 1 开始
+		err := st.ResetDB()
+		if err != nil {
+			t.Fatalf("ResetDB: %v", err)
+		}
+		if user := st.createUser("walt@breakingbad.com"); user.ID != 1 {
+			t.Errorf("CreateUser after reset ID = %d, want 1", user.ID)
+		}
+	})
+}
+
+// TestInitSequences 没有 sequences 的旧数据库文件，新 ID 从已有的最大 ID 之后开始，不会覆盖已有记录
+func TestInitSequences(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "db.json")
+	legacy := `{
+  "chirps": {
+    "1": {"id": 1, "body": "one", "author_id": 1},
+    "3": {"id": 3, "body": "three", "author_id": 1}
+  },
+  "users": {"1": {"id": 1, "email": "walt@breakingbad.com"}}
+}`
+	err := os.WriteFile(path, []byte(legacy), 0600)
+	if err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	db, err := NewDB(path)
+	if err != nil {
+		t.Fatalf("NewDB: %v", err)
+	}
+	defer db.Close()
+
+	chirp, err := db.CreateChirp(Chirp{AuthorID: 1, Body: "four"})
+	if err != nil || chirp.ID != 4 {
+		t.Errorf("CreateChirp = %+v, %v, want ID 4", chirp, err)
+	}
+	user, err := db.CreateUser("jesse@breakingbad.com", "hash")
+	if err != nil || user.ID != 2 {
+		t.Errorf("CreateUser = %+v, %v, want ID 2", user, err)
+	}
+	if chirp, _ := db.GetChirp(3); chirp.Body != "three" {
+		t.Errorf("GetChirp(3) = %+v", chirp)
+	}
+}
@@ -0,0 +1,438 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// SQLiteDB 是基于 SQLite 的存储后端
+type SQLiteDB struct {
+	db *sql.DB
+}
+
+// sqliteMigrations 按顺序保存 schema 迁移。
+// 所有表都使用 AUTOINCREMENT，SQLite 保证删除记录后 ID 不会被重新分配。
+// 已执行的版本号记录在 PRAGMA user_version 中，只能在末尾追加新的迁移，不要修改已有的条目。
+var sqliteMigrations = []string{
+	// 1: 初始 schema
+	`
+	CREATE TABLE users (
+		id              INTEGER PRIMARY KEY AUTOINCREMENT,
+		email           TEXT    NOT NULL UNIQUE,
+		hashed_password TEXT    NOT NULL,
+		is_chirpy_red   BOOLEAN NOT NULL DEFAULT FALSE
+	);
+
+	CREATE TABLE chirps (
+		id        INTEGER PRIMARY KEY AUTOINCREMENT,
+		body      TEXT    NOT NULL,
+		author_id INTEGER NOT NULL
+	);
+	CREATE INDEX chirps_author_id ON chirps (author_id);
+
+	CREATE TABLE refresh_tokens (
+		token      TEXT      PRIMARY KEY,
+		user_id    INTEGER   NOT NULL,
+		expires_at TIMESTAMP NOT NULL
+	);
+	CREATE INDEX refresh_tokens_user_id ON refresh_tokens (user_id);
+	`,
+	// 2: created_at/updated_at，已有记录使用迁移时间。
+	// 保存为 Unix 纳秒，保证按数值比较和排序是正确的。
+	`
+	ALTER TABLE users ADD COLUMN created_at INTEGER NOT NULL DEFAULT 0;
+	ALTER TABLE users ADD COLUMN updated_at INTEGER NOT NULL DEFAULT 0;
+	UPDATE users SET created_at = strftime('%s', 'now') * 1000000000, updated_at = strftime('%s', 'now') * 1000000000;
+
+	ALTER TABLE chirps ADD COLUMN created_at INTEGER NOT NULL DEFAULT 0;
+	ALTER TABLE chirps ADD COLUMN updated_at INTEGER NOT NULL DEFAULT 0;
+	UPDATE chirps SET created_at = strftime('%s', 'now') * 1000000000, updated_at = strftime('%s', 'now') * 1000000000;
+	CREATE INDEX chirps_created_at ON chirps (created_at, id);
+	CREATE INDEX chirps_author_id_created_at ON chirps (author_id, created_at, id);
+	`,
+	// 3: chirp 正文的 FTS5 全文索引，由触发器和 chirps 表保持同步
+	`
+	CREATE VIRTUAL TABLE chirps_fts USING fts5 (body, content = 'chirps', content_rowid = 'id');
+	INSERT INTO chirps_fts (rowid, body) SELECT id, body FROM chirps;
+
+	CREATE TRIGGER chirps_fts_insert AFTER INSERT ON chirps BEGIN
+		INSERT INTO chirps_fts (rowid, body) VALUES (new.id, new.body);
+	END;
+	CREATE TRIGGER chirps_fts_delete AFTER DELETE ON chirps BEGIN
+		INSERT INTO chirps_fts (chirps_fts, rowid, body) VALUES ('delete', old.id, old.body);
+	END;
+	CREATE TRIGGER chirps_fts_update AFTER UPDATE OF body ON chirps BEGIN
+		INSERT INTO chirps_fts (chirps_fts, rowid, body) VALUES ('delete', old.id, old.body);
+		INSERT INTO chirps_fts (rowid, body) VALUES (new.id, new.body);
+	END;
+	`,
+	// 4: 内容审核结果（JSON）
+	`
+	ALTER TABLE chirps ADD COLUMN moderation TEXT NOT NULL DEFAULT '{}';
+	`,
+	// 5: 编辑 chirp，保存历史版本
+	`
+	ALTER TABLE chirps ADD COLUMN edited_at INTEGER;
+
+	CREATE TABLE chirp_revisions (
+		id          INTEGER PRIMARY KEY AUTOINCREMENT,
+		chirp_id    INTEGER NOT NULL REFERENCES chirps (id) ON DELETE CASCADE,
+		body        TEXT    NOT NULL,
+		moderation  TEXT    NOT NULL,
+		created_at  INTEGER NOT NULL,
+		replaced_at INTEGER NOT NULL
+	);
+	CREATE INDEX chirp_revisions_chirp_id ON chirp_revisions (chirp_id, id);
+	`,
+	// 6: 回收站（软删除）
+	`
+	ALTER TABLE chirps ADD COLUMN deleted_at INTEGER;
+	ALTER TABLE chirps ADD COLUMN deleted_by INTEGER;
+	CREATE INDEX chirps_deleted_at ON chirps (deleted_at) WHERE deleted_at IS NOT NULL;
+	`,
+	// 7: 回复
+	`
+	ALTER TABLE chirps ADD COLUMN in_reply_to INTEGER;
+	CREATE INDEX chirps_in_reply_to ON chirps (in_reply_to, id) WHERE in_reply_to IS NOT NULL;
+	`,
+	// 8: 点赞
+	`
+	CREATE TABLE likes (
+		chirp_id   INTEGER NOT NULL REFERENCES chirps (id) ON DELETE CASCADE,
+		user_id    INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+		created_at INTEGER NOT NULL,
+		PRIMARY KEY (chirp_id, user_id)
+	);
+	CREATE INDEX likes_user_id ON likes (user_id, created_at, chirp_id);
+	`,
+	// 9: 关注
+	`
+	CREATE TABLE follows (
+		follower_id INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+		followee_id INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+		created_at  INTEGER NOT NULL,
+		PRIMARY KEY (follower_id, followee_id)
+	);
+	CREATE INDEX follows_follower_id ON follows (follower_id, created_at, followee_id);
+	CREATE INDEX follows_followee_id ON follows (followee_id, created_at, follower_id);
+	`,
+	// 10: 转发和引用，每个用户对同一个 chirp 只能有一个不在回收站中的转发
+	`
+	ALTER TABLE chirps ADD COLUMN rechirp_of INTEGER;
+	ALTER TABLE chirps ADD COLUMN quote_of INTEGER;
+	CREATE UNIQUE INDEX chirps_rechirp_of ON chirps (rechirp_of, author_id) WHERE rechirp_of IS NOT NULL AND deleted_at IS NULL;
+	CREATE INDEX chirps_quote_of ON chirps (quote_of) WHERE quote_of IS NOT NULL AND deleted_at IS NULL;
+	`,
+	// 11: handle、话题和提及、通知
+	`
+	ALTER TABLE users ADD COLUMN handle TEXT NOT NULL DEFAULT '';
+	UPDATE users SET handle = 'user' || id;
+	CREATE UNIQUE INDEX users_handle ON users (handle COLLATE NOCASE);
+	ALTER TABLE chirps ADD COLUMN entities TEXT NOT NULL DEFAULT '[]';
+	CREATE TABLE chirp_tags (
+		chirp_id   INTEGER NOT NULL REFERENCES chirps (id) ON DELETE CASCADE,
+		tag        TEXT NOT NULL,
+		created_at INTEGER NOT NULL,
+		PRIMARY KEY (chirp_id, tag)
+	);
+	CREATE INDEX chirp_tags_tag ON chirp_tags (tag, created_at, chirp_id);
+	CREATE INDEX chirp_tags_created_at ON chirp_tags (created_at);
+	CREATE TABLE notifications (
+		id         INTEGER PRIMARY KEY AUTOINCREMENT,
+		user_id    INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+		type       TEXT NOT NULL,
+		actor_id   INTEGER NOT NULL,
+		chirp_id   INTEGER NOT NULL REFERENCES chirps (id) ON DELETE CASCADE,
+		created_at INTEGER NOT NULL,
+		read_at    INTEGER
+	);
+	CREATE INDEX notifications_user_id ON notifications (user_id, id);
+	CREATE INDEX notifications_chirp_id ON notifications (chirp_id);
+	`,
+	// 12: 媒体。chirp 被永久删除时媒体记录的 chirp_id 被置为 NULL，之后由 PurgeMedia 清理
+	`
+	ALTER TABLE chirps ADD COLUMN media TEXT NOT NULL DEFAULT '[]';
+	CREATE TABLE media (
+		id           INTEGER PRIMARY KEY AUTOINCREMENT,
+		user_id      INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+		hash         TEXT    NOT NULL,
+		content_type TEXT    NOT NULL,
+		size         INTEGER NOT NULL,
+		width        INTEGER NOT NULL,
+		height       INTEGER NOT NULL,
+		chirp_id     INTEGER REFERENCES chirps (id) ON DELETE SET NULL,
+		created_at   INTEGER NOT NULL
+	);
+	CREATE INDEX media_hash ON media (hash);
+	CREATE INDEX media_chirp_id ON media (chirp_id);
+	CREATE INDEX media_unattached ON media (created_at) WHERE chirp_id IS NULL;
+	`,
+	// 13: 投票，poll 是投票的 JSON，没有投票时为 NULL
+	`
+	ALTER TABLE chirps ADD COLUMN poll TEXT;
+	CREATE TABLE poll_votes (
+		chirp_id   INTEGER NOT NULL REFERENCES chirps (id) ON DELETE CASCADE,
+		user_id    INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+		option     INTEGER NOT NULL,
+		created_at INTEGER NOT NULL,
+		PRIMARY KEY (chirp_id, user_id)
+	);
+	CREATE INDEX poll_votes_user_id ON poll_votes (user_id, chirp_id);
+	`,
+	// 14: 定时 chirp。发布或取消时删除记录，预留的媒体的 scheduled_chirp_id 被置为 NULL
+	`
+	CREATE TABLE scheduled_chirps (
+		id          INTEGER PRIMARY KEY AUTOINCREMENT,
+		author_id   INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+		body        TEXT    NOT NULL,
+		in_reply_to INTEGER,
+		quote_of    INTEGER,
+		moderation  TEXT    NOT NULL,
+		media       TEXT    NOT NULL DEFAULT '[]',
+		poll        TEXT,
+		publish_at  INTEGER NOT NULL,
+		created_at  INTEGER NOT NULL,
+		failed_at   INTEGER,
+		error       TEXT    NOT NULL DEFAULT ''
+	);
+	CREATE INDEX scheduled_chirps_author_id ON scheduled_chirps (author_id, publish_at, id);
+	CREATE INDEX scheduled_chirps_publish_at ON scheduled_chirps (publish_at, id) WHERE failed_at IS NULL;
+	ALTER TABLE media ADD COLUMN scheduled_chirp_id INTEGER REFERENCES scheduled_chirps (id) ON DELETE SET NULL;
+	`,
+	// 15: 用户资料，avatar 是头像的 JSON，没有头像时为 NULL
+	`
+	ALTER TABLE users ADD COLUMN display_name TEXT NOT NULL DEFAULT '';
+	ALTER TABLE users ADD COLUMN bio TEXT NOT NULL DEFAULT '';
+	ALTER TABLE users ADD COLUMN avatar TEXT;
+	ALTER TABLE users ADD COLUMN handle_changed_at INTEGER;
+	ALTER TABLE media ADD COLUMN avatar_user_id INTEGER REFERENCES users (id) ON DELETE SET NULL;
+	`,
+	// 16: 邮箱验证。已有的用户视为已验证，一次性令牌只保存哈希
+	`
+	ALTER TABLE users ADD COLUMN verified BOOLEAN NOT NULL DEFAULT FALSE;
+	UPDATE users SET verified = TRUE;
+	CREATE TABLE user_tokens (
+		hash       TEXT PRIMARY KEY,
+		user_id    INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+		purpose    TEXT    NOT NULL,
+		email      TEXT    NOT NULL DEFAULT '',
+		expires_at INTEGER NOT NULL,
+		created_at INTEGER NOT NULL
+	);
+	CREATE INDEX user_tokens_user_id ON user_tokens (user_id, purpose);
+	CREATE INDEX user_tokens_expires_at ON user_tokens (expires_at);
+	`,
+	// 17: 删除账号。delete_at 是宽限期结束、账号被永久删除的时间
+	`
+	ALTER TABLE users ADD COLUMN delete_at INTEGER;
+	CREATE INDEX users_delete_at ON users (delete_at) WHERE delete_at IS NOT NULL;
+	`,
+	// 18: 修改匿名化的账号保留的 handle，见 sqliteMigrationFuncs
+	`-- renameAnonymousHandles`,
+}
+
+// sqliteMigrationFuncs 保存不能只用 SQL 完成的迁移，键是版本号。
+// 它们在同一个事务中、在该版本的 SQL 之后执行。
+var sqliteMigrationFuncs = map[int]func(tx *sql.Tx) error{
+	18: renameAnonymousHandles,
+}
+
+// renameAnonymousHandles 按 anonymousHandleRenames 修改 handle。
+// 先把要修改的 handle 改为临时的 ~<id>，再改为新的 handle，以免中间状态违反 handle 的唯一索引。
+func renameAnonymousHandles(tx *sql.Tx) error {
+	rows, err := tx.Query("SELECT id, email, handle FROM users")
+	if err != nil {
+		return err
+	}
+	users := []User{}
+	for rows.Next() {
+		user := User{}
+		err = rows.Scan(&user.ID, &user.Email, &user.Handle)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		users = append(users, user)
+	}
+	rows.Close()
+	err = rows.Err()
+	if err != nil {
+		return err
+	}
+
+	renames, err := anonymousHandleRenames(users, func(handle string) (bool, error) {
+		var taken bool
+		err := tx.QueryRow("SELECT EXISTS (SELECT 1 FROM users WHERE handle = ? COLLATE NOCASE)", handle).Scan(&taken)
+		return taken, err
+	})
+	if err != nil {
+		return err
+	}
+	for id := range renames {
+		_, err = tx.Exec("UPDATE users SET handle = '~' || id WHERE id = ?", id)
+		if err != nil {
+			return err
+		}
+	}
+	for id, handle := range renames {
+		_, err = tx.Exec("UPDATE users SET handle = ? WHERE id = ?", handle, id)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ==== 创建 SQLite 数据库 ====
+/*
+NewSQLiteDB 打开（或创建）path 处的 SQLite 数据库，并执行尚未执行的迁移。
+*/
+func NewSQLiteDB(path string) (*SQLiteDB, error) {
+	dsn := fmt.Sprintf("file:%s?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)&_pragma=foreign_keys(1)", path)
+	conn, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, err
+	}
+	// SQLite 同一时间只允许一个写入者，单连接可以避免 SQLITE_BUSY
+	conn.SetMaxOpenConns(1)
+
+	db := &SQLiteDB{db: conn}
+	err = db.migrate()
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// migrate 执行 user_version 之后的所有迁移，每个迁移在单独的事务中执行
+func (db *SQLiteDB) migrate() error {
+	version := 0
+	err := db.db.QueryRow("PRAGMA user_version").Scan(&version)
+	if err != nil {
+		return err
+	}
+
+	for i := version; i < len(sqliteMigrations); i++ {
+		tx, err := db.db.Begin()
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(sqliteMigrations[i])
+		if err != nil {
+			tx.Rollback()
+			return fmt.Errorf("sqlite migration %d: %w", i+1, err)
+		}
+		if migrate, ok := sqliteMigrationFuncs[i+1]; ok {
+			err = migrate(tx)
+			if err != nil {
+				tx.Rollback()
+				return fmt.Errorf("sqlite migration %d: %w", i+1, err)
+			}
+		}
+		// PRAGMA 不支持占位符
+		_, err = tx.Exec(fmt.Sprintf("PRAGMA user_version = %d", i+1))
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+		err = tx.Commit()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ==== 迁移预演 ====
+/*
+PlanSQLiteMigrations 以只读方式打开 path 处的 SQLite 数据库，报告尚未执行的迁移，不执行它们。
+*/
+func PlanSQLiteMigrations(path string) (MigrationPlan, error) {
+	// 只读模式不会创建文件，文件不存在时直接返回错误
+	_, err := os.Stat(path)
+	if err != nil {
+		return MigrationPlan{}, err
+	}
+
+	conn, err := sql.Open("sqlite", fmt.Sprintf("file:%s?mode=ro", path))
+	if err != nil {
+		return MigrationPlan{}, err
+	}
+	defer conn.Close()
+
+	version := 0
+	err = conn.QueryRow("PRAGMA user_version").Scan(&version)
+	if err != nil {
+		return MigrationPlan{}, err
+	}
+
+	plan := MigrationPlan{
+		FromVersion: version,
+		ToVersion:   version,
+	}
+	for i := version; i < len(sqliteMigrations); i++ {
+		plan.ToVersion = i + 1
+		plan.Steps = append(plan.Steps, MigrationStep{
+			Version:     i + 1,
+			Description: "apply SQL schema migration",
+		})
+	}
+	return plan, nil
+}
+
+func (db *SQLiteDB) Close() error {
+	return db.db.Close()
+}
+
+// 重置数据库：清空所有表，并重置自增 ID
+func (db *SQLiteDB) ResetDB() error {
+	tx, err := db.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// 先删除引用其他表的记录
+	for _, table := range []string{"poll_votes", "notifications", "chirp_tags", "follows", "likes", "chirp_revisions", "refresh_tokens", "user_tokens", "media", "scheduled_chirps", "chirps", "users"} {
+		_, err = tx.Exec("DELETE FROM " + table)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = tx.Exec("DELETE FROM sqlite_sequence")
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// unixTime 和 fromUnixTime 在 time.Time 和 Unix 纳秒之间转换，零值对应 0
+func unixTime(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixNano()
+}
+
+func fromUnixTime(n int64) time.Time {
+	if n == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, n).UTC()
+}
+
+// isUniqueViolation 判断 err 是否由 UNIQUE 约束冲突引起
+func isUniqueViolation(err error) bool {
+	var sqliteErr *sqlite.Error
+	if errors.As(err, &sqliteErr) {
+		return sqliteErr.Code() == sqlite3.SQLITE_CONSTRAINT_UNIQUE
+	}
+	return false
+}
@@ -0,0 +1,39 @@
+package database
+
+import "encoding/json"
+
+func (db *SQLiteDB) GetChirpRevisions(chirpID int) ([]ChirpRevision, error) {
+	_, err := db.GetChirp(chirpID)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.db.Query(
+		"SELECT id, chirp_id, body, moderation, created_at, replaced_at FROM chirp_revisions WHERE chirp_id = ? ORDER BY id",
+		chirpID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	revisions := []ChirpRevision{}
+	for rows.Next() {
+		revision := ChirpRevision{}
+		var moderation string
+		var createdAt, replacedAt int64
+		err := rows.Scan(&revision.ID, &revision.ChirpID, &revision.Body, &moderation, &createdAt, &replacedAt)
+		if err != nil {
+			return nil, err
+		}
+		err = json.Unmarshal([]byte(moderation), &revision.Moderation)
+		if err != nil {
+			return nil, err
+		}
+		revision.CreatedAt = fromUnixTime(createdAt)
+		revision.ReplacedAt = fromUnixTime(replacedAt)
+		revisions = append(revisions, revision)
+	}
+
+	return revisions, rows.Err()
+}
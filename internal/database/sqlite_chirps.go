@@ -0,0 +1,407 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+// sqliteChirpColumns 的最后是派生字段，用子查询计算
+const sqliteChirpColumns = "id, body, author_id, in_reply_to, rechirp_of, quote_of, created_at, updated_at, edited_at, deleted_at, deleted_by, moderation, entities, media, poll, " +
+	"(SELECT COUNT(*) FROM chirps AS replies WHERE replies.in_reply_to = chirps.id AND replies.deleted_at IS NULL), " +
+	"(SELECT COUNT(*) FROM likes WHERE likes.chirp_id = chirps.id), " +
+	"(SELECT COUNT(*) FROM chirps AS rechirps WHERE rechirps.rechirp_of = chirps.id AND rechirps.deleted_at IS NULL), " +
+	"(SELECT COUNT(*) FROM chirps AS quotes WHERE quotes.quote_of = chirps.id AND quotes.deleted_at IS NULL), " +
+	"(SELECT json_group_object(CAST(option AS TEXT), n) FROM (SELECT option, COUNT(*) AS n FROM poll_votes WHERE poll_votes.chirp_id = chirps.id GROUP BY option)), " +
+	"(SELECT json_object('handle', handle, 'display_name', display_name, 'avatar', json(avatar)) FROM users WHERE users.id = chirps.author_id)"
+
+// scanChirp 把一行 sqliteChirpColumns 扫描为 Chirp
+func scanChirp(row interface{ Scan(...any) error }) (Chirp, error) {
+	chirp := Chirp{}
+	var createdAt, updatedAt int64
+	var inReplyTo, rechirpOf, quoteOf, editedAt, deletedAt, deletedBy sql.NullInt64
+	var moderation, entities, media, pollVotes string
+	var poll, author sql.NullString
+	err := row.Scan(
+		&chirp.ID, &chirp.Body, &chirp.AuthorID, &inReplyTo, &rechirpOf, &quoteOf,
+		&createdAt, &updatedAt, &editedAt, &deletedAt, &deletedBy, &moderation, &entities, &media, &poll,
+		&chirp.ReplyCount, &chirp.LikeCount, &chirp.RechirpCount, &chirp.QuoteCount, &pollVotes, &author,
+	)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Chirp{}, ErrNotExist
+	}
+	if err != nil {
+		return Chirp{}, err
+	}
+	chirp.InReplyTo = int(inReplyTo.Int64)
+	chirp.RechirpOf = int(rechirpOf.Int64)
+	chirp.QuoteOf = int(quoteOf.Int64)
+	chirp.CreatedAt = fromUnixTime(createdAt)
+	chirp.UpdatedAt = fromUnixTime(updatedAt)
+	if editedAt.Valid {
+		t := fromUnixTime(editedAt.Int64)
+		chirp.EditedAt = &t
+	}
+	if deletedAt.Valid {
+		t := fromUnixTime(deletedAt.Int64)
+		chirp.DeletedAt = &t
+		chirp.DeletedBy = int(deletedBy.Int64)
+	}
+	err = json.Unmarshal([]byte(moderation), &chirp.Moderation)
+	if err != nil {
+		return Chirp{}, err
+	}
+	err = json.Unmarshal([]byte(entities), &chirp.Entities)
+	if err != nil {
+		return Chirp{}, err
+	}
+	if len(chirp.Entities) == 0 {
+		// 和 JSON 后端一致，没有实体时为 nil
+		chirp.Entities = nil
+	}
+	err = json.Unmarshal([]byte(media), &chirp.Media)
+	if err != nil {
+		return Chirp{}, err
+	}
+	if len(chirp.Media) == 0 {
+		chirp.Media = nil
+	}
+	if poll.Valid {
+		chirp.Poll, err = scanPoll(poll.String, pollVotes)
+		if err != nil {
+			return Chirp{}, err
+		}
+	}
+	if author.Valid {
+		err = json.Unmarshal([]byte(author.String), &chirp.Author)
+		if err != nil {
+			return Chirp{}, err
+		}
+	}
+	return chirp, nil
+}
+
+// queryChirps 执行查询并把结果扫描为 Chirp 切片
+func (db *SQLiteDB) queryChirps(query string, args ...any) ([]Chirp, error) {
+	rows, err := db.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	chirps := []Chirp{}
+	for rows.Next() {
+		chirp, err := scanChirp(rows)
+		if err != nil {
+			return nil, err
+		}
+		chirps = append(chirps, chirp)
+	}
+
+	return chirps, rows.Err()
+}
+
+// CreateChirp 在一个事务中检查被回复和被引用的 chirp 以及要附加的媒体，插入新的 chirp 以及它的话题和通知
+func (db *SQLiteDB) CreateChirp(chirp Chirp) (Chirp, error) {
+	tx, err := db.db.Begin()
+	if err != nil {
+		return Chirp{}, err
+	}
+	defer tx.Rollback()
+
+	chirp, err = createChirp(tx, chirp)
+	if err != nil {
+		return Chirp{}, err
+	}
+	return chirp, tx.Commit()
+}
+
+// createChirp 是 CreateChirp 的事务内部分，发布定时 chirp 时也会用到
+func createChirp(tx *sql.Tx, chirp Chirp) (Chirp, error) {
+	moderation, err := json.Marshal(chirp.Moderation)
+	if err != nil {
+		return Chirp{}, err
+	}
+	entities, err := marshalEntities(chirp.Entities)
+	if err != nil {
+		return Chirp{}, err
+	}
+	err = checkReferences(tx, &chirp)
+	if err != nil {
+		return Chirp{}, err
+	}
+
+	attaching := map[int]bool{}
+	for i, attached := range chirp.Media {
+		// 和 JSON 后端一致，同一个媒体不能附加两次
+		if attaching[attached.ID] {
+			return Chirp{}, ErrMediaNotAvailable
+		}
+		attaching[attached.ID] = true
+		chirp.Media[i], err = availableMedia(tx, attached.ID, chirp.AuthorID)
+		if err != nil {
+			return Chirp{}, err
+		}
+	}
+	media, err := marshalChirpMedia(chirp.Media)
+	if err != nil {
+		return Chirp{}, err
+	}
+	poll, err := marshalPoll(chirp.Poll)
+	if err != nil {
+		return Chirp{}, err
+	}
+
+	chirp.CreatedAt = time.Now().UTC()
+	chirp.UpdatedAt = chirp.CreatedAt
+	res, err := tx.Exec(
+		"INSERT INTO chirps (body, author_id, in_reply_to, quote_of, created_at, updated_at, moderation, entities, media, poll) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		chirp.Body, chirp.AuthorID, nullID(chirp.InReplyTo), nullID(chirp.QuoteOf), unixTime(chirp.CreatedAt), unixTime(chirp.UpdatedAt), string(moderation), entities, media, poll,
+	)
+	if err != nil {
+		return Chirp{}, err
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return Chirp{}, err
+	}
+	chirp.ID = int(id)
+
+	for _, attached := range chirp.Media {
+		_, err = tx.Exec("UPDATE media SET chirp_id = ? WHERE id = ?", chirp.ID, attached.ID)
+		if err != nil {
+			return Chirp{}, err
+		}
+	}
+	err = insertChirpTags(tx, chirp)
+	if err != nil {
+		return Chirp{}, err
+	}
+	err = insertMentionNotifications(tx, chirp, nil)
+	if err != nil {
+		return Chirp{}, err
+	}
+	// 重新读取，填充派生字段
+	return scanChirp(tx.QueryRow("SELECT "+sqliteChirpColumns+" FROM chirps WHERE id = ?", chirp.ID))
+}
+
+// checkReferences 在事务中检查 chirp 回复和引用的 chirp 存在且不在回收站中，并把 QuoteOf 解析为原始 chirp
+func checkReferences(tx *sql.Tx, chirp *Chirp) error {
+	if chirp.InReplyTo != 0 {
+		var exists bool
+		err := tx.QueryRow(
+			"SELECT EXISTS (SELECT 1 FROM chirps WHERE id = ? AND deleted_at IS NULL)", chirp.InReplyTo,
+		).Scan(&exists)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return ErrParentNotExist
+		}
+	}
+	chirp.RechirpOf = 0
+	if chirp.QuoteOf != 0 {
+		quoted, err := originalChirp(tx, chirp.QuoteOf)
+		if errors.Is(err, ErrNotExist) {
+			return ErrQuotedNotExist
+		}
+		if err != nil {
+			return err
+		}
+		chirp.QuoteOf = quoted
+	}
+	return nil
+}
+
+// nullID 把 0 转换为 NULL，用于可选的 ID 列
+func nullID(id int) any {
+	if id == 0 {
+		return nil
+	}
+	return id
+}
+
+func (db *SQLiteDB) ListChirps(q ChirpQuery) ([]Chirp, error) {
+	query := "SELECT " + sqliteChirpColumns + " FROM chirps WHERE deleted_at IS NULL"
+	args := []any{}
+	if q.AuthorID != 0 {
+		query += " AND author_id = ?"
+		args = append(args, q.AuthorID)
+	}
+	if !q.Since.IsZero() {
+		query += " AND created_at >= ?"
+		args = append(args, unixTime(q.Since))
+	}
+	if !q.Until.IsZero() {
+		query += " AND created_at < ?"
+		args = append(args, unixTime(q.Until))
+	}
+
+	cmp, order := ">", "ASC"
+	if q.Desc {
+		cmp, order = "<", "DESC"
+	}
+	if q.SortBy == ChirpSortCreatedAt {
+		if q.AfterID != 0 {
+			query += " AND (created_at, id) " + cmp + " (?, ?)"
+			args = append(args, unixTime(q.AfterCreatedAt), q.AfterID)
+		}
+		query += " ORDER BY created_at " + order + ", id " + order
+	} else {
+		if q.AfterID != 0 {
+			query += " AND id " + cmp + " ?"
+			args = append(args, q.AfterID)
+		}
+		query += " ORDER BY id " + order
+	}
+	if q.Limit != 0 {
+		query += " LIMIT ?"
+		args = append(args, q.Limit)
+	}
+
+	return db.queryChirps(query, args...)
+}
+
+func (db *SQLiteDB) GetChirp(id int) (Chirp, error) {
+	return scanChirp(db.db.QueryRow(
+		"SELECT "+sqliteChirpColumns+" FROM chirps WHERE id = ?", id,
+	))
+}
+
+// UpdateChirp 在一个事务中保存修改前的版本，更新 chirp 和它的话题，并通知新提及的用户
+func (db *SQLiteDB) UpdateChirp(id int, body string, moderation ChirpModeration, entities []ChirpEntity) (Chirp, error) {
+	moderationJSON, err := json.Marshal(moderation)
+	if err != nil {
+		return Chirp{}, err
+	}
+	entitiesJSON, err := marshalEntities(entities)
+	if err != nil {
+		return Chirp{}, err
+	}
+
+	tx, err := db.db.Begin()
+	if err != nil {
+		return Chirp{}, err
+	}
+	defer tx.Rollback()
+
+	old, err := scanChirp(tx.QueryRow(
+		"SELECT "+sqliteChirpColumns+" FROM chirps WHERE id = ? AND deleted_at IS NULL", id,
+	))
+	if err != nil {
+		return Chirp{}, err
+	}
+
+	now := time.Now().UTC()
+	revision := revisionOf(old, now)
+	oldModeration, err := json.Marshal(revision.Moderation)
+	if err != nil {
+		return Chirp{}, err
+	}
+	_, err = tx.Exec(
+		"INSERT INTO chirp_revisions (chirp_id, body, moderation, created_at, replaced_at) VALUES (?, ?, ?, ?, ?)",
+		revision.ChirpID, revision.Body, string(oldModeration), unixTime(revision.CreatedAt), unixTime(revision.ReplacedAt),
+	)
+	if err != nil {
+		return Chirp{}, err
+	}
+
+	chirp, err := scanChirp(tx.QueryRow(
+		"UPDATE chirps SET body = ?, moderation = ?, entities = ?, updated_at = ?, edited_at = ? WHERE id = ? RETURNING "+sqliteChirpColumns,
+		body, string(moderationJSON), entitiesJSON, unixTime(now), unixTime(now), id,
+	))
+	if err != nil {
+		return Chirp{}, err
+	}
+
+	_, err = tx.Exec("DELETE FROM chirp_tags WHERE chirp_id = ?", id)
+	if err != nil {
+		return Chirp{}, err
+	}
+	err = insertChirpTags(tx, chirp)
+	if err != nil {
+		return Chirp{}, err
+	}
+	mentioned := map[int]bool{}
+	for _, userID := range old.mentionedUsers() {
+		mentioned[userID] = true
+	}
+	err = insertMentionNotifications(tx, chirp, mentioned)
+	if err != nil {
+		return Chirp{}, err
+	}
+
+	return chirp, tx.Commit()
+}
+
+// DeleteChirp 把 chirp 移入回收站
+func (db *SQLiteDB) DeleteChirp(id int, deletedBy int) error {
+	res, err := db.db.Exec(
+		"UPDATE chirps SET deleted_at = ?, deleted_by = ? WHERE id = ? AND deleted_at IS NULL",
+		unixTime(time.Now()), deletedBy, id,
+	)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNotExist
+	}
+	return nil
+}
+
+// RestoreChirp 在一个事务中检查转发是否重复并恢复 chirp
+func (db *SQLiteDB) RestoreChirp(id int) (Chirp, error) {
+	tx, err := db.db.Begin()
+	if err != nil {
+		return Chirp{}, err
+	}
+	defer tx.Rollback()
+
+	var conflict bool
+	err = tx.QueryRow(
+		`SELECT EXISTS (
+			SELECT 1 FROM chirps AS deleted JOIN chirps AS active
+			ON active.rechirp_of = deleted.rechirp_of AND active.author_id = deleted.author_id
+			WHERE deleted.id = ? AND deleted.deleted_at IS NOT NULL AND active.deleted_at IS NULL
+		)`, id,
+	).Scan(&conflict)
+	if err != nil {
+		return Chirp{}, err
+	}
+	if conflict {
+		return Chirp{}, ErrAlreadyExists
+	}
+
+	chirp, err := scanChirp(tx.QueryRow(
+		"UPDATE chirps SET deleted_at = NULL, deleted_by = NULL WHERE id = ? AND deleted_at IS NOT NULL RETURNING "+sqliteChirpColumns,
+		id,
+	))
+	if err != nil {
+		return Chirp{}, err
+	}
+	return chirp, tx.Commit()
+}
+
+func (db *SQLiteDB) ListDeletedChirps(authorID int) ([]Chirp, error) {
+	return db.queryChirps(
+		"SELECT "+sqliteChirpColumns+" FROM chirps WHERE author_id = ? AND deleted_at IS NOT NULL ORDER BY deleted_at DESC, id DESC",
+		authorID,
+	)
+}
+
+// PurgeChirps 永久删除在 deletedBefore 之前被删除的 chirp，它们的历史版本、点赞、话题和通知由外键级联删除，
+// 媒体记录的 chirp_id 被置为 NULL
+func (db *SQLiteDB) PurgeChirps(deletedBefore time.Time) (int, error) {
+	res, err := db.db.Exec("DELETE FROM chirps WHERE deleted_at < ?", unixTime(deletedBefore))
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	return int(n), err
+}
@@ -0,0 +1,82 @@
+package database
+
+import (
+	"time"
+)
+
+// Follow 用 INSERT OR IGNORE 保证幂等
+func (db *SQLiteDB) Follow(followerID, followeeID int) error {
+	tx, err := db.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(
+		`INSERT OR IGNORE INTO follows (follower_id, followee_id, created_at)
+		SELECT ?, id, ? FROM users WHERE id = ?`,
+		followerID, unixTime(time.Now()), followeeID,
+	)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		// 可能是已经关注了，也可能是用户不存在
+		var exists bool
+		err = tx.QueryRow("SELECT EXISTS (SELECT 1 FROM users WHERE id = ?)", followeeID).Scan(&exists)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return ErrNotExist
+		}
+	}
+	return tx.Commit()
+}
+
+func (db *SQLiteDB) Unfollow(followerID, followeeID int) error {
+	_, err := db.db.Exec("DELETE FROM follows WHERE follower_id = ? AND followee_id = ?", followerID, followeeID)
+	return err
+}
+
+func (db *SQLiteDB) ListFollowers(userID int) ([]Follow, error) {
+	return db.queryFollows(userID,
+		"SELECT follower_id, followee_id, created_at FROM follows WHERE followee_id = ? ORDER BY created_at DESC, follower_id DESC",
+	)
+}
+
+func (db *SQLiteDB) ListFollowing(userID int) ([]Follow, error) {
+	return db.queryFollows(userID,
+		"SELECT follower_id, followee_id, created_at FROM follows WHERE follower_id = ? ORDER BY created_at DESC, followee_id DESC",
+	)
+}
+
+func (db *SQLiteDB) queryFollows(userID int, query string) ([]Follow, error) {
+	_, err := db.GetUser(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.db.Query(query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	follows := []Follow{}
+	for rows.Next() {
+		follow := Follow{}
+		var createdAt int64
+		err := rows.Scan(&follow.FollowerID, &follow.FolloweeID, &createdAt)
+		if err != nil {
+			return nil, err
+		}
+		follow.CreatedAt = fromUnixTime(createdAt)
+		follows = append(follows, follow)
+	}
+	return follows, rows.Err()
+}
@@ -0,0 +1,107 @@
+package database
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+)
+
+// LikeChirp 用 INSERT OR IGNORE 保证幂等
+func (db *SQLiteDB) LikeChirp(chirpID, userID int) (Chirp, error) {
+	tx, err := db.db.Begin()
+	if err != nil {
+		return Chirp{}, err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(
+		`INSERT OR IGNORE INTO likes (chirp_id, user_id, created_at)
+		SELECT id, ?, ? FROM chirps WHERE id = ? AND deleted_at IS NULL`,
+		userID, unixTime(time.Now()), chirpID,
+	)
+	if err != nil {
+		return Chirp{}, err
+	}
+
+	chirp, err := db.visibleChirp(tx, chirpID)
+	if err != nil {
+		return Chirp{}, err
+	}
+	return chirp, tx.Commit()
+}
+
+func (db *SQLiteDB) UnlikeChirp(chirpID, userID int) (Chirp, error) {
+	tx, err := db.db.Begin()
+	if err != nil {
+		return Chirp{}, err
+	}
+	defer tx.Rollback()
+
+	chirp, err := db.visibleChirp(tx, chirpID)
+	if err != nil {
+		return Chirp{}, err
+	}
+	_, err = tx.Exec("DELETE FROM likes WHERE chirp_id = ? AND user_id = ?", chirpID, userID)
+	if err != nil {
+		return Chirp{}, err
+	}
+
+	// 重新读取，点赞数已经变化
+	chirp, err = db.visibleChirp(tx, chirpID)
+	if err != nil {
+		return Chirp{}, err
+	}
+	return chirp, tx.Commit()
+}
+
+// visibleChirp 在事务中读取不在回收站中的 chirp，否则返回 ErrNotExist
+func (db *SQLiteDB) visibleChirp(tx *sql.Tx, id int) (Chirp, error) {
+	return scanChirp(tx.QueryRow(
+		"SELECT "+sqliteChirpColumns+" FROM chirps WHERE id = ? AND deleted_at IS NULL", id,
+	))
+}
+
+func (db *SQLiteDB) LikedByUser(userID int, chirpIDs []int) (map[int]bool, error) {
+	liked := map[int]bool{}
+	if len(chirpIDs) == 0 {
+		return liked, nil
+	}
+
+	args := []any{userID}
+	for _, id := range chirpIDs {
+		args = append(args, id)
+	}
+	rows, err := db.db.Query(
+		"SELECT chirp_id FROM likes WHERE user_id = ? AND chirp_id IN (?"+strings.Repeat(", ?", len(chirpIDs)-1)+")",
+		args...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var chirpID int
+		err := rows.Scan(&chirpID)
+		if err != nil {
+			return nil, err
+		}
+		liked[chirpID] = true
+	}
+	return liked, rows.Err()
+}
+
+func (db *SQLiteDB) ListLikedChirps(userID int) ([]Chirp, error) {
+	_, err := db.GetUser(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	return db.queryChirps(`
+	SELECT `+sqliteChirpColumns+` FROM chirps
+	JOIN (
+		SELECT chirp_id, created_at AS liked_at FROM likes WHERE user_id = ?
+	) AS liked ON liked.chirp_id = chirps.id
+	WHERE deleted_at IS NULL
+	ORDER BY liked.liked_at DESC, liked.chirp_id DESC`, userID)
+}
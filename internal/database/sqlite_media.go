@@ -0,0 +1,96 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"sort"
+	"time"
+)
+
+// marshalChirpMedia 把附加的媒体编码为 media 列的 JSON，nil 编码为 "[]"
+func marshalChirpMedia(media []ChirpMedia) (string, error) {
+	if media == nil {
+		media = []ChirpMedia{}
+	}
+	dat, err := json.Marshal(media)
+	return string(dat), err
+}
+
+// availableMedia 在事务中读取可以附加到 authorID 的新 chirp（或预留给定时 chirp、用作头像）的媒体，
+// 媒体不存在、不属于作者、已经附加到其他 chirp、被预留或被用作头像时返回 ErrMediaNotAvailable
+func availableMedia(tx *sql.Tx, id, authorID int) (ChirpMedia, error) {
+	media := ChirpMedia{}
+	err := tx.QueryRow(
+		"SELECT id, hash, content_type, size, width, height FROM media WHERE id = ? AND user_id = ? AND chirp_id IS NULL AND scheduled_chirp_id IS NULL AND avatar_user_id IS NULL",
+		id, authorID,
+	).Scan(&media.ID, &media.Hash, &media.ContentType, &media.Size, &media.Width, &media.Height)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ChirpMedia{}, ErrMediaNotAvailable
+	}
+	return media, err
+}
+
+func (db *SQLiteDB) CreateMedia(media Media) (Media, error) {
+	media.ChirpID = 0
+	media.CreatedAt = time.Now().UTC()
+	res, err := db.db.Exec(
+		"INSERT INTO media (user_id, hash, content_type, size, width, height, created_at) VALUES (?, ?, ?, ?, ?, ?, ?)",
+		media.UserID, media.Hash, media.ContentType, media.Size, media.Width, media.Height, unixTime(media.CreatedAt),
+	)
+	if err != nil {
+		return Media{}, err
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return Media{}, err
+	}
+	media.ID = int(id)
+	return media, nil
+}
+
+// PurgeMedia 在一个事务中删除孤儿媒体记录，并找出不再被任何记录引用的文件
+func (db *SQLiteDB) PurgeMedia(unattachedBefore time.Time) ([]string, error) {
+	tx, err := db.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(
+		"DELETE FROM media WHERE chirp_id IS NULL AND scheduled_chirp_id IS NULL AND avatar_user_id IS NULL AND created_at < ? RETURNING hash", unixTime(unattachedBefore),
+	)
+	if err != nil {
+		return nil, err
+	}
+	deleted := map[string]bool{}
+	for rows.Next() {
+		var hash string
+		err = rows.Scan(&hash)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		deleted[hash] = true
+	}
+	rows.Close()
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	unused := []string{}
+	for hash := range deleted {
+		var referenced bool
+		err = tx.QueryRow("SELECT EXISTS (SELECT 1 FROM media WHERE hash = ?)", hash).Scan(&referenced)
+		if err != nil {
+			return nil, err
+		}
+		if !referenced {
+			unused = append(unused, hash)
+		}
+	}
+	sort.Strings(unused)
+	return unused, tx.Commit()
+}
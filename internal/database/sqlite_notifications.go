@@ -0,0 +1,72 @@
+package database
+
+import (
+	"database/sql"
+	"time"
+)
+
+// insertMentionNotifications 在事务中为 chirp 中提及的、不在 except 中的用户插入通知
+func insertMentionNotifications(tx *sql.Tx, chirp Chirp, except map[int]bool) error {
+	for _, userID := range chirp.mentionedUsers() {
+		if except[userID] {
+			continue
+		}
+		_, err := tx.Exec(
+			"INSERT INTO notifications (user_id, type, actor_id, chirp_id, created_at) VALUES (?, ?, ?, ?, ?)",
+			userID, NotificationMention, chirp.AuthorID, chirp.ID, unixTime(chirp.UpdatedAt),
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (db *SQLiteDB) ListNotifications(q NotificationQuery) ([]Notification, error) {
+	query := "SELECT id, user_id, type, actor_id, chirp_id, created_at, read_at FROM notifications WHERE user_id = ?"
+	args := []any{q.UserID}
+	if q.BeforeID != 0 {
+		query += " AND id < ?"
+		args = append(args, q.BeforeID)
+	}
+	query += " ORDER BY id DESC"
+	if q.Limit != 0 {
+		query += " LIMIT ?"
+		args = append(args, q.Limit)
+	}
+
+	rows, err := db.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	notifications := []Notification{}
+	for rows.Next() {
+		notification := Notification{}
+		var createdAt int64
+		var readAt sql.NullInt64
+		err := rows.Scan(
+			&notification.ID, &notification.UserID, &notification.Type, &notification.ActorID, &notification.ChirpID,
+			&createdAt, &readAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		notification.CreatedAt = fromUnixTime(createdAt)
+		if readAt.Valid {
+			t := fromUnixTime(readAt.Int64)
+			notification.ReadAt = &t
+		}
+		notifications = append(notifications, notification)
+	}
+	return notifications, rows.Err()
+}
+
+func (db *SQLiteDB) MarkNotificationsRead(userID int) error {
+	_, err := db.db.Exec(
+		"UPDATE notifications SET read_at = ? WHERE user_id = ? AND read_at IS NULL",
+		unixTime(time.Now()), userID,
+	)
+	return err
+}
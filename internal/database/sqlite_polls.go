@@ -0,0 +1,124 @@
+package database
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// marshalPoll 把投票编码为 poll 列的 JSON，没有投票时为 NULL
+func marshalPoll(poll *ChirpPoll) (any, error) {
+	if poll == nil {
+		return nil, nil
+	}
+	dat, err := json.Marshal(poll)
+	if err != nil {
+		return nil, err
+	}
+	return string(dat), nil
+}
+
+// scanPoll 解码 poll 列，votes 是 sqliteChirpColumns 中按选项统计的票数（{"选项": 票数}）
+func scanPoll(poll, votes string) (*ChirpPoll, error) {
+	p := &ChirpPoll{}
+	err := json.Unmarshal([]byte(poll), p)
+	if err != nil {
+		return nil, err
+	}
+	tallies := map[string]int{}
+	err = json.Unmarshal([]byte(votes), &tallies)
+	if err != nil {
+		return nil, err
+	}
+	p.Votes = make([]int, len(p.Options))
+	for option, n := range tallies {
+		i, err := strconv.Atoi(option)
+		if err != nil {
+			return nil, err
+		}
+		if i >= 0 && i < len(p.Votes) {
+			p.Votes[i] = n
+		}
+	}
+	return p, nil
+}
+
+// VotePoll 在一个事务中检查投票的状态和是否已经投过票，然后插入投票
+func (db *SQLiteDB) VotePoll(chirpID, userID, option int) (Chirp, error) {
+	tx, err := db.db.Begin()
+	if err != nil {
+		return Chirp{}, err
+	}
+	defer tx.Rollback()
+
+	chirp, err := db.visibleChirp(tx, chirpID)
+	if err != nil {
+		return Chirp{}, err
+	}
+	if chirp.Poll == nil {
+		return Chirp{}, ErrNotExist
+	}
+	now := time.Now().UTC()
+	if chirp.Poll.Closed(now) {
+		return Chirp{}, ErrPollClosed
+	}
+	if option < 0 || option >= len(chirp.Poll.Options) {
+		return Chirp{}, ErrInvalidPollOption
+	}
+	var voted bool
+	err = tx.QueryRow(
+		"SELECT EXISTS (SELECT 1 FROM poll_votes WHERE chirp_id = ? AND user_id = ?)", chirpID, userID,
+	).Scan(&voted)
+	if err != nil {
+		return Chirp{}, err
+	}
+	if voted {
+		return Chirp{}, ErrAlreadyExists
+	}
+
+	_, err = tx.Exec(
+		"INSERT INTO poll_votes (chirp_id, user_id, option, created_at) VALUES (?, ?, ?, ?)",
+		chirpID, userID, option, unixTime(now),
+	)
+	if err != nil {
+		return Chirp{}, err
+	}
+
+	// 重新读取，票数已经变化
+	chirp, err = db.visibleChirp(tx, chirpID)
+	if err != nil {
+		return Chirp{}, err
+	}
+	return chirp, tx.Commit()
+}
+
+func (db *SQLiteDB) PollVotesByUser(userID int, chirpIDs []int) (map[int]int, error) {
+	votes := map[int]int{}
+	if len(chirpIDs) == 0 {
+		return votes, nil
+	}
+
+	args := []any{userID}
+	for _, id := range chirpIDs {
+		args = append(args, id)
+	}
+	rows, err := db.db.Query(
+		"SELECT chirp_id, option FROM poll_votes WHERE user_id = ? AND chirp_id IN (?"+strings.Repeat(", ?", len(chirpIDs)-1)+")",
+		args...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var chirpID, option int
+		err := rows.Scan(&chirpID, &option)
+		if err != nil {
+			return nil, err
+		}
+		votes[chirpID] = option
+	}
+	return votes, rows.Err()
+}
@@ -0,0 +1,101 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+)
+
+// originalChirp 在事务中返回 id 对应的不在回收站中的原始 chirp 的 ID（转发会被解析为被转发的 chirp），否则返回 ErrNotExist
+func originalChirp(tx *sql.Tx, id int) (int, error) {
+	var originalID int
+	err := tx.QueryRow(
+		`SELECT original.id FROM chirps
+		JOIN chirps AS original ON original.id = COALESCE(chirps.rechirp_of, chirps.id)
+		WHERE chirps.id = ? AND original.deleted_at IS NULL`, id,
+	).Scan(&originalID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, ErrNotExist
+	}
+	return originalID, err
+}
+
+// Rechirp 在一个事务中解析原始 chirp，并返回已有的转发或插入新的转发
+func (db *SQLiteDB) Rechirp(chirpID, userID int) (Chirp, bool, error) {
+	tx, err := db.db.Begin()
+	if err != nil {
+		return Chirp{}, false, err
+	}
+	defer tx.Rollback()
+
+	originalID, err := originalChirp(tx, chirpID)
+	if err != nil {
+		return Chirp{}, false, err
+	}
+
+	rechirp, err := scanChirp(tx.QueryRow(
+		"SELECT "+sqliteChirpColumns+" FROM chirps WHERE rechirp_of = ? AND author_id = ? AND deleted_at IS NULL",
+		originalID, userID,
+	))
+	if err == nil {
+		return rechirp, false, tx.Commit()
+	}
+	if !errors.Is(err, ErrNotExist) {
+		return Chirp{}, false, err
+	}
+
+	now := unixTime(time.Now())
+	rechirp, err = scanChirp(tx.QueryRow(
+		`INSERT INTO chirps (body, author_id, rechirp_of, created_at, updated_at) VALUES ('', ?, ?, ?, ?)
+		RETURNING `+sqliteChirpColumns,
+		userID, originalID, now, now,
+	))
+	if err != nil {
+		return Chirp{}, false, err
+	}
+	return rechirp, true, tx.Commit()
+}
+
+func (db *SQLiteDB) Unrechirp(chirpID, userID int) error {
+	res, err := db.db.Exec(
+		`UPDATE chirps SET deleted_at = ?, deleted_by = ?
+		WHERE author_id = ? AND deleted_at IS NULL
+		AND rechirp_of = COALESCE((SELECT rechirp_of FROM chirps WHERE id = ?), ?)`,
+		unixTime(time.Now()), userID, userID, chirpID, chirpID,
+	)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNotExist
+	}
+	return nil
+}
+
+func (db *SQLiteDB) GetChirpsByID(ids []int) (map[int]Chirp, error) {
+	chirps := map[int]Chirp{}
+	if len(ids) == 0 {
+		return chirps, nil
+	}
+
+	args := []any{}
+	for _, id := range ids {
+		args = append(args, id)
+	}
+	list, err := db.queryChirps(
+		"SELECT "+sqliteChirpColumns+" FROM chirps WHERE id IN (?"+strings.Repeat(", ?", len(ids)-1)+")",
+		args...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	for _, chirp := range list {
+		chirps[chirp.ID] = chirp
+	}
+	return chirps, nil
+}
@@ -0,0 +1,46 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+func (db *SQLiteDB) SaveRefreshToken(userID int, token string) error {
+	_, err := db.db.Exec(
+		"INSERT INTO refresh_tokens (token, user_id, expires_at) VALUES (?, ?, ?)",
+		token, userID, time.Now().Add(time.Hour).UTC(),
+	)
+	return err
+}
+
+func (db *SQLiteDB) RevokeRefreshToken(token string) error {
+	_, err := db.db.Exec("DELETE FROM refresh_tokens WHERE token = ?", token)
+	return err
+}
+
+func (db *SQLiteDB) RevokeUserRefreshTokens(userID int, except string) error {
+	_, err := db.db.Exec("DELETE FROM refresh_tokens WHERE user_id = ? AND token != ?", userID, except)
+	return err
+}
+
+func (db *SQLiteDB) UserForRefreshToken(token string) (User, error) {
+	userID := 0
+	expiresAt := time.Time{}
+	err := db.db.QueryRow(
+		"SELECT user_id, expires_at FROM refresh_tokens WHERE token = ?", token,
+	).Scan(&userID, &expiresAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return User{}, ErrNotExist
+	}
+	if err != nil {
+		return User{}, err
+	}
+
+	// 检查 refreshToken 是否已经过期
+	if expiresAt.Before(time.Now()) {
+		return User{}, ErrNotExist
+	}
+
+	return db.GetUser(userID)
+}
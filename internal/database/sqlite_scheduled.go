@@ -0,0 +1,219 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+const sqliteScheduledChirpColumns = "id, author_id, body, in_reply_to, quote_of, moderation, media, poll, publish_at, created_at, failed_at, error"
+
+// scanScheduledChirp 把一行 sqliteScheduledChirpColumns 扫描为 ScheduledChirp
+func scanScheduledChirp(row interface{ Scan(...any) error }) (ScheduledChirp, error) {
+	scheduled := ScheduledChirp{}
+	var inReplyTo, quoteOf, failedAt sql.NullInt64
+	var moderation, media string
+	var poll sql.NullString
+	var publishAt, createdAt int64
+	err := row.Scan(
+		&scheduled.ID, &scheduled.AuthorID, &scheduled.Body, &inReplyTo, &quoteOf,
+		&moderation, &media, &poll, &publishAt, &createdAt, &failedAt, &scheduled.Error,
+	)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ScheduledChirp{}, ErrNotExist
+	}
+	if err != nil {
+		return ScheduledChirp{}, err
+	}
+	scheduled.InReplyTo = int(inReplyTo.Int64)
+	scheduled.QuoteOf = int(quoteOf.Int64)
+	scheduled.PublishAt = fromUnixTime(publishAt)
+	scheduled.CreatedAt = fromUnixTime(createdAt)
+	if failedAt.Valid {
+		t := fromUnixTime(failedAt.Int64)
+		scheduled.FailedAt = &t
+	}
+	err = json.Unmarshal([]byte(moderation), &scheduled.Moderation)
+	if err != nil {
+		return ScheduledChirp{}, err
+	}
+	err = json.Unmarshal([]byte(media), &scheduled.Media)
+	if err != nil {
+		return ScheduledChirp{}, err
+	}
+	if len(scheduled.Media) == 0 {
+		scheduled.Media = nil
+	}
+	if poll.Valid {
+		scheduled.Poll = &ChirpPoll{}
+		err = json.Unmarshal([]byte(poll.String), scheduled.Poll)
+		if err != nil {
+			return ScheduledChirp{}, err
+		}
+	}
+	return scheduled, nil
+}
+
+// queryScheduledChirps 执行查询并把结果扫描为 ScheduledChirp 切片
+func (db *SQLiteDB) queryScheduledChirps(query string, args ...any) ([]ScheduledChirp, error) {
+	rows, err := db.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	scheduled := []ScheduledChirp{}
+	for rows.Next() {
+		s, err := scanScheduledChirp(rows)
+		if err != nil {
+			return nil, err
+		}
+		scheduled = append(scheduled, s)
+	}
+	return scheduled, rows.Err()
+}
+
+// ScheduleChirp 在一个事务中检查被回复和被引用的 chirp，插入定时 chirp 并预留媒体
+func (db *SQLiteDB) ScheduleChirp(scheduled ScheduledChirp) (ScheduledChirp, error) {
+	moderation, err := json.Marshal(scheduled.Moderation)
+	if err != nil {
+		return ScheduledChirp{}, err
+	}
+	poll, err := marshalPoll(scheduled.Poll)
+	if err != nil {
+		return ScheduledChirp{}, err
+	}
+
+	tx, err := db.db.Begin()
+	if err != nil {
+		return ScheduledChirp{}, err
+	}
+	defer tx.Rollback()
+
+	chirp := Chirp{InReplyTo: scheduled.InReplyTo, QuoteOf: scheduled.QuoteOf}
+	err = checkReferences(tx, &chirp)
+	if err != nil {
+		return ScheduledChirp{}, err
+	}
+	scheduled.QuoteOf = chirp.QuoteOf
+
+	reserving := map[int]bool{}
+	for i, reserved := range scheduled.Media {
+		if reserving[reserved.ID] {
+			return ScheduledChirp{}, ErrMediaNotAvailable
+		}
+		reserving[reserved.ID] = true
+		scheduled.Media[i], err = availableMedia(tx, reserved.ID, scheduled.AuthorID)
+		if err != nil {
+			return ScheduledChirp{}, err
+		}
+	}
+	media, err := marshalChirpMedia(scheduled.Media)
+	if err != nil {
+		return ScheduledChirp{}, err
+	}
+
+	scheduled.CreatedAt = time.Now().UTC()
+	scheduled.FailedAt = nil
+	scheduled.Error = ""
+	res, err := tx.Exec(
+		"INSERT INTO scheduled_chirps (author_id, body, in_reply_to, quote_of, moderation, media, poll, publish_at, created_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		scheduled.AuthorID, scheduled.Body, nullID(scheduled.InReplyTo), nullID(scheduled.QuoteOf), string(moderation), media, poll,
+		unixTime(scheduled.PublishAt), unixTime(scheduled.CreatedAt),
+	)
+	if err != nil {
+		return ScheduledChirp{}, err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return ScheduledChirp{}, err
+	}
+	scheduled.ID = int(id)
+
+	for _, reserved := range scheduled.Media {
+		_, err = tx.Exec("UPDATE media SET scheduled_chirp_id = ? WHERE id = ?", scheduled.ID, reserved.ID)
+		if err != nil {
+			return ScheduledChirp{}, err
+		}
+	}
+	return scheduled, tx.Commit()
+}
+
+func (db *SQLiteDB) GetScheduledChirp(id int) (ScheduledChirp, error) {
+	return scanScheduledChirp(db.db.QueryRow(
+		"SELECT "+sqliteScheduledChirpColumns+" FROM scheduled_chirps WHERE id = ?", id,
+	))
+}
+
+func (db *SQLiteDB) ListScheduledChirps(authorID int) ([]ScheduledChirp, error) {
+	return db.queryScheduledChirps(
+		"SELECT "+sqliteScheduledChirpColumns+" FROM scheduled_chirps WHERE author_id = ? ORDER BY publish_at ASC, id ASC", authorID,
+	)
+}
+
+// CancelScheduledChirp 删除定时 chirp，预留的媒体由外键释放
+func (db *SQLiteDB) CancelScheduledChirp(id int) error {
+	res, err := db.db.Exec("DELETE FROM scheduled_chirps WHERE id = ?", id)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNotExist
+	}
+	return nil
+}
+
+func (db *SQLiteDB) DueScheduledChirps(now time.Time) ([]ScheduledChirp, error) {
+	return db.queryScheduledChirps(
+		"SELECT "+sqliteScheduledChirpColumns+" FROM scheduled_chirps WHERE failed_at IS NULL AND publish_at <= ? ORDER BY publish_at ASC, id ASC",
+		unixTime(now),
+	)
+}
+
+// PublishScheduledChirp 在一个事务中删除定时 chirp（释放预留的媒体），然后用 createChirp 创建 chirp
+func (db *SQLiteDB) PublishScheduledChirp(id int, entities []ChirpEntity) (Chirp, error) {
+	tx, err := db.db.Begin()
+	if err != nil {
+		return Chirp{}, err
+	}
+	defer tx.Rollback()
+
+	scheduled, err := scanScheduledChirp(tx.QueryRow(
+		"SELECT "+sqliteScheduledChirpColumns+" FROM scheduled_chirps WHERE id = ? AND failed_at IS NULL", id,
+	))
+	if err != nil {
+		return Chirp{}, err
+	}
+	_, err = tx.Exec("DELETE FROM scheduled_chirps WHERE id = ?", id)
+	if err != nil {
+		return Chirp{}, err
+	}
+
+	chirp, err := createChirp(tx, scheduled.chirp(entities))
+	if err != nil {
+		return Chirp{}, err
+	}
+	return chirp, tx.Commit()
+}
+
+func (db *SQLiteDB) FailScheduledChirp(id int, reason string) error {
+	res, err := db.db.Exec(
+		"UPDATE scheduled_chirps SET failed_at = ?, error = ? WHERE id = ?", unixTime(time.Now()), reason, id,
+	)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNotExist
+	}
+	return nil
+}
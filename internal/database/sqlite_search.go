@@ -0,0 +1,41 @@
+package database
+
+import "strings"
+
+// ==== 搜索 Chirps ====
+/*
+SQLite 后端使用 FTS5 的 unicode61 分词器，同样不区分大小写、以字母和数字以外的字符为分隔符。
+查询语句先用 parseSearchQuery 解析，再转换为 FTS5 的 MATCH 语法：每个子句是一个带引号的短语，子句之间是 AND。
+结果按 FTS5 的 bm25() 排序（值越小越相关）。
+*/
+func (db *SQLiteDB) SearchChirps(q SearchQuery) ([]Chirp, error) {
+	clauses := parseSearchQuery(q.Text)
+	if len(clauses) == 0 {
+		return []Chirp{}, nil
+	}
+
+	phrases := make([]string, 0, len(clauses))
+	for _, clause := range clauses {
+		// tokenize 只保留字母和数字，短语中不会出现需要转义的双引号
+		phrases = append(phrases, `"`+strings.Join(clause, " ")+`"`)
+	}
+
+	query := `
+	SELECT ` + sqliteChirpColumns + ` FROM chirps
+	JOIN (
+		SELECT rowid, bm25(chirps_fts) AS rank FROM chirps_fts WHERE chirps_fts MATCH ?
+	) AS hits ON hits.rowid = chirps.id
+	WHERE deleted_at IS NULL`
+	args := []any{strings.Join(phrases, " AND ")}
+	if q.AuthorID != 0 {
+		query += " AND author_id = ?"
+		args = append(args, q.AuthorID)
+	}
+	query += " ORDER BY hits.rank, id DESC"
+	if q.Limit != 0 {
+		query += " LIMIT ?"
+		args = append(args, q.Limit)
+	}
+
+	return db.queryChirps(query, args...)
+}
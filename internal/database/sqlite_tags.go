@@ -0,0 +1,78 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+	"time"
+)
+
+// marshalEntities 把实体编码为 entities 列的 JSON，nil 编码为 "[]"
+func marshalEntities(entities []ChirpEntity) (string, error) {
+	if entities == nil {
+		entities = []ChirpEntity{}
+	}
+	dat, err := json.Marshal(entities)
+	return string(dat), err
+}
+
+// insertChirpTags 在事务中为 chirp 的每个话题插入一行 chirp_tags
+func insertChirpTags(tx *sql.Tx, chirp Chirp) error {
+	for _, tag := range chirp.tags() {
+		_, err := tx.Exec(
+			"INSERT INTO chirp_tags (chirp_id, tag, created_at) VALUES (?, ?, ?)",
+			chirp.ID, tag, unixTime(chirp.CreatedAt),
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (db *SQLiteDB) ListTagChirps(q TagQuery) ([]Chirp, error) {
+	query := "SELECT " + sqliteChirpColumns + " FROM chirps " +
+		"WHERE id IN (SELECT chirp_id FROM chirp_tags WHERE tag = ?) AND deleted_at IS NULL"
+	args := []any{q.Tag}
+	if q.AfterID != 0 {
+		query += " AND (created_at, id) < (?, ?)"
+		args = append(args, unixTime(q.AfterCreatedAt), q.AfterID)
+	}
+	query += " ORDER BY created_at DESC, id DESC"
+	if q.Limit != 0 {
+		query += " LIMIT ?"
+		args = append(args, q.Limit)
+	}
+
+	return db.queryChirps(query, args...)
+}
+
+func (db *SQLiteDB) TrendingTags(since time.Time, limit int) ([]TagCount, error) {
+	query := `
+	SELECT tag, COUNT(*) AS n FROM chirp_tags
+	JOIN chirps ON chirps.id = chirp_tags.chirp_id
+	WHERE chirp_tags.created_at >= ? AND chirps.deleted_at IS NULL
+	GROUP BY tag
+	ORDER BY n DESC, tag ASC`
+	args := []any{unixTime(since)}
+	if limit != 0 {
+		query += " LIMIT ?"
+		args = append(args, limit)
+	}
+
+	rows, err := db.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := []TagCount{}
+	for rows.Next() {
+		count := TagCount{}
+		err := rows.Scan(&count.Tag, &count.Count)
+		if err != nil {
+			return nil, err
+		}
+		counts = append(counts, count)
+	}
+	return counts, rows.Err()
+}
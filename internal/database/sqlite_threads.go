@@ -0,0 +1,49 @@
+package database
+
+func (db *SQLiteDB) GetChirpThread(id int, maxDepth int) (ChirpThread, error) {
+	chirp, err := db.GetChirp(id)
+	if err != nil {
+		return ChirpThread{}, err
+	}
+	if chirp.DeletedAt != nil {
+		return ChirpThread{}, ErrNotExist
+	}
+
+	// 祖先的 ID 总是比回复小，按 ID 排序就是从根开始的顺序
+	ancestors, err := db.queryChirps(`
+	WITH RECURSIVE chain (chirp_id) AS (
+		SELECT in_reply_to FROM chirps WHERE id = ?
+		UNION ALL
+		SELECT chirps.in_reply_to FROM chirps JOIN chain ON chirps.id = chain.chirp_id
+	)
+	SELECT `+sqliteChirpColumns+` FROM chirps JOIN chain ON chirps.id = chain.chirp_id
+	WHERE deleted_at IS NULL
+	ORDER BY id`, id)
+	if err != nil {
+		return ChirpThread{}, err
+	}
+
+	// 回收站中的回复不会被递归，它下面的回复也就不会出现
+	replies, err := db.queryChirps(`
+	WITH RECURSIVE tree (chirp_id, depth) AS (
+		SELECT id, 1 FROM chirps WHERE in_reply_to = ? AND deleted_at IS NULL AND 1 <= ?
+		UNION ALL
+		SELECT chirps.id, tree.depth + 1 FROM chirps JOIN tree ON chirps.in_reply_to = tree.chirp_id
+		WHERE chirps.deleted_at IS NULL AND tree.depth < ?
+	)
+	SELECT `+sqliteChirpColumns+` FROM chirps JOIN tree ON chirps.id = tree.chirp_id
+	ORDER BY id`, id, maxDepth, maxDepth)
+	if err != nil {
+		return ChirpThread{}, err
+	}
+
+	thread := ChirpThread{
+		Ancestors: ancestors,
+		Chirp:     chirp,
+		Replies:   map[int][]Chirp{},
+	}
+	for _, reply := range replies {
+		thread.Replies[reply.InReplyTo] = append(thread.Replies[reply.InReplyTo], reply)
+	}
+	return thread, nil
+}
@@ -0,0 +1,19 @@
+package database
+
+// Timeline 对每个关注的人使用 chirps_author_id_created_at 索引，由 SQLite 合并排序
+func (db *SQLiteDB) Timeline(q TimelineQuery) ([]Chirp, error) {
+	query := "SELECT " + sqliteChirpColumns + " FROM chirps " +
+		"WHERE author_id IN (SELECT followee_id FROM follows WHERE follower_id = ?) AND deleted_at IS NULL"
+	args := []any{q.UserID}
+	if q.AfterID != 0 {
+		query += " AND (created_at, id) < (?, ?)"
+		args = append(args, unixTime(q.AfterCreatedAt), q.AfterID)
+	}
+	query += " ORDER BY created_at DESC, id DESC"
+	if q.Limit != 0 {
+		query += " LIMIT ?"
+		args = append(args, q.Limit)
+	}
+
+	return db.queryChirps(query, args...)
+}
@@ -0,0 +1,174 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/Grey-1011/go-server/internal/entities"
+)
+
+// ScheduleUserDeletion 在一个事务中标记用户并删除用户的 refresh token 和一次性令牌
+func (db *SQLiteDB) ScheduleUserDeletion(id int, deleteAt time.Time) (User, error) {
+	tx, err := db.db.Begin()
+	if err != nil {
+		return User{}, err
+	}
+	defer tx.Rollback()
+
+	user, err := scanUser(tx.QueryRow("SELECT "+sqliteUserColumns+" FROM users WHERE id = ?", id))
+	if err != nil {
+		return User{}, err
+	}
+	if user.DeleteAt != nil {
+		return User{}, ErrAlreadyExists
+	}
+
+	user, err = scanUser(tx.QueryRow(
+		"UPDATE users SET delete_at = ?, updated_at = ? WHERE id = ? RETURNING "+sqliteUserColumns,
+		unixTime(deleteAt), unixTime(time.Now()), id,
+	))
+	if err != nil {
+		return User{}, err
+	}
+	for _, query := range []string{
+		"DELETE FROM refresh_tokens WHERE user_id = ?",
+		"DELETE FROM user_tokens WHERE user_id = ?",
+	} {
+		_, err = tx.Exec(query, id)
+		if err != nil {
+			return User{}, err
+		}
+	}
+	return user, tx.Commit()
+}
+
+func (db *SQLiteDB) CancelUserDeletion(id int) (User, error) {
+	return scanUser(db.db.QueryRow(
+		`UPDATE users SET delete_at = NULL, updated_at = CASE WHEN delete_at IS NULL THEN updated_at ELSE ? END
+		WHERE id = ? RETURNING `+sqliteUserColumns,
+		unixTime(time.Now()), id,
+	))
+}
+
+func (db *SQLiteDB) DueUserDeletions(now time.Time) ([]int, error) {
+	rows, err := db.db.Query("SELECT id FROM users WHERE delete_at <= ? ORDER BY id", unixTime(now))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := []int{}
+	for rows.Next() {
+		var id int
+		err := rows.Scan(&id)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// ==== 永久删除账号 ====
+/*
+PurgeUser 在一个事务中删除用户的数据，语义见 (*DB).PurgeUser。
+chirp 的历史版本、点赞、话题、通知和投票，以及用户的关注、点赞、投票、定时 chirp 和一次性令牌由外键级联删除；
+没有外键的 refresh token 和由用户产生的通知需要显式删除。
+媒体记录在删除用户之前删除（外键级联删除不会返回哈希），然后检查它们的文件是否还被其他记录引用。
+*/
+func (db *SQLiteDB) PurgeUser(id int, now time.Time, anonymize bool) ([]string, error) {
+	tx, err := db.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var deleteAt sql.NullInt64
+	err = tx.QueryRow("SELECT delete_at FROM users WHERE id = ?", id).Scan(&deleteAt)
+	if errors.Is(err, sql.ErrNoRows) || err == nil && (!deleteAt.Valid || deleteAt.Int64 > unixTime(now)) {
+		return nil, ErrNotExist
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	queries := []string{
+		"DELETE FROM notifications WHERE actor_id = ?",
+		"DELETE FROM refresh_tokens WHERE user_id = ?",
+	}
+	if anonymize {
+		queries = append(queries,
+			"DELETE FROM chirps WHERE author_id = ? AND deleted_at IS NOT NULL",
+			"DELETE FROM notifications WHERE user_id = ?",
+			"DELETE FROM follows WHERE follower_id = ?1 OR followee_id = ?1",
+			"DELETE FROM likes WHERE user_id = ?",
+			"DELETE FROM scheduled_chirps WHERE author_id = ?",
+			"DELETE FROM user_tokens WHERE user_id = ?",
+		)
+	} else {
+		queries = append(queries, "DELETE FROM chirps WHERE author_id = ?")
+	}
+	for _, query := range queries {
+		_, err = tx.Exec(query, id)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	mediaQuery := "DELETE FROM media WHERE user_id = ? RETURNING hash"
+	if anonymize {
+		mediaQuery = "DELETE FROM media WHERE user_id = ? AND chirp_id IS NULL RETURNING hash"
+	}
+	rows, err := tx.Query(mediaQuery, id)
+	if err != nil {
+		return nil, err
+	}
+	deleted := map[string]bool{}
+	for rows.Next() {
+		var hash string
+		err = rows.Scan(&hash)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		deleted[hash] = true
+	}
+	rows.Close()
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	if anonymize {
+		_, err = tx.Exec(
+			`UPDATE users SET email = ?, handle = ?, hashed_password = '', is_chirpy_red = FALSE, verified = FALSE,
+			display_name = '', bio = '', avatar = NULL, handle_changed_at = NULL, delete_at = NULL, updated_at = ?
+			WHERE id = ?`,
+			anonymousEmail(id), entities.AnonymousHandle(id), unixTime(time.Now()), id,
+		)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		_, err = tx.Exec("DELETE FROM users WHERE id = ?", id)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	unused := []string{}
+	for hash := range deleted {
+		var referenced bool
+		err = tx.QueryRow("SELECT EXISTS (SELECT 1 FROM media WHERE hash = ?)", hash).Scan(&referenced)
+		if err != nil {
+			return nil, err
+		}
+		if !referenced {
+			unused = append(unused, hash)
+		}
+	}
+	sort.Strings(unused)
+	return unused, tx.Commit()
+}
@@ -0,0 +1,180 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+)
+
+// ExportUser 在一个事务中读取用户的所有记录，保证它们来自同一个快照
+func (db *SQLiteDB) ExportUser(id int) (UserExport, error) {
+	tx, err := db.db.Begin()
+	if err != nil {
+		return UserExport{}, err
+	}
+	defer tx.Rollback()
+
+	user, err := scanUser(tx.QueryRow("SELECT "+sqliteUserColumns+" FROM users WHERE id = ?", id))
+	if err != nil {
+		return UserExport{}, err
+	}
+	export := UserExport{
+		User:            user,
+		Chirps:          []Chirp{},
+		ChirpRevisions:  []ChirpRevision{},
+		Likes:           []Like{},
+		Following:       []Follow{},
+		Followers:       []Follow{},
+		Notifications:   []Notification{},
+		PollVotes:       []PollVote{},
+		ScheduledChirps: []ScheduledChirp{},
+		Media:           []Media{},
+	}
+
+	queries := []struct {
+		query string
+		scan  func(rows *sql.Rows) error
+	}{
+		{
+			"SELECT " + sqliteChirpColumns + " FROM chirps WHERE author_id = ? ORDER BY id",
+			func(rows *sql.Rows) error {
+				chirp, err := scanChirp(rows)
+				export.Chirps = append(export.Chirps, chirp)
+				return err
+			},
+		},
+		{
+			`SELECT r.id, r.chirp_id, r.body, r.moderation, r.created_at, r.replaced_at
+			FROM chirp_revisions r JOIN chirps c ON c.id = r.chirp_id WHERE c.author_id = ? ORDER BY r.id`,
+			func(rows *sql.Rows) error {
+				revision := ChirpRevision{}
+				var moderation string
+				var createdAt, replacedAt int64
+				err := rows.Scan(&revision.ID, &revision.ChirpID, &revision.Body, &moderation, &createdAt, &replacedAt)
+				if err != nil {
+					return err
+				}
+				err = json.Unmarshal([]byte(moderation), &revision.Moderation)
+				if err != nil {
+					return err
+				}
+				revision.CreatedAt = fromUnixTime(createdAt)
+				revision.ReplacedAt = fromUnixTime(replacedAt)
+				export.ChirpRevisions = append(export.ChirpRevisions, revision)
+				return nil
+			},
+		},
+		{
+			"SELECT chirp_id, user_id, created_at FROM likes WHERE user_id = ? ORDER BY created_at, chirp_id",
+			func(rows *sql.Rows) error {
+				like := Like{}
+				var createdAt int64
+				err := rows.Scan(&like.ChirpID, &like.UserID, &createdAt)
+				like.CreatedAt = fromUnixTime(createdAt)
+				export.Likes = append(export.Likes, like)
+				return err
+			},
+		},
+		{
+			"SELECT follower_id, followee_id, created_at FROM follows WHERE follower_id = ? ORDER BY created_at, followee_id",
+			func(rows *sql.Rows) error {
+				follow, err := scanExportFollow(rows)
+				export.Following = append(export.Following, follow)
+				return err
+			},
+		},
+		{
+			"SELECT follower_id, followee_id, created_at FROM follows WHERE followee_id = ? ORDER BY created_at, follower_id",
+			func(rows *sql.Rows) error {
+				follow, err := scanExportFollow(rows)
+				export.Followers = append(export.Followers, follow)
+				return err
+			},
+		},
+		{
+			"SELECT id, user_id, type, actor_id, chirp_id, created_at, read_at FROM notifications WHERE user_id = ? ORDER BY id",
+			func(rows *sql.Rows) error {
+				notification := Notification{}
+				var createdAt int64
+				var readAt sql.NullInt64
+				err := rows.Scan(
+					&notification.ID, &notification.UserID, &notification.Type, &notification.ActorID, &notification.ChirpID,
+					&createdAt, &readAt,
+				)
+				notification.CreatedAt = fromUnixTime(createdAt)
+				if readAt.Valid {
+					t := fromUnixTime(readAt.Int64)
+					notification.ReadAt = &t
+				}
+				export.Notifications = append(export.Notifications, notification)
+				return err
+			},
+		},
+		{
+			"SELECT chirp_id, user_id, option, created_at FROM poll_votes WHERE user_id = ? ORDER BY chirp_id",
+			func(rows *sql.Rows) error {
+				vote := PollVote{}
+				var createdAt int64
+				err := rows.Scan(&vote.ChirpID, &vote.UserID, &vote.Option, &createdAt)
+				vote.CreatedAt = fromUnixTime(createdAt)
+				export.PollVotes = append(export.PollVotes, vote)
+				return err
+			},
+		},
+		{
+			"SELECT " + sqliteScheduledChirpColumns + " FROM scheduled_chirps WHERE author_id = ? ORDER BY id",
+			func(rows *sql.Rows) error {
+				scheduled, err := scanScheduledChirp(rows)
+				export.ScheduledChirps = append(export.ScheduledChirps, scheduled)
+				return err
+			},
+		},
+		{
+			`SELECT id, user_id, hash, content_type, size, width, height, chirp_id, created_at, scheduled_chirp_id, avatar_user_id
+			FROM media WHERE user_id = ? ORDER BY id`,
+			func(rows *sql.Rows) error {
+				media := Media{}
+				var chirpID, scheduledChirpID, avatarUserID sql.NullInt64
+				var createdAt int64
+				err := rows.Scan(
+					&media.ID, &media.UserID, &media.Hash, &media.ContentType, &media.Size, &media.Width, &media.Height,
+					&chirpID, &createdAt, &scheduledChirpID, &avatarUserID,
+				)
+				media.ChirpID = int(chirpID.Int64)
+				media.CreatedAt = fromUnixTime(createdAt)
+				media.ScheduledChirpID = int(scheduledChirpID.Int64)
+				media.AvatarUserID = int(avatarUserID.Int64)
+				export.Media = append(export.Media, media)
+				return err
+			},
+		},
+	}
+
+	for _, q := range queries {
+		rows, err := tx.Query(q.query, id)
+		if err != nil {
+			return UserExport{}, err
+		}
+		for rows.Next() {
+			err = q.scan(rows)
+			if err != nil {
+				rows.Close()
+				return UserExport{}, err
+			}
+		}
+		rows.Close()
+		err = rows.Err()
+		if err != nil {
+			return UserExport{}, err
+		}
+	}
+
+	return export, nil
+}
+
+func scanExportFollow(rows *sql.Rows) (Follow, error) {
+	follow := Follow{}
+	var createdAt int64
+	err := rows.Scan(&follow.FollowerID, &follow.FolloweeID, &createdAt)
+	follow.CreatedAt = fromUnixTime(createdAt)
+	return follow, err
+}
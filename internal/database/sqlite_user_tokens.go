@@ -0,0 +1,117 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// CreateUserToken 在一个事务中删除同一个用户相同用途的旧令牌并插入新的令牌
+func (db *SQLiteDB) CreateUserToken(token UserToken) error {
+	tx, err := db.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec("DELETE FROM user_tokens WHERE user_id = ? AND purpose = ?", token.UserID, token.Purpose)
+	if err != nil {
+		return err
+	}
+	res, err := tx.Exec(
+		`INSERT INTO user_tokens (hash, user_id, purpose, email, expires_at, created_at)
+		SELECT ?, id, ?, ?, ?, ? FROM users WHERE id = ?`,
+		token.Hash, token.Purpose, token.Email, unixTime(token.ExpiresAt), unixTime(time.Now()), token.UserID,
+	)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNotExist
+	}
+	return tx.Commit()
+}
+
+// consumeUserToken 在事务中查找并删除用途为 purpose、在 now 时还没有过期的令牌，不存在时返回 ErrNotExist
+func consumeUserToken(tx *sql.Tx, hash, purpose string, now time.Time) (UserToken, error) {
+	token := UserToken{Hash: hash, Purpose: purpose}
+	var expiresAt, createdAt int64
+	err := tx.QueryRow(
+		"DELETE FROM user_tokens WHERE hash = ? AND purpose = ? AND expires_at > ? RETURNING user_id, email, expires_at, created_at",
+		hash, purpose, unixTime(now),
+	).Scan(&token.UserID, &token.Email, &expiresAt, &createdAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return UserToken{}, ErrNotExist
+	}
+	if err != nil {
+		return UserToken{}, err
+	}
+	token.ExpiresAt = fromUnixTime(expiresAt)
+	token.CreatedAt = fromUnixTime(createdAt)
+	return token, nil
+}
+
+// VerifyEmail 在一个事务中消耗令牌并修改邮箱，邮箱的唯一性由唯一索引保证
+func (db *SQLiteDB) VerifyEmail(hash string, now time.Time) (User, error) {
+	tx, err := db.db.Begin()
+	if err != nil {
+		return User{}, err
+	}
+	defer tx.Rollback()
+
+	token, err := consumeUserToken(tx, hash, TokenPurposeVerifyEmail, now)
+	if err != nil {
+		return User{}, err
+	}
+	user, err := scanUser(tx.QueryRow(
+		"UPDATE users SET email = ?, verified = TRUE, updated_at = ? WHERE id = ? RETURNING "+sqliteUserColumns,
+		token.Email, unixTime(time.Now()), token.UserID,
+	))
+	if isUniqueViolation(err) {
+		return User{}, ErrAlreadyExists
+	}
+	if err != nil {
+		return User{}, err
+	}
+	return user, tx.Commit()
+}
+
+// ResetPassword 在一个事务中消耗令牌、修改密码并撤销用户所有的 refresh token
+func (db *SQLiteDB) ResetPassword(hash, hashedPassword string, now time.Time) (User, error) {
+	tx, err := db.db.Begin()
+	if err != nil {
+		return User{}, err
+	}
+	defer tx.Rollback()
+
+	token, err := consumeUserToken(tx, hash, TokenPurposeResetPassword, now)
+	if err != nil {
+		return User{}, err
+	}
+	// 发送令牌之后修改了邮箱时没有匹配的行，scanUser 返回 ErrNotExist
+	user, err := scanUser(tx.QueryRow(
+		"UPDATE users SET hashed_password = ?, verified = TRUE, updated_at = ? WHERE id = ? AND email = ? RETURNING "+sqliteUserColumns,
+		hashedPassword, unixTime(time.Now()), token.UserID, token.Email,
+	))
+	if err != nil {
+		return User{}, err
+	}
+	_, err = tx.Exec("DELETE FROM refresh_tokens WHERE user_id = ?", user.ID)
+	if err != nil {
+		return User{}, err
+	}
+	return user, tx.Commit()
+}
+
+func (db *SQLiteDB) PurgeUserTokens(expiredBefore time.Time) (int, error) {
+	res, err := db.db.Exec("DELETE FROM user_tokens WHERE expires_at < ?", unixTime(expiredBefore))
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	return int(n), err
+}
@@ -0,0 +1,256 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+)
+
+const sqliteUserColumns = "id, email, handle, hashed_password, is_chirpy_red, created_at, updated_at, display_name, bio, avatar, handle_changed_at, verified, delete_at"
+
+// scanUser 把一行 sqliteUserColumns 扫描为 User
+func scanUser(row interface{ Scan(...any) error }) (User, error) {
+	user := User{}
+	var createdAt, updatedAt int64
+	var avatar sql.NullString
+	var handleChangedAt, deleteAt sql.NullInt64
+	err := row.Scan(
+		&user.ID, &user.Email, &user.Handle, &user.HashedPassword, &user.IsChirpyRed, &createdAt, &updatedAt,
+		&user.DisplayName, &user.Bio, &avatar, &handleChangedAt, &user.Verified, &deleteAt,
+	)
+	if errors.Is(err, sql.ErrNoRows) {
+		return User{}, ErrNotExist
+	}
+	if err != nil {
+		return User{}, err
+	}
+	user.CreatedAt = fromUnixTime(createdAt)
+	user.UpdatedAt = fromUnixTime(updatedAt)
+	if avatar.Valid {
+		err = json.Unmarshal([]byte(avatar.String), &user.Avatar)
+		if err != nil {
+			return User{}, err
+		}
+	}
+	if handleChangedAt.Valid {
+		t := fromUnixTime(handleChangedAt.Int64)
+		user.HandleChangedAt = &t
+	}
+	if deleteAt.Valid {
+		t := fromUnixTime(deleteAt.Int64)
+		user.DeleteAt = &t
+	}
+	return user, nil
+}
+
+// CreateUser 在一个事务中分配 handle 并插入用户
+func (db *SQLiteDB) CreateUser(email string, hashedPassword string) (User, error) {
+	tx, err := db.db.Begin()
+	if err != nil {
+		return User{}, err
+	}
+	defer tx.Rollback()
+
+	handle, err := availableHandle(email, func(handle string) (bool, error) {
+		var taken bool
+		err := tx.QueryRow("SELECT EXISTS (SELECT 1 FROM users WHERE handle = ? COLLATE NOCASE)", handle).Scan(&taken)
+		return taken, err
+	})
+	if err != nil {
+		return User{}, err
+	}
+
+	now := time.Now().UTC()
+	res, err := tx.Exec(
+		"INSERT INTO users (email, handle, hashed_password, created_at, updated_at) VALUES (?, ?, ?, ?, ?)",
+		email, handle, hashedPassword, unixTime(now), unixTime(now),
+	)
+	if isUniqueViolation(err) {
+		return User{}, ErrAlreadyExists
+	}
+	if err != nil {
+		return User{}, err
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return User{}, err
+	}
+
+	return User{
+		ID:             int(id),
+		Email:          email,
+		Handle:         handle,
+		HashedPassword: hashedPassword,
+		CreatedAt:      now,
+		UpdatedAt:      now,
+	}, tx.Commit()
+}
+
+func (db *SQLiteDB) GetUser(id int) (User, error) {
+	return scanUser(db.db.QueryRow(
+		"SELECT "+sqliteUserColumns+" FROM users WHERE id = ?", id,
+	))
+}
+
+func (db *SQLiteDB) GetUserStats(id int) (UserStats, error) {
+	stats := UserStats{}
+	err := db.db.QueryRow(
+		`SELECT
+			(SELECT COUNT(*) FROM chirps WHERE author_id = u.id AND deleted_at IS NULL),
+			(SELECT COUNT(*) FROM follows WHERE followee_id = u.id),
+			(SELECT COUNT(*) FROM follows WHERE follower_id = u.id)
+		FROM users u WHERE u.id = ?`,
+		id,
+	).Scan(&stats.ChirpCount, &stats.FollowerCount, &stats.FollowingCount)
+	if errors.Is(err, sql.ErrNoRows) {
+		return UserStats{}, ErrNotExist
+	}
+	if err != nil {
+		return UserStats{}, err
+	}
+	return stats, nil
+}
+
+func (db *SQLiteDB) GetUserByEmail(email string) (User, error) {
+	return scanUser(db.db.QueryRow(
+		"SELECT "+sqliteUserColumns+" FROM users WHERE email = ?", email,
+	))
+}
+
+func (db *SQLiteDB) GetUsersByHandle(handles []string) (map[string]User, error) {
+	users := map[string]User{}
+	if len(handles) == 0 {
+		return users, nil
+	}
+
+	args := []any{}
+	for _, handle := range handles {
+		args = append(args, handle)
+	}
+	rows, err := db.db.Query(
+		"SELECT "+sqliteUserColumns+" FROM users WHERE handle COLLATE NOCASE IN (?"+strings.Repeat(", ?", len(handles)-1)+")",
+		args...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			return nil, err
+		}
+		users[strings.ToLower(user.Handle)] = user
+	}
+	return users, rows.Err()
+}
+
+// UpdateUser 用 COALESCE 保留 update 中为 nil 的字段，邮箱的唯一性由唯一索引保证
+func (db *SQLiteDB) UpdateUser(id int, update UserUpdate) (User, error) {
+	user, err := scanUser(db.db.QueryRow(
+		`UPDATE users SET email = COALESCE(?, email), hashed_password = COALESCE(?, hashed_password), updated_at = ?
+		WHERE id = ? RETURNING `+sqliteUserColumns,
+		update.Email, update.HashedPassword, unixTime(time.Now()), id,
+	))
+	if isUniqueViolation(err) {
+		return User{}, ErrAlreadyExists
+	}
+	return user, err
+}
+
+func (db *SQLiteDB) UpgradeChirpyRed(id int) (User, error) {
+	return scanUser(db.db.QueryRow(
+		"UPDATE users SET is_chirpy_red = TRUE, updated_at = ? WHERE id = ? RETURNING "+sqliteUserColumns,
+		unixTime(time.Now()), id,
+	))
+}
+
+// ChangeHandle 在一个事务中检查修改的间隔并修改 handle，handle 的唯一性由唯一索引保证
+func (db *SQLiteDB) ChangeHandle(id int, handle string, changedBefore time.Time) (User, error) {
+	tx, err := db.db.Begin()
+	if err != nil {
+		return User{}, err
+	}
+	defer tx.Rollback()
+
+	user, err := scanUser(tx.QueryRow("SELECT "+sqliteUserColumns+" FROM users WHERE id = ?", id))
+	if err != nil {
+		return User{}, err
+	}
+	if user.Handle == handle {
+		return user, nil
+	}
+	if user.HandleChangedAt != nil && user.HandleChangedAt.After(changedBefore) {
+		return User{}, ErrHandleChangeTooSoon
+	}
+
+	now := time.Now().UTC()
+	user, err = scanUser(tx.QueryRow(
+		"UPDATE users SET handle = ?, handle_changed_at = ?, updated_at = ? WHERE id = ? RETURNING "+sqliteUserColumns,
+		handle, unixTime(now), unixTime(now), id,
+	))
+	if isUniqueViolation(err) {
+		return User{}, ErrAlreadyExists
+	}
+	if err != nil {
+		return User{}, err
+	}
+	return user, tx.Commit()
+}
+
+// UpdateProfile 在一个事务中更换头像（释放旧的、预留新的）并保存资料，用 COALESCE 保留 profile 中为 nil 的字段
+func (db *SQLiteDB) UpdateProfile(id int, profile UserProfile) (User, error) {
+	tx, err := db.db.Begin()
+	if err != nil {
+		return User{}, err
+	}
+	defer tx.Rollback()
+
+	user, err := scanUser(tx.QueryRow("SELECT "+sqliteUserColumns+" FROM users WHERE id = ?", id))
+	if err != nil {
+		return User{}, err
+	}
+
+	avatarChanged := profile.AvatarID != nil && *profile.AvatarID != user.avatarID()
+	var avatar *ChirpMedia
+	if avatarChanged {
+		_, err = tx.Exec("UPDATE media SET avatar_user_id = NULL WHERE avatar_user_id = ?", id)
+		if err != nil {
+			return User{}, err
+		}
+		if *profile.AvatarID != 0 {
+			media, err := availableMedia(tx, *profile.AvatarID, id)
+			if err != nil {
+				return User{}, err
+			}
+			_, err = tx.Exec("UPDATE media SET avatar_user_id = ? WHERE id = ?", id, media.ID)
+			if err != nil {
+				return User{}, err
+			}
+			avatar = &media
+		}
+	}
+	var avatarJSON any
+	if avatar != nil {
+		dat, err := json.Marshal(avatar)
+		if err != nil {
+			return User{}, err
+		}
+		avatarJSON = string(dat)
+	}
+
+	user, err = scanUser(tx.QueryRow(
+		`UPDATE users SET display_name = COALESCE(?, display_name), bio = COALESCE(?, bio),
+		avatar = CASE WHEN ? THEN ? ELSE avatar END, updated_at = ?
+		WHERE id = ? RETURNING `+sqliteUserColumns,
+		profile.DisplayName, profile.Bio, avatarChanged, avatarJSON, unixTime(time.Now()), id,
+	))
+	if err != nil {
+		return User{}, err
+	}
+	return user, tx.Commit()
+}
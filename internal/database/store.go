@@ -0,0 +1,115 @@
+package database
+
+import (
+	"fmt"
+	"time"
+)
+
+// Store 是 chirpy 的持久化接口。
+// 处理程序只依赖这个接口，JSON 文件（DB）和 SQLite（SQLiteDB）两种后端都实现了它。
+type Store interface {
+	CreateChirp(chirp Chirp) (Chirp, error)
+	ListChirps(q ChirpQuery) ([]Chirp, error)
+	SearchChirps(q SearchQuery) ([]Chirp, error)
+	GetChirp(id int) (Chirp, error)
+	UpdateChirp(id int, body string, moderation ChirpModeration, entities []ChirpEntity) (Chirp, error)
+	GetChirpRevisions(chirpID int) ([]ChirpRevision, error)
+	GetChirpThread(id int, maxDepth int) (ChirpThread, error)
+	DeleteChirp(id int, deletedBy int) error
+	RestoreChirp(id int) (Chirp, error)
+	ListDeletedChirps(authorID int) ([]Chirp, error)
+	PurgeChirps(deletedBefore time.Time) (int, error)
+
+	ScheduleChirp(scheduled ScheduledChirp) (ScheduledChirp, error)
+	GetScheduledChirp(id int) (ScheduledChirp, error)
+	ListScheduledChirps(authorID int) ([]ScheduledChirp, error)
+	CancelScheduledChirp(id int) error
+	DueScheduledChirps(now time.Time) ([]ScheduledChirp, error)
+	PublishScheduledChirp(id int, entities []ChirpEntity) (Chirp, error)
+	FailScheduledChirp(id int, reason string) error
+
+	CreateMedia(media Media) (Media, error)
+	PurgeMedia(unattachedBefore time.Time) ([]string, error)
+
+	LikeChirp(chirpID, userID int) (Chirp, error)
+	UnlikeChirp(chirpID, userID int) (Chirp, error)
+	LikedByUser(userID int, chirpIDs []int) (map[int]bool, error)
+	ListLikedChirps(userID int) ([]Chirp, error)
+
+	VotePoll(chirpID, userID, option int) (Chirp, error)
+	PollVotesByUser(userID int, chirpIDs []int) (map[int]int, error)
+
+	Rechirp(chirpID, userID int) (rechirp Chirp, created bool, err error)
+	Unrechirp(chirpID, userID int) error
+	GetChirpsByID(ids []int) (map[int]Chirp, error)
+
+	ListTagChirps(q TagQuery) ([]Chirp, error)
+	TrendingTags(since time.Time, limit int) ([]TagCount, error)
+
+	ListNotifications(q NotificationQuery) ([]Notification, error)
+	MarkNotificationsRead(userID int) error
+
+	Follow(followerID, followeeID int) error
+	Unfollow(followerID, followeeID int) error
+	ListFollowers(userID int) ([]Follow, error)
+	ListFollowing(userID int) ([]Follow, error)
+	Timeline(q TimelineQuery) ([]Chirp, error)
+
+	CreateUser(email string, hashedPassword string) (User, error)
+	GetUser(id int) (User, error)
+	GetUserStats(id int) (UserStats, error)
+	GetUserByEmail(email string) (User, error)
+	GetUsersByHandle(handles []string) (map[string]User, error)
+	UpdateUser(id int, update UserUpdate) (User, error)
+	ChangeHandle(id int, handle string, changedBefore time.Time) (User, error)
+	UpdateProfile(id int, profile UserProfile) (User, error)
+	UpgradeChirpyRed(id int) (User, error)
+	ScheduleUserDeletion(id int, deleteAt time.Time) (User, error)
+	CancelUserDeletion(id int) (User, error)
+	DueUserDeletions(now time.Time) ([]int, error)
+	PurgeUser(id int, now time.Time, anonymize bool) ([]string, error)
+	ExportUser(id int) (UserExport, error)
+
+	SaveRefreshToken(userID int, token string) error
+	RevokeRefreshToken(token string) error
+	RevokeUserRefreshTokens(userID int, except string) error
+	UserForRefreshToken(token string) (User, error)
+
+	CreateUserToken(token UserToken) error
+	VerifyEmail(hash string, now time.Time) (User, error)
+	ResetPassword(hash, hashedPassword string, now time.Time) (User, error)
+	PurgeUserTokens(expiredBefore time.Time) (int, error)
+
+	ResetDB() error
+	Close() error
+}
+
+// 支持的存储后端
+const (
+	DriverJSON   = "json"
+	DriverSQLite = "sqlite"
+)
+
+// ==== 打开存储 ====
+/*
+Open 根据 driver 选择存储后端：
+- "json"（默认）：整个数据库保存在一个 JSON 文件中。
+- "sqlite"：使用纯 Go 的 SQLite 驱动，启动时自动执行 schema 迁移。
+*/
+func Open(driver, path string) (Store, error) {
+	switch driver {
+	case "", DriverJSON:
+		db, err := NewDB(path)
+		if err != nil {
+			return nil, err
+		}
+		return db, nil
+	case DriverSQLite:
+		db, err := NewSQLiteDB(path)
+		if err != nil {
+			return nil, err
+		}
+		return db, nil
+	}
+	return nil, fmt.Errorf("unknown database driver: %q", driver)
+}
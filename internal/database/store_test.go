@@ -0,0 +1,212 @@
+package database
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+// backends 是 Store 的所有实现，同一套测试在每个后端上运行，保证两者可以互相替换
+var backends = []struct {
+	name string
+	open func(dir string) (Store, error)
+}{
+	{"json", func(dir string) (Store, error) { return NewDB(filepath.Join(dir, "db.json")) }},
+	{"sqlite", func(dir string) (Store, error) { return NewSQLiteDB(filepath.Join(dir, "db.sqlite")) }},
+}
+
+// storeHarness 是一个测试用的存储，reopen 关闭后重新打开同一个数据库，用来检查数据是否持久化
+type storeHarness struct {
+	t    *testing.T
+	dir  string
+	open func(dir string) (Store, error)
+	Store
+}
+
+func (h *storeHarness) reopen() {
+	h.t.Helper()
+	err := h.Store.Close()
+	if err != nil {
+		h.t.Fatalf("Close: %v", err)
+	}
+	h.Store, err = h.open(h.dir)
+	if err != nil {
+		h.t.Fatalf("reopen: %v", err)
+	}
+}
+
+func (h *storeHarness) createUser(email string) User {
+	h.t.Helper()
+	user, err := h.CreateUser(email, "hash")
+	if err != nil {
+		h.t.Fatalf("CreateUser(%q): %v", email, err)
+	}
+	return user
+}
+
+func (h *storeHarness) createChirp(authorID int, body string) Chirp {
+	h.t.Helper()
+	chirp, err := h.CreateChirp(Chirp{AuthorID: authorID, Body: body})
+	if err != nil {
+		h.t.Fatalf("CreateChirp(%d, %q): %v", authorID, body, err)
+	}
+	return chirp
+}
+
+func chirpIDs(chirps []Chirp) []int {
+	ids := []int{}
+	for _, chirp := range chirps {
+		ids = append(ids, chirp.ID)
+	}
+	return ids
+}
+
+func equalIDs(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// testStore 在每个后端上运行 run，每个后端使用一个新的数据库
+func testStore(t *testing.T, run func(t *testing.T, st *storeHarness)) {
+	t.Helper()
+	for _, backend := range backends {
+		t.Run(backend.name, func(t *testing.T) {
+			h := &storeHarness{t: t, dir: t.TempDir(), open: backend.open}
+			var err error
+			h.Store, err = backend.open(h.dir)
+			if err != nil {
+				t.Fatalf("open: %v", err)
+			}
+			defer func() { h.Store.Close() }()
+
+			run(t, h)
+		})
+	}
+}
+
+func TestStoreChirps(t *testing.T) {
+	testStore(t, func(t *testing.T, st *storeHarness) {
+		st.createUser("walt@breakingbad.com")
+		chirp := st.createChirp(1, "Say my name")
+		if chirp.ID != 1 || chirp.AuthorID != 1 || chirp.Body != "Say my name" {
+			t.Fatalf("CreateChirp = %+v", chirp)
+		}
+
+		st.reopen()
+		got, err := st.GetChirp(chirp.ID)
+		if err != nil || got.Body != "Say my name" || got.AuthorID != 1 {
+			t.Fatalf("GetChirp = %+v, %v", got, err)
+		}
+		_, err = st.GetChirp(99)
+		if !errors.Is(err, ErrNotExist) {
+			t.Errorf("GetChirp(99) error = %v, want ErrNotExist", err)
+		}
+	})
+}
+
+func TestStoreUsers(t *testing.T) {
+	testStore(t, func(t *testing.T, st *storeHarness) {
+		walt := st.createUser("walt@breakingbad.com")
+		if walt.ID != 1 || walt.Email != "walt@breakingbad.com" || walt.IsChirpyRed {
+			t.Fatalf("CreateUser = %+v", walt)
+		}
+		_, err := st.CreateUser("walt@breakingbad.com", "hash")
+		if !errors.Is(err, ErrAlreadyExists) {
+			t.Errorf("CreateUser(duplicate) error = %v, want ErrAlreadyExists", err)
+		}
+		jesse := st.createUser("jesse@breakingbad.com")
+
+		email := "walt@breakingbad.com"
+		_, err = st.UpdateUser(jesse.ID, UserUpdate{Email: &email})
+		if !errors.Is(err, ErrAlreadyExists) {
+			t.Errorf("UpdateUser(taken email) error = %v, want ErrAlreadyExists", err)
+		}
+		email = "pinkman@breakingbad.com"
+		hashedPassword := "new hash"
+		updated, err := st.UpdateUser(jesse.ID, UserUpdate{Email: &email, HashedPassword: &hashedPassword})
+		if err != nil || updated.Email != email || updated.HashedPassword != hashedPassword {
+			t.Fatalf("UpdateUser = %+v, %v", updated, err)
+		}
+
+		upgraded, err := st.UpgradeChirpyRed(walt.ID)
+		if err != nil || !upgraded.IsChirpyRed {
+			t.Errorf("UpgradeChirpyRed = %+v, %v", upgraded, err)
+		}
+		_, err = st.UpgradeChirpyRed(99)
+		if !errors.Is(err, ErrNotExist) {
+			t.Errorf("UpgradeChirpyRed(99) error = %v, want ErrNotExist", err)
+		}
+
+		st.reopen()
+		got, err := st.GetUserByEmail("pinkman@breakingbad.com")
+		if err != nil || got.ID != jesse.ID {
+			t.Errorf("GetUserByEmail(new email) = %+v, %v", got, err)
+		}
+		_, err = st.GetUserByEmail("jesse@breakingbad.com")
+		if !errors.Is(err, ErrNotExist) {
+			t.Errorf("GetUserByEmail(old email) error = %v, want ErrNotExist", err)
+		}
+		_, err = st.GetUser(99)
+		if !errors.Is(err, ErrNotExist) {
+			t.Errorf("GetUser(99) error = %v, want ErrNotExist", err)
+		}
+		got, _ = st.GetUser(walt.ID)
+		if !got.IsChirpyRed {
+			t.Errorf("GetUser after reopen = %+v, want Chirpy Red", got)
+		}
+	})
+}
+
+func TestStoreRefreshTokens(t *testing.T) {
+	testStore(t, func(t *testing.T, st *storeHarness) {
+		st.createUser("walt@breakingbad.com")
+		for _, token := range []string{"a", "b"} {
+			err := st.SaveRefreshToken(1, token)
+			if err != nil {
+				t.Fatalf("SaveRefreshToken: %v", err)
+			}
+		}
+
+		st.reopen()
+		user, err := st.UserForRefreshToken("a")
+		if err != nil || user.ID != 1 {
+			t.Fatalf("UserForRefreshToken = %+v, %v", user, err)
+		}
+		err = st.RevokeRefreshToken("a")
+		if err != nil {
+			t.Fatalf("RevokeRefreshToken: %v", err)
+		}
+		_, err = st.UserForRefreshToken("a")
+		if !errors.Is(err, ErrNotExist) {
+			t.Errorf("UserForRefreshToken(revoked) error = %v, want ErrNotExist", err)
+		}
+		if _, err := st.UserForRefreshToken("b"); err != nil {
+			t.Errorf("UserForRefreshToken(b) error = %v", err)
+		}
+	})
+}
+
+func TestStoreResetDB(t *testing.T) {
+	testStore(t, func(t *testing.T, st *storeHarness) {
+		st.createUser("walt@breakingbad.com")
+		st.createChirp(1, "one")
+
+		err := st.ResetDB()
+		if err != nil {
+			t.Fatalf("ResetDB: %v", err)
+		}
+		if chirps, _ := st.ListChirps(ChirpQuery{}); len(chirps) != 0 {
+			t.Errorf("ListChirps after reset = %v", chirpIDs(chirps))
+		}
+		if _, err := st.GetUserByEmail("walt@breakingbad.com"); !errors.Is(err, ErrNotExist) {
+			t.Errorf("GetUserByEmail after reset error = %v, want ErrNotExist", err)
+		}
+	})
+}
@@ -0,0 +1,127 @@
+package database
+
+import (
+	"sort"
+	"time"
+)
+
+// 实体的类型，和 entities 包一致
+const (
+	EntityHashtag = "hashtag"
+	EntityMention = "mention"
+)
+
+// ChirpEntity 是 chirp 正文中的一个话题或提及，在创建和编辑 chirp 时提取
+type ChirpEntity struct {
+	Type string `json:"type"` // EntityHashtag 或 EntityMention
+	Text string `json:"text"` // 话题的小写形式，或提及的 handle（不包括 # 和 @）
+	// 在正文中的字节位置 [Start, End) 和字符位置 [RuneStart, RuneEnd)，包括 # 和 @
+	Start     int `json:"start"`
+	End       int `json:"end"`
+	RuneStart int `json:"rune_start"`
+	RuneEnd   int `json:"rune_end"`
+	UserID    int `json:"user_id,omitempty"` // 被提及的用户
+}
+
+// tags 返回 chirp 中不重复的话题
+func (chirp Chirp) tags() []string {
+	seen := map[string]bool{}
+	tags := []string{}
+	for _, entity := range chirp.Entities {
+		if entity.Type == EntityHashtag && !seen[entity.Text] {
+			seen[entity.Text] = true
+			tags = append(tags, entity.Text)
+		}
+	}
+	return tags
+}
+
+// mentionedUsers 返回 chirp 中提及的不重复的用户，不包括作者自己
+func (chirp Chirp) mentionedUsers() []int {
+	seen := map[int]bool{chirp.AuthorID: true}
+	users := []int{}
+	for _, entity := range chirp.Entities {
+		if entity.Type == EntityMention && !seen[entity.UserID] {
+			seen[entity.UserID] = true
+			users = append(users, entity.UserID)
+		}
+	}
+	return users
+}
+
+// TagQuery 描述一次话题 chirp 查询，结果按 created_at 降序排列
+type TagQuery struct {
+	Tag string // 规范化的话题
+	// 只返回排序上位于 (AfterCreatedAt, AfterID) 之后的 chirp（用于分页），AfterID 为 0 表示从头开始
+	AfterID        int
+	AfterCreatedAt time.Time
+	Limit          int // 最多返回的数量，0 表示不限制
+}
+
+// TagCount 是一个话题以及使用它的 chirp 数
+type TagCount struct {
+	Tag   string
+	Count int
+}
+
+func (db *DB) ListTagChirps(q TagQuery) ([]Chirp, error) {
+	chirps := []Chirp{}
+	err := db.View(func(dbStructure *DBStructure) error {
+		ix, ok := dbStructure.idx.tags[q.Tag]
+		if !ok {
+			return nil
+		}
+
+		var after *timeKey
+		if q.AfterID != 0 {
+			after = &timeKey{at: q.AfterCreatedAt, id: q.AfterID}
+		}
+		ix.scan(time.Time{}, time.Time{}, after, true, func(id int) bool {
+			chirps = append(chirps, dbStructure.withCounts(dbStructure.Chirps[id]))
+			return q.Limit == 0 || len(chirps) < q.Limit
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return chirps, nil
+}
+
+// ==== 热门话题 ====
+/*
+TrendingTags 统计 since 之后创建的、不在回收站中的 chirp 使用的话题，
+按使用次数降序返回前 limit 个，次数相同时按话题排序。
+每个话题的 chirp 索引按创建时间排序，二分查找 since 的位置就能得到次数。
+*/
+func (db *DB) TrendingTags(since time.Time, limit int) ([]TagCount, error) {
+	counts := []TagCount{}
+	err := db.View(func(dbStructure *DBStructure) error {
+		for tag, ix := range dbStructure.idx.tags {
+			n := len(*ix) - ix.search(timeKey{at: since})
+			if n > 0 {
+				counts = append(counts, TagCount{Tag: tag, Count: n})
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sortTagCounts(counts)
+	if limit != 0 && len(counts) > limit {
+		counts = counts[:limit]
+	}
+	return counts, nil
+}
+
+func sortTagCounts(counts []TagCount) {
+	sort.Slice(counts, func(i, j int) bool {
+		if counts[i].Count != counts[j].Count {
+			return counts[i].Count > counts[j].Count
+		}
+		return counts[i].Tag < counts[j].Tag
+	})
+}
@@ -0,0 +1,69 @@
+package database
+
+// ==== 对话 ====
+/*
+chirp 通过 InReplyTo 指向被回复的 chirp，形成一棵对话树。
+被回复的 chirp 总是先创建，所以它的 ID 总是比回复小。
+回收站中的 chirp 和它下面的回复都不会出现在对话中。
+*/
+
+// ChirpThread 是一个 chirp 所在的对话
+type ChirpThread struct {
+	Ancestors []Chirp         // 从对话的根到被回复的 chirp
+	Chirp     Chirp           // 请求的 chirp
+	Replies   map[int][]Chirp // chirp ID -> 直接回复（按 ID 升序），只包含 Chirp 之下 maxDepth 层以内的回复
+}
+
+// GetChirpThread 返回 chirp 的祖先和 maxDepth 层以内的回复。
+// chirp 不存在或在回收站中时返回 ErrNotExist。
+func (db *DB) GetChirpThread(id int, maxDepth int) (ChirpThread, error) {
+	thread := ChirpThread{
+		Ancestors: []Chirp{},
+		Replies:   map[int][]Chirp{},
+	}
+	err := db.View(func(dbStructure *DBStructure) error {
+		chirp, ok := dbStructure.Chirps[id]
+		if !ok || chirp.DeletedAt != nil {
+			return ErrNotExist
+		}
+		thread.Chirp = dbStructure.withCounts(chirp)
+
+		// 沿着 InReplyTo 向上，被回复的 chirp 可能已经被永久删除
+		for parentID := chirp.InReplyTo; parentID != 0; {
+			parent, ok := dbStructure.Chirps[parentID]
+			if !ok {
+				break
+			}
+			if parent.DeletedAt == nil {
+				thread.Ancestors = append(thread.Ancestors, dbStructure.withCounts(parent))
+			}
+			parentID = parent.InReplyTo
+		}
+		for i, j := 0, len(thread.Ancestors)-1; i < j; i, j = i+1, j-1 {
+			thread.Ancestors[i], thread.Ancestors[j] = thread.Ancestors[j], thread.Ancestors[i]
+		}
+
+		// 按层遍历回复，索引中只有不在回收站中的回复
+		level := []int{id}
+		for depth := 0; depth < maxDepth && len(level) > 0; depth++ {
+			next := []int{}
+			for _, parentID := range level {
+				replies, ok := dbStructure.idx.replies[parentID]
+				if !ok {
+					continue
+				}
+				for _, replyID := range *replies {
+					thread.Replies[parentID] = append(thread.Replies[parentID], dbStructure.withCounts(dbStructure.Chirps[replyID]))
+					next = append(next, replyID)
+				}
+			}
+			level = next
+		}
+		return nil
+	})
+	if err != nil {
+		return ChirpThread{}, err
+	}
+
+	return thread, nil
+}
@@ -0,0 +1,92 @@
+package database
+
+import (
+	"container/heap"
+	"time"
+)
+
+// ==== 首页时间线 ====
+/*
+时间线采用读时合并（fan-out on read）：不为每个用户保存时间线，
+读取时对每个关注的人的 chirp 索引（chirpsByAuthorAndTime，按 (created_at, id) 排序）做多路归并。
+- 发 chirp 只更新作者自己的索引，代价和粉丝数无关。
+- 读一页的代价是 O(F log F + limit log F)，F 是关注的人数，和 chirp 总数无关。
+写时分发（fan-out on write）需要在每次发 chirp 时写入所有粉丝的时间线，
+对 JSON 后端来说这些写入都要进入日志，而且删除、恢复、取消关注时还要修正已经分发的数据。
+两种方式的对比见 timeline_bench_test.go 中的 BenchmarkTimeline。
+*/
+
+// TimelineQuery 描述一次时间线查询，结果按 created_at 降序排列
+type TimelineQuery struct {
+	UserID int // 读取该用户关注的人的 chirp
+	// 只返回排序上位于 (AfterCreatedAt, AfterID) 之后的 chirp（用于分页），AfterID 为 0 表示从头开始
+	AfterID        int
+	AfterCreatedAt time.Time
+	Limit          int // 最多返回的数量，0 表示不限制
+}
+
+// timelineCursor 是一个作者的 chirp 索引上的读取位置，从 pos 开始向前（时间更早）读取
+type timelineCursor struct {
+	ix  timeIndex
+	pos int
+}
+
+// timelineHeap 是按当前位置的 timeKey 降序排列的堆
+type timelineHeap []timelineCursor
+
+func (h timelineHeap) Len() int { return len(h) }
+func (h timelineHeap) Less(i, j int) bool {
+	return h[j].ix[h[j].pos].less(h[i].ix[h[i].pos])
+}
+func (h timelineHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+func (h *timelineHeap) Push(x any)   { *h = append(*h, x.(timelineCursor)) }
+func (h *timelineHeap) Pop() any {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
+
+func (db *DB) Timeline(q TimelineQuery) ([]Chirp, error) {
+	chirps := []Chirp{}
+	err := db.View(func(dbStructure *DBStructure) error {
+		following, ok := dbStructure.idx.following[q.UserID]
+		if !ok {
+			return nil
+		}
+
+		h := timelineHeap{}
+		for _, followee := range *following {
+			byAuthor, ok := dbStructure.idx.chirpsByAuthorAndTime[followee.id]
+			if !ok {
+				continue
+			}
+			ix := *byAuthor
+			pos := len(ix) - 1
+			if q.AfterID != 0 {
+				pos = ix.search(timeKey{at: q.AfterCreatedAt, id: q.AfterID}) - 1
+			}
+			if pos >= 0 {
+				h = append(h, timelineCursor{ix: ix, pos: pos})
+			}
+		}
+		heap.Init(&h)
+
+		for h.Len() > 0 && (q.Limit == 0 || len(chirps) < q.Limit) {
+			c := &h[0]
+			chirps = append(chirps, dbStructure.withCounts(dbStructure.Chirps[c.ix[c.pos].id]))
+			c.pos--
+			if c.pos < 0 {
+				heap.Pop(&h)
+			} else {
+				heap.Fix(&h, 0)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return chirps, nil
+}
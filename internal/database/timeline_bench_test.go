@@ -0,0 +1,180 @@
+package database
+
+import (
+	"fmt"
+	"math/rand"
+	"path/filepath"
+	"testing"
+)
+
+// ==== 时间线基准测试 ====
+/*
+比较首页时间线的两种实现方式在同样的关注关系和 chirp 数量下的代价：
+- 读时合并（fan-out on read）：Timeline 的实现，发 chirp 只写 chirp 本身，读时合并关注的人的索引。
+- 写时分发（fan-out on write）：发 chirp 时为每个粉丝写一条时间线记录，读时直接取自己的记录。
+  这里用通知表充当每个用户的时间线（记录同样是 (用户, chirp, 时间)，同样写入日志、按用户建立索引），
+  所以两种方式付出的持久化代价是可比的。
+关注关系服从 Zipf 分布，用户 1 的粉丝最多；post 由粉丝最多的用户发 chirp，page 读取随机用户的第一页。
+
+	go test ./internal/database -run '^$' -bench Timeline
+*/
+
+const (
+	benchUsers   = 300
+	benchFollows = 30   // 每个用户关注的人数
+	benchChirps  = 3000 // 预先发布的 chirp 数
+	benchLimit   = 20   // 时间线每页的数量
+)
+
+// timelineStrategy 是时间线的一种实现方式
+type timelineStrategy struct {
+	name   string
+	fanOut bool // 发 chirp 时为每个粉丝写一条记录
+	post   func(db *DB, chirp Chirp) error
+	page   func(db *DB, userID int) ([]Chirp, error)
+}
+
+var timelineStrategies = []timelineStrategy{
+	{
+		name: "fan-out-on-read",
+		post: func(db *DB, chirp Chirp) error {
+			_, err := db.CreateChirp(chirp)
+			return err
+		},
+		page: func(db *DB, userID int) ([]Chirp, error) {
+			return db.Timeline(TimelineQuery{UserID: userID, Limit: benchLimit})
+		},
+	},
+	{
+		name:   "fan-out-on-write",
+		fanOut: true,
+		post:   fanOutPost,
+		page:   fanOutPage,
+	},
+}
+
+// fanOutPost 发布 chirp，然后把它写入每个粉丝的时间线
+func fanOutPost(db *DB, chirp Chirp) error {
+	chirp, err := db.CreateChirp(chirp)
+	if err != nil {
+		return err
+	}
+	return db.Update(func(dbStructure *DBStructure) error {
+		followers, ok := dbStructure.idx.followers[chirp.AuthorID]
+		if !ok {
+			return nil
+		}
+		for _, follower := range *followers {
+			id := dbStructure.nextID(notificationsTable.name)
+			notificationsTable.put(dbStructure, id, Notification{
+				ID:        id,
+				UserID:    follower.id,
+				ActorID:   chirp.AuthorID,
+				ChirpID:   chirp.ID,
+				CreatedAt: chirp.CreatedAt,
+			})
+		}
+		return nil
+	})
+}
+
+// fanOutPage 读取用户时间线中最新的 benchLimit 条记录对应的 chirp
+func fanOutPage(db *DB, userID int) ([]Chirp, error) {
+	chirps := []Chirp{}
+	err := db.View(func(dbStructure *DBStructure) error {
+		ids, ok := dbStructure.idx.notificationsByUser[userID]
+		if !ok {
+			return nil
+		}
+		for i := len(*ids) - 1; i >= 0 && len(chirps) < benchLimit; i-- {
+			entry := dbStructure.Notifications[(*ids)[i]]
+			chirps = append(chirps, dbStructure.withCounts(dbStructure.Chirps[entry.ChirpID]))
+		}
+		return nil
+	})
+	return chirps, err
+}
+
+// seedTimeline 创建一个使用 strategy 的数据库：benchUsers 个用户，每人关注 benchFollows 人，并预先发布 benchChirps 条 chirp。
+// 返回用户 1（粉丝最多）的粉丝数。
+func seedTimeline(b *testing.B, strategy timelineStrategy) (*DB, int) {
+	b.Helper()
+	db, err := NewDB(filepath.Join(b.TempDir(), "db.json"))
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.Cleanup(func() { db.Close() })
+
+	rng := rand.New(rand.NewSource(1))
+	for i := 1; i <= benchUsers; i++ {
+		_, err := db.CreateUser(fmt.Sprintf("user%d@example.com", i), "hash")
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+	zipf := rand.NewZipf(rng, 1.1, 1, benchUsers-1)
+	for follower := 1; follower <= benchUsers; follower++ {
+		seen := map[int]bool{follower: true}
+		for len(seen) <= benchFollows {
+			followee := int(zipf.Uint64()) + 1
+			if seen[followee] {
+				continue
+			}
+			seen[followee] = true
+			err := db.Follow(follower, followee)
+			if err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+	// 作者同样服从 Zipf 分布：粉丝多的用户也更活跃
+	authors := rand.NewZipf(rng, 1.1, 1, benchUsers-1)
+	for i := 0; i < benchChirps; i++ {
+		err := strategy.post(db, Chirp{AuthorID: int(authors.Uint64()) + 1, Body: "chirp"})
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+
+	followers, err := db.ListFollowers(1)
+	if err != nil {
+		b.Fatal(err)
+	}
+	return db, len(followers)
+}
+
+func BenchmarkTimeline(b *testing.B) {
+	for _, strategy := range timelineStrategies {
+		b.Run(strategy.name, func(b *testing.B) {
+			db, followers := seedTimeline(b, strategy)
+
+			b.Run("post", func(b *testing.B) {
+				for i := 0; i < b.N; i++ {
+					err := strategy.post(db, Chirp{AuthorID: 1, Body: "chirp"})
+					if err != nil {
+						b.Fatal(err)
+					}
+				}
+				// 每条 chirp 需要额外写入的时间线记录数
+				writes := 0
+				if strategy.fanOut {
+					writes = followers
+				}
+				b.ReportMetric(float64(writes), "writes/op")
+			})
+
+			b.Run("page", func(b *testing.B) {
+				rng := rand.New(rand.NewSource(2))
+				for i := 0; i < b.N; i++ {
+					page, err := strategy.page(db, rng.Intn(benchUsers)+1)
+					if err != nil {
+						b.Fatal(err)
+					}
+					if len(page) == 0 {
+						b.Fatal("empty timeline")
+					}
+				}
+			})
+		})
+	}
+}
@@ -0,0 +1,256 @@
+package database
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Grey-1011/go-server/internal/entities"
+)
+
+// ==== 申请删除账号 ====
+/*
+ScheduleUserDeletion 把用户标记为在 deleteAt 永久删除，宽限期内可以用 CancelUserDeletion 撤销：
+1) 已经申请过时返回 ErrAlreadyExists。
+2) 用户所有的 refresh token 和一次性令牌同时被删除，其他设备需要重新登录。
+*/
+func (db *DB) ScheduleUserDeletion(id int, deleteAt time.Time) (User, error) {
+	user := User{}
+	err := db.Update(func(dbStructure *DBStructure) error {
+		var ok bool
+		user, ok = dbStructure.Users[id]
+		if !ok {
+			return ErrNotExist
+		}
+		if user.DeleteAt != nil {
+			return ErrAlreadyExists
+		}
+
+		deleteAt = deleteAt.UTC()
+		user.DeleteAt = &deleteAt
+		user.UpdatedAt = time.Now().UTC()
+		usersTable.put(dbStructure, id, user)
+
+		for token, refreshToken := range dbStructure.RefreshTokens {
+			if refreshToken.UserID == id {
+				refreshTokensTable.delete(dbStructure, token)
+			}
+		}
+		for hash, token := range dbStructure.UserTokens {
+			if token.UserID == id {
+				userTokensTable.delete(dbStructure, hash)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return User{}, err
+	}
+
+	return user, nil
+}
+
+// CancelUserDeletion 撤销删除账号的申请，没有申请时什么都不做
+func (db *DB) CancelUserDeletion(id int) (User, error) {
+	user := User{}
+	err := db.Update(func(dbStructure *DBStructure) error {
+		var ok bool
+		user, ok = dbStructure.Users[id]
+		if !ok {
+			return ErrNotExist
+		}
+		if user.DeleteAt == nil {
+			return nil
+		}
+
+		user.DeleteAt = nil
+		user.UpdatedAt = time.Now().UTC()
+		usersTable.put(dbStructure, id, user)
+		return nil
+	})
+	if err != nil {
+		return User{}, err
+	}
+
+	return user, nil
+}
+
+// DueUserDeletions 返回宽限期在 now 之前（含）结束的用户的 ID，升序
+func (db *DB) DueUserDeletions(now time.Time) ([]int, error) {
+	ids := []int{}
+	err := db.View(func(dbStructure *DBStructure) error {
+		for id, user := range dbStructure.Users {
+			if user.DeleteAt != nil && !user.DeleteAt.After(now) {
+				ids = append(ids, id)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Ints(ids)
+	return ids, nil
+}
+
+// ==== 永久删除账号 ====
+/*
+PurgeUser 在一个 Update 事务中永久删除宽限期在 now 之前（含）结束的用户，返回不再被任何记录引用的媒体文件的哈希：
+1) 用户不存在、没有申请删除或宽限期还没有结束（例如在这期间撤销了）时返回 ErrNotExist。
+2) 删除用户的关注和被关注、点赞、通知（发给用户的和由用户产生的）、令牌、定时 chirp，以及回收站中的 chirp。
+3) anonymize 为 false 时删除用户的所有 chirp（以及它们的历史版本、收到的点赞和投票）、投出的票、上传的媒体和用户本身。
+4) anonymize 为 true 时保留用户的 chirp 和投出的票，只删除没有附加到 chirp 的媒体；
+   用户记录保留为一个匿名账号：清空邮箱、密码和资料，handle 改为 deleted 加用户 ID（见 entities.AnonymousHandle），不能再登录。
+其他用户对这些 chirp 的回复、引用和转发保留，和 chirp 在回收站中被永久删除时一样。
+*/
+func (db *DB) PurgeUser(id int, now time.Time, anonymize bool) ([]string, error) {
+	unused := []string{}
+	err := db.Update(func(dbStructure *DBStructure) error {
+		user, ok := dbStructure.Users[id]
+		if !ok || user.DeleteAt == nil || user.DeleteAt.After(now) {
+			return ErrNotExist
+		}
+
+		// chirp：匿名化时只删除回收站中的
+		chirpIDs := []int{}
+		if !anonymize {
+			if byAuthor, ok := dbStructure.idx.chirpsByAuthor[id]; ok {
+				chirpIDs = append(chirpIDs, *byAuthor...)
+			}
+		}
+		for _, chirpID := range dbStructure.idx.deletedChirps {
+			if dbStructure.Chirps[chirpID].AuthorID == id {
+				chirpIDs = append(chirpIDs, chirpID)
+			}
+		}
+		for _, chirpID := range chirpIDs {
+			dbStructure.purgeChirp(chirpID)
+		}
+
+		scheduledIDs := []int{}
+		if byAuthor, ok := dbStructure.idx.scheduledByAuthor[id]; ok {
+			scheduledIDs = append(scheduledIDs, *byAuthor...)
+		}
+		for _, scheduledID := range scheduledIDs {
+			dbStructure.releaseMedia(dbStructure.ScheduledChirps[scheduledID])
+			scheduledChirpsTable.delete(dbStructure, scheduledID)
+		}
+
+		for key, like := range dbStructure.Likes {
+			if like.UserID == id {
+				likesTable.delete(dbStructure, key)
+			}
+		}
+		for key, follow := range dbStructure.Follows {
+			if follow.FollowerID == id || follow.FolloweeID == id {
+				followsTable.delete(dbStructure, key)
+			}
+		}
+		for notificationID, notification := range dbStructure.Notifications {
+			if notification.UserID == id || notification.ActorID == id {
+				notificationsTable.delete(dbStructure, notificationID)
+			}
+		}
+		if !anonymize {
+			for key, vote := range dbStructure.PollVotes {
+				if vote.UserID == id {
+					pollVotesTable.delete(dbStructure, key)
+				}
+			}
+		}
+		for token, refreshToken := range dbStructure.RefreshTokens {
+			if refreshToken.UserID == id {
+				refreshTokensTable.delete(dbStructure, token)
+			}
+		}
+		for hash, token := range dbStructure.UserTokens {
+			if token.UserID == id {
+				userTokensTable.delete(dbStructure, hash)
+			}
+		}
+
+		// 媒体：chirp 已经被删除，头像也不再需要。匿名化时保留的 chirp 仍然引用它们的媒体
+		dbStructure.releaseAvatar(user)
+		for mediaID, media := range dbStructure.Media {
+			if media.UserID != id || anonymize && media.ChirpID != 0 {
+				continue
+			}
+			mediaTable.delete(dbStructure, mediaID)
+			if dbStructure.idx.mediaByHash[media.Hash] == 0 {
+				unused = append(unused, media.Hash)
+			}
+		}
+
+		if !anonymize {
+			usersTable.delete(dbStructure, id)
+			return nil
+		}
+
+		usersTable.put(dbStructure, id, anonymizedUser(user))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Strings(unused)
+	return unused, nil
+}
+
+// anonymizedUser 返回匿名化之后的用户：只保留 ID 和创建时间，handle 改为保留的匿名 handle。
+// 邮箱改为一个不会被注册的地址，空的密码哈希不能匹配任何密码，所以账号不能再登录。
+func anonymizedUser(user User) User {
+	return User{
+		ID:        user.ID,
+		Email:     anonymousEmail(user.ID),
+		Handle:    entities.AnonymousHandle(user.ID),
+		CreatedAt: user.CreatedAt,
+		UpdatedAt: time.Now().UTC(),
+	}
+}
+
+// anonymousEmail 返回 ID 为 id 的匿名化账号的邮箱，使用保留的顶级域名 .invalid，不会被注册
+func anonymousEmail(id int) string {
+	return "deleted-" + strconv.Itoa(id) + "@invalid"
+}
+
+// ==== 修复保留的 handle ====
+/*
+anonymousHandleRenames 用于两个后端的迁移，计算 users 中需要修改的 handle，返回 用户 ID -> 新的 handle：
+1) 匿名化的账号（邮箱是 anonymousEmail）改为 entities.AnonymousHandle。
+   以前匿名化的账号依次使用 deleted、deleted2 等，可能是另一个账号的 ID 对应的 handle。
+2) 其他用户的 handle 如果属于 entities.ReservedHandleBase（以前 deleted 加数字可以注册），
+   用 availableHandle 重新分配，例如 deleted7 -> userdeleted7。
+taken 判断 handle 是否已经被某个用户使用（不区分大小写）。被修改的旧 handle 都是保留的，不会被重新分配。
+*/
+func anonymousHandleRenames(users []User, taken func(handle string) (bool, error)) (map[int]string, error) {
+	sort.Slice(users, func(i, j int) bool { return users[i].ID < users[j].ID })
+
+	renames := map[int]string{}
+	assigned := map[string]bool{}
+	for _, user := range users {
+		if user.Email == anonymousEmail(user.ID) {
+			if user.Handle != entities.AnonymousHandle(user.ID) {
+				renames[user.ID] = entities.AnonymousHandle(user.ID)
+			}
+			continue
+		}
+		if !entities.ReservedHandleBase(user.Handle) {
+			continue
+		}
+		handle, err := availableHandle(user.Handle, func(handle string) (bool, error) {
+			if assigned[strings.ToLower(handle)] {
+				return true, nil
+			}
+			return taken(handle)
+		})
+		if err != nil {
+			return nil, err
+		}
+		assigned[strings.ToLower(handle)] = true
+		renames[user.ID] = handle
+	}
+	return renames, nil
+}
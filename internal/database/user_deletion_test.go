@@ -0,0 +1,175 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestStoreDeleteAccount(t *testing.T) {
+	testStore(t, func(t *testing.T, st *storeHarness) {
+		st.createUser("walt@breakingbad.com")
+		st.createUser("jesse@breakingbad.com")
+		st.createChirp(1, "walt")
+		st.createChirp(2, "jesse")
+		st.Follow(1, 2)
+		st.SaveRefreshToken(1, "session")
+
+		deleteAt := time.Now().Add(time.Hour)
+		user, err := st.ScheduleUserDeletion(1, deleteAt)
+		if err != nil || user.DeleteAt == nil {
+			t.Fatalf("ScheduleUserDeletion = %+v, %v", user, err)
+		}
+		_, err = st.ScheduleUserDeletion(1, deleteAt)
+		if !errors.Is(err, ErrAlreadyExists) {
+			t.Errorf("ScheduleUserDeletion twice error = %v, want ErrAlreadyExists", err)
+		}
+		if _, err := st.UserForRefreshToken("session"); !errors.Is(err, ErrNotExist) {
+			t.Errorf("UserForRefreshToken after scheduling error = %v, want ErrNotExist", err)
+		}
+		user, err = st.CancelUserDeletion(1)
+		if err != nil || user.DeleteAt != nil {
+			t.Fatalf("CancelUserDeletion = %+v, %v", user, err)
+		}
+		st.ScheduleUserDeletion(1, deleteAt)
+		st.ScheduleUserDeletion(2, deleteAt)
+
+		// 宽限期结束之前不会被删除
+		if ids, _ := st.DueUserDeletions(time.Now()); len(ids) != 0 {
+			t.Errorf("DueUserDeletions before grace period = %v", ids)
+		}
+		_, err = st.PurgeUser(1, time.Now(), false)
+		if !errors.Is(err, ErrNotExist) {
+			t.Errorf("PurgeUser before grace period error = %v, want ErrNotExist", err)
+		}
+
+		later := deleteAt.Add(time.Second)
+		if ids, _ := st.DueUserDeletions(later); !equalIDs(ids, []int{1, 2}) {
+			t.Errorf("DueUserDeletions = %v, want [1 2]", ids)
+		}
+		// 用户 1 被删除，用户 2 被匿名化
+		_, err = st.PurgeUser(1, later, false)
+		if err != nil {
+			t.Fatalf("PurgeUser(delete): %v", err)
+		}
+		_, err = st.PurgeUser(2, later, true)
+		if err != nil {
+			t.Fatalf("PurgeUser(anonymize): %v", err)
+		}
+
+		st.reopen()
+		_, err = st.GetUser(1)
+		if !errors.Is(err, ErrNotExist) {
+			t.Errorf("GetUser(deleted) error = %v, want ErrNotExist", err)
+		}
+		anonymous, err := st.GetUser(2)
+		if err != nil || anonymous.Handle != "deleted2" || anonymous.HashedPassword != "" || anonymous.DeleteAt != nil {
+			t.Errorf("GetUser(anonymized) = %+v, %v", anonymous, err)
+		}
+		if _, err := st.GetUserByEmail("jesse@breakingbad.com"); !errors.Is(err, ErrNotExist) {
+			t.Errorf("GetUserByEmail(anonymized) error = %v, want ErrNotExist", err)
+		}
+		if chirps, _ := st.ListChirps(ChirpQuery{}); !equalIDs(chirpIDs(chirps), []int{2}) {
+			t.Errorf("ListChirps = %v, want [2]", chirpIDs(chirps))
+		}
+		if followers, _ := st.ListFollowers(2); len(followers) != 0 {
+			t.Errorf("ListFollowers(anonymized) = %+v", followers)
+		}
+	})
+}
+
+// TestMigrateAnonymousHandles 升级之前的数据库：匿名化的账号依次使用了 deleted、deleted2，
+// 用户也可以注册 deleted 加数字的 handle。迁移后匿名化的账号使用 deleted 加自己的 ID，
+// 和它冲突的用户的 handle 被重新分配。
+func TestMigrateAnonymousHandles(t *testing.T) {
+	// 用户 2 是匿名化的账号，用户 3 的 handle 是用户 2 应该使用的 deleted2，
+	// 用户 4 占用了用户 3 的第一个候选 handle
+	handles := map[int]string{1: "walt", 2: "deleted", 3: "deleted2", 4: "userdeleted2"}
+	emails := map[int]string{1: "walt@breakingbad.com", 2: "deleted-2@invalid", 3: "jesse@breakingbad.com", 4: "skyler@breakingbad.com"}
+
+	seeds := map[string]func(t *testing.T, dir string){
+		// schema 版本 4 的 JSON 文件
+		"json": func(t *testing.T, dir string) {
+			dbStructure := DBStructure{SchemaVersion: 4, Users: map[int]User{}, Sequences: map[string]int{"users": 4}}
+			for id := 1; id <= 4; id++ {
+				dbStructure.Users[id] = User{ID: id, Email: emails[id], Handle: handles[id], CreatedAt: time.Now().UTC(), Verified: true}
+			}
+			dat, err := json.Marshal(dbStructure)
+			if err != nil {
+				t.Fatalf("Marshal: %v", err)
+			}
+			err = os.WriteFile(filepath.Join(dir, "db.json"), dat, 0600)
+			if err != nil {
+				t.Fatalf("WriteFile: %v", err)
+			}
+		},
+		// user_version 为 17 的 SQLite 数据库
+		"sqlite": func(t *testing.T, dir string) {
+			path := filepath.Join(dir, "db.sqlite")
+			db, err := NewSQLiteDB(path)
+			if err != nil {
+				t.Fatalf("NewSQLiteDB: %v", err)
+			}
+			for id := 1; id <= 4; id++ {
+				_, err = db.CreateUser(emails[id], "hash")
+				if err != nil {
+					t.Fatalf("CreateUser: %v", err)
+				}
+			}
+			db.Close()
+
+			conn, err := sql.Open("sqlite", path)
+			if err != nil {
+				t.Fatalf("sql.Open: %v", err)
+			}
+			defer conn.Close()
+			for id := 1; id <= 4; id++ {
+				_, err = conn.Exec("UPDATE users SET handle = ? WHERE id = ?", handles[id], id)
+				if err != nil {
+					t.Fatalf("UPDATE users: %v", err)
+				}
+			}
+			_, err = conn.Exec("PRAGMA user_version = 17")
+			if err != nil {
+				t.Fatalf("PRAGMA user_version: %v", err)
+			}
+		},
+	}
+
+	want := map[int]string{1: "walt", 2: "deleted2", 3: "userdeleted22", 4: "userdeleted2"}
+	for _, backend := range backends {
+		t.Run(backend.name, func(t *testing.T) {
+			dir := t.TempDir()
+			seeds[backend.name](t, dir)
+			st, err := backend.open(dir)
+			if err != nil {
+				t.Fatalf("open: %v", err)
+			}
+			defer st.Close()
+
+			for id, handle := range want {
+				user, err := st.GetUser(id)
+				if err != nil || user.Handle != handle {
+					t.Errorf("GetUser(%d) = %+v, %v, want handle %s", id, user, err, handle)
+				}
+			}
+			// 之后匿名化的账号不会和用户的 handle 冲突
+			deleteAt := time.Now()
+			_, err = st.ScheduleUserDeletion(3, deleteAt)
+			if err != nil {
+				t.Fatalf("ScheduleUserDeletion: %v", err)
+			}
+			_, err = st.PurgeUser(3, deleteAt, true)
+			if err != nil {
+				t.Fatalf("PurgeUser: %v", err)
+			}
+			if user, _ := st.GetUser(3); user.Handle != "deleted3" {
+				t.Errorf("GetUser(anonymized) handle = %q, want deleted3", user.Handle)
+			}
+		})
+	}
+}
@@ -0,0 +1,122 @@
+package database
+
+import "sort"
+
+// UserExport 是数据库中保存的与一个用户有关的所有记录，用于导出用户的数据。
+// 除了 User 之外都是数据库中的原始记录，按 ID（或时间）升序排列。
+type UserExport struct {
+	User            User
+	Chirps          []Chirp // 包括回收站中的和转发
+	ChirpRevisions  []ChirpRevision
+	Likes           []Like
+	Following       []Follow
+	Followers       []Follow
+	Notifications   []Notification
+	PollVotes       []PollVote
+	ScheduledChirps []ScheduledChirp
+	Media           []Media // 上传的媒体，包括没有附加到 chirp 的
+}
+
+// ExportUser 在一个 View 中收集用户的所有记录，用户不存在时返回 ErrNotExist
+func (db *DB) ExportUser(id int) (UserExport, error) {
+	export := UserExport{}
+	err := db.View(func(dbStructure *DBStructure) error {
+		user, ok := dbStructure.Users[id]
+		if !ok {
+			return ErrNotExist
+		}
+		export = UserExport{
+			User:            user,
+			Chirps:          []Chirp{},
+			ChirpRevisions:  []ChirpRevision{},
+			Likes:           []Like{},
+			Following:       []Follow{},
+			Followers:       []Follow{},
+			Notifications:   []Notification{},
+			PollVotes:       []PollVote{},
+			ScheduledChirps: []ScheduledChirp{},
+			Media:           []Media{},
+		}
+
+		for _, chirp := range dbStructure.Chirps {
+			if chirp.AuthorID != id {
+				continue
+			}
+			export.Chirps = append(export.Chirps, dbStructure.withCounts(chirp))
+			if byChirp, ok := dbStructure.idx.chirpRevisions[chirp.ID]; ok {
+				for _, revisionID := range *byChirp {
+					export.ChirpRevisions = append(export.ChirpRevisions, dbStructure.ChirpRevisions[revisionID])
+				}
+			}
+		}
+		for _, like := range dbStructure.Likes {
+			if like.UserID == id {
+				export.Likes = append(export.Likes, like)
+			}
+		}
+		for _, follow := range dbStructure.Follows {
+			if follow.FollowerID == id {
+				export.Following = append(export.Following, follow)
+			}
+			if follow.FolloweeID == id {
+				export.Followers = append(export.Followers, follow)
+			}
+		}
+		if byUser, ok := dbStructure.idx.notificationsByUser[id]; ok {
+			for _, notificationID := range *byUser {
+				export.Notifications = append(export.Notifications, dbStructure.Notifications[notificationID])
+			}
+		}
+		for _, vote := range dbStructure.PollVotes {
+			if vote.UserID == id {
+				export.PollVotes = append(export.PollVotes, vote)
+			}
+		}
+		if byAuthor, ok := dbStructure.idx.scheduledByAuthor[id]; ok {
+			for _, scheduledID := range *byAuthor {
+				export.ScheduledChirps = append(export.ScheduledChirps, dbStructure.ScheduledChirps[scheduledID])
+			}
+		}
+		for _, media := range dbStructure.Media {
+			if media.UserID == id {
+				export.Media = append(export.Media, media)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return UserExport{}, err
+	}
+
+	export.sort()
+	return export, nil
+}
+
+// sort 把从 map 中收集的记录排成稳定的顺序
+func (export *UserExport) sort() {
+	sort.Slice(export.Chirps, func(i, j int) bool { return export.Chirps[i].ID < export.Chirps[j].ID })
+	sort.Slice(export.ChirpRevisions, func(i, j int) bool { return export.ChirpRevisions[i].ID < export.ChirpRevisions[j].ID })
+	sort.Slice(export.Likes, func(i, j int) bool {
+		a, b := export.Likes[i], export.Likes[j]
+		if !a.CreatedAt.Equal(b.CreatedAt) {
+			return a.CreatedAt.Before(b.CreatedAt)
+		}
+		return a.ChirpID < b.ChirpID
+	})
+	// other 返回关注关系中另一个用户的 ID，关注时间相同时按它排序
+	sortFollows := func(follows []Follow, other func(Follow) int) {
+		sort.Slice(follows, func(i, j int) bool {
+			a, b := follows[i], follows[j]
+			if !a.CreatedAt.Equal(b.CreatedAt) {
+				return a.CreatedAt.Before(b.CreatedAt)
+			}
+			return other(a) < other(b)
+		})
+	}
+	sortFollows(export.Following, func(follow Follow) int { return follow.FolloweeID })
+	sortFollows(export.Followers, func(follow Follow) int { return follow.FollowerID })
+	sort.Slice(export.Notifications, func(i, j int) bool { return export.Notifications[i].ID < export.Notifications[j].ID })
+	sort.Slice(export.PollVotes, func(i, j int) bool { return export.PollVotes[i].ChirpID < export.PollVotes[j].ChirpID })
+	sort.Slice(export.ScheduledChirps, func(i, j int) bool { return export.ScheduledChirps[i].ID < export.ScheduledChirps[j].ID })
+	sort.Slice(export.Media, func(i, j int) bool { return export.Media[i].ID < export.Media[j].ID })
+}
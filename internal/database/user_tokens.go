@@ -0,0 +1,146 @@
+package database
+
+import "time"
+
+// 一次性令牌的用途
+const (
+	TokenPurposeVerifyEmail   = "verify_email"   // 验证邮箱，Email 是要验证的邮箱
+	TokenPurposeResetPassword = "reset_password" // 重置密码，Email 是发送令牌时用户的邮箱
+)
+
+// UserToken 是发给用户的一次性令牌，例如邮件中的验证码。
+// 只保存令牌的哈希，数据库泄露时令牌本身不会泄露；令牌使用一次后即被删除。
+type UserToken struct {
+	Hash      string    `json:"hash"`
+	UserID    int       `json:"user_id"`
+	Purpose   string    `json:"purpose"`
+	Email     string    `json:"email,omitempty"`
+	ExpiresAt time.Time `json:"expires_at"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+var userTokensTable = table[string, UserToken]{
+	name: "user_tokens",
+	m:    func(dbStructure *DBStructure) *map[string]UserToken { return &dbStructure.UserTokens },
+}
+
+// CreateUserToken 保存一个令牌，同一个用户相同用途的旧令牌同时失效，所以只有最新发出的令牌可以使用
+func (db *DB) CreateUserToken(token UserToken) error {
+	return db.Update(func(dbStructure *DBStructure) error {
+		if _, ok := dbStructure.Users[token.UserID]; !ok {
+			return ErrNotExist
+		}
+		for hash, other := range dbStructure.UserTokens {
+			if other.UserID == token.UserID && other.Purpose == token.Purpose {
+				userTokensTable.delete(dbStructure, hash)
+			}
+		}
+		token.CreatedAt = time.Now().UTC()
+		userTokensTable.put(dbStructure, token.Hash, token)
+		return nil
+	})
+}
+
+// consumeUserToken 查找并删除用途为 purpose、在 now 时还没有过期的令牌，不存在时返回 ErrNotExist，必须在 Update 事务中调用
+func (dbStructure *DBStructure) consumeUserToken(hash, purpose string, now time.Time) (UserToken, error) {
+	token, ok := dbStructure.UserTokens[hash]
+	if !ok || token.Purpose != purpose || !now.Before(token.ExpiresAt) {
+		return UserToken{}, ErrNotExist
+	}
+	userTokensTable.delete(dbStructure, hash)
+	return token, nil
+}
+
+// ==== 验证邮箱 ====
+/*
+VerifyEmail 使用一个验证邮箱的令牌：把用户的邮箱设为令牌中的邮箱，并标记为已验证。
+1) 令牌不存在、已经使用过或已过期时返回 ErrNotExist。
+2) 修改邮箱时，新邮箱在验证之前可能已被其他用户使用，这时返回 ErrAlreadyExists，令牌不会被消耗。
+*/
+func (db *DB) VerifyEmail(hash string, now time.Time) (User, error) {
+	user := User{}
+	err := db.Update(func(dbStructure *DBStructure) error {
+		token, err := dbStructure.consumeUserToken(hash, TokenPurposeVerifyEmail, now)
+		if err != nil {
+			return err
+		}
+		var ok bool
+		user, ok = dbStructure.Users[token.UserID]
+		if !ok {
+			return ErrNotExist
+		}
+		if other, ok := dbStructure.userByEmail(token.Email); ok && other.ID != user.ID {
+			return ErrAlreadyExists
+		}
+
+		user.Email = token.Email
+		user.Verified = true
+		user.UpdatedAt = time.Now().UTC()
+		usersTable.put(dbStructure, user.ID, user)
+		return nil
+	})
+	if err != nil {
+		return User{}, err
+	}
+
+	return user, nil
+}
+
+// ==== 重置密码 ====
+/*
+ResetPassword 使用一个重置密码的令牌，把用户的密码改为 hashedPassword：
+1) 令牌不存在、已经使用过或已过期时返回 ErrNotExist。
+2) 发送令牌之后用户修改了邮箱时令牌同样无效，令牌只对收到它的邮箱有效。
+3) 用户所有的 refresh token 同时被撤销，其他设备需要重新登录。
+4) 能使用发到邮箱的令牌说明用户拥有这个邮箱，所以邮箱同时被标记为已验证。
+*/
+func (db *DB) ResetPassword(hash, hashedPassword string, now time.Time) (User, error) {
+	user := User{}
+	err := db.Update(func(dbStructure *DBStructure) error {
+		token, err := dbStructure.consumeUserToken(hash, TokenPurposeResetPassword, now)
+		if err != nil {
+			return err
+		}
+		var ok bool
+		user, ok = dbStructure.Users[token.UserID]
+		if !ok || user.Email != token.Email {
+			return ErrNotExist
+		}
+
+		user.HashedPassword = hashedPassword
+		user.Verified = true
+		user.UpdatedAt = time.Now().UTC()
+		usersTable.put(dbStructure, user.ID, user)
+
+		for key, refreshToken := range dbStructure.RefreshTokens {
+			if refreshToken.UserID == user.ID {
+				refreshTokensTable.delete(dbStructure, key)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return User{}, err
+	}
+
+	return user, nil
+}
+
+// PurgeUserTokens 删除在 expiredBefore 之前过期的令牌，返回删除的数量
+func (db *DB) PurgeUserTokens(expiredBefore time.Time) (int, error) {
+	n := 0
+	err := db.Update(func(dbStructure *DBStructure) error {
+		for hash, token := range dbStructure.UserTokens {
+			if token.ExpiresAt.Before(expiredBefore) {
+				userTokensTable.delete(dbStructure, hash)
+				n++
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return n, nil
+}
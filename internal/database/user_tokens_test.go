@@ -0,0 +1,137 @@
+package database
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestStoreVerifyEmail(t *testing.T) {
+	testStore(t, func(t *testing.T, st *storeHarness) {
+		st.createUser("walt@breakingbad.com")
+		st.createUser("jesse@breakingbad.com")
+		now := time.Now()
+		tokens := []UserToken{
+			// 同一个用户相同用途的新令牌使旧令牌失效
+			{Hash: "superseded", UserID: 1, Purpose: TokenPurposeVerifyEmail, Email: "walt@breakingbad.com", ExpiresAt: now.Add(time.Hour)},
+			{Hash: "verify", UserID: 1, Purpose: TokenPurposeVerifyEmail, Email: "walt@breakingbad.com", ExpiresAt: now.Add(time.Hour)},
+			{Hash: "expired", UserID: 2, Purpose: TokenPurposeVerifyEmail, Email: "jesse@breakingbad.com", ExpiresAt: now.Add(-time.Hour)},
+		}
+		for _, token := range tokens {
+			err := st.CreateUserToken(token)
+			if err != nil {
+				t.Fatalf("CreateUserToken: %v", err)
+			}
+		}
+
+		st.reopen()
+		_, err := st.VerifyEmail("superseded", now)
+		if !errors.Is(err, ErrNotExist) {
+			t.Errorf("VerifyEmail(superseded) error = %v, want ErrNotExist", err)
+		}
+		_, err = st.VerifyEmail("expired", now)
+		if !errors.Is(err, ErrNotExist) {
+			t.Errorf("VerifyEmail(expired) error = %v, want ErrNotExist", err)
+		}
+		user, err := st.VerifyEmail("verify", now)
+		if err != nil || !user.Verified {
+			t.Fatalf("VerifyEmail = %+v, %v", user, err)
+		}
+		_, err = st.VerifyEmail("verify", now)
+		if !errors.Is(err, ErrNotExist) {
+			t.Errorf("VerifyEmail(used) error = %v, want ErrNotExist", err)
+		}
+
+		n, err := st.PurgeUserTokens(now)
+		if err != nil || n != 1 {
+			t.Errorf("PurgeUserTokens = %d, %v, want 1", n, err)
+		}
+	})
+}
+
+// 修改邮箱时令牌中是新的邮箱，验证之后才替换原来的邮箱
+func TestStoreVerifyEmailChange(t *testing.T) {
+	testStore(t, func(t *testing.T, st *storeHarness) {
+		st.createUser("walt@breakingbad.com")
+		st.createUser("jesse@breakingbad.com")
+		now := time.Now()
+		for _, token := range []UserToken{
+			{Hash: "change", UserID: 1, Purpose: TokenPurposeVerifyEmail, Email: "heisenberg@breakingbad.com", ExpiresAt: now.Add(time.Hour)},
+			{Hash: "taken", UserID: 2, Purpose: TokenPurposeVerifyEmail, Email: "heisenberg@breakingbad.com", ExpiresAt: now.Add(time.Hour)},
+		} {
+			err := st.CreateUserToken(token)
+			if err != nil {
+				t.Fatalf("CreateUserToken: %v", err)
+			}
+		}
+
+		user, err := st.GetUser(1)
+		if err != nil || user.Email != "walt@breakingbad.com" {
+			t.Fatalf("GetUser before verification = %+v, %v", user, err)
+		}
+		user, err = st.VerifyEmail("change", now)
+		if err != nil || user.Email != "heisenberg@breakingbad.com" || !user.Verified {
+			t.Fatalf("VerifyEmail = %+v, %v", user, err)
+		}
+		// 新的邮箱在验证之前被别人占用
+		_, err = st.VerifyEmail("taken", now)
+		if !errors.Is(err, ErrAlreadyExists) {
+			t.Errorf("VerifyEmail(taken email) error = %v, want ErrAlreadyExists", err)
+		}
+
+		st.reopen()
+		if _, err := st.GetUserByEmail("walt@breakingbad.com"); !errors.Is(err, ErrNotExist) {
+			t.Errorf("GetUserByEmail(old email) error = %v, want ErrNotExist", err)
+		}
+		if user, err := st.GetUserByEmail("heisenberg@breakingbad.com"); err != nil || user.ID != 1 {
+			t.Errorf("GetUserByEmail(new email) = %+v, %v", user, err)
+		}
+	})
+}
+
+func TestStoreResetPassword(t *testing.T) {
+	testStore(t, func(t *testing.T, st *storeHarness) {
+		st.createUser("walt@breakingbad.com")
+		st.createUser("jesse@breakingbad.com")
+		now := time.Now()
+		for _, token := range []UserToken{
+			{Hash: "verify", UserID: 1, Purpose: TokenPurposeVerifyEmail, Email: "walt@breakingbad.com", ExpiresAt: now.Add(time.Hour)},
+			{Hash: "reset", UserID: 2, Purpose: TokenPurposeResetPassword, Email: "jesse@breakingbad.com", ExpiresAt: now.Add(time.Hour)},
+			{Hash: "expired", UserID: 1, Purpose: TokenPurposeResetPassword, Email: "walt@breakingbad.com", ExpiresAt: now.Add(-time.Hour)},
+		} {
+			err := st.CreateUserToken(token)
+			if err != nil {
+				t.Fatalf("CreateUserToken: %v", err)
+			}
+		}
+		st.SaveRefreshToken(2, "session")
+
+		// 令牌只能用于创建它时的用途
+		_, err := st.VerifyEmail("reset", now)
+		if !errors.Is(err, ErrNotExist) {
+			t.Errorf("VerifyEmail(reset token) error = %v, want ErrNotExist", err)
+		}
+		_, err = st.ResetPassword("verify", "new hash", now)
+		if !errors.Is(err, ErrNotExist) {
+			t.Errorf("ResetPassword(verify token) error = %v, want ErrNotExist", err)
+		}
+		_, err = st.ResetPassword("expired", "new hash", now)
+		if !errors.Is(err, ErrNotExist) {
+			t.Errorf("ResetPassword(expired) error = %v, want ErrNotExist", err)
+		}
+
+		st.reopen()
+		// 重置密码的令牌也证明了用户拥有这个邮箱，所有会话被撤销
+		user, err := st.ResetPassword("reset", "new hash", now)
+		if err != nil || user.ID != 2 || user.HashedPassword != "new hash" || !user.Verified {
+			t.Fatalf("ResetPassword = %+v, %v", user, err)
+		}
+		if _, err := st.UserForRefreshToken("session"); !errors.Is(err, ErrNotExist) {
+			t.Errorf("UserForRefreshToken after reset error = %v, want ErrNotExist", err)
+		}
+		_, err = st.ResetPassword("reset", "another hash", now)
+		if !errors.Is(err, ErrNotExist) {
+			t.Errorf("ResetPassword(used) error = %v, want ErrNotExist", err)
+		}
+	})
+}
@@ -2,36 +2,165 @@ package database
 
 import (
 	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Grey-1011/go-server/internal/entities"
 )
 
+// ErrHandleChangeTooSoon 表示距离上一次修改 handle 的时间太短
+var ErrHandleChangeTooSoon = errors.New("handle changed too recently")
+
 type User struct {
-	ID             int    `json:"id"`
-	Email          string `json:"email"`
-	HashedPassword string `json:"hashed_password"`
-	IsChirpyRed    bool   `json:"is_chirpy_red"`
+	ID             int       `json:"id"`
+	Email          string    `json:"email"`
+	Handle         string    `json:"handle"` // 唯一，不区分大小写，用于 @提及
+	HashedPassword string    `json:"hashed_password"`
+	IsChirpyRed    bool      `json:"is_chirpy_red"`
+	Verified       bool      `json:"verified"` // 邮箱已通过验证，未验证的用户不能发布 chirp
+	CreatedAt      time.Time `json:"created_at"`
+	UpdatedAt      time.Time `json:"updated_at"`
+	// 公开的资料
+	DisplayName string      `json:"display_name,omitempty"`
+	Bio         string      `json:"bio,omitempty"`
+	Avatar      *ChirpMedia `json:"avatar,omitempty"` // 头像，和 chirp 的媒体一样复制了元数据
+	// 最后一次修改 handle 的时间，注册时自动分配的 handle 不算，没有修改过时为 nil
+	HandleChangedAt *time.Time `json:"handle_changed_at,omitempty"`
+	// 用户申请删除账号后，账号被永久删除（或匿名化）的时间，没有申请时为 nil
+	DeleteAt *time.Time `json:"delete_at,omitempty"`
 }
 
-var ErrAlreadyExists = errors.New("already exists")
+// UserProfile 是 UpdateProfile 要修改的公开资料，nil 表示保持不变
+type UserProfile struct {
+	DisplayName *string
+	Bio         *string
+	AvatarID    *int // 作为头像的媒体的 ID，0 表示移除头像
+}
 
-func (db *DB) CreateUser(email string, hashedPassword string) (User, error) {
-	if _, err := db.GetUserByEmail(email); !errors.Is(err, ErrNotExist) {
-		return User{}, ErrAlreadyExists
+// UserStats 是用户的统计数据
+type UserStats struct {
+	ChirpCount     int // 发布的 chirp（包括回复和转发，不包括回收站中的）
+	FollowerCount  int
+	FollowingCount int
+}
+
+// UserUpdate 是 UpdateUser 要修改的字段，nil 表示保持不变
+type UserUpdate struct {
+	Email          *string
+	HashedPassword *string
+}
+
+// avatarID 返回用户头像的媒体 ID，没有头像时返回 0
+func (user *User) avatarID() int {
+	if user.Avatar == nil {
+		return 0
 	}
+	return user.Avatar.ID
+}
 
-	dbStructure, err := db.loadDB()
-	if err != nil {
-		return User{}, err
+var usersTable = table[int, User]{
+	name:  "users",
+	m:     func(dbStructure *DBStructure) *map[int]User { return &dbStructure.Users },
+	index: indexUser,
+}
+
+// indexUser 维护 handle（小写）和邮箱到用户 ID 的索引
+func indexUser(dbStructure *DBStructure, id int, old, new *User) {
+	if old != nil {
+		delete(dbStructure.idx.usersByHandle, strings.ToLower(old.Handle))
+		// 旧数据中可能有重复的邮箱，只删除指向这个用户的索引
+		if dbStructure.idx.usersByEmail[old.Email] == id {
+			delete(dbStructure.idx.usersByEmail, old.Email)
+		}
+	}
+	if new != nil && new.Handle != "" {
+		dbStructure.idx.usersByHandle[strings.ToLower(new.Handle)] = id
+	}
+	if new != nil {
+		dbStructure.idx.usersByEmail[new.Email] = id
 	}
+}
 
-	id := len(dbStructure.Users) + 1
-	user := User{
-		ID:             id,
-		Email:          email,
-		HashedPassword: hashedPassword,
+// ==== 分配 handle ====
+/*
+注册时根据邮箱的本地部分自动分配 handle：
+1) 去掉 handle 中不允许的字符，截断到 entities.MaxHandleLength；什么都不剩时使用 "user"，
+   加上数字后缀也仍然保留时（只剩数字，或者是 deleted，见 entities.ReservedHandleBase）在前面加上 "user"。
+2) 如果已被占用（不区分大小写）或被保留，依次尝试加上后缀 2、3、……，必要时再截断前面的部分。
+taken 判断一个 handle 是否已被占用。
+*/
+func availableHandle(email string, taken func(handle string) (bool, error)) (string, error) {
+	local, _, _ := strings.Cut(email, "@")
+	base := strings.Map(func(r rune) rune {
+		if entities.ValidHandle(string(r)) {
+			return r
+		}
+		return -1
+	}, local)
+	if base == "" || entities.ReservedHandleBase(base) {
+		base = "user" + base
 	}
-	dbStructure.Users[id] = user
 
-	err = db.writeDB(dbStructure)
+	for n := 1; ; n++ {
+		suffix := ""
+		if n > 1 {
+			suffix = strconv.Itoa(n)
+		}
+		handle := base[:min(len(base), entities.MaxHandleLength-len(suffix))] + suffix
+		if entities.ReservedHandle(handle) {
+			continue
+		}
+		ok, err := taken(handle)
+		if err != nil {
+			return "", err
+		}
+		if !ok {
+			return handle, nil
+		}
+	}
+}
+
+// userByHandle 不区分大小写地查找 handle
+func (dbStructure *DBStructure) userByHandle(handle string) (User, bool) {
+	id, ok := dbStructure.idx.usersByHandle[strings.ToLower(handle)]
+	if !ok {
+		return User{}, false
+	}
+	return dbStructure.Users[id], true
+}
+
+var ErrAlreadyExists = errors.New("already exists")
+
+func (db *DB) CreateUser(email string, hashedPassword string) (User, error) {
+	user := User{}
+	// 唯一性检查和插入在同一个事务里，避免并发注册同一个邮箱
+	err := db.Update(func(dbStructure *DBStructure) error {
+		if _, ok := dbStructure.userByEmail(email); ok {
+			return ErrAlreadyExists
+		}
+
+		handle, err := availableHandle(email, func(handle string) (bool, error) {
+			_, ok := dbStructure.userByHandle(handle)
+			return ok, nil
+		})
+		if err != nil {
+			return err
+		}
+
+		id := dbStructure.nextID(usersTable.name)
+		now := time.Now().UTC()
+		user = User{
+			ID:             id,
+			Email:          email,
+			Handle:         handle,
+			HashedPassword: hashedPassword,
+			CreatedAt:      now,
+			UpdatedAt:      now,
+		}
+		usersTable.put(dbStructure, id, user)
+		return nil
+	})
 	if err != nil {
 		return User{}, err
 	}
@@ -40,50 +169,153 @@ func (db *DB) CreateUser(email string, hashedPassword string) (User, error) {
 }
 
 func (db *DB) GetUser(id int) (User, error) {
-	dbStructure, err := db.loadDB()
+	user := User{}
+	err := db.View(func(dbStructure *DBStructure) error {
+		var ok bool
+		user, ok = dbStructure.Users[id]
+		if !ok {
+			return ErrNotExist
+		}
+		return nil
+	})
 	if err != nil {
 		return User{}, err
 	}
 
-	user, ok := dbStructure.Users[id]
-	if !ok {
-		return User{}, ErrNotExist
+	return user, nil
+}
+
+// GetUserStats 从索引中统计用户的数据，用户不存在时返回 ErrNotExist
+func (db *DB) GetUserStats(id int) (UserStats, error) {
+	stats := UserStats{}
+	err := db.View(func(dbStructure *DBStructure) error {
+		if _, ok := dbStructure.Users[id]; !ok {
+			return ErrNotExist
+		}
+		if byAuthor, ok := dbStructure.idx.chirpsByAuthor[id]; ok {
+			stats.ChirpCount = len(*byAuthor)
+		}
+		if followers, ok := dbStructure.idx.followers[id]; ok {
+			stats.FollowerCount = len(*followers)
+		}
+		if following, ok := dbStructure.idx.following[id]; ok {
+			stats.FollowingCount = len(*following)
+		}
+		return nil
+	})
+	if err != nil {
+		return UserStats{}, err
 	}
 
-	return user, nil
+	return stats, nil
 }
 
 func (db *DB) GetUserByEmail(email string) (User, error) {
-	dbStructure, err := db.loadDB()
+	user := User{}
+	err := db.View(func(dbStructure *DBStructure) error {
+		var ok bool
+		user, ok = dbStructure.userByEmail(email)
+		if !ok {
+			return ErrNotExist
+		}
+		return nil
+	})
 	if err != nil {
 		return User{}, err
 	}
 
-	for _, user := range dbStructure.Users {
-		if user.Email == email {
-			return user, nil
+	return user, nil
+}
+
+// GetUsersByHandle 批量查找 handle，结果的键是小写的 handle。不存在的 handle 不会出现在结果中。
+func (db *DB) GetUsersByHandle(handles []string) (map[string]User, error) {
+	users := map[string]User{}
+	err := db.View(func(dbStructure *DBStructure) error {
+		for _, handle := range handles {
+			if user, ok := dbStructure.userByHandle(handle); ok {
+				users[strings.ToLower(handle)] = user
+			}
 		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
 
-	return User{}, ErrNotExist
+	return users, nil
 }
 
-func (db *DB) UpdateUser(id int, email, hashedPassword string) (User, error) {
-	dbStructure, err := db.loadDB()
+func (dbStructure *DBStructure) userByEmail(email string) (User, bool) {
+	id, ok := dbStructure.idx.usersByEmail[email]
+	if !ok {
+		return User{}, false
+	}
+	return dbStructure.Users[id], true
+}
+
+// UpdateUser 修改 update 中不为 nil 的字段，邮箱已被其他用户使用时返回 ErrAlreadyExists
+func (db *DB) UpdateUser(id int, update UserUpdate) (User, error) {
+	user := User{}
+	err := db.Update(func(dbStructure *DBStructure) error {
+		var ok bool
+		user, ok = dbStructure.Users[id]
+		if !ok {
+			return ErrNotExist
+		}
+
+		if update.Email != nil {
+			if other, ok := dbStructure.userByEmail(*update.Email); ok && other.ID != id {
+				return ErrAlreadyExists
+			}
+			user.Email = *update.Email
+		}
+		if update.HashedPassword != nil {
+			user.HashedPassword = *update.HashedPassword
+		}
+		user.UpdatedAt = time.Now().UTC()
+		usersTable.put(dbStructure, id, user)
+		return nil
+	})
 	if err != nil {
 		return User{}, err
 	}
 
-	user, ok := dbStructure.Users[id]
-	if !ok {
-		return User{}, ErrNotExist
-	}
+	return user, nil
+}
 
-	user.Email = email
-	user.HashedPassword = hashedPassword
-	dbStructure.Users[id] = user
+// ==== 修改 handle ====
+/*
+ChangeHandle 把用户的 handle 改为 handle（调用方负责检查格式和保留的 handle）：
+1) 和当前的 handle 完全相同时不做任何修改。
+2) 上一次修改在 changedBefore 之后时返回 ErrHandleChangeTooSoon。
+3) handle 被其他用户占用（不区分大小写）时返回 ErrAlreadyExists；只修改自己 handle 的大小写是允许的。
+旧的 handle 立即释放，已有 chirp 中的提及仍然指向这个用户。
+*/
+func (db *DB) ChangeHandle(id int, handle string, changedBefore time.Time) (User, error) {
+	user := User{}
+	err := db.Update(func(dbStructure *DBStructure) error {
+		var ok bool
+		user, ok = dbStructure.Users[id]
+		if !ok {
+			return ErrNotExist
+		}
+		if user.Handle == handle {
+			return nil
+		}
+		if user.HandleChangedAt != nil && user.HandleChangedAt.After(changedBefore) {
+			return ErrHandleChangeTooSoon
+		}
+		if other, ok := dbStructure.userByHandle(handle); ok && other.ID != id {
+			return ErrAlreadyExists
+		}
 
-	err = db.writeDB(dbStructure)
+		now := time.Now().UTC()
+		user.Handle = handle
+		user.HandleChangedAt = &now
+		user.UpdatedAt = now
+		usersTable.put(dbStructure, id, user)
+		return nil
+	})
 	if err != nil {
 		return User{}, err
 	}
@@ -91,27 +323,84 @@ func (db *DB) UpdateUser(id int, email, hashedPassword string) (User, error) {
 	return user, nil
 }
 
+// ==== 修改资料 ====
+/*
+UpdateProfile 修改用户的公开资料（调用方负责校验和内容审核），profile 中为 nil 的字段保持不变。
+在同一个事务中读取和修改，所以同时修改不同字段的请求不会覆盖彼此。
+头像改变时释放旧的头像（之后由 PurgeMedia 清理），新的头像必须是用户上传、
+并且没有附加到 chirp 的媒体，否则返回 ErrMediaNotAvailable。
+*/
+func (db *DB) UpdateProfile(id int, profile UserProfile) (User, error) {
+	user := User{}
+	err := db.Update(func(dbStructure *DBStructure) error {
+		var ok bool
+		user, ok = dbStructure.Users[id]
+		if !ok {
+			return ErrNotExist
+		}
 
-// === UpgradeChirpyRed 
-func (db *DB) UpgradeChirpyRed(id int) (User, error) {
-	dbStructure, err := db.loadDB()
+		if profile.AvatarID != nil && *profile.AvatarID != user.avatarID() {
+			dbStructure.releaseAvatar(user)
+			user.Avatar = nil
+			if *profile.AvatarID != 0 {
+				media, ok := dbStructure.Media[*profile.AvatarID]
+				if !ok || media.UserID != id || !media.unattached() {
+					return ErrMediaNotAvailable
+				}
+				media.AvatarUserID = id
+				mediaTable.put(dbStructure, media.ID, media)
+				avatar := chirpMediaOf(media)
+				user.Avatar = &avatar
+			}
+		}
+		if profile.DisplayName != nil {
+			user.DisplayName = *profile.DisplayName
+		}
+		if profile.Bio != nil {
+			user.Bio = *profile.Bio
+		}
+		user.UpdatedAt = time.Now().UTC()
+		usersTable.put(dbStructure, id, user)
+		return nil
+	})
 	if err != nil {
 		return User{}, err
 	}
 
-	user, ok := dbStructure.Users[id]
-	if !ok {
-		return User{}, ErrNotExist
+	return user, nil
+}
+
+// releaseAvatar 释放用户的头像，必须在 Update 事务中调用
+func (dbStructure *DBStructure) releaseAvatar(user User) {
+	if user.Avatar == nil {
+		return
+	}
+	media, ok := dbStructure.Media[user.Avatar.ID]
+	if !ok || media.AvatarUserID != user.ID {
+		return
 	}
+	media.AvatarUserID = 0
+	mediaTable.put(dbStructure, media.ID, media)
+}
 
-	user.IsChirpyRed = true
-	dbStructure.Users[id] = user
-	
-	err = db.writeDB(dbStructure)
+// === UpgradeChirpyRed
+func (db *DB) UpgradeChirpyRed(id int) (User, error) {
+	user := User{}
+	err := db.Update(func(dbStructure *DBStructure) error {
+		var ok bool
+		user, ok = dbStructure.Users[id]
+		if !ok {
+			return ErrNotExist
+		}
+
+		user.IsChirpyRed = true
+		user.UpdatedAt = time.Now().UTC()
+		usersTable.put(dbStructure, id, user)
+		return nil
+	})
 	if err != nil {
 		return User{}, err
 	}
 
 	return user, nil
-
 }
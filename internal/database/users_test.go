@@ -0,0 +1,146 @@
+package database
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStoreHandles(t *testing.T) {
+	testStore(t, func(t *testing.T, st *storeHarness) {
+		st.createUser("walt@breakingbad.com")
+		// chirp 中带有作者的 handle
+		if chirp := st.createChirp(1, "Say my name"); chirp.Author.Handle != "walt" {
+			t.Errorf("Author.Handle = %q, want walt", chirp.Author.Handle)
+		}
+		// 被占用的、保留的和纯数字的 handle 会被调整
+		if user := st.createUser("walt@example.com"); user.Handle != "walt2" {
+			t.Errorf("handle = %q, want walt2", user.Handle)
+		}
+		if user := st.createUser("admin@example.com"); user.Handle != "admin2" {
+			t.Errorf("handle = %q, want admin2", user.Handle)
+		}
+		if user := st.createUser("42@example.com"); user.Handle != "user42" {
+			t.Errorf("handle = %q, want user42", user.Handle)
+		}
+		// deleted 加数字是匿名化的账号使用的
+		if user := st.createUser("Deleted7@example.com"); user.Handle != "userDeleted7" {
+			t.Errorf("handle = %q, want userDeleted7", user.Handle)
+		}
+
+		_, err := st.ChangeHandle(1, "WALT2", time.Now())
+		if !errors.Is(err, ErrAlreadyExists) {
+			t.Errorf("ChangeHandle(taken) error = %v, want ErrAlreadyExists", err)
+		}
+		user, err := st.ChangeHandle(1, "heisenberg", time.Now())
+		if err != nil || user.Handle != "heisenberg" || user.HandleChangedAt == nil {
+			t.Fatalf("ChangeHandle = %+v, %v", user, err)
+		}
+		_, err = st.ChangeHandle(1, "walter", time.Now().Add(-time.Hour))
+		if !errors.Is(err, ErrHandleChangeTooSoon) {
+			t.Errorf("ChangeHandle(too soon) error = %v, want ErrHandleChangeTooSoon", err)
+		}
+
+		st.reopen()
+		users, err := st.GetUsersByHandle([]string{"Heisenberg", "walt", "nobody"})
+		if err != nil || len(users) != 1 || users["heisenberg"].ID != 1 {
+			t.Errorf("GetUsersByHandle = %+v, %v", users, err)
+		}
+		// 旧的 handle 被释放
+		if user := st.createUser("walt@albuquerque.com"); user.Handle != "walt" {
+			t.Errorf("handle = %q, want walt", user.Handle)
+		}
+	})
+}
+
+func TestStoreUpdateProfile(t *testing.T) {
+	testStore(t, func(t *testing.T, st *storeHarness) {
+		st.createUser("walt@breakingbad.com")
+		st.createUser("jesse@breakingbad.com")
+		for _, userID := range []int{1, 1, 2} {
+			_, err := st.CreateMedia(Media{UserID: userID, Hash: strings.Repeat("a", 64), ContentType: "image/png", Size: 10, Width: 1, Height: 1})
+			if err != nil {
+				t.Fatalf("CreateMedia: %v", err)
+			}
+		}
+		displayName, bio, avatarID := "Heisenberg", "Chemistry teacher", 1
+
+		// 只修改 profile 中不为 nil 的字段，两次修改不同字段不会覆盖彼此
+		_, err := st.UpdateProfile(1, UserProfile{DisplayName: &displayName})
+		if err != nil {
+			t.Fatalf("UpdateProfile(display name): %v", err)
+		}
+		user, err := st.UpdateProfile(1, UserProfile{Bio: &bio, AvatarID: &avatarID})
+		if err != nil || user.DisplayName != displayName || user.Bio != bio || user.Avatar == nil || user.Avatar.ID != 1 {
+			t.Fatalf("UpdateProfile(bio, avatar) = %+v, %v", user, err)
+		}
+		user, err = st.UpdateProfile(1, UserProfile{})
+		if err != nil || user.DisplayName != displayName || user.Bio != bio || user.Avatar == nil {
+			t.Errorf("UpdateProfile(nothing) = %+v, %v", user, err)
+		}
+
+		// 头像必须是自己上传的、没有被使用的媒体
+		for _, id := range []int{1, 99} {
+			_, err = st.UpdateProfile(2, UserProfile{AvatarID: &id})
+			if !errors.Is(err, ErrMediaNotAvailable) {
+				t.Errorf("UpdateProfile(avatar %d) error = %v, want ErrMediaNotAvailable", id, err)
+			}
+		}
+		_, err = st.UpdateProfile(99, UserProfile{Bio: &bio})
+		if !errors.Is(err, ErrNotExist) {
+			t.Errorf("UpdateProfile(99) error = %v, want ErrNotExist", err)
+		}
+
+		// 更换头像释放旧的头像，空字符串清除资料
+		avatarID = 2
+		empty := ""
+		_, err = st.UpdateProfile(1, UserProfile{Bio: &empty, AvatarID: &avatarID})
+		if err != nil {
+			t.Fatalf("UpdateProfile(new avatar): %v", err)
+		}
+		st.reopen()
+		user, err = st.GetUser(1)
+		if err != nil || user.DisplayName != displayName || user.Bio != "" || user.Avatar == nil || user.Avatar.ID != 2 {
+			t.Errorf("GetUser after reopen = %+v, %v", user, err)
+		}
+		// 移除头像后，所有的记录都没有被使用，可以被清理
+		avatarID = 0
+		st.UpdateProfile(1, UserProfile{AvatarID: &avatarID})
+		hashes, err := st.PurgeMedia(time.Now().Add(time.Second))
+		if err != nil || len(hashes) != 1 {
+			t.Errorf("PurgeMedia = %v, %v, want 1 hash", hashes, err)
+		}
+		if user, _ := st.GetUser(1); user.Avatar != nil {
+			t.Errorf("Avatar after removal = %+v", user.Avatar)
+		}
+	})
+}
+
+// UpdateUser 只修改 update 中不为 nil 的字段
+func TestStoreUpdateUserPartial(t *testing.T) {
+	testStore(t, func(t *testing.T, st *storeHarness) {
+		st.createUser("walt@breakingbad.com")
+
+		hashedPassword := "new hash"
+		user, err := st.UpdateUser(1, UserUpdate{HashedPassword: &hashedPassword})
+		if err != nil || user.Email != "walt@breakingbad.com" || user.HashedPassword != hashedPassword {
+			t.Fatalf("UpdateUser(password) = %+v, %v", user, err)
+		}
+		email := "heisenberg@breakingbad.com"
+		user, err = st.UpdateUser(1, UserUpdate{Email: &email})
+		if err != nil || user.Email != email || user.HashedPassword != hashedPassword {
+			t.Fatalf("UpdateUser(email) = %+v, %v", user, err)
+		}
+		_, err = st.UpdateUser(99, UserUpdate{Email: &email})
+		if !errors.Is(err, ErrNotExist) {
+			t.Errorf("UpdateUser(99) error = %v, want ErrNotExist", err)
+		}
+
+		st.reopen()
+		user, err = st.GetUser(1)
+		if err != nil || user.Email != email || user.HashedPassword != hashedPassword {
+			t.Errorf("GetUser after reopen = %+v, %v", user, err)
+		}
+	})
+}
@@ -0,0 +1,267 @@
+package database
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// ==== 预写日志（write-ahead log） ====
+/*
+DB 把 DBStructure 常驻内存，每个成功的 Update 事务只把它修改过的记录追加到日志文件（<path>.log），
+日志达到 compactThreshold 条后再压缩：把内存状态整体写成快照（<path>），然后清空日志。
+
+日志的每一行是一个事务（logRecord），一行写入、一次 fsync，所以事务要么整体可见，要么整体丢失。
+崩溃时最后一行可能只写了一半，重放时会忽略它。
+put 和 delete 都是幂等的，快照写完、日志还没清空时崩溃，重放日志也能得到同样的结果。
+*/
+
+const compactThreshold = 1000
+
+const (
+	opPut    = "put"
+	opDelete = "delete"
+)
+
+// logRecord 是日志中的一行，对应一个 Update 事务
+type logRecord struct {
+	Changes []logEntry `json:"changes"`
+}
+
+// logEntry 是对某个集合中一条记录的修改
+type logEntry struct {
+	Op         string          `json:"op"`
+	Collection string          `json:"collection"`
+	Key        json.RawMessage `json:"key"`
+	Value      json.RawMessage `json:"value,omitempty"`
+}
+
+// change 是事务中尚未写入日志的修改，undo 用于事务失败时回滚内存状态
+type change struct {
+	op         string
+	collection string
+	key        any
+	value      any
+	undo       func()
+}
+
+// table 描述 DBStructure 中的一个集合（map[K]V），所有修改都必须通过 table 的 put/delete 进行，
+// 否则修改不会写入日志，重启后会丢失，内存中的索引也不会更新。
+type table[K comparable, V any] struct {
+	name string
+	m    func(*DBStructure) *map[K]V
+	// index 可选，在记录变化时维护内存中的二级索引（见 index.go）。
+	// 新增记录时 old 为 nil，删除记录时 new 为 nil。
+	index func(dbStructure *DBStructure, key K, old, new *V)
+}
+
+// collection 让日志重放可以按名字找到集合，而不用关心它的键值类型
+type collection interface {
+	init(dbStructure *DBStructure)
+	reindex(dbStructure *DBStructure)
+	apply(dbStructure *DBStructure, entry logEntry) error
+}
+
+// collections 按日志中的集合名注册所有集合
+var collections = map[string]collection{
+	chirpsTable.name:          chirpsTable,
+	chirpRevisionsTable.name:  chirpRevisionsTable,
+	usersTable.name:           usersTable,
+	refreshTokensTable.name:   refreshTokensTable,
+	likesTable.name:           likesTable,
+	followsTable.name:         followsTable,
+	notificationsTable.name:   notificationsTable,
+	mediaTable.name:           mediaTable,
+	pollVotesTable.name:       pollVotesTable,
+	scheduledChirpsTable.name: scheduledChirpsTable,
+	userTokensTable.name:      userTokensTable,
+	sequencesTable.name:       sequencesTable,
+}
+
+// set 修改 map 中的一条记录并更新索引，new 为 nil 时删除记录
+func (t table[K, V]) set(dbStructure *DBStructure, key K, old, new *V) {
+	m := *t.m(dbStructure)
+	if new != nil {
+		m[key] = *new
+	} else {
+		delete(m, key)
+	}
+	if t.index != nil {
+		t.index(dbStructure, key, old, new)
+	}
+}
+
+func (t table[K, V]) put(dbStructure *DBStructure, key K, value V) {
+	var old *V
+	if v, ok := (*t.m(dbStructure))[key]; ok {
+		old = &v
+	}
+	t.set(dbStructure, key, old, &value)
+	dbStructure.changes = append(dbStructure.changes, change{
+		op:         opPut,
+		collection: t.name,
+		key:        key,
+		value:      value,
+		undo: func() {
+			t.set(dbStructure, key, &value, old)
+		},
+	})
+}
+
+func (t table[K, V]) delete(dbStructure *DBStructure, key K) {
+	old, existed := (*t.m(dbStructure))[key]
+	if !existed {
+		return
+	}
+	t.set(dbStructure, key, &old, nil)
+	dbStructure.changes = append(dbStructure.changes, change{
+		op:         opDelete,
+		collection: t.name,
+		key:        key,
+		undo: func() {
+			t.set(dbStructure, key, nil, &old)
+		},
+	})
+}
+
+// init 确保集合的 map 不为 nil（旧的数据库文件可能没有这个集合）
+func (t table[K, V]) init(dbStructure *DBStructure) {
+	m := t.m(dbStructure)
+	if *m == nil {
+		*m = map[K]V{}
+	}
+}
+
+// reindex 用集合中的全部记录重建索引
+func (t table[K, V]) reindex(dbStructure *DBStructure) {
+	if t.index == nil {
+		return
+	}
+	for key, value := range *t.m(dbStructure) {
+		t.index(dbStructure, key, nil, &value)
+	}
+}
+
+func (t table[K, V]) apply(dbStructure *DBStructure, entry logEntry) error {
+	var key K
+	err := json.Unmarshal(entry.Key, &key)
+	if err != nil {
+		return err
+	}
+
+	var old *V
+	if v, ok := (*t.m(dbStructure))[key]; ok {
+		old = &v
+	}
+	switch entry.Op {
+	case opPut:
+		var value V
+		err = json.Unmarshal(entry.Value, &value)
+		if err != nil {
+			return err
+		}
+		t.set(dbStructure, key, old, &value)
+	case opDelete:
+		if old != nil {
+			t.set(dbStructure, key, old, nil)
+		}
+	default:
+		return fmt.Errorf("unknown log op: %q", entry.Op)
+	}
+	return nil
+}
+
+// initCollections 初始化所有集合，并重建内存索引
+func (dbStructure *DBStructure) initCollections() {
+	for _, c := range collections {
+		c.init(dbStructure)
+	}
+	dbStructure.idx = newIndexes()
+	for _, c := range collections {
+		c.reindex(dbStructure)
+	}
+}
+
+// rollback 按相反顺序撤销事务中的修改
+func (dbStructure *DBStructure) rollback() {
+	for i := len(dbStructure.changes) - 1; i >= 0; i-- {
+		dbStructure.changes[i].undo()
+	}
+	dbStructure.changes = nil
+}
+
+// encodeChanges 把事务中的修改编码为一行日志（包含结尾的换行符）
+func encodeChanges(changes []change) ([]byte, error) {
+	record := logRecord{
+		Changes: make([]logEntry, 0, len(changes)),
+	}
+	for _, c := range changes {
+		key, err := json.Marshal(c.key)
+		if err != nil {
+			return nil, err
+		}
+		entry := logEntry{
+			Op:         c.op,
+			Collection: c.collection,
+			Key:        key,
+		}
+		if c.op == opPut {
+			entry.Value, err = json.Marshal(c.value)
+			if err != nil {
+				return nil, err
+			}
+		}
+		record.Changes = append(record.Changes, entry)
+	}
+
+	dat, err := json.Marshal(record)
+	if err != nil {
+		return nil, err
+	}
+	return append(dat, '\n'), nil
+}
+
+// replayLog 把日志中的事务按顺序应用到 dbStructure，返回应用的事务数
+func replayLog(path string, dbStructure *DBStructure) (int, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	n := 0
+	reader := bufio.NewReader(f)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			// 没有换行符结尾的行是崩溃时写了一半的事务，忽略它
+			return n, nil
+		}
+		if err != nil {
+			return n, err
+		}
+
+		record := logRecord{}
+		err = json.Unmarshal(line, &record)
+		if err != nil {
+			return n, fmt.Errorf("corrupt log record %d: %w", n+1, err)
+		}
+		for _, entry := range record.Changes {
+			c, ok := collections[entry.Collection]
+			if !ok {
+				return n, fmt.Errorf("unknown log collection: %q", entry.Collection)
+			}
+			err = c.apply(dbStructure, entry)
+			if err != nil {
+				return n, err
+			}
+		}
+		n++
+	}
+}
@@ -0,0 +1,169 @@
+package entities
+
+import (
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// ==== 实体提取 ====
+/*
+Parse 从 chirp 正文中提取 #话题 和 @提及：
+- 符号前面必须是正文开头或非单词字符，所以邮箱地址（a@b.c）和 a#b 不会被识别，
+  紧跟在实体后面的符号也不会：#foo#bar 只有 #foo，@a@b 只有 @a。
+- 话题由字母、数字和下划线组成，至少包含一个字母（#1 不是话题），不区分大小写。
+- 提及的是用户的 handle，只能由 ASCII 字母、数字和下划线组成，最长 MaxHandleLength 个字符，
+  更长的不会被识别（而不是截断）。
+提取出的提及不一定对应存在的用户，由调用方解析。
+*/
+
+// MaxHandleLength 是 handle 的最大长度
+const MaxHandleLength = 15
+
+// Type 是实体的类型
+type Type string
+
+const (
+	TypeHashtag Type = "hashtag"
+	TypeMention Type = "mention"
+)
+
+// Entity 是正文中的一个实体，位置包括开头的 # 或 @
+type Entity struct {
+	Type Type
+	// 规范化的文本，不包括 # 或 @：话题是小写形式，提及是正文中的 handle
+	Text string
+	// 在正文中的字节位置 [Start, End) 和字符（rune）位置 [RuneStart, RuneEnd)
+	Start, End         int
+	RuneStart, RuneEnd int
+}
+
+func Parse(body string) []Entity {
+	entities := []Entity{}
+	prev := ' '
+	runeIndex := 0
+	for i := 0; i < len(body); {
+		r, size := utf8.DecodeRuneInString(body[i:])
+		if (r == '#' || r == '@') && !isWordRune(prev) {
+			if entity, ok := parseAt(body, i, runeIndex); ok {
+				entities = append(entities, entity)
+				// 下一个符号前面是实体的最后一个字符，而不是这个实体的符号
+				prev, _ = utf8.DecodeLastRuneInString(body[:entity.End])
+				i = entity.End
+				runeIndex = entity.RuneEnd
+				continue
+			}
+		}
+		prev = r
+		i += size
+		runeIndex++
+	}
+	return entities
+}
+
+// parseAt 尝试从 body[start] 处的 # 或 @ 开始解析一个实体，runeStart 是它的字符位置
+func parseAt(body string, start, runeStart int) (Entity, bool) {
+	sigil := body[start]
+	end := start + 1
+	runes := 0
+	hasLetter := false
+	for end < len(body) {
+		r, size := utf8.DecodeRuneInString(body[end:])
+		if sigil == '@' && !isHandleRune(r) || sigil == '#' && !isWordRune(r) {
+			break
+		}
+		hasLetter = hasLetter || unicode.IsLetter(r)
+		end += size
+		runes++
+	}
+
+	text := body[start+1 : end]
+	entity := Entity{
+		Start:     start,
+		End:       end,
+		RuneStart: runeStart,
+		RuneEnd:   runeStart + 1 + runes,
+	}
+	switch sigil {
+	case '#':
+		if !hasLetter {
+			return Entity{}, false
+		}
+		entity.Type = TypeHashtag
+		entity.Text = NormalizeTag(text)
+	case '@':
+		if runes == 0 || runes > MaxHandleLength {
+			return Entity{}, false
+		}
+		entity.Type = TypeMention
+		entity.Text = text
+	}
+	return entity, true
+}
+
+// NormalizeTag 返回话题的规范形式（小写，不包括开头的 #），用于存储和查询
+func NormalizeTag(tag string) string {
+	return strings.ToLower(strings.TrimPrefix(tag, "#"))
+}
+
+// ValidTag 判断 tag（不包括 #）是否是一个有效的话题
+func ValidTag(tag string) bool {
+	entities := Parse("#" + tag)
+	return len(entities) == 1 && entities[0].End == len(tag)+1
+}
+
+// ValidHandle 判断 handle（不包括 @）是否是一个有效的 handle
+func ValidHandle(handle string) bool {
+	if handle == "" || len(handle) > MaxHandleLength {
+		return false
+	}
+	for _, r := range handle {
+		if !isHandleRune(r) {
+			return false
+		}
+	}
+	return true
+}
+
+// 保留的 handle，不能被注册或修改为这些 handle（不区分大小写）。
+// 其中一部分是 /api/users/ 下的路径，其余的容易被用来冒充站点或管理员。
+var reservedHandles = map[string]bool{
+	"me": true, "handle": true, "profile": true, "settings": true,
+	"admin": true, "administrator": true, "root": true, "system": true,
+	"support": true, "help": true, "staff": true, "moderator": true, "official": true,
+	"chirpy": true, "api": true, "everyone": true, "here": true, "null": true, "undefined": true,
+	"deleted": true,
+}
+
+// 匿名化的账号的 handle 前缀，后面是用户 ID
+const anonymousHandlePrefix = "deleted"
+
+// AnonymousHandle 返回 ID 为 id 的匿名化账号的 handle，例如 deleted42。
+// deleted 加数字的 handle 都是保留的，所以不会和用户的 handle 冲突。
+func AnonymousHandle(id int) string {
+	return anonymousHandlePrefix + strconv.Itoa(id)
+}
+
+// ReservedHandle 判断 handle 是否被保留：reservedHandles 中的，以及 ReservedHandleBase 中的
+func ReservedHandle(handle string) bool {
+	return reservedHandles[strings.ToLower(handle)] || ReservedHandleBase(handle)
+}
+
+// ReservedHandleBase 判断 handle 是否属于加上数字后缀之后仍然保留的一类：
+// 纯数字的（以免和用户 ID 混淆），以及 deleted 加数字的（匿名化的账号，见 AnonymousHandle）。
+func ReservedHandleBase(handle string) bool {
+	if handle != "" && strings.Trim(handle, "0123456789") == "" {
+		return true
+	}
+	rest, ok := strings.CutPrefix(strings.ToLower(handle), anonymousHandlePrefix)
+	return ok && strings.Trim(rest, "0123456789") == ""
+}
+
+func isWordRune(r rune) bool {
+	return r == '_' || unicode.IsLetter(r) || unicode.IsNumber(r) || unicode.Is(unicode.Mn, r)
+}
+
+func isHandleRune(r rune) bool {
+	return r == '_' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9'
+}
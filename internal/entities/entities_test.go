@@ -0,0 +1,85 @@
+package entities
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want []Entity
+	}{
+		{"empty", "", []Entity{}},
+		{"hashtag and mention", "#Go @walt", []Entity{
+			{Type: TypeHashtag, Text: "go", Start: 0, End: 3, RuneStart: 0, RuneEnd: 3},
+			{Type: TypeMention, Text: "walt", Start: 4, End: 9, RuneStart: 4, RuneEnd: 9},
+		}},
+		{"punctuation", "(#go), @walt!", []Entity{
+			{Type: TypeHashtag, Text: "go", Start: 1, End: 4, RuneStart: 1, RuneEnd: 4},
+			{Type: TypeMention, Text: "walt", Start: 7, End: 12, RuneStart: 7, RuneEnd: 12},
+		}},
+		// 紧跟在实体后面的符号前面是单词字符
+		{"adjacent hashtags", "#foo#bar", []Entity{
+			{Type: TypeHashtag, Text: "foo", Start: 0, End: 4, RuneStart: 0, RuneEnd: 4},
+		}},
+		{"adjacent mentions", "@a@b", []Entity{
+			{Type: TypeMention, Text: "a", Start: 0, End: 2, RuneStart: 0, RuneEnd: 2},
+		}},
+		{"mention then hashtag", "@walt#go", []Entity{
+			{Type: TypeMention, Text: "walt", Start: 0, End: 5, RuneStart: 0, RuneEnd: 5},
+		}},
+		{"separated", "#foo #bar", []Entity{
+			{Type: TypeHashtag, Text: "foo", Start: 0, End: 4, RuneStart: 0, RuneEnd: 4},
+			{Type: TypeHashtag, Text: "bar", Start: 5, End: 9, RuneStart: 5, RuneEnd: 9},
+		}},
+		{"email", "mail a@b.com", []Entity{}},
+		{"email after mention", "@walt a@b.com", []Entity{
+			{Type: TypeMention, Text: "walt", Start: 0, End: 5, RuneStart: 0, RuneEnd: 5},
+		}},
+		{"inside word", "a#b", []Entity{}},
+		{"number tag", "#1 #2024", []Entity{}},
+		{"empty sigils", "# @ #", []Entity{}},
+		{"handle too long", "@abcdefghijklmnop", []Entity{}},
+		// 提及只能是 ASCII，后面的中文不属于 handle
+		{"multibyte", "你好 #世界 @walt说", []Entity{
+			{Type: TypeHashtag, Text: "世界", Start: 7, End: 14, RuneStart: 3, RuneEnd: 6},
+			{Type: TypeMention, Text: "walt", Start: 15, End: 20, RuneStart: 7, RuneEnd: 12},
+		}},
+		{"multibyte before sigil", "你好#世界", []Entity{}},
+		{"emoji", "🐦#Chirpy 🐦 #chirpy", []Entity{
+			{Type: TypeHashtag, Text: "chirpy", Start: 4, End: 11, RuneStart: 1, RuneEnd: 8},
+			{Type: TypeHashtag, Text: "chirpy", Start: 17, End: 24, RuneStart: 11, RuneEnd: 18},
+		}},
+		{"multibyte adjacent hashtags", "#世界#你好", []Entity{
+			{Type: TypeHashtag, Text: "世界", Start: 0, End: 7, RuneStart: 0, RuneEnd: 3},
+		}},
+	}
+	for _, tt := range tests {
+		got := Parse(tt.body)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: Parse(%q) = %+v, want %+v", tt.name, tt.body, got, tt.want)
+		}
+	}
+}
+
+func TestValidTag(t *testing.T) {
+	tests := []struct {
+		tag  string
+		want bool
+	}{
+		{"go", true},
+		{"Go_lang", true},
+		{"世界", true},
+		{"2024", false},
+		{"", false},
+		{"foo#bar", false},
+		{"foo bar", false},
+	}
+	for _, tt := range tests {
+		if got := ValidTag(tt.tag); got != tt.want {
+			t.Errorf("ValidTag(%q) = %v, want %v", tt.tag, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package mailer
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/smtp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrInvalidHeader 表示收件人或主题中包含换行，可能被用来注入邮件头
+var ErrInvalidHeader = errors.New("invalid header value")
+
+// Message 是一封纯文本邮件
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer 发送邮件。生产环境使用 SMTPMailer，本地开发和测试使用 LogMailer。
+type Mailer interface {
+	Send(msg Message) error
+}
+
+// format 把邮件编码为 RFC 5322 格式，主题按 RFC 2047 编码，所以可以包含非 ASCII 字符
+func format(from string, msg Message) ([]byte, error) {
+	if strings.ContainsAny(msg.To, "\r\n") || strings.ContainsAny(msg.Subject, "\r\n") {
+		return nil, ErrInvalidHeader
+	}
+
+	b := &strings.Builder{}
+	fmt.Fprintf(b, "From: %s\r\n", from)
+	fmt.Fprintf(b, "To: %s\r\n", msg.To)
+	fmt.Fprintf(b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", msg.Subject))
+	fmt.Fprintf(b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(strings.ReplaceAll(msg.Body, "\n", "\r\n"))
+	return []byte(b.String()), nil
+}
+
+// ==== SMTP ====
+/*
+SMTPMailer 通过 SMTP 服务器发送邮件。
+服务器支持 STARTTLS 时 smtp.SendMail 会自动使用；设置了用户名时使用 PLAIN 认证，
+net/smtp 只允许在 TLS 连接或 localhost 上使用 PLAIN 认证。
+*/
+type SMTPMailer struct {
+	addr string // host:port
+	from string
+	auth smtp.Auth
+}
+
+func NewSMTPMailer(addr, from, username, password string) (*SMTPMailer, error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	m := &SMTPMailer{addr: addr, from: from}
+	if username != "" {
+		m.auth = smtp.PlainAuth("", username, password, host)
+	}
+	return m, nil
+}
+
+func (m *SMTPMailer) Send(msg Message) error {
+	dat, err := format(m.from, msg)
+	if err != nil {
+		return err
+	}
+	return smtp.SendMail(m.addr, m.auth, m.from, []string{msg.To}, dat)
+}
+
+// ==== 日志 ====
+/*
+LogMailer 不发送邮件，而是把邮件写到 w（例如日志文件或标准错误），用于本地开发和测试：
+邮件中的验证码可以直接从文件中读取。
+*/
+type LogMailer struct {
+	from string
+	mu   *sync.Mutex
+	w    io.Writer
+}
+
+func NewLogMailer(from string, w io.Writer) *LogMailer {
+	return &LogMailer{from: from, mu: &sync.Mutex{}, w: w}
+}
+
+func (m *LogMailer) Send(msg Message) error {
+	dat, err := format(m.from, msg)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, err = fmt.Fprintf(m.w, "==== mail ====\n%s\n==== end of mail ====\n", strings.ReplaceAll(string(dat), "\r\n", "\n"))
+	return err
+}
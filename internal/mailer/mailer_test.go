@@ -0,0 +1,56 @@
+package mailer
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestLogMailer(t *testing.T) {
+	var b strings.Builder
+	m := NewLogMailer("chirpy@localhost", &b)
+	err := m.Send(Message{
+		To:      "walt@breakingbad.com",
+		Subject: "Vérifiez votre adresse",
+		Body:    "line 1\nline 2\n",
+	})
+	if err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+
+	got := b.String()
+	for _, want := range []string{
+		"From: chirpy@localhost\n",
+		"To: walt@breakingbad.com\n",
+		// 非 ASCII 的主题按 RFC 2047 编码
+		"Subject: =?utf-8?q?V=C3=A9rifiez_votre_adresse?=\n",
+		"Content-Type: text/plain; charset=utf-8\n",
+		"\nline 1\nline 2\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("mail does not contain %q:\n%s", want, got)
+		}
+	}
+}
+
+func TestFormat(t *testing.T) {
+	dat, err := format("chirpy@localhost", Message{To: "walt@breakingbad.com", Subject: "Hi", Body: "a\nb"})
+	if err != nil {
+		t.Fatalf("format: %v", err)
+	}
+	// 邮件使用 CRLF 换行
+	if !strings.HasSuffix(string(dat), "\r\n\r\na\r\nb") {
+		t.Errorf("format = %q", dat)
+	}
+
+	// 收件人和主题中的换行可能被用来注入邮件头
+	for _, msg := range []Message{
+		{To: "walt@breakingbad.com\r\nBcc: jesse@breakingbad.com", Subject: "Hi"},
+		{To: "walt@breakingbad.com", Subject: "Hi\nBcc: jesse@breakingbad.com"},
+	} {
+		_, err := format("chirpy@localhost", msg)
+		if !errors.Is(err, ErrInvalidHeader) {
+			t.Errorf("format(%q, %q) error = %v, want ErrInvalidHeader", msg.To, msg.Subject, err)
+		}
+	}
+}
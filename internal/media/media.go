@@ -0,0 +1,220 @@
+package media
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif" // 注册解码器，用于 image.DecodeConfig
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+var ErrTooLarge = errors.New("file is too large")
+var ErrUnsupportedType = errors.New("unsupported media type")
+var ErrInvalidImage = errors.New("invalid image")
+
+// AllowedTypes 是允许上传的类型，都可以用标准库读取宽高
+var AllowedTypes = map[string]bool{
+	"image/png":  true,
+	"image/jpeg": true,
+	"image/gif":  true,
+}
+
+// MaxDimension 是图片宽和高的上限
+const MaxDimension = 8192
+
+// Blob 是保存在磁盘上的一个文件
+type Blob struct {
+	Hash        string // 内容的 SHA-256，十六进制小写
+	ContentType string // 嗅探出的类型
+	Size        int64
+	Width       int
+	Height      int
+}
+
+// ==== 内容寻址存储 ====
+/*
+Store 把上传的文件按内容的 SHA-256 保存在本地目录中，路径是 <dir>/<hash 前两位>/<hash>，相同内容只保存一份。
+- 类型由内容嗅探（http.DetectContentType）决定，不信任文件名和客户端声明的 Content-Type，
+  只接受 AllowedTypes 中的图片，并用 image.DecodeConfig 读取宽高（只读文件头，不解码像素）。
+- 上传先写入临时文件，校验通过后再重命名，读取方不会看到写了一半的文件。
+- 文件是否还被引用由数据库决定。Put 的 commit 和 Collect 的 unused 在同一把锁内执行，
+  所以清理时不会删掉一个刚刚被重新上传、正在创建记录的文件。
+*/
+type Store struct {
+	dir     string
+	maxSize int64
+	mu      *sync.Mutex
+}
+
+// NewStore 返回保存在 dir 中、单个文件最大 maxSize 字节的 Store，dir 不存在时创建它
+func NewStore(dir string, maxSize int64) (*Store, error) {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, err
+	}
+	return &Store{
+		dir:     dir,
+		maxSize: maxSize,
+		mu:      &sync.Mutex{},
+	}, nil
+}
+
+// MaxSize 返回单个文件的大小上限
+func (s *Store) MaxSize() int64 {
+	return s.maxSize
+}
+
+// ValidHash 判断 hash 是否是一个 SHA-256 的十六进制小写表示
+func ValidHash(hash string) bool {
+	if len(hash) != sha256.Size*2 {
+		return false
+	}
+	for _, c := range hash {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *Store) path(hash string) string {
+	return filepath.Join(s.dir, hash[:2], hash)
+}
+
+// ==== 保存文件 ====
+/*
+Put 读取 r 并保存：
+1) 写入临时文件，同时计算 SHA-256，超过大小上限时返回 ErrTooLarge。
+2) 嗅探类型，不在 AllowedTypes 中时返回 ErrUnsupportedType；读取宽高，失败或超过 MaxDimension 时返回 ErrInvalidImage。
+3) 加锁后把临时文件重命名为最终路径，然后调用 commit（通常是创建数据库记录）。
+   commit 返回错误时，如果文件是这次新建的，会把它删除。
+*/
+func (s *Store) Put(r io.Reader, commit func(Blob) error) (Blob, error) {
+	tmp, err := os.CreateTemp(s.dir, ".upload-*")
+	if err != nil {
+		return Blob{}, err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	h := sha256.New()
+	size, err := io.Copy(io.MultiWriter(tmp, h), io.LimitReader(r, s.maxSize+1))
+	if err != nil {
+		return Blob{}, err
+	}
+	if size > s.maxSize {
+		return Blob{}, ErrTooLarge
+	}
+
+	head := make([]byte, 512)
+	n, err := tmp.ReadAt(head, 0)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return Blob{}, err
+	}
+	blob := Blob{
+		Hash:        hex.EncodeToString(h.Sum(nil)),
+		ContentType: http.DetectContentType(head[:n]),
+		Size:        size,
+	}
+	if !AllowedTypes[blob.ContentType] {
+		return Blob{}, ErrUnsupportedType
+	}
+
+	_, err = tmp.Seek(0, io.SeekStart)
+	if err != nil {
+		return Blob{}, err
+	}
+	config, _, err := image.DecodeConfig(tmp)
+	if err != nil {
+		return Blob{}, fmt.Errorf("%w: %s", ErrInvalidImage, err)
+	}
+	if config.Width <= 0 || config.Height <= 0 || config.Width > MaxDimension || config.Height > MaxDimension {
+		return Blob{}, fmt.Errorf("%w: %dx%d", ErrInvalidImage, config.Width, config.Height)
+	}
+	blob.Width = config.Width
+	blob.Height = config.Height
+
+	err = tmp.Sync()
+	if err != nil {
+		return Blob{}, err
+	}
+	err = tmp.Close()
+	if err != nil {
+		return Blob{}, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	path := s.path(blob.Hash)
+	_, err = os.Stat(path)
+	created := errors.Is(err, os.ErrNotExist)
+	if created {
+		err = os.MkdirAll(filepath.Dir(path), 0755)
+		if err != nil {
+			return Blob{}, err
+		}
+		err = os.Rename(tmp.Name(), path)
+		if err != nil {
+			return Blob{}, err
+		}
+	} else if err != nil {
+		return Blob{}, err
+	}
+
+	err = commit(blob)
+	if err != nil {
+		if created {
+			os.Remove(path)
+		}
+		return Blob{}, err
+	}
+	return blob, nil
+}
+
+// Open 打开 hash 对应的文件，hash 不合法或文件不存在时返回 os.ErrNotExist
+func (s *Store) Open(hash string) (*os.File, error) {
+	if !ValidHash(hash) {
+		return nil, os.ErrNotExist
+	}
+	return os.Open(s.path(hash))
+}
+
+// ==== 清理文件 ====
+/*
+Collect 在锁内调用 unused（通常是删除数据库中的孤儿记录），然后删除它返回的、已经没有记录引用的文件，
+返回删除的文件数量。
+*/
+func (s *Store) Collect(unused func() ([]string, error)) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	hashes, err := unused()
+	if err != nil {
+		return 0, err
+	}
+
+	removed := 0
+	for _, hash := range hashes {
+		if !ValidHash(hash) {
+			continue
+		}
+		err := os.Remove(s.path(hash))
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return removed, err
+		}
+		removed++
+	}
+	return removed, nil
+}
@@ -0,0 +1,300 @@
+package media
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/png"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// pngOf 返回一个 width x height 的 PNG 文件
+func pngOf(t *testing.T, width, height int) []byte {
+	t.Helper()
+	var b bytes.Buffer
+	img := image.NewGray(image.Rect(0, 0, width, height))
+	img.SetGray(0, 0, color.Gray{Y: 255})
+	err := png.Encode(&b, img)
+	if err != nil {
+		t.Fatalf("png.Encode: %v", err)
+	}
+	return b.Bytes()
+}
+
+// gifOf 返回一个 width x height 的 GIF 文件
+func gifOf(t *testing.T, width, height int) []byte {
+	t.Helper()
+	var b bytes.Buffer
+	img := image.NewPaletted(image.Rect(0, 0, width, height), color.Palette{color.Black, color.White})
+	err := gif.Encode(&b, img, nil)
+	if err != nil {
+		t.Fatalf("gif.Encode: %v", err)
+	}
+	return b.Bytes()
+}
+
+func newTestStore(t *testing.T, maxSize int64) *Store {
+	t.Helper()
+	s, err := NewStore(filepath.Join(t.TempDir(), "media"), maxSize)
+	if err != nil {
+		t.Fatalf("NewStore: %v", err)
+	}
+	return s
+}
+
+func accept(Blob) error { return nil }
+
+// stored 判断 hash 对应的文件是否存在
+func stored(s *Store, hash string) bool {
+	f, err := s.Open(hash)
+	if err != nil {
+		return false
+	}
+	f.Close()
+	return true
+}
+
+// leftovers 返回存储目录中残留的临时文件
+func leftovers(t *testing.T, s *Store) []string {
+	t.Helper()
+	tmps, err := filepath.Glob(filepath.Join(s.dir, ".upload-*"))
+	if err != nil {
+		t.Fatalf("Glob: %v", err)
+	}
+	return tmps
+}
+
+func TestPut(t *testing.T) {
+	s := newTestStore(t, 1<<20)
+	tests := []struct {
+		name        string
+		data        []byte
+		contentType string
+		width       int
+		height      int
+	}{
+		{"png", pngOf(t, 3, 2), "image/png", 3, 2},
+		{"gif", gifOf(t, 4, 5), "image/gif", 4, 5},
+	}
+	for _, tt := range tests {
+		var committed Blob
+		blob, err := s.Put(bytes.NewReader(tt.data), func(b Blob) error {
+			committed = b
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("%s: Put: %v", tt.name, err)
+		}
+		if blob.ContentType != tt.contentType || blob.Width != tt.width || blob.Height != tt.height || blob.Size != int64(len(tt.data)) {
+			t.Errorf("%s: Put = %+v", tt.name, blob)
+		}
+		if committed != blob || !ValidHash(blob.Hash) {
+			t.Errorf("%s: committed %+v, returned %+v", tt.name, committed, blob)
+		}
+
+		f, err := s.Open(blob.Hash)
+		if err != nil {
+			t.Fatalf("%s: Open: %v", tt.name, err)
+		}
+		got, _ := io.ReadAll(f)
+		f.Close()
+		if !bytes.Equal(got, tt.data) {
+			t.Errorf("%s: stored content differs from the upload", tt.name)
+		}
+	}
+	if tmps := leftovers(t, s); len(tmps) != 0 {
+		t.Errorf("temporary files left behind: %v", tmps)
+	}
+}
+
+func TestPutRejected(t *testing.T) {
+	s := newTestStore(t, 1<<20)
+	tests := []struct {
+		name string
+		data []byte
+		err  error
+	}{
+		// 类型由内容决定，文本和 HTML 不被接受
+		{"text", []byte("hello, world"), ErrUnsupportedType},
+		{"html", []byte("<html><body>x</body></html>"), ErrUnsupportedType},
+		{"empty", nil, ErrUnsupportedType},
+		// 文件头像 PNG，但内容无法解析
+		{"truncated png", pngOf(t, 3, 2)[:20], ErrInvalidImage},
+		{"too wide", pngOf(t, MaxDimension+1, 1), ErrInvalidImage},
+		{"too tall", gifOf(t, 1, MaxDimension+1), ErrInvalidImage},
+	}
+	for _, tt := range tests {
+		committed := false
+		_, err := s.Put(bytes.NewReader(tt.data), func(Blob) error {
+			committed = true
+			return nil
+		})
+		if !errors.Is(err, tt.err) {
+			t.Errorf("%s: Put error = %v, want %v", tt.name, err, tt.err)
+		}
+		if committed {
+			t.Errorf("%s: commit was called", tt.name)
+		}
+	}
+
+	// 最大尺寸本身是允许的
+	_, err := s.Put(bytes.NewReader(pngOf(t, MaxDimension, 1)), accept)
+	if err != nil {
+		t.Errorf("Put(%dx1) error = %v", MaxDimension, err)
+	}
+	if tmps := leftovers(t, s); len(tmps) != 0 {
+		t.Errorf("temporary files left behind: %v", tmps)
+	}
+}
+
+func TestPutMaxSize(t *testing.T) {
+	data := pngOf(t, 3, 2)
+	size := int64(len(data))
+
+	// 刚好等于上限时允许
+	s := newTestStore(t, size)
+	_, err := s.Put(bytes.NewReader(data), accept)
+	if err != nil {
+		t.Errorf("Put(maxSize bytes) error = %v", err)
+	}
+
+	// 多一个字节时拒绝
+	s = newTestStore(t, size-1)
+	_, err = s.Put(bytes.NewReader(data), accept)
+	if !errors.Is(err, ErrTooLarge) {
+		t.Errorf("Put(maxSize+1 bytes) error = %v, want ErrTooLarge", err)
+	}
+	// 不会读取超过上限 + 1 个字节
+	r := &countingReader{r: io.MultiReader(bytes.NewReader(data), strings.NewReader(strings.Repeat("x", 1<<20)))}
+	_, err = s.Put(r, accept)
+	if !errors.Is(err, ErrTooLarge) || r.n != size {
+		t.Errorf("Put(large stream) = %v after reading %d bytes, want ErrTooLarge after %d", err, r.n, size)
+	}
+}
+
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+func TestPutDedup(t *testing.T) {
+	s := newTestStore(t, 1<<20)
+	data := pngOf(t, 3, 2)
+
+	first, err := s.Put(bytes.NewReader(data), accept)
+	if err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	second, err := s.Put(bytes.NewReader(data), accept)
+	if err != nil {
+		t.Fatalf("Put again: %v", err)
+	}
+	if first != second {
+		t.Errorf("Put again = %+v, want %+v", second, first)
+	}
+	// 相同内容只保存一份
+	files, _ := filepath.Glob(filepath.Join(s.dir, "*", "*"))
+	if len(files) != 1 {
+		t.Errorf("stored files = %v, want 1", files)
+	}
+
+	other, err := s.Put(bytes.NewReader(pngOf(t, 2, 3)), accept)
+	if err != nil || other.Hash == first.Hash {
+		t.Errorf("Put(other image) = %+v, %v", other, err)
+	}
+}
+
+func TestPutCommitFailure(t *testing.T) {
+	s := newTestStore(t, 1<<20)
+	data := pngOf(t, 3, 2)
+	errCommit := errors.New("commit failed")
+	fail := func(Blob) error { return errCommit }
+
+	// 这次新建的文件被删除
+	blob, err := s.Put(bytes.NewReader(data), fail)
+	if !errors.Is(err, errCommit) {
+		t.Fatalf("Put error = %v, want %v", err, errCommit)
+	}
+	hash := sha256Hex(data)
+	if stored(s, hash) {
+		t.Error("file of a failed commit was kept")
+	}
+	if blob != (Blob{}) {
+		t.Errorf("Put = %+v, want zero Blob", blob)
+	}
+
+	// 已经存在的文件属于其他记录，不会被删除
+	_, err = s.Put(bytes.NewReader(data), accept)
+	if err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	_, err = s.Put(bytes.NewReader(data), fail)
+	if !errors.Is(err, errCommit) {
+		t.Fatalf("Put error = %v, want %v", err, errCommit)
+	}
+	if !stored(s, hash) {
+		t.Error("existing file was removed after a failed commit")
+	}
+	if tmps := leftovers(t, s); len(tmps) != 0 {
+		t.Errorf("temporary files left behind: %v", tmps)
+	}
+}
+
+func TestCollect(t *testing.T) {
+	s := newTestStore(t, 1<<20)
+	kept, err := s.Put(bytes.NewReader(pngOf(t, 3, 2)), accept)
+	if err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	unused, err := s.Put(bytes.NewReader(gifOf(t, 3, 2)), accept)
+	if err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+
+	// 不存在的和不合法的哈希被跳过
+	missing := strings.Repeat("0", 64)
+	removed, err := s.Collect(func() ([]string, error) {
+		return []string{unused.Hash, missing, "../../etc/passwd"}, nil
+	})
+	if err != nil || removed != 1 {
+		t.Errorf("Collect = %d, %v, want 1", removed, err)
+	}
+	if stored(s, unused.Hash) || !stored(s, kept.Hash) {
+		t.Error("Collect removed the wrong files")
+	}
+
+	errUnused := errors.New("unused failed")
+	removed, err = s.Collect(func() ([]string, error) { return nil, errUnused })
+	if !errors.Is(err, errUnused) || removed != 0 {
+		t.Errorf("Collect = %d, %v, want %v", removed, err, errUnused)
+	}
+}
+
+func TestOpenInvalidHash(t *testing.T) {
+	s := newTestStore(t, 1<<20)
+	for _, hash := range []string{"", "../media", strings.Repeat("A", 64), strings.Repeat("0", 64)} {
+		_, err := s.Open(hash)
+		if !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("Open(%q) error = %v, want os.ErrNotExist", hash, err)
+		}
+	}
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
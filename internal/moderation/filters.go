@@ -0,0 +1,118 @@
+package moderation
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// mask 是被屏蔽的词的替换文本
+const mask = "****"
+
+// ==== 长度限制 ====
+/*
+LengthFilter 按 rune（Unicode 码点）而不是字节计算长度，
+所以 140 个中文字符或 emoji 和 140 个英文字母一样可以发布。
+*/
+type LengthFilter struct {
+	Max int
+}
+
+func (f LengthFilter) Apply(d *Decision) {
+	if utf8.RuneCountInString(d.Body) > f.Max {
+		d.escalate(ActionReject)
+		d.Reason = "Chirp is too long"
+		d.Matched = append(d.Matched, fmt.Sprintf("length > %d", f.Max))
+	}
+}
+
+// ==== 敏感词 ====
+/*
+WordFilter 把正文切分为词（连续的字母、数字和组合符号），规范化后在词表中查找：
+- 词表中策略为 mask 的词被替换为 "****"，其余文本（包括标点）保持不变，
+  所以 "kerfuffle!" 会变成 "****!"。
+- 策略为 reject 的词会拒绝整条 chirp。
+*/
+type WordFilter struct {
+	Words *WordList
+}
+
+func (f WordFilter) Apply(d *Decision) {
+	var b strings.Builder
+	last := 0
+	for _, span := range wordSpans(d.Body) {
+		word := Normalize(d.Body[span[0]:span[1]])
+		policy, ok := f.Words.Lookup(word)
+		if !ok {
+			continue
+		}
+
+		d.Matched = append(d.Matched, word)
+		if policy == ActionReject {
+			d.escalate(ActionReject)
+			d.Reason = "Chirp contains a banned word"
+			return
+		}
+
+		d.escalate(ActionMask)
+		b.WriteString(d.Body[last:span[0]])
+		b.WriteString(mask)
+		last = span[1]
+	}
+	if last == 0 {
+		return
+	}
+	b.WriteString(d.Body[last:])
+	d.Body = b.String()
+}
+
+// isWordRune 判断 r 是否属于一个词
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsNumber(r) || unicode.Is(unicode.Mn, r)
+}
+
+// wordSpans 返回 text 中每个词的字节区间 [start, end)
+func wordSpans(text string) [][2]int {
+	spans := [][2]int{}
+	start := -1
+	for i, r := range text {
+		if isWordRune(r) {
+			if start < 0 {
+				start = i
+			}
+			continue
+		}
+		if start >= 0 {
+			spans = append(spans, [2]int{start, i})
+			start = -1
+		}
+	}
+	if start >= 0 {
+		spans = append(spans, [2]int{start, len(text)})
+	}
+	return spans
+}
+
+// ==== 规范化 ====
+/*
+Normalize 让外观相同或相近的写法得到同一个词：
+1) NFKD 分解：全角字母、连字等兼容字符变为普通字符，带重音的字母拆成 字母 + 组合符号。
+2) 去掉组合符号（重音等），例如 "kérfüffle" -> "kerfuffle"。
+3) 转为小写。
+4) 去掉首尾的非字母数字字符。
+*/
+func Normalize(word string) string {
+	var b strings.Builder
+	for _, r := range norm.NFKD.String(word) {
+		if unicode.Is(unicode.Mn, r) {
+			continue
+		}
+		b.WriteRune(unicode.ToLower(r))
+	}
+	return strings.TrimFunc(b.String(), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+}
@@ -0,0 +1,121 @@
+package moderation
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// newTestWordList 在临时目录中创建只包含 lines 的词表
+func newTestWordList(t *testing.T, lines ...string) *WordList {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "words.txt")
+	err := os.WriteFile(path, []byte(strings.Join(lines, "\n")), 0600)
+	if err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	wl, err := LoadWordList(path)
+	if err != nil {
+		t.Fatalf("LoadWordList: %v", err)
+	}
+	return wl
+}
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		word string
+		want string
+	}{
+		{"kerfuffle", "kerfuffle"},
+		{"KerFuffle", "kerfuffle"},
+		{"Kérfuffle", "kerfuffle"},
+		{"kérfüffle", "kerfuffle"},
+		// 组合符号形式（e + U+0301）和预组合形式相同
+		{"ke\u0301rfuffle", "kerfuffle"},
+		// 全角字母
+		{"ｋｅｒｆｕｆｆｌｅ", "kerfuffle"},
+		{"ＫＥＲＦＵＦＦＬＥ", "kerfuffle"},
+		{"\"kerfuffle!\"", "kerfuffle"},
+		{"ﬁne", "fine"},
+		{"!!!", ""},
+	}
+	for _, tt := range tests {
+		if got := Normalize(tt.word); got != tt.want {
+			t.Errorf("Normalize(%q) = %q, want %q", tt.word, got, tt.want)
+		}
+	}
+}
+
+func TestWordFilter(t *testing.T) {
+	words := newTestWordList(t, "kerfuffle", "sharbert mask", "fornax reject")
+	pipeline := NewPipeline(WordFilter{Words: words})
+
+	tests := []struct {
+		name    string
+		body    string
+		action  Action
+		want    string
+		matched []string
+	}{
+		{"clean", "This is a clean chirp", ActionAllow, "This is a clean chirp", nil},
+		{"mask", "What a kerfuffle today", ActionMask, "What a **** today", []string{"kerfuffle"}},
+		{"punctuation", "kerfuffle! (sharbert), kerfuffle.", ActionMask, "****! (****), ****.", []string{"kerfuffle", "sharbert", "kerfuffle"}},
+		{"case", "KERFUFFLE and Sharbert", ActionMask, "**** and ****", []string{"kerfuffle", "sharbert"}},
+		{"accent", "Kérfuffle", ActionMask, "****", []string{"kerfuffle"}},
+		{"fullwidth", "ｋｅｒｆｕｆｆｌｅ！", ActionMask, "****！", []string{"kerfuffle"}},
+		{"multibyte neighbours", "你好kerfuffle", ActionAllow, "你好kerfuffle", nil},
+		{"substring", "kerfuffles", ActionAllow, "kerfuffles", nil},
+		{"reject", "kerfuffle and Fornax", ActionReject, "kerfuffle and Fornax", []string{"kerfuffle", "fornax"}},
+	}
+	for _, tt := range tests {
+		d := pipeline.Moderate(tt.body)
+		if d.Action != tt.action || d.Body != tt.want || strings.Join(d.Matched, ",") != strings.Join(tt.matched, ",") {
+			t.Errorf("%s: Moderate(%q) = %s %q %v, want %s %q %v", tt.name, tt.body, d.Action, d.Body, d.Matched, tt.action, tt.want, tt.matched)
+		}
+		if tt.action == ActionReject && d.Reason == "" {
+			t.Errorf("%s: rejected without a reason", tt.name)
+		}
+	}
+}
+
+func TestLengthFilter(t *testing.T) {
+	pipeline := NewPipeline(LengthFilter{Max: 5})
+
+	tests := []struct {
+		body   string
+		action Action
+	}{
+		{"hello", ActionAllow},
+		{"hello!", ActionReject},
+		// 按 rune 计算：5 个中文字符是 15 个字节
+		{"你好世界啊", ActionAllow},
+		{"你好世界啊!", ActionReject},
+		{"🐦🐦🐦🐦🐦", ActionAllow},
+		{"🐦🐦🐦🐦🐦🐦", ActionReject},
+	}
+	for _, tt := range tests {
+		d := pipeline.Moderate(tt.body)
+		if d.Action != tt.action {
+			t.Errorf("Moderate(%q) = %s, want %s", tt.body, d.Action, tt.action)
+		}
+	}
+}
+
+func TestPipelineOrder(t *testing.T) {
+	words := newTestWordList(t, "kerfuffle", "fornax reject")
+
+	// 拒绝之后不再执行后面的 Filter
+	pipeline := NewPipeline(LengthFilter{Max: 10}, WordFilter{Words: words})
+	d := pipeline.Moderate("kerfuffle kerfuffle")
+	if d.Action != ActionReject || d.Body != "kerfuffle kerfuffle" || len(d.Matched) != 1 {
+		t.Errorf("Moderate = %+v, want rejected by length only", d)
+	}
+
+	// 屏蔽之后的 Filter 看到的是屏蔽后的正文，动作不会降低
+	pipeline = NewPipeline(WordFilter{Words: words}, LengthFilter{Max: 10})
+	d = pipeline.Moderate("kerfuffle!")
+	if d.Action != ActionMask || d.Body != "****!" {
+		t.Errorf("Moderate = %+v, want masked", d)
+	}
+}
@@ -0,0 +1,75 @@
+package moderation
+
+// ==== 内容审核管道 ====
+/*
+Pipeline 按顺序执行一组 Filter。每个 Filter 可以：
+- 放行（什么都不做）
+- 屏蔽（Mask）：修改正文，例如把脏话替换为 "****"
+- 拒绝（Reject）：整条 chirp 不允许发布，后面的 Filter 不再执行
+最终的 Decision 取所有 Filter 中最严重的动作。
+*/
+
+// Action 是审核动作，按严重程度递增
+type Action string
+
+const (
+	ActionAllow  Action = "allow"
+	ActionMask   Action = "mask"
+	ActionReject Action = "reject"
+)
+
+func (a Action) severity() int {
+	switch a {
+	case ActionMask:
+		return 1
+	case ActionReject:
+		return 2
+	}
+	return 0
+}
+
+// Decision 是审核结果
+type Decision struct {
+	Action  Action   // 最严重的动作
+	Body    string   // 审核后的正文（可能被屏蔽了一部分）
+	Reason  string   // 拒绝的原因，可以直接返回给客户端
+	Matched []string // 命中的规则，例如被屏蔽的词（已规范化）
+}
+
+// escalate 把 d.Action 提升到 action（不会降低）
+func (d *Decision) escalate(action Action) {
+	if action.severity() > d.Action.severity() {
+		d.Action = action
+	}
+}
+
+// Filter 是管道中的一个步骤
+type Filter interface {
+	Apply(d *Decision)
+}
+
+// Pipeline 是一组按顺序执行的 Filter
+type Pipeline struct {
+	filters []Filter
+}
+
+func NewPipeline(filters ...Filter) *Pipeline {
+	return &Pipeline{
+		filters: filters,
+	}
+}
+
+// Moderate 用管道审核 body
+func (p *Pipeline) Moderate(body string) Decision {
+	d := Decision{
+		Action: ActionAllow,
+		Body:   body,
+	}
+	for _, f := range p.filters {
+		f.Apply(&d)
+		if d.Action == ActionReject {
+			break
+		}
+	}
+	return d
+}
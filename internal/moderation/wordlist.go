@@ -0,0 +1,200 @@
+package moderation
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+var ErrInvalidWord = errors.New("invalid word")
+var ErrInvalidPolicy = errors.New("invalid policy")
+var ErrWordNotFound = errors.New("word not found")
+
+// DefaultWords 是词表文件不存在时使用的默认词表
+var DefaultWords = []Entry{
+	{Word: "kerfuffle", Policy: ActionMask},
+	{Word: "sharbert", Policy: ActionMask},
+	{Word: "fornax", Policy: ActionMask},
+}
+
+// Entry 是词表中的一项
+type Entry struct {
+	Word   string `json:"word"`
+	Policy Action `json:"policy"` // ActionMask 或 ActionReject
+}
+
+// ==== 词表 ====
+/*
+WordList 是可以在运行时修改的敏感词表，并发安全。
+词表文件每行一个词，后面可以跟策略（mask 或 reject，默认 mask），# 开头的行是注释：
+
+	# 屏蔽
+	kerfuffle
+	# 拒绝整条 chirp
+	fornax reject
+
+词在加载和添加时都会被规范化（见 Normalize）。
+*/
+type WordList struct {
+	path  string
+	mu    *sync.RWMutex
+	words map[string]Action
+}
+
+// LoadWordList 从 path 加载词表；文件不存在时使用 DefaultWords 创建它
+func LoadWordList(path string) (*WordList, error) {
+	wl := &WordList{
+		path:  path,
+		mu:    &sync.RWMutex{},
+		words: map[string]Action{},
+	}
+
+	dat, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		for _, entry := range DefaultWords {
+			wl.words[entry.Word] = entry.Policy
+		}
+		return wl, wl.save()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(dat))
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		policy := ActionMask
+		if len(fields) > 1 {
+			policy = Action(fields[1])
+		}
+		word, err := validateEntry(fields[0], policy)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, n, err)
+		}
+		wl.words[word] = policy
+	}
+	return wl, scanner.Err()
+}
+
+// validateEntry 规范化 word 并检查 word 和 policy 是否有效
+func validateEntry(word string, policy Action) (string, error) {
+	if policy != ActionMask && policy != ActionReject {
+		return "", ErrInvalidPolicy
+	}
+	normalized := Normalize(word)
+	// 词表中的词必须是单个词，否则永远无法匹配
+	if normalized == "" || len(wordSpans(normalized)) != 1 {
+		return "", ErrInvalidWord
+	}
+	return normalized, nil
+}
+
+// Lookup 返回规范化后的 word 的策略
+func (wl *WordList) Lookup(word string) (Action, bool) {
+	wl.mu.RLock()
+	defer wl.mu.RUnlock()
+
+	policy, ok := wl.words[word]
+	return policy, ok
+}
+
+// Entries 返回按字母顺序排列的词表
+func (wl *WordList) Entries() []Entry {
+	wl.mu.RLock()
+	defer wl.mu.RUnlock()
+
+	return wl.entries()
+}
+
+func (wl *WordList) entries() []Entry {
+	entries := make([]Entry, 0, len(wl.words))
+	for word, policy := range wl.words {
+		entries = append(entries, Entry{Word: word, Policy: policy})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Word < entries[j].Word
+	})
+	return entries
+}
+
+// Set 添加一个词或修改它的策略，并写回词表文件
+func (wl *WordList) Set(word string, policy Action) (Entry, error) {
+	word, err := validateEntry(word, policy)
+	if err != nil {
+		return Entry{}, err
+	}
+
+	wl.mu.Lock()
+	defer wl.mu.Unlock()
+
+	old, existed := wl.words[word]
+	wl.words[word] = policy
+	err = wl.save()
+	if err != nil {
+		// 写文件失败时恢复内存中的词表
+		if existed {
+			wl.words[word] = old
+		} else {
+			delete(wl.words, word)
+		}
+		return Entry{}, err
+	}
+	return Entry{Word: word, Policy: policy}, nil
+}
+
+// Remove 删除一个词，并写回词表文件
+func (wl *WordList) Remove(word string) error {
+	word = Normalize(word)
+
+	wl.mu.Lock()
+	defer wl.mu.Unlock()
+
+	old, ok := wl.words[word]
+	if !ok {
+		return ErrWordNotFound
+	}
+	delete(wl.words, word)
+	err := wl.save()
+	if err != nil {
+		wl.words[word] = old
+		return err
+	}
+	return nil
+}
+
+// save 原子地写回词表文件，调用方必须持有写锁
+func (wl *WordList) save() error {
+	var b bytes.Buffer
+	b.WriteString("# Chirpy moderation word list: <word> [mask|reject]\n")
+	for _, entry := range wl.entries() {
+		fmt.Fprintf(&b, "%s %s\n", entry.Word, entry.Policy)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(wl.path), filepath.Base(wl.path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(b.Bytes())
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+	err = tmp.Close()
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), wl.path)
+}
@@ -0,0 +1,112 @@
+package moderation
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLoadWordList(t *testing.T) {
+	wl := newTestWordList(t,
+		"# comment",
+		"",
+		"  Kerfuffle  ",
+		"ＦＯＲＮＡＸ reject",
+		"sharbert mask",
+	)
+	want := []Entry{
+		{Word: "fornax", Policy: ActionReject},
+		{Word: "kerfuffle", Policy: ActionMask},
+		{Word: "sharbert", Policy: ActionMask},
+	}
+	if got := wl.Entries(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Entries = %v, want %v", got, want)
+	}
+}
+
+func TestLoadWordListInvalid(t *testing.T) {
+	tests := []struct {
+		line string
+		err  error
+	}{
+		{"kerfuffle ban", ErrInvalidPolicy},
+		{"!!!", ErrInvalidWord},
+		{"kerfuffle-sharbert", ErrInvalidWord},
+	}
+	for _, tt := range tests {
+		path := filepath.Join(t.TempDir(), "words.txt")
+		os.WriteFile(path, []byte("fornax\n"+tt.line+"\n"), 0600)
+		_, err := LoadWordList(path)
+		if !errors.Is(err, tt.err) || !strings.Contains(err.Error(), ":2:") {
+			t.Errorf("LoadWordList(%q) error = %v, want %v on line 2", tt.line, err, tt.err)
+		}
+	}
+}
+
+// 词表文件不存在时使用默认词表，并写出文件
+func TestLoadWordListDefault(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "words.txt")
+	wl, err := LoadWordList(path)
+	if err != nil {
+		t.Fatalf("LoadWordList: %v", err)
+	}
+	if got := wl.Entries(); len(got) != len(DefaultWords) {
+		t.Errorf("Entries = %v, want %v", got, DefaultWords)
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Errorf("word list file was not created: %v", err)
+	}
+}
+
+func TestWordListSetRemove(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "words.txt")
+	os.WriteFile(path, []byte("kerfuffle\n"), 0600)
+	wl, err := LoadWordList(path)
+	if err != nil {
+		t.Fatalf("LoadWordList: %v", err)
+	}
+
+	entry, err := wl.Set("Fórnax", ActionReject)
+	if err != nil || entry != (Entry{Word: "fornax", Policy: ActionReject}) {
+		t.Fatalf("Set = %+v, %v", entry, err)
+	}
+	// 修改已有的词的策略
+	_, err = wl.Set("KERFUFFLE", ActionReject)
+	if err != nil {
+		t.Fatalf("Set(existing): %v", err)
+	}
+	if _, err := wl.Set("two words", ActionMask); !errors.Is(err, ErrInvalidWord) {
+		t.Errorf("Set(two words) error = %v, want ErrInvalidWord", err)
+	}
+	if _, err := wl.Set("sharbert", "ban"); !errors.Is(err, ErrInvalidPolicy) {
+		t.Errorf("Set(invalid policy) error = %v, want ErrInvalidPolicy", err)
+	}
+
+	err = wl.Remove("Kerfuffle")
+	if err != nil {
+		t.Fatalf("Remove: %v", err)
+	}
+	if err := wl.Remove("kerfuffle"); !errors.Is(err, ErrWordNotFound) {
+		t.Errorf("Remove twice error = %v, want ErrWordNotFound", err)
+	}
+	if _, ok := wl.Lookup("kerfuffle"); ok {
+		t.Error("kerfuffle is still in the word list")
+	}
+
+	// 修改被写回文件，重新加载后相同
+	reloaded, err := LoadWordList(path)
+	if err != nil {
+		t.Fatalf("LoadWordList after changes: %v", err)
+	}
+	want := []Entry{{Word: "fornax", Policy: ActionReject}}
+	if got := reloaded.Entries(); !reflect.DeepEqual(got, want) {
+		t.Errorf("reloaded Entries = %v, want %v", got, want)
+	}
+	d := NewPipeline(WordFilter{Words: reloaded}).Moderate("fornax")
+	if d.Action != ActionReject {
+		t.Errorf("Moderate after reload = %s, want reject", d.Action)
+	}
+}
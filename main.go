@@ -2,19 +2,40 @@ package main
 
 import (
 	"flag"
+	"io"
 	"log"
 	"net/http"
 	"os"
+	"strconv"
+	"time"
 
 	"github.com/Grey-1011/go-server/internal/database"
+	"github.com/Grey-1011/go-server/internal/mailer"
+	"github.com/Grey-1011/go-server/internal/media"
+	"github.com/Grey-1011/go-server/internal/moderation"
 	"github.com/joho/godotenv"
 )
 
+// chirp 正文的最大长度（按 Unicode 字符计算）
+const maxChirpLength = 140
+
 type apiConfig struct {
-	fileserverHits int
-	DB             *database.DB
-	jwtSecret      string
-	polkaKey       string
+	fileserverHits  int
+	DB              database.Store
+	jwtSecret       string
+	polkaKey        string
+	adminKey        string
+	moderation      *moderation.Pipeline
+	moderationWords *moderation.WordList
+	chirpRetention  time.Duration // 被删除的 chirp 在回收站中保留的时间
+	media           *media.Store  // 上传的媒体文件
+
+	// 用户资料（显示名称、简介）的审核，和 chirp 共用词表，但长度另有限制
+	profileModeration *moderation.Pipeline
+	mailer            mailer.Mailer // 发送验证邮件等
+
+	accountDeletionGrace time.Duration // 申请删除账号之后，账号被永久删除之前可以撤销的时间
+	anonymizeAccounts    bool          // 删除账号时保留 chirp、只匿名化账号，而不是删除所有内容
 }
 
 func main() {
@@ -23,6 +44,17 @@ func main() {
 
 	godotenv.Load(".env")
 
+	dbDriver, dbPath := dbConfig()
+
+	// 子命令: ./out migrate [--dry-run]
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		err := runMigrate(dbDriver, dbPath, os.Args[2:])
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
 	jwtSecret := os.Getenv("JWT_SECRET")
 	if jwtSecret == "" {
 		log.Fatal("JWT_SECRET enviroment variable is not set")
@@ -33,11 +65,93 @@ func main() {
 		log.Fatal("POLKA_KEY environment variable is not set")
 	}
 
+	// 可选：没有设置时 /admin/moderation 接口不可用
+	adminKey := os.Getenv("ADMIN_API_KEY")
+
+	// 敏感词表，文件不存在时使用默认词表创建
+	wordsPath := os.Getenv("MODERATION_WORDS_FILE")
+	if wordsPath == "" {
+		wordsPath = "moderation_words.txt"
+	}
+	words, err := moderation.LoadWordList(wordsPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// 回收站保留期，默认 30 天，例如 CHIRP_RETENTION=720h
+	chirpRetention := 30 * 24 * time.Hour
+	if s := os.Getenv("CHIRP_RETENTION"); s != "" {
+		chirpRetention, err = time.ParseDuration(s)
+		if err != nil || chirpRetention <= 0 {
+			log.Fatalf("Invalid CHIRP_RETENTION: %q", s)
+		}
+	}
+
+	// 删除账号的宽限期，默认 30 天，例如 ACCOUNT_DELETION_GRACE=720h；
+	// ACCOUNT_DELETION_MODE 为 delete（默认）时删除账号的所有内容，为 anonymize 时保留 chirp 并匿名化账号
+	accountDeletionGrace := 30 * 24 * time.Hour
+	if s := os.Getenv("ACCOUNT_DELETION_GRACE"); s != "" {
+		accountDeletionGrace, err = time.ParseDuration(s)
+		if err != nil || accountDeletionGrace < 0 {
+			log.Fatalf("Invalid ACCOUNT_DELETION_GRACE: %q", s)
+		}
+	}
+	anonymizeAccounts := false
+	switch mode := os.Getenv("ACCOUNT_DELETION_MODE"); mode {
+	case "", "delete":
+	case "anonymize":
+		anonymizeAccounts = true
+	default:
+		log.Fatalf("Invalid ACCOUNT_DELETION_MODE: %q", mode)
+	}
+
+	// 上传的媒体保存在 MEDIA_DIR（默认 ./media），单个文件最大 MEDIA_MAX_BYTES 字节（默认 5 MiB）
+	mediaDir := os.Getenv("MEDIA_DIR")
+	if mediaDir == "" {
+		mediaDir = "media"
+	}
+	mediaMaxBytes := int64(5 << 20)
+	if s := os.Getenv("MEDIA_MAX_BYTES"); s != "" {
+		mediaMaxBytes, err = strconv.ParseInt(s, 10, 64)
+		if err != nil || mediaMaxBytes <= 0 {
+			log.Fatalf("Invalid MEDIA_MAX_BYTES: %q", s)
+		}
+	}
+	mediaStore, err := media.NewStore(mediaDir, mediaMaxBytes)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// 邮件：设置了 SMTP_ADDR（host:port）时通过 SMTP 发送，否则写到 MAIL_LOG 文件（默认标准错误），用于本地开发
+	mailFrom := os.Getenv("MAIL_FROM")
+	if mailFrom == "" {
+		mailFrom = "chirpy@localhost"
+	}
+	var mail mailer.Mailer
+	if addr := os.Getenv("SMTP_ADDR"); addr != "" {
+		mail, err = mailer.NewSMTPMailer(addr, mailFrom, os.Getenv("SMTP_USERNAME"), os.Getenv("SMTP_PASSWORD"))
+		if err != nil {
+			log.Fatalf("Invalid SMTP_ADDR: %q", addr)
+		}
+	} else {
+		mailLog := io.Writer(os.Stderr)
+		if path := os.Getenv("MAIL_LOG"); path != "" {
+			f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+			if err != nil {
+				log.Fatal(err)
+			}
+			defer f.Close()
+			mailLog = f
+		}
+		mail = mailer.NewLogMailer(mailFrom, mailLog)
+	}
+
 	// 创建新数据库
-	db, err := database.NewDB("database.json")
+	db, err := database.Open(dbDriver, dbPath)
 	if err != nil {
 		log.Fatal(err)
 	}
+	defer db.Close()
 
 	dbg := flag.Bool("debug", false, "Enable debug mode")
 	flag.Parse()
@@ -54,8 +168,26 @@ func main() {
 		DB:             db,
 		jwtSecret:      jwtSecret,
 		polkaKey:       polkaKey,
+		adminKey:       adminKey,
+		moderation: moderation.NewPipeline(
+			moderation.LengthFilter{Max: maxChirpLength},
+			moderation.WordFilter{Words: words},
+		),
+		moderationWords:   words,
+		profileModeration: moderation.NewPipeline(moderation.WordFilter{Words: words}),
+		chirpRetention:    chirpRetention,
+		media:             mediaStore,
+		mailer:            mail,
+
+		accountDeletionGrace: accountDeletionGrace,
+		anonymizeAccounts:    anonymizeAccounts,
 	}
 
+	// 后台定期清空回收站中超过保留期的 chirp、没有附加到 chirp 的媒体，以及宽限期已过的账号
+	go apiCfg.runChirpPurger()
+	// 后台发布到期的定时 chirp
+	go apiCfg.runScheduler()
+
 	// create a  new http.ServeMux
 	/*
 		http.NewServeMux() 创建了一个新的 ServeMux 实例， 这是一个 HTTP 请求的路由器。
@@ -68,28 +200,86 @@ func main() {
 	// 使用 middlewareMetricsInc 中间件包装文件服务器处理程序
 	mux.Handle("/app/*", fsHandler)
 
+	// 上传的媒体文件，按内容哈希寻址
+	mux.HandleFunc("GET /media/{hash}", apiCfg.handlerMediaGet)
+
 	mux.HandleFunc("GET /api/healthz", handlerReadiness)
 	// 注册 /metrics 处理程序
 	mux.HandleFunc("GET /admin/metrics", apiCfg.handlerMetrics)
 	// 注册 /reset 处理程序
 	mux.HandleFunc("GET /api/reset", apiCfg.handlerReset)
+	// 管理敏感词表
+	mux.HandleFunc("GET /admin/moderation/words", apiCfg.handlerModerationWordsList)
+	mux.HandleFunc("POST /admin/moderation/words", apiCfg.handlerModerationWordsSet)
+	mux.HandleFunc("DELETE /admin/moderation/words/{word}", apiCfg.handlerModerationWordsDelete)
 
 	// 我们定义了一个路由规则，将 POST 请求映射到 /api/validate_chirp 处理函数 handlerValidateChirp：
 	mux.HandleFunc("POST /api/chirps", apiCfg.handlerChirpsCreate)
+	// 上传媒体，之后在创建 chirp 时通过 media_ids 附加
+	mux.HandleFunc("POST /api/media", apiCfg.handlerMediaUpload)
 	// handlerChirpsRetrieve 获取所有 Chirps
 	mux.HandleFunc("GET /api/chirps", apiCfg.handlerChirpsRetrieve)
+	// 全文搜索 Chirps
+	mux.HandleFunc("GET /api/chirps/search", apiCfg.handlerChirpsSearch)
+	// 回收站：列出自己删除的 Chirps，以及在保留期内恢复
+	mux.HandleFunc("GET /api/chirps/trash", apiCfg.handlerChirpsTrash)
+	// 定时 chirp：列出自己还没有发布的，以及取消
+	mux.HandleFunc("GET /api/chirps/scheduled", apiCfg.handlerChirpsScheduled)
+	mux.HandleFunc("DELETE /api/chirps/scheduled", apiCfg.handlerChirpsScheduledDelete)
+	mux.HandleFunc("POST /api/chirps/{chirpID}/restore", apiCfg.handlerChirpsRestore)
 	// 根据 ID 获取 Chirps
 	mux.HandleFunc("GET /api/chirps/{chirpID}", apiCfg.handlerChirpsGet)
 
 	mux.HandleFunc("POST /api/users", apiCfg.handlerUsersCreate)
-	// 更新用户的电子邮件和密码
+	// 更新用户的电子邮件和密码，只修改请求体中有的字段；PUT 是为了兼容旧的客户端
+	mux.HandleFunc("PATCH /api/users", apiCfg.handlerUsersUpdate)
 	mux.HandleFunc("PUT /api/users", apiCfg.handlerUsersUpdate)
+	// 验证邮箱，以及重新发送验证邮件
+	mux.HandleFunc("POST /api/users/verify", apiCfg.handlerUsersVerify)
+	mux.HandleFunc("POST /api/users/verify/resend", apiCfg.handlerUsersVerifyResend)
+	// 删除账号（宽限期之后永久删除）、在宽限期内撤销，以及导出自己的所有数据
+	mux.HandleFunc("DELETE /api/users", apiCfg.handlerUsersDelete)
+	mux.HandleFunc("POST /api/users/restore", apiCfg.handlerUsersRestore)
+	mux.HandleFunc("GET /api/users/me/export", apiCfg.handlerUsersExport)
+	// 当前用户；公开资料：按 handle 或 ID 读取、修改 handle、修改显示名称、简介和头像
+	mux.HandleFunc("GET /api/users/me", apiCfg.handlerUsersMe)
+	mux.HandleFunc("GET /api/users/{user}", apiCfg.handlerUsersGet)
+	mux.HandleFunc("PUT /api/users/handle", apiCfg.handlerUsersHandleChange)
+	mux.HandleFunc("PATCH /api/users/profile", apiCfg.handlerUsersProfileUpdate)
 	mux.HandleFunc("POST /api/login", apiCfg.handlerLogin)
 
 	mux.HandleFunc("POST /api/refresh", apiCfg.handlerRefresh)
 	mux.HandleFunc("POST /api/revoke", apiCfg.handlerRevoke)
+	// 忘记密码：发送重置密码的邮件，以及使用邮件中的令牌设置新密码
+	mux.HandleFunc("POST /api/password/forgot", apiCfg.handlerPasswordForgot)
+	mux.HandleFunc("POST /api/password/reset", apiCfg.handlerPasswordReset)
 
+	// 编辑 Chirp，以及查看它的历史版本
+	mux.HandleFunc("PATCH /api/chirps/{chirpID}", apiCfg.handlerChirpsUpdate)
+	mux.HandleFunc("GET /api/chirps/{chirpID}/revisions", apiCfg.handlerChirpRevisionsGet)
+	// 回复组成的对话
+	mux.HandleFunc("GET /api/chirps/{chirpID}/thread", apiCfg.handlerChirpThreadGet)
 	mux.HandleFunc("DELETE /api/chirps/{chirpID}", apiCfg.handlerChirpsDelete)
+	// 点赞
+	mux.HandleFunc("POST /api/chirps/{chirpID}/likes", apiCfg.handlerChirpsLike)
+	mux.HandleFunc("DELETE /api/chirps/{chirpID}/likes", apiCfg.handlerChirpsUnlike)
+	mux.HandleFunc("GET /api/users/{userID}/likes", apiCfg.handlerUserLikesGet)
+
+	mux.HandleFunc("POST /api/chirps/{chirpID}/rechirp", apiCfg.handlerChirpsRechirp)
+	mux.HandleFunc("DELETE /api/chirps/{chirpID}/rechirp", apiCfg.handlerChirpsUnrechirp)
+	// 投票
+	mux.HandleFunc("POST /api/chirps/{chirpID}/poll/votes", apiCfg.handlerPollVote)
+
+	mux.HandleFunc("POST /api/users/{userID}/follow", apiCfg.handlerUsersFollow)
+	mux.HandleFunc("DELETE /api/users/{userID}/follow", apiCfg.handlerUsersUnfollow)
+	mux.HandleFunc("GET /api/users/{userID}/followers", apiCfg.handlerUserFollowersGet)
+	mux.HandleFunc("GET /api/users/{userID}/following", apiCfg.handlerUserFollowingGet)
+	mux.HandleFunc("GET /api/timeline", apiCfg.handlerTimeline)
+
+	mux.HandleFunc("GET /api/tags/trending", apiCfg.handlerTrendingTags)
+	mux.HandleFunc("GET /api/tags/{tag}/chirps", apiCfg.handlerTagChirps)
+	mux.HandleFunc("GET /api/notifications", apiCfg.handlerNotificationsGet)
+	mux.HandleFunc("POST /api/notifications/read", apiCfg.handlerNotificationsRead)
 	mux.HandleFunc("POST /api/polka/webhooks", apiCfg.handlerWebhook)
 
 	/*
@@ -106,3 +296,16 @@ func main() {
 	log.Fatal(srv.ListenAndServe())
 
 }
+
+// 存储后端: json（默认）或 sqlite
+func dbConfig() (driver, path string) {
+	driver = os.Getenv("DB_DRIVER")
+	path = os.Getenv("DB_PATH")
+	if path == "" {
+		path = "database.json"
+		if driver == database.DriverSQLite {
+			path = "chirpy.db"
+		}
+	}
+	return driver, path
+}
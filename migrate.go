@@ -0,0 +1,52 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+
+	"github.com/Grey-1011/go-server/internal/database"
+)
+
+// runMigrate 实现 migrate 子命令：
+// 默认执行尚未执行的迁移；--dry-run 只报告会执行哪些迁移以及它们会修改哪些数据。
+func runMigrate(driver, path string, args []string) error {
+	flags := flag.NewFlagSet("migrate", flag.ExitOnError)
+	dryRun := flags.Bool("dry-run", false, "Report pending migrations without applying them")
+	flags.Parse(args)
+
+	var plan database.MigrationPlan
+	var err error
+	if driver == database.DriverSQLite {
+		plan, err = database.PlanSQLiteMigrations(path)
+	} else {
+		plan, err = database.PlanMigrations(path)
+	}
+	if err != nil {
+		return err
+	}
+
+	if len(plan.Steps) == 0 {
+		fmt.Printf("%s is up to date (schema version %d)\n", path, plan.FromVersion)
+		return nil
+	}
+
+	fmt.Printf("%s: schema version %d -> %d\n", path, plan.FromVersion, plan.ToVersion)
+	for _, step := range plan.Steps {
+		fmt.Printf("  %d: %s\n", step.Version, step.Description)
+		for _, change := range step.Changes {
+			fmt.Printf("       %s\n", change)
+		}
+	}
+
+	if *dryRun {
+		fmt.Println("Dry run, no changes were made")
+		return nil
+	}
+
+	// 打开数据库时会执行迁移
+	db, err := database.Open(driver, path)
+	if err != nil {
+		return err
+	}
+	return db.Close()
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"time"
+
+	"github.com/Grey-1011/go-server/internal/database"
+)
+
+// 清理任务最长的执行间隔
+const maxPurgeInterval = time.Hour
+
+// 上传后一直没有附加到 chirp（或者所在 chirp 已被永久删除）的媒体保留的时间
+const mediaOrphanRetention = 24 * time.Hour
+
+// runChirpPurger 启动时以及之后每隔一段时间，永久删除回收站中超过保留期的 chirp，然后清理孤儿媒体和过期的一次性令牌，
+// 最后永久删除宽限期已过的账号。
+// 间隔取保留期和 maxPurgeInterval 中较小的一个，所以 chirp 最晚在保留期结束后一个间隔内被清理。
+func (cfg *apiConfig) runChirpPurger() {
+	ticker := time.NewTicker(min(cfg.chirpRetention, maxPurgeInterval))
+	defer ticker.Stop()
+
+	for {
+		n, err := cfg.DB.PurgeChirps(time.Now().Add(-cfg.chirpRetention))
+		if err != nil {
+			log.Printf("Couldn't purge deleted chirps: %s", err)
+		} else if n > 0 {
+			log.Printf("Purged %d deleted chirps", n)
+		}
+
+		n, err = cfg.media.Collect(func() ([]string, error) {
+			return cfg.DB.PurgeMedia(time.Now().Add(-mediaOrphanRetention))
+		})
+		if err != nil {
+			log.Printf("Couldn't purge orphaned media: %s", err)
+		} else if n > 0 {
+			log.Printf("Removed %d orphaned media files", n)
+		}
+
+		n, err = cfg.DB.PurgeUserTokens(time.Now())
+		if err != nil {
+			log.Printf("Couldn't purge expired tokens: %s", err)
+		} else if n > 0 {
+			log.Printf("Purged %d expired tokens", n)
+		}
+
+		cfg.purgeDeletedUsers(time.Now())
+		<-ticker.C
+	}
+}
+
+// purgeDeletedUsers 逐个永久删除宽限期在 now 之前结束的账号（按 ACCOUNT_DELETION_MODE 删除或匿名化），
+// 每个账号一个事务，不再被引用的媒体文件随之删除
+func (cfg *apiConfig) purgeDeletedUsers(now time.Time) {
+	ids, err := cfg.DB.DueUserDeletions(now)
+	if err != nil {
+		log.Printf("Couldn't list accounts to delete: %s", err)
+		return
+	}
+
+	for _, id := range ids {
+		n, err := cfg.media.Collect(func() ([]string, error) {
+			return cfg.DB.PurgeUser(id, now, cfg.anonymizeAccounts)
+		})
+		// 在这期间撤销了申请
+		if errors.Is(err, database.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			log.Printf("Couldn't delete account %d: %s", id, err)
+			continue
+		}
+		log.Printf("Deleted account %d (%d media files removed)", id, n)
+	}
+}
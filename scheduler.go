@@ -0,0 +1,73 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"time"
+
+	"github.com/Grey-1011/go-server/internal/database"
+)
+
+// 调度器检查到期的定时 chirp 的间隔，chirp 最晚在 publish_at 之后一个间隔内发布
+const schedulerInterval = 10 * time.Second
+
+// runScheduler 启动时以及之后每隔 schedulerInterval 发布到期的定时 chirp。
+// 定时 chirp 保存在数据库中，重启后启动时的第一次检查会发布停机期间到期的 chirp。
+func (cfg *apiConfig) runScheduler() {
+	ticker := time.NewTicker(schedulerInterval)
+	defer ticker.Stop()
+
+	for {
+		cfg.publishDueChirps(time.Now())
+		<-ticker.C
+	}
+}
+
+// ==== 发布定时 chirp ====
+/*
+publishDueChirps 按发布时间顺序发布所有到期的定时 chirp：
+1) 重新解析正文中的提及，被提及的用户可能在定时期间修改了 handle。
+2) 发布在一个事务中完成，重启后不会重复发布。
+3) 被回复、被引用的 chirp 在定时期间被删除时，把定时 chirp 标记为失败，作者可以在列表中看到原因。
+   其他错误（例如写入失败）只记录日志，下一次检查时重试。
+*/
+func (cfg *apiConfig) publishDueChirps(now time.Time) {
+	due, err := cfg.DB.DueScheduledChirps(now)
+	if err != nil {
+		log.Printf("Couldn't retrieve scheduled chirps: %s", err)
+		return
+	}
+
+	for _, scheduled := range due {
+		chirpEntities, err := cfg.chirpEntities(scheduled.Body)
+		if err != nil {
+			log.Printf("Couldn't resolve mentions of scheduled chirp %d: %s", scheduled.ID, err)
+			continue
+		}
+
+		chirp, err := cfg.DB.PublishScheduledChirp(scheduled.ID, chirpEntities)
+		reason := ""
+		switch {
+		case errors.Is(err, database.ErrNotExist):
+			// 在这期间被作者取消了
+			continue
+		case errors.Is(err, database.ErrParentNotExist):
+			reason = "The chirp to reply to has been deleted"
+		case errors.Is(err, database.ErrQuotedNotExist):
+			reason = "The chirp to quote has been deleted"
+		case errors.Is(err, database.ErrMediaNotAvailable):
+			reason = "The attached media is no longer available"
+		case err != nil:
+			log.Printf("Couldn't publish scheduled chirp %d: %s", scheduled.ID, err)
+			continue
+		default:
+			log.Printf("Published scheduled chirp %d as chirp %d", scheduled.ID, chirp.ID)
+			continue
+		}
+
+		err = cfg.DB.FailScheduledChirp(scheduled.ID, reason)
+		if err != nil && !errors.Is(err, database.ErrNotExist) {
+			log.Printf("Couldn't mark scheduled chirp %d as failed: %s", scheduled.ID, err)
+		}
+	}
+}